@@ -2,29 +2,50 @@ package app
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/newsamples/imapsync/internal/config"
+	"github.com/newsamples/imapsync/internal/export"
 	"github.com/newsamples/imapsync/internal/imap"
+	"github.com/newsamples/imapsync/internal/metrics"
 	"github.com/newsamples/imapsync/internal/server"
 	"github.com/newsamples/imapsync/internal/storage"
 	"github.com/newsamples/imapsync/internal/syncer"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 var (
 	CfgFile string
 	Log     = logrus.New()
+
+	// logFile is the file Log writes to, if any, closed when logging is
+	// configured again.
+	logFile io.Closer
 )
 
 var RootCmd = &cobra.Command{
 	Use:   "imapsync",
 	Short: "IMAP email backup tool",
 	Long:  "A tool to backup emails from IMAP servers to local storage using badgerdb",
+
+	PersistentPreRunE: handleVersionFlag,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return cmd.Help()
+	},
 }
 
 var syncCmd = &cobra.Command{
@@ -39,100 +60,454 @@ var serverCmd = &cobra.Command{
 	RunE:  RunServer,
 }
 
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Upload stored emails back to the IMAP server",
+	RunE:  RunRestore,
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export a stored mailbox to a file other mail clients can import",
+	RunE:  RunExport,
+}
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Summarize the contents of the backup",
+	RunE:  RunStats,
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Work with the config file",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the config file for problems without connecting",
+	RunE:  RunConfigValidate,
+}
+
+var configInitCmd = &cobra.Command{
+	Use:   "init [path]",
+	Short: "Write a commented starter config file",
+	Args:  cobra.MaximumNArgs(1),
+	RunE:  RunConfigInit,
+}
+
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Continuously sync a mailbox as new mail arrives",
+	RunE:  RunWatch,
+}
+
 func init() {
 	RootCmd.PersistentFlags().StringVarP(&CfgFile, "config", "c", "config.yaml", "config file path")
 	RootCmd.PersistentFlags().Bool("verbose", false, "enable verbose logging")
+	RootCmd.PersistentFlags().Bool("quiet", false, "only log errors and hide progress bars, e.g. for cron; overrides --verbose")
+	RootCmd.PersistentFlags().String("log-format", "", "log output format: text or json; overrides log.format from config (default text)")
+	RootCmd.PersistentFlags().String("log-file", "", "append logs to this file instead of stderr; overrides log.file from config")
+	RootCmd.PersistentFlags().Bool("version", false, "print version information and exit")
+	RootCmd.PersistentFlags().String("account", "", "account to use from the accounts list in config; sync uses all by default")
 
 	syncCmd.Flags().Bool("progress", false, "show progress bars")
+	syncCmd.Flags().String("progress-format", "", "how to show progress: bar, plain (log lines, e.g. for CI) or none; overrides --progress")
 	syncCmd.Flags().Bool("watch", false, "watch for changes and sync continuously")
+	syncCmd.Flags().Bool("sync-flags", false, "refresh flags (read, flagged...) of already-downloaded messages")
+	syncCmd.Flags().Int("batch-size", 0, "messages fetched per round-trip; 0 uses sync.batch_size from config (default 5)")
 	syncCmd.Flags().Duration("interval", 0, "polling interval for watch mode; 0 uses IMAP IDLE (real-time)")
+	syncCmd.Flags().StringArray("mailbox", nil, "sync only this mailbox (repeatable, * wildcard)")
+	syncCmd.Flags().StringArray("exclude", nil, "skip mailboxes matching this pattern (repeatable, * wildcard)")
+	syncCmd.Flags().String("since", "", "only sync messages received on or after this date (2006-01-02 or RFC3339)")
+	syncCmd.Flags().String("before", "", "only sync messages received before this date (2006-01-02 or RFC3339)")
+	syncCmd.Flags().String("rate-limit", "", "cap download bandwidth, e.g. 500KB (bytes per second)")
+	syncCmd.Flags().Bool("metadata-first", false, "store all envelopes first, then download bodies")
+	syncCmd.Flags().String("max-size", "", "skip the body of messages larger than this, e.g. 25MB; overrides sync.max_message_size from config")
+	syncCmd.Flags().String("report-file", "", "write a JSON report of the sync to this file")
+	syncCmd.Flags().Bool("dry-run", false, "report how many messages and bytes would be downloaded without downloading or storing anything")
+	syncCmd.Flags().Bool("strict", false, "fail a mailbox whose stored state doesn't match the server's UIDVALIDITY instead of resyncing it")
+	syncCmd.Flags().Bool("fail-fast", false, "stop at the first mailbox or account that fails to sync instead of continuing with the others")
+	syncCmd.Flags().String("metrics-addr", "", "serve Prometheus sync metrics at /metrics on this address while syncing, e.g. :9090")
 
 	serverCmd.Flags().String("addr", ":8080", "server address to listen on")
+	serverCmd.Flags().String("tls-cert", "", "TLS certificate file; overrides server.tls_cert from config")
+	serverCmd.Flags().String("tls-key", "", "TLS private key file; overrides server.tls_key from config")
+
+	watchCmd.Flags().String("mailbox", "INBOX", "mailbox to watch")
+	watchCmd.Flags().Duration("interval", time.Minute, "polling interval used when the server doesn't support IDLE")
+
+	configInitCmd.Flags().Bool("force", false, "overwrite an existing file")
+
+	pruneCmd.Flags().String("older-than", "", "delete messages older than this age, e.g. 90d, 6w, 18m (months) or 2y")
+	pruneCmd.Flags().String("mailbox", "", "prune only this mailbox (default: all)")
+	pruneCmd.Flags().Bool("vacuum", false, "compact the database afterwards to reclaim disk space")
+	pruneCmd.Flags().Bool("yes", false, "don't ask for confirmation")
+
+	deleteMailboxCmd.Flags().Bool("yes", false, "don't ask for confirmation")
+
+	restoreCmd.Flags().String("mailbox", "", "restore only this mailbox")
+	restoreCmd.Flags().Bool("dry-run", false, "log what would be uploaded without changing the server")
+
+	exportCmd.Flags().String("format", "mbox", "export format: mbox or maildir")
+	exportCmd.Flags().String("mailbox", "INBOX", "mailbox to export")
+	exportCmd.Flags().String("out", "", "output file (mbox) or directory (maildir)")
+
+	importCmd.Flags().String("format", "mbox", "import format: mbox, or eml for an .eml file or a directory of them")
+	importCmd.Flags().String("mailbox", "", "mailbox to import into; use one that isn't on the server")
+
+	statsCmd.Flags().Bool("json", false, "print stats as JSON")
+	statsCmd.Flags().Bool("all-accounts", false, "show stats of every account in config, each stored in its own database")
+
+	mailboxesCmd.Flags().Bool("json", false, "print mailboxes as JSON")
+
+	searchCmd.Flags().String("mailbox", "", "search only this mailbox (default: all)")
+	searchCmd.Flags().Int("limit", 20, "maximum number of messages to print")
+	searchCmd.Flags().Bool("json", false, "print matches as JSON")
+
+	dedupCmd.Flags().Bool("list", false, "list every duplicated message and where it is stored")
 
 	RootCmd.AddCommand(syncCmd)
 	RootCmd.AddCommand(serverCmd)
+	RootCmd.AddCommand(restoreCmd)
+	RootCmd.AddCommand(watchCmd)
+	RootCmd.AddCommand(exportCmd)
+	RootCmd.AddCommand(importCmd)
+	RootCmd.AddCommand(statsCmd)
+	RootCmd.AddCommand(mailboxesCmd)
+	RootCmd.AddCommand(versionCmd)
+	RootCmd.AddCommand(pruneCmd)
+	RootCmd.AddCommand(deleteMailboxCmd)
+	RootCmd.AddCommand(compactCmd)
+	RootCmd.AddCommand(dedupCmd)
+	RootCmd.AddCommand(verifyCmd)
+	RootCmd.AddCommand(quotaCmd)
+	RootCmd.AddCommand(searchCmd)
+
+	configCmd.AddCommand(configInitCmd)
+	configCmd.AddCommand(configValidateCmd)
+	RootCmd.AddCommand(configCmd)
 
 	cobra.OnInitialize(InitConfig)
 }
 
+// InitConfig sets up logging from the log section of the config file and the
+// global flags. It runs before any command, so problems with the rest of the
+// config, or a missing file, are left for the command to report.
 func InitConfig() {
-	if verbose, _ := RootCmd.PersistentFlags().GetBool("verbose"); verbose {
-		Log.SetLevel(logrus.DebugLevel)
-	} else {
-		Log.SetLevel(logrus.InfoLevel)
+	logCfg, err := config.LoadLogConfig(CfgFile)
+	if err != nil {
+		logCfg = config.LogConfig{}
+	}
+	if format, _ := RootCmd.PersistentFlags().GetString("log-format"); format != "" {
+		logCfg.Format = format
+	}
+	if file, _ := RootCmd.PersistentFlags().GetString("log-file"); file != "" {
+		logCfg.File = file
+	}
+	verbose, _ := RootCmd.PersistentFlags().GetBool("verbose")
+	quiet, _ := RootCmd.PersistentFlags().GetBool("quiet")
+	switch {
+	case quiet:
+		if verbose {
+			// Logged before the level goes up, or it would be hidden.
+			Log.Warn("--quiet overrides --verbose, only errors are logged")
+		}
+		logCfg.Level = "error"
+	case verbose:
+		logCfg.Level = "debug"
 	}
 
-	Log.SetFormatter(&logrus.TextFormatter{
-		FullTimestamp: true,
-	})
+	if err := configureLogger(Log, logCfg); err != nil {
+		Log.WithError(err).Warn("Invalid logging settings, using defaults")
+	}
 }
 
-func RunSync(cmd *cobra.Command, _ []string) error {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+// configureLogger applies cfg to log. An unknown level or format is
+// reported and replaced by its default, and a log file that can't be opened
+// leaves logs on stderr.
+func configureLogger(log *logrus.Logger, cfg config.LogConfig) error {
+	var errs []error
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	if logFile != nil {
+		logFile.Close()
+		logFile = nil
+	}
+	log.SetOutput(os.Stderr)
+	if cfg.File != "" {
+		out, err := openLogFile(cfg)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			log.SetOutput(out)
+			logFile = out
+		}
+	}
 
-	go func() {
-		<-sigChan
-		Log.Warn("Interrupt signal received, shutting down gracefully...")
-		cancel()
-	}()
+	level := logrus.InfoLevel
+	if cfg.Level != "" {
+		parsed, err := logrus.ParseLevel(cfg.Level)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			level = parsed
+		}
+	}
+	log.SetLevel(level)
 
-	cfg, err := config.Load(CfgFile)
+	switch cfg.Format {
+	case "", "text":
+		log.SetFormatter(&logrus.TextFormatter{
+			FullTimestamp: true,
+		})
+	case "json":
+		log.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		log.SetFormatter(&logrus.TextFormatter{
+			FullTimestamp: true,
+		})
+		errs = append(errs, fmt.Errorf("unknown log format %q, want text or json", cfg.Format))
+	}
+
+	return errors.Join(errs...)
+}
+
+// openLogFile opens cfg.File for appending, through lumberjack when it is
+// rotated by size.
+func openLogFile(cfg config.LogConfig) (io.WriteCloser, error) {
+	if cfg.MaxSizeMB > 0 {
+		return &lumberjack.Logger{
+			Filename:   cfg.File,
+			MaxSize:    cfg.MaxSizeMB,
+			MaxBackups: cfg.MaxBackups,
+		}, nil
+	}
+
+	f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+	return f, nil
+}
+
+func RunSync(cmd *cobra.Command, _ []string) error {
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	cfg, err := config.Load(CfgFile, config.WithWritableStorage())
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
-	showProgress, _ := cmd.Flags().GetBool("progress")
+	progressFormat := syncer.ProgressFormatNone
+	if showProgress, _ := cmd.Flags().GetBool("progress"); showProgress {
+		progressFormat = syncer.ProgressFormatBar
+	}
+	if name, _ := cmd.Flags().GetString("progress-format"); name != "" {
+		if progressFormat, err = syncer.ParseProgressFormat(name); err != nil {
+			return fmt.Errorf("invalid --progress-format: %w", err)
+		}
+	}
+	if quiet, _ := cmd.Flags().GetBool("quiet"); quiet {
+		progressFormat = syncer.ProgressFormatNone
+	}
 	watchMode, _ := cmd.Flags().GetBool("watch")
 	interval, _ := cmd.Flags().GetDuration("interval")
 
-	Log.Infof("Connecting to IMAP server: %s:%d", cfg.IMAP.Host, cfg.IMAP.Port)
+	syncFlags, _ := cmd.Flags().GetBool("sync-flags")
+	metadataFirst, _ := cmd.Flags().GetBool("metadata-first")
+	strict, _ := cmd.Flags().GetBool("strict")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	failFast, _ := cmd.Flags().GetBool("fail-fast")
+	include, _ := cmd.Flags().GetStringArray("mailbox")
+	exclude, _ := cmd.Flags().GetStringArray("exclude")
 
-	client, err := imap.Connect(imap.ConnectOptions{
-		Host:     cfg.IMAP.Host,
-		Port:     cfg.IMAP.Port,
-		Username: cfg.IMAP.Username,
-		Password: cfg.IMAP.Password,
-		TLS:      cfg.IMAP.TLS,
-		Logger:   Log,
-	})
+	since, err := dateFlag(cmd, "since")
 	if err != nil {
-		return fmt.Errorf("failed to connect to IMAP server: %w", err)
+		return err
+	}
+	before, err := dateFlag(cmd, "before")
+	if err != nil {
+		return err
+	}
+	if !since.IsZero() && !before.IsZero() && !before.After(since) {
+		return fmt.Errorf("--before must be later than --since")
 	}
-	defer client.Close()
 
-	Log.Info("Connected to IMAP server successfully")
+	batchSize := cfg.Sync.BatchSizeOrDefault()
+	if n, _ := cmd.Flags().GetInt("batch-size"); n > 0 {
+		batchSize = n
+	}
 
-	store, err := storage.New(cfg.Storage.Path, Log)
+	maxSize, err := cfg.Sync.MaxMessageSizeBytes()
 	if err != nil {
-		return fmt.Errorf("failed to open storage: %w", err)
+		return err
+	}
+	if value, _ := cmd.Flags().GetString("max-size"); value != "" {
+		if maxSize, err = config.ParseSize(value); err != nil {
+			return fmt.Errorf("invalid --max-size: %w", err)
+		}
 	}
-	defer store.Close()
 
-	Log.Infof("Opened storage at: %s", cfg.Storage.Path)
+	var rateLimit int64
+	if value, _ := cmd.Flags().GetString("rate-limit"); value != "" {
+		if rateLimit, err = config.ParseSize(value); err != nil {
+			return fmt.Errorf("invalid --rate-limit: %w", err)
+		}
+	}
+
+	if addr, _ := cmd.Flags().GetString("metrics-addr"); addr != "" {
+		if err := serveMetrics(ctx, addr); err != nil {
+			return err
+		}
+	}
+
+	opts := []syncer.Option{
+		syncer.WithProgressFormat(progressFormat),
+		syncer.WithBatchSize(batchSize),
+		syncer.WithSyncFlags(syncFlags),
+		syncer.WithMailboxFilter(include, exclude),
+		syncer.WithSince(since),
+		syncer.WithBefore(before),
+		syncer.WithMaxMessageSize(maxSize),
+		syncer.WithMetadataFirst(metadataFirst),
+		syncer.WithRateLimit(int(rateLimit)),
+		syncer.WithStrictUIDValidity(strict),
+		syncer.WithDryRun(dryRun),
+		syncer.WithFailFast(failFast),
+	}
 
-	// Detect if server is Gmail
-	isGmail := false
-	if cfg.Gmail.IsEnabled() {
-		detected, err := client.IsGmailServer(ctx)
+	accounts := cfg.AccountsOrDefault()
+	if name, _ := cmd.Flags().GetString("account"); name != "" {
+		account, err := cfg.Account(name)
 		if err != nil {
-			Log.WithError(err).Warn("Failed to detect Gmail server, continuing without Gmail-specific handling")
-		} else {
-			isGmail = detected
-			if isGmail {
-				Log.Info("Gmail server detected, applying Gmail-specific configuration")
+			return err
+		}
+		accounts = []config.AccountConfig{*account}
+	}
+
+	if watchMode && len(accounts) > 1 {
+		return fmt.Errorf("watch mode syncs a single account, select one with --account")
+	}
+
+	reportFile, _ := cmd.Flags().GetString("report-file")
+	if watchMode {
+		if reportFile != "" {
+			return fmt.Errorf("--report-file can't be used with --watch")
+		}
+		if dryRun {
+			return fmt.Errorf("--dry-run can't be used with --watch")
+		}
+		_, err := syncAccount(ctx, cfg.ForAccount(accounts[0]), opts, true, interval)
+		return err
+	}
+
+	// A failing account doesn't stop the others from being backed up,
+	// unless --fail-fast is set.
+	var errs []error
+	reports := make([]accountReport, 0, len(accounts))
+	for _, account := range accounts {
+		if ctx.Err() != nil {
+			break
+		}
+
+		if len(accounts) > 1 {
+			Log.Infof("Syncing account: %s", account.Name)
+		}
+
+		report, err := syncAccount(ctx, cfg.ForAccount(account), opts, false, 0)
+		entry := accountReport{Account: account.Name, SyncReport: report}
+		if err != nil {
+			entry.Error = err.Error()
+			if len(accounts) > 1 {
+				Log.WithError(err).Errorf("Account %s failed", account.Name)
+				err = fmt.Errorf("account %s: %w", account.Name, err)
 			}
+			errs = append(errs, err)
+		}
+		reports = append(reports, entry)
+
+		if err != nil && failFast {
+			break
 		}
 	}
 
-	s := syncer.New(client, store, Log,
-		syncer.WithProgress(showProgress),
-		syncer.WithGmailConfig(&cfg.Gmail, isGmail),
-		syncer.WithPurgeAfterDays(cfg.Storage.PurgeAfterDaysOrDefault()),
-	)
+	if reportFile != "" {
+		if err := writeSyncReport(reportFile, reports); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// serveMetrics exposes the Prometheus metrics on addr until ctx is done.
+func serveMetrics(ctx context.Context, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for metrics: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	srv := &http.Server{Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			Log.WithError(err).Error("Metrics server failed")
+		}
+	}()
+
+	Log.Infof("Serving metrics on %s/metrics", listener.Addr())
+	return nil
+}
+
+// accountReport is one element of the --report-file JSON array. SyncReport is
+// nil when the account failed before syncing started.
+type accountReport struct {
+	Account string `json:"account"`
+	*syncer.SyncReport
+	Error string `json:"error,omitempty"`
+}
+
+func writeSyncReport(path string, reports []accountReport) error {
+	data, err := json.MarshalIndent(reports, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode sync report: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write sync report: %w", err)
+	}
+	return nil
+}
+
+// syncAccount syncs the account cfg was narrowed to with ForAccount, once or
+// continuously in watch mode. The report is nil in watch mode or when the
+// sync couldn't start.
+func syncAccount(ctx context.Context, cfg *config.Config, opts []syncer.Option, watchMode bool, interval time.Duration) (*syncer.SyncReport, error) {
+	Log.Infof("Connecting to IMAP server: %s:%d", cfg.IMAP.Host, cfg.IMAP.Port)
+
+	client, err := imap.Connect(connectOptions(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to IMAP server: %w", err)
+	}
+	defer client.Close()
+
+	Log.Info("Connected to IMAP server successfully")
+
+	store, err := storage.New(cfg.Storage.Path, Log, storageOptions(cfg)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+	defer store.Close()
+
+	Log.Infof("Opened storage at: %s", cfg.Storage.Path)
+
+	s := syncer.New(client, store, Log, append(accountSyncOptions(cfg), opts...)...)
 
 	if watchMode {
 		if interval == 0 {
@@ -142,44 +517,583 @@ func RunSync(cmd *cobra.Command, _ []string) error {
 		}
 
 		if err := s.Watch(ctx, interval); err != nil {
-			return fmt.Errorf("watch failed: %w", err)
+			return nil, fmt.Errorf("watch failed: %w", err)
 		}
 
 		Log.Info("Watch mode stopped")
-		return nil
+		return nil, nil
 	}
 
 	Log.Info("Starting email sync...")
 
-	if err := s.SyncAll(ctx); err != nil {
+	report, err := s.SyncAll(ctx)
+	if err != nil {
 		if ctx.Err() == context.Canceled {
 			Log.Info("Sync cancelled by user")
-			return nil
+			return report, nil
 		}
-		return fmt.Errorf("sync failed: %w", err)
+		return report, fmt.Errorf("sync failed: %w", err)
 	}
 
 	Log.Info("Email sync completed successfully")
 
+	return report, nil
+}
+
+func RunRestore(cmd *cobra.Command, _ []string) error {
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	cfg, err := loadAccountConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	mailbox, _ := cmd.Flags().GetString("mailbox")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+	store, err := storage.New(cfg.Storage.Path, Log, storageOptions(cfg, storage.WithReadOnly(true))...)
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	defer store.Close()
+
+	Log.Infof("Opened storage at: %s (read-only)", cfg.Storage.Path)
+	Log.Infof("Connecting to IMAP server: %s:%d", cfg.IMAP.Host, cfg.IMAP.Port)
+
+	client, err := imap.Connect(connectOptions(cfg))
+	if err != nil {
+		return fmt.Errorf("failed to connect to IMAP server: %w", err)
+	}
+	defer client.Close()
+
+	s := syncer.New(client, store, Log)
+
+	if _, err := s.Restore(ctx, syncer.RestoreOptions{Mailbox: mailbox, DryRun: dryRun}); err != nil {
+		if ctx.Err() == context.Canceled {
+			Log.Info("Restore cancelled by user")
+			return nil
+		}
+		return fmt.Errorf("restore failed: %w", err)
+	}
+
 	return nil
 }
 
+func RunWatch(cmd *cobra.Command, _ []string) error {
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	cfg, err := loadAccountConfig(cmd, config.WithWritableStorage())
+	if err != nil {
+		return err
+	}
+
+	mailbox, _ := cmd.Flags().GetString("mailbox")
+	interval, _ := cmd.Flags().GetDuration("interval")
+
+	maxSize, err := cfg.Sync.MaxMessageSizeBytes()
+	if err != nil {
+		return err
+	}
+
+	Log.Infof("Connecting to IMAP server: %s:%d", cfg.IMAP.Host, cfg.IMAP.Port)
+
+	client, err := imap.Connect(connectOptions(cfg))
+	if err != nil {
+		return fmt.Errorf("failed to connect to IMAP server: %w", err)
+	}
+	defer client.Close()
+
+	store, err := storage.New(cfg.Storage.Path, Log, storageOptions(cfg)...)
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	defer store.Close()
+
+	Log.Infof("Opened storage at: %s", cfg.Storage.Path)
+
+	s := syncer.New(client, store, Log, append(accountSyncOptions(cfg),
+		syncer.WithBatchSize(cfg.Sync.BatchSizeOrDefault()),
+		syncer.WithMaxMessageSize(maxSize),
+	)...)
+
+	if err := s.WatchMailbox(ctx, mailbox, interval); err != nil {
+		return fmt.Errorf("watch failed: %w", err)
+	}
+
+	Log.Info("Watch stopped")
+	return nil
+}
+
+// accountSyncOptions returns the syncer options sync and watch both take from
+// the config of the account cfg was narrowed to with ForAccount. Options
+// from command-line flags go after them.
+func accountSyncOptions(cfg *config.Config) []syncer.Option {
+	return []syncer.Option{
+		syncer.WithGmailConfig(&cfg.Gmail),
+		syncer.WithPurgeAfterDays(cfg.Storage.PurgeAfterDaysOrDefault()),
+		syncer.WithMailboxPolicies(mailboxPolicies(cfg)),
+	}
+}
+
+// mailboxPolicies returns the sync.mailbox_policies of cfg, which Load has
+// validated.
+func mailboxPolicies(cfg *config.Config) map[string]syncer.FetchPolicy {
+	policies := make(map[string]syncer.FetchPolicy, len(cfg.Sync.MailboxPolicies))
+	for pattern, policy := range cfg.Sync.MailboxPolicies {
+		policies[pattern] = syncer.FetchPolicy(policy)
+	}
+	return policies
+}
+
+// signalContext returns a context that is cancelled on SIGINT or SIGTERM.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-sigChan:
+			Log.Warn("Interrupt signal received, shutting down gracefully...")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+// storageOptions adds the storage settings every command needs, such as the
+// compression and encryption key, to opts.
+func storageOptions(cfg *config.Config, opts ...storage.Option) []storage.Option {
+	opts = append(opts, storage.WithCompression(storage.Compression(cfg.Storage.CompressionOrDefault())))
+	if cfg.Storage.EncryptionKey != "" {
+		opts = append(opts, storage.WithEncryptionKey(cfg.Storage.EncryptionKey))
+	}
+	if cfg.Storage.WriteRetries != nil {
+		opts = append(opts, storage.WithWriteRetries(*cfg.Storage.WriteRetries))
+	}
+	return opts
+}
+
+// connectOptions builds IMAP connection options from the loaded config.
+func connectOptions(cfg *config.Config) imap.ConnectOptions {
+	return imap.ConnectOptions{
+		Host:        cfg.IMAP.Host,
+		Port:        cfg.IMAP.Port,
+		Username:    cfg.IMAP.Username,
+		Password:    cfg.IMAP.Password,
+		TLS:         cfg.IMAP.TLS,
+		Logger:      Log,
+		AuthMethod:  cfg.IMAP.Auth.MethodOrDefault(),
+		AccessToken: cfg.IMAP.Auth.Token,
+		TokenFunc:   cfg.IMAP.Auth.AccessToken,
+		Proxy:       cfg.IMAP.Proxy,
+		KeepAlive:   cfg.IMAP.KeepAlive,
+		Compress:    cfg.IMAP.Compress,
+		MaxRetries:  cfg.IMAP.MaxRetries,
+		MaxBackoff:  cfg.IMAP.MaxBackoff,
+
+		ListReference:  cfg.IMAP.ListReference,
+		ListPattern:    cfg.IMAP.ListPattern,
+		SubscribedOnly: cfg.IMAP.SubscribedOnly,
+	}
+}
+
 func RunServer(cmd *cobra.Command, _ []string) error {
-	cfg, err := config.Load(CfgFile)
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	cfg, err := loadAccountConfig(cmd)
 	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
+		return err
 	}
 
-	store, err := storage.New(cfg.Storage.Path, Log, storage.WithReadOnly(true))
+	certFile, keyFile, err := serverTLSFiles(cmd, cfg)
+	if err != nil {
+		return err
+	}
+
+	// Flag changes and syncs are stored, so only they need a writable
+	// database.
+	liveFlags, liveSync := cfg.Server.LiveFlags, cfg.Server.LiveSync
+	writable := liveFlags || liveSync
+	store, err := storage.New(cfg.Storage.Path, Log, storageOptions(cfg, storage.WithReadOnly(!writable))...)
 	if err != nil {
 		return fmt.Errorf("failed to open storage: %w", err)
 	}
 	defer store.Close()
 
-	Log.Infof("Opened storage at: %s (read-only)", cfg.Storage.Path)
+	opts := []server.Option{
+		server.WithMetrics(cfg.Server.Metrics),
+		server.WithAPIToken(cfg.Server.APIToken),
+	}
+	if writable {
+		Log.Infof("Opened storage at: %s", cfg.Storage.Path)
+	} else {
+		Log.Infof("Opened storage at: %s (read-only)", cfg.Storage.Path)
+	}
+	if liveFlags {
+		Log.Infof("Connecting to IMAP server for flag changes: %s:%d", cfg.IMAP.Host, cfg.IMAP.Port)
+
+		client, err := imap.Connect(connectOptions(cfg))
+		if err != nil {
+			return fmt.Errorf("failed to connect to IMAP server: %w", err)
+		}
+		defer client.Close()
+		opts = append(opts, server.WithFlagClient(client))
+	}
+	if liveSync {
+		opts = append(opts, server.WithSync(func(ctx context.Context, progress func(syncer.ProgressEvent)) (*syncer.SyncReport, error) {
+			return serverSync(ctx, cfg, store, progress)
+		}))
+	}
 
-	srv := server.New(store, Log)
+	srv := server.New(store, Log, opts...)
 
 	addr, _ := cmd.Flags().GetString("addr")
-	return srv.Run(addr)
+	if certFile != "" {
+		return srv.RunTLSWithContext(ctx, addr, certFile, keyFile)
+	}
+	return srv.RunWithContext(ctx, addr)
+}
+
+// serverSync syncs every mailbox into the database serve has open, for a
+// sync started from the web UI. It connects separately from the flag
+// client, since a sync keeps mailboxes selected between commands.
+func serverSync(ctx context.Context, cfg *config.Config, store *storage.Storage, progress func(syncer.ProgressEvent)) (*syncer.SyncReport, error) {
+	maxSize, err := cfg.Sync.MaxMessageSizeBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := imap.Connect(connectOptions(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to IMAP server: %w", err)
+	}
+	defer client.Close()
+
+	s := syncer.New(client, store, Log,
+		syncer.WithGmailConfig(&cfg.Gmail),
+		syncer.WithPurgeAfterDays(cfg.Storage.PurgeAfterDaysOrDefault()),
+		syncer.WithBatchSize(cfg.Sync.BatchSizeOrDefault()),
+		syncer.WithMaxMessageSize(maxSize),
+		syncer.WithMailboxPolicies(mailboxPolicies(cfg)),
+		syncer.WithProgressCallback(progress),
+	)
+	return s.SyncAll(ctx)
+}
+
+// loadAccountConfig loads the config narrowed to the account selected with
+// --account, which may be omitted when the config has a single account.
+func loadAccountConfig(cmd *cobra.Command, opts ...config.LoadOption) (*config.Config, error) {
+	cfg, err := config.Load(CfgFile, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	name, _ := cmd.Flags().GetString("account")
+	if name == "" {
+		accounts := cfg.AccountsOrDefault()
+		if len(accounts) > 1 {
+			return nil, fmt.Errorf("config has %d accounts, select one with --account", len(accounts))
+		}
+		name = accounts[0].Name
+	}
+
+	account, err := cfg.Account(name)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.ForAccount(*account), nil
+}
+
+// dateFlag parses a date flag given as 2006-01-02 or RFC3339. An unset flag
+// yields the zero time.
+func dateFlag(cmd *cobra.Command, name string) (time.Time, error) {
+	value, _ := cmd.Flags().GetString(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --%s %q: use 2006-01-02 or RFC3339", name, value)
+	}
+	return t, nil
+}
+
+// serverTLSFiles returns the certificate and key to serve HTTPS with, taking
+// flags over config. Both are empty when TLS is disabled; setting only one
+// of the pair is an error.
+func serverTLSFiles(cmd *cobra.Command, cfg *config.Config) (certFile, keyFile string, err error) {
+	certFile = cfg.Server.TLSCert
+	keyFile = cfg.Server.TLSKey
+	if v, _ := cmd.Flags().GetString("tls-cert"); v != "" {
+		certFile = v
+	}
+	if v, _ := cmd.Flags().GetString("tls-key"); v != "" {
+		keyFile = v
+	}
+
+	if (certFile == "") != (keyFile == "") {
+		return "", "", fmt.Errorf("both a TLS certificate and key are required for HTTPS (--tls-cert/--tls-key or server.tls_cert/server.tls_key)")
+	}
+
+	return certFile, keyFile, nil
+}
+
+func RunExport(cmd *cobra.Command, _ []string) error {
+	cfg, err := loadAccountConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	format, _ := cmd.Flags().GetString("format")
+	mailbox, _ := cmd.Flags().GetString("mailbox")
+	out, _ := cmd.Flags().GetString("out")
+
+	if format != "mbox" && format != "maildir" {
+		return fmt.Errorf("unsupported export format: %s", format)
+	}
+	if out == "" {
+		return fmt.Errorf("--out is required")
+	}
+
+	store, err := storage.New(cfg.Storage.Path, Log, storageOptions(cfg, storage.WithReadOnly(true))...)
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	defer store.Close()
+
+	Log.Infof("Opened storage at: %s (read-only)", cfg.Storage.Path)
+
+	mailboxes, err := store.ListMailboxes()
+	if err != nil {
+		return fmt.Errorf("failed to list stored mailboxes: %w", err)
+	}
+	if !slices.Contains(mailboxes, mailbox) {
+		return fmt.Errorf("mailbox %q not found in storage", mailbox)
+	}
+
+	var stats *export.Stats
+	if format == "maildir" {
+		stats, err = export.Maildir(store, mailbox, out, Log)
+	} else {
+		stats, err = exportMboxFile(store, mailbox, out)
+	}
+	if err != nil {
+		return fmt.Errorf("export failed: %w", err)
+	}
+
+	Log.Infof("Exported %d messages from %s to %s (%d skipped)", stats.Exported, mailbox, out, stats.Skipped)
+	return nil
+}
+
+// exportMboxFile writes a mailbox to an mbox file, removing it on failure.
+func exportMboxFile(store *storage.Storage, mailbox, path string) (*export.Stats, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+
+	stats, err := export.Mbox(store, mailbox, f, Log)
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write output file: %w", closeErr)
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// RunConfigInit writes the starter config to the given path, or to the
+// --config path when none is given.
+func RunConfigInit(cmd *cobra.Command, args []string) error {
+	path := CfgFile
+	if len(args) > 0 {
+		path = args[0]
+	}
+	force, _ := cmd.Flags().GetBool("force")
+
+	if err := config.WriteTemplate(path, force); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Wrote %s; edit the imap and storage settings, then run: imapsync config validate -c %s\n", path, path)
+	return nil
+}
+
+// RunConfigValidate loads the config with the same checks as sync, reporting
+// every problem at once, without connecting to the server.
+func RunConfigValidate(cmd *cobra.Command, _ []string) error {
+	cfg, err := config.Load(CfgFile, config.WithWritableStorage())
+	if err != nil {
+		return fmt.Errorf("%s: %w", CfgFile, err)
+	}
+
+	accounts := cfg.AccountsOrDefault()
+	names := make([]string, len(accounts))
+	for i, a := range accounts {
+		names[i] = a.Name
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "%s is valid (accounts: %s)\n", CfgFile, strings.Join(names, ", "))
+	return nil
+}
+
+func RunStats(cmd *cobra.Command, _ []string) error {
+	if all, _ := cmd.Flags().GetBool("all-accounts"); all {
+		return runStatsAllAccounts(cmd)
+	}
+
+	cfg, err := loadAccountConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	store, err := storage.New(cfg.Storage.Path, Log, storageOptions(cfg, storage.WithReadOnly(true))...)
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	defer store.Close()
+
+	stats, err := store.Stats()
+	if err != nil {
+		return fmt.Errorf("failed to compute stats: %w", err)
+	}
+
+	out := cmd.OutOrStdout()
+
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stats)
+	}
+
+	printStats(out, cfg.Storage.Path, stats)
+	return nil
+}
+
+// accountStats is one element of the stats --all-accounts --json array.
+type accountStats struct {
+	Account string `json:"account"`
+	// Missing is set for an account whose database doesn't exist yet.
+	Missing bool `json:"missing,omitempty"`
+	*storage.StorageStats
+}
+
+// runStatsAllAccounts prints the stats of every account, opening the
+// database of each as a shard. Accounts never synced are listed as missing.
+func runStatsAllAccounts(cmd *cobra.Command) error {
+	if name, _ := cmd.Flags().GetString("account"); name != "" {
+		return fmt.Errorf("--all-accounts can't be used with --account")
+	}
+
+	cfg, err := config.Load(CfgFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	accounts := cfg.AccountsOrDefault()
+	shardList := make([]storage.Shard, len(accounts))
+	for i, account := range accounts {
+		shardList[i] = storage.Shard{
+			Name:    account.Name,
+			Path:    account.Storage.Path,
+			Options: storageOptions(cfg.ForAccount(account)),
+		}
+	}
+	shards, err := storage.OpenShards(shardList, Log)
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	defer shards.Close()
+
+	all := make([]accountStats, len(accounts))
+	for i, account := range accounts {
+		all[i].Account = account.Name
+		store, err := shards.Shard(account.Name)
+		if err != nil {
+			all[i].Missing = true
+			continue
+		}
+		if all[i].StorageStats, err = store.Stats(); err != nil {
+			return fmt.Errorf("failed to compute stats of account %s: %w", account.Name, err)
+		}
+	}
+
+	out := cmd.OutOrStdout()
+
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(all)
+	}
+
+	for i, stats := range all {
+		fmt.Fprintf(out, "Account:         %s\n", stats.Account)
+		if stats.Missing {
+			fmt.Fprintf(out, "Database:        %s (not synced yet)\n\n", accounts[i].Storage.Path)
+			continue
+		}
+		printStats(out, accounts[i].Storage.Path, stats.StorageStats)
+		fmt.Fprintln(out)
+	}
+
+	messages, err := shards.CountAllMessages()
+	if err != nil {
+		return fmt.Errorf("failed to count messages: %w", err)
+	}
+	mailboxes, err := shards.ListMailboxes()
+	if err != nil {
+		return fmt.Errorf("failed to list mailboxes: %w", err)
+	}
+	fmt.Fprintf(out, "Total:           %d messages in %d mailboxes across %d accounts\n", messages, len(mailboxes), len(all))
+	return nil
+}
+
+func printStats(w io.Writer, path string, stats *storage.StorageStats) {
+	fmt.Fprintf(w, "Database:        %s (%s)\n", path, formatBytes(stats.DatabaseSize))
+	fmt.Fprintf(w, "Messages:        %d in %d mailboxes\n", stats.Messages, len(stats.Mailboxes))
+	fmt.Fprintf(w, "Message size:    %s uncompressed, %s stored\n", formatBytes(stats.MessageSize), formatBytes(stats.CompressedSize))
+
+	if stats.Oldest != nil && stats.Newest != nil {
+		fmt.Fprintf(w, "Date range:      %s to %s\n", stats.Oldest.Format(time.DateOnly), stats.Newest.Format(time.DateOnly))
+	}
+
+	if len(stats.Mailboxes) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "MAILBOX\tMESSAGES\tSIZE")
+	for _, m := range stats.Mailboxes {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", m.Name, m.Messages, formatBytes(m.Size))
+	}
+	tw.Flush()
+}
+
+// formatBytes renders a byte count with a binary unit, e.g. "1.5 MiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
 }
@@ -1,8 +1,14 @@
 package app
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath"
 	"syscall"
@@ -11,10 +17,13 @@ import (
 
 	"github.com/emersion/go-imap/v2/imapserver"
 	"github.com/emersion/go-imap/v2/imapserver/imapmemserver"
+	"github.com/newsamples/imapsync/internal/config"
 	"github.com/newsamples/imapsync/internal/storage"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 func TestInitConfig_Verbose(t *testing.T) {
@@ -24,6 +33,89 @@ func TestInitConfig_Verbose(t *testing.T) {
 	InitConfig()
 }
 
+func TestInitConfig_Quiet(t *testing.T) {
+	defer func() {
+		require.NoError(t, RootCmd.PersistentFlags().Set("quiet", "false"))
+		require.NoError(t, RootCmd.PersistentFlags().Set("verbose", "false"))
+		InitConfig()
+	}()
+
+	require.NoError(t, RootCmd.PersistentFlags().Set("quiet", "true"))
+	InitConfig()
+	assert.Equal(t, logrus.ErrorLevel, Log.GetLevel())
+
+	require.NoError(t, RootCmd.PersistentFlags().Set("verbose", "true"))
+	InitConfig()
+	assert.Equal(t, logrus.ErrorLevel, Log.GetLevel(), "quiet wins over verbose")
+}
+
+func TestInitConfig_LogFormat(t *testing.T) {
+	old := CfgFile
+	CfgFile = filepath.Join(t.TempDir(), "missing.yaml")
+	defer func() {
+		CfgFile = old
+		InitConfig()
+	}()
+
+	require.NoError(t, RootCmd.PersistentFlags().Set("log-format", "json"))
+	InitConfig()
+	assert.IsType(t, &logrus.JSONFormatter{}, Log.Formatter)
+
+	require.NoError(t, RootCmd.PersistentFlags().Set("log-format", ""))
+	InitConfig()
+	assert.IsType(t, &logrus.TextFormatter{}, Log.Formatter)
+
+	// The config file applies unless the flags override it.
+	CfgFile = filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(CfgFile, []byte("log:\n  level: warn\n  format: json\n"), 0o600))
+	InitConfig()
+	assert.IsType(t, &logrus.JSONFormatter{}, Log.Formatter)
+	assert.Equal(t, logrus.WarnLevel, Log.GetLevel())
+
+	require.NoError(t, RootCmd.PersistentFlags().Set("verbose", "true"))
+	require.NoError(t, RootCmd.PersistentFlags().Set("log-format", "text"))
+	InitConfig()
+	require.NoError(t, RootCmd.PersistentFlags().Set("verbose", "false"))
+	require.NoError(t, RootCmd.PersistentFlags().Set("log-format", ""))
+	assert.IsType(t, &logrus.TextFormatter{}, Log.Formatter)
+	assert.Equal(t, logrus.DebugLevel, Log.GetLevel())
+}
+
+func TestConfigureLogger_File(t *testing.T) {
+	log := logrus.New()
+	defer configureLogger(log, config.LogConfig{}) //nolint:errcheck
+
+	path := filepath.Join(t.TempDir(), "imapsync.log")
+	require.NoError(t, configureLogger(log, config.LogConfig{File: path}))
+	log.Info("written to the file")
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "written to the file")
+
+	rotated := filepath.Join(t.TempDir(), "rotated.log")
+	require.NoError(t, configureLogger(log, config.LogConfig{File: rotated, MaxSizeMB: 1, MaxBackups: 2}))
+	assert.IsType(t, &lumberjack.Logger{}, log.Out)
+	log.Info("rotated by size")
+
+	data, err = os.ReadFile(rotated)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "rotated by size")
+
+	err = configureLogger(log, config.LogConfig{File: filepath.Join(t.TempDir(), "missing", "x.log")})
+	assert.ErrorContains(t, err, "failed to open log file")
+	assert.Equal(t, os.Stderr, log.Out)
+}
+
+func TestConfigureLogger_Invalid(t *testing.T) {
+	log := logrus.New()
+	err := configureLogger(log, config.LogConfig{Level: "loud", Format: "xml"})
+	assert.ErrorContains(t, err, `unknown log format "xml"`)
+	assert.ErrorContains(t, err, "loud")
+	assert.Equal(t, logrus.InfoLevel, log.GetLevel())
+	assert.IsType(t, &logrus.TextFormatter{}, log.Formatter)
+}
+
 func writeInvalidConfig(t *testing.T) string {
 	t.Helper()
 	f, err := os.CreateTemp("", "invalid-config-*.yaml")
@@ -115,12 +207,112 @@ func TestRunSync_IMAPConnectFail(t *testing.T) {
 	assert.Contains(t, err.Error(), "failed to connect to IMAP server")
 }
 
+func TestRunSync_DateRangeFlags(t *testing.T) {
+	old := CfgFile
+	CfgFile = writeValidConfig(t, "127.0.0.1", 1, filepath.Join(t.TempDir(), "test.db"))
+	defer func() { CfgFile = old }()
+
+	newCmd := func(since, before string) *cobra.Command {
+		cmd := &cobra.Command{}
+		cmd.Flags().String("since", since, "")
+		cmd.Flags().String("before", before, "")
+		return cmd
+	}
+
+	assert.ErrorContains(t, RunSync(newCmd("yesterday", ""), nil), `invalid --since "yesterday"`)
+	assert.ErrorContains(t, RunSync(newCmd("", "2024-13-01"), nil), `invalid --before "2024-13-01"`)
+	assert.ErrorContains(t, RunSync(newCmd("2024-06-01", "2024-01-01"), nil), "--before must be later than --since")
+	assert.ErrorContains(t, RunSync(newCmd("2024-01-01", "2024-01-01"), nil), "--before must be later than --since")
+}
+
+func TestRunSync_InvalidSizeFlags(t *testing.T) {
+	old := CfgFile
+	CfgFile = writeValidConfig(t, "127.0.0.1", 1, filepath.Join(t.TempDir(), "test.db"))
+	defer func() { CfgFile = old }()
+
+	cmd := &cobra.Command{}
+	cmd.Flags().String("max-size", "25XB", "")
+
+	assert.ErrorContains(t, RunSync(cmd, nil), `invalid --max-size: invalid size "25XB"`)
+
+	cmd = &cobra.Command{}
+	cmd.Flags().String("rate-limit", "fast", "")
+
+	assert.ErrorContains(t, RunSync(cmd, nil), `invalid --rate-limit: invalid size "fast"`)
+}
+
+func TestRunSync_InvalidProgressFormat(t *testing.T) {
+	old := CfgFile
+	CfgFile = writeValidConfig(t, "127.0.0.1", 1, filepath.Join(t.TempDir(), "test.db"))
+	defer func() { CfgFile = old }()
+
+	cmd := &cobra.Command{}
+	cmd.Flags().String("progress-format", "fancy", "")
+
+	assert.ErrorContains(t, RunSync(cmd, nil), `invalid --progress-format: invalid progress format "fancy"`)
+}
+
+func TestDateFlag(t *testing.T) {
+	parse := func(value string) (time.Time, error) {
+		cmd := &cobra.Command{}
+		cmd.Flags().String("since", value, "")
+		return dateFlag(cmd, "since")
+	}
+
+	got, err := parse("")
+	require.NoError(t, err)
+	assert.True(t, got.IsZero())
+
+	got, err = parse("2024-03-15")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC), got)
+
+	got, err = parse("2024-03-15T10:30:00+02:00")
+	require.NoError(t, err)
+	assert.True(t, got.Equal(time.Date(2024, 3, 15, 8, 30, 0, 0, time.UTC)))
+
+	_, err = parse("15/03/2024")
+	assert.ErrorContains(t, err, "use 2006-01-02 or RFC3339")
+}
+
+func TestServeMetrics(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+
+	// The address is taken.
+	require.Error(t, serveMetrics(context.Background(), addr))
+	require.NoError(t, l.Close())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, serveMetrics(ctx, addr))
+
+	resp, err := http.Get("http://" + addr + "/metrics")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), "imapsync_imap_reconnects_total")
+
+	cancel()
+	assert.Eventually(t, func() bool {
+		_, err := http.Get("http://" + addr + "/metrics")
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+}
+
 func TestRunSync_StorageFail(t *testing.T) {
 	host, port, cleanup := newMainTestServer(t)
 	defer cleanup()
 
+	// A directory passes the config check on its parent but can't be opened
+	// as a database.
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	require.NoError(t, os.Mkdir(dbPath, 0o755))
+
 	old := CfgFile
-	CfgFile = writeValidConfig(t, host, port, "/nonexistent_xyz/test.db")
+	CfgFile = writeValidConfig(t, host, port, dbPath)
 	defer func() { CfgFile = old }()
 
 	cmd := &cobra.Command{}
@@ -150,6 +342,162 @@ func TestRunSync_FullPath(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestRunSync_ReportFile(t *testing.T) {
+	host, port, cleanup := newMainTestServer(t)
+	defer cleanup()
+
+	old := CfgFile
+	CfgFile = writeValidConfig(t, host, port, filepath.Join(t.TempDir(), "test.db"))
+	defer func() { CfgFile = old }()
+
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	cmd := &cobra.Command{}
+	cmd.Flags().Bool("progress", false, "")
+	cmd.Flags().Bool("watch", false, "")
+	cmd.Flags().Duration("interval", 0, "")
+	cmd.Flags().String("report-file", reportPath, "")
+	require.NoError(t, RunSync(cmd, nil))
+
+	data, err := os.ReadFile(reportPath)
+	require.NoError(t, err)
+	var reports []map[string]any
+	require.NoError(t, json.Unmarshal(data, &reports))
+	require.Len(t, reports, 1)
+	assert.Equal(t, "default", reports[0]["account"])
+	assert.NotContains(t, reports[0], "error")
+	require.Len(t, reports[0]["mailboxes"], 1)
+	inbox := reports[0]["mailboxes"].([]any)[0].(map[string]any)
+	assert.Equal(t, "INBOX", inbox["mailbox"])
+	assert.Equal(t, float64(0), inbox["new_messages"])
+
+	require.NoError(t, cmd.Flags().Set("watch", "true"))
+	assert.ErrorContains(t, RunSync(cmd, nil), "--report-file can't be used with --watch")
+}
+
+func TestRunSync_ReportFileFailure(t *testing.T) {
+	old := CfgFile
+	CfgFile = writeValidConfig(t, "127.0.0.1", 1, filepath.Join(t.TempDir(), "test.db"))
+	defer func() { CfgFile = old }()
+
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	cmd := &cobra.Command{}
+	cmd.Flags().String("report-file", reportPath, "")
+	require.Error(t, RunSync(cmd, nil))
+
+	data, err := os.ReadFile(reportPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"error": "failed to connect to IMAP server`)
+}
+
+func TestRunSync_Accounts(t *testing.T) {
+	host, port, cleanup := newMainTestServer(t)
+	defer cleanup()
+
+	dir := t.TempDir()
+	goodDB := filepath.Join(dir, "good.db")
+	brokenDB := filepath.Join(dir, "broken.db")
+
+	f, err := os.CreateTemp("", "accounts-config-*.yaml")
+	require.NoError(t, err)
+	account := "  - name: %s\n    imap:\n      host: %q\n      port: %d\n      username: \"testuser\"\n      password: \"testpass\"\n    storage:\n      path: %q\n"
+	_, err = fmt.Fprintf(f, "accounts:\n"+account+account, "broken", host, 1, brokenDB, "good", host, port, goodDB)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	t.Cleanup(func() { os.Remove(f.Name()) })
+
+	old := CfgFile
+	CfgFile = f.Name()
+	defer func() { CfgFile = old }()
+
+	newCmd := func(account string) *cobra.Command {
+		cmd := &cobra.Command{}
+		cmd.Flags().String("account", account, "")
+		cmd.Flags().Bool("watch", false, "")
+		return cmd
+	}
+
+	t.Run("all accounts", func(t *testing.T) {
+		err := RunSync(newCmd(""), nil)
+		assert.ErrorContains(t, err, "account broken: failed to connect to IMAP server")
+
+		// The failing account doesn't prevent the next one from syncing.
+		s, err := storage.New(goodDB, Log, storage.WithReadOnly(true))
+		require.NoError(t, err)
+		defer s.Close()
+		mailboxes, err := s.ListMailboxes()
+		require.NoError(t, err)
+		assert.Contains(t, mailboxes, "INBOX")
+	})
+
+	t.Run("single account", func(t *testing.T) {
+		assert.NoError(t, RunSync(newCmd("good"), nil))
+		assert.ErrorContains(t, RunSync(newCmd("missing"), nil), `account "missing" not found`)
+	})
+
+	t.Run("watch needs one account", func(t *testing.T) {
+		cmd := newCmd("")
+		require.NoError(t, cmd.Flags().Set("watch", "true"))
+		assert.ErrorContains(t, RunSync(cmd, nil), "select one with --account")
+	})
+
+	t.Run("other commands need --account", func(t *testing.T) {
+		cmd := newCmd("")
+		cmd.Flags().Bool("json", true, "")
+		assert.ErrorContains(t, RunStats(cmd, nil), "config has 2 accounts, select one with --account")
+
+		var out bytes.Buffer
+		cmd = newCmd("good")
+		cmd.Flags().Bool("json", true, "")
+		cmd.SetOut(&out)
+		require.NoError(t, RunStats(cmd, nil))
+		assert.Contains(t, out.String(), `"database_size"`)
+	})
+
+	t.Run("stats of all accounts", func(t *testing.T) {
+		var out bytes.Buffer
+		cmd := newCmd("")
+		cmd.Flags().Bool("json", false, "")
+		cmd.Flags().Bool("all-accounts", true, "")
+		cmd.SetOut(&out)
+		require.NoError(t, RunStats(cmd, nil))
+		assert.Contains(t, out.String(), "Account:         broken\nDatabase:        "+brokenDB+" (not synced yet)", "the broken account was never synced")
+		assert.Contains(t, out.String(), "Total:           0 messages in 1 mailboxes across 2 accounts")
+		assert.NoFileExists(t, brokenDB)
+
+		out.Reset()
+		require.NoError(t, cmd.Flags().Set("json", "true"))
+		require.NoError(t, RunStats(cmd, nil))
+		var stats []accountStats
+		require.NoError(t, json.Unmarshal(out.Bytes(), &stats))
+		require.Len(t, stats, 2)
+		assert.Equal(t, accountStats{Account: "broken", Missing: true}, stats[0])
+
+		out.Reset()
+		require.NoError(t, cmd.Flags().Set("json", "false"))
+		s, err := storage.New(brokenDB, Log)
+		require.NoError(t, err)
+		require.NoError(t, s.Close())
+		require.NoError(t, RunStats(cmd, nil))
+		assert.Contains(t, out.String(), "Account:         broken\nDatabase:        "+brokenDB)
+		assert.Contains(t, out.String(), "Account:         good\nDatabase:        "+goodDB)
+		assert.Contains(t, out.String(), "Total:           0 messages in 1 mailboxes across 2 accounts", "the INBOX of good")
+
+		out.Reset()
+		require.NoError(t, cmd.Flags().Set("json", "true"))
+		require.NoError(t, RunStats(cmd, nil))
+		stats = nil
+		require.NoError(t, json.Unmarshal(out.Bytes(), &stats))
+		require.Len(t, stats, 2)
+		assert.False(t, stats[0].Missing)
+		assert.Equal(t, "good", stats[1].Account)
+		assert.NotZero(t, stats[1].DatabaseSize)
+
+		cmd = newCmd("good")
+		cmd.Flags().Bool("all-accounts", true, "")
+		assert.ErrorContains(t, RunStats(cmd, nil), "--all-accounts can't be used with --account")
+	})
+}
+
 func TestRunServer_StorageFail(t *testing.T) {
 	host, port, cleanup := newMainTestServer(t)
 	defer cleanup()
@@ -186,6 +534,64 @@ func TestRunServer_InvalidAddr(t *testing.T) {
 	assert.Error(t, runErr)
 }
 
+func TestRunServer_TLSPairRequired(t *testing.T) {
+	host, port, cleanup := newMainTestServer(t)
+	defer cleanup()
+
+	dbPath := filepath.Join(t.TempDir(), "server.db")
+
+	t.Run("flag", func(t *testing.T) {
+		old := CfgFile
+		CfgFile = writeValidConfig(t, host, port, dbPath)
+		defer func() { CfgFile = old }()
+
+		cmd := &cobra.Command{}
+		cmd.Flags().String("addr", "127.0.0.1:0", "")
+		cmd.Flags().String("tls-cert", "cert.pem", "")
+		cmd.Flags().String("tls-key", "", "")
+
+		err := RunServer(cmd, nil)
+		assert.ErrorContains(t, err, "both a TLS certificate and key are required")
+	})
+
+	t.Run("config", func(t *testing.T) {
+		f, err := os.CreateTemp("", "tls-config-*.yaml")
+		require.NoError(t, err)
+		_, err = fmt.Fprintf(f, "imap:\n  host: %q\n  port: %d\n  username: \"testuser\"\n  password: \"testpass\"\nstorage:\n  path: %q\nserver:\n  tls_key: key.pem\n", host, port, dbPath)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		t.Cleanup(func() { os.Remove(f.Name()) })
+
+		old := CfgFile
+		CfgFile = f.Name()
+		defer func() { CfgFile = old }()
+
+		cmd := &cobra.Command{}
+		cmd.Flags().String("addr", "127.0.0.1:0", "")
+
+		err = RunServer(cmd, nil)
+		assert.ErrorContains(t, err, "both a TLS certificate and key are required")
+	})
+
+	t.Run("invalid cert files", func(t *testing.T) {
+		s, err := storage.New(dbPath, Log)
+		require.NoError(t, err)
+		s.Close()
+
+		old := CfgFile
+		CfgFile = writeValidConfig(t, host, port, dbPath)
+		defer func() { CfgFile = old }()
+
+		dir := t.TempDir()
+		cmd := &cobra.Command{}
+		cmd.Flags().String("addr", "127.0.0.1:0", "")
+		cmd.Flags().String("tls-cert", filepath.Join(dir, "cert.pem"), "")
+		cmd.Flags().String("tls-key", filepath.Join(dir, "key.pem"), "")
+
+		assert.Error(t, RunServer(cmd, nil))
+	})
+}
+
 func TestRunSync_WatchMode(t *testing.T) {
 	host, port, cleanup := newMainTestServer(t)
 	defer cleanup()
@@ -207,3 +613,677 @@ func TestRunSync_WatchMode(t *testing.T) {
 	err := RunSync(cmd, nil)
 	assert.NoError(t, err)
 }
+
+func TestRunRestore_MissingConfig(t *testing.T) {
+	old := CfgFile
+	CfgFile = writeInvalidConfig(t)
+	defer func() { CfgFile = old }()
+
+	cmd := &cobra.Command{}
+	cmd.Flags().String("mailbox", "", "")
+	cmd.Flags().Bool("dry-run", false, "")
+
+	err := RunRestore(cmd, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to load config")
+}
+
+func TestRunRestore_FullPath(t *testing.T) {
+	host, port, cleanup := newMainTestServer(t)
+	defer cleanup()
+
+	dbPath := filepath.Join(t.TempDir(), "restore.db")
+	s, err := storage.New(dbPath, Log)
+	require.NoError(t, err)
+	require.NoError(t, s.SaveMailboxState(&storage.MailboxState{Name: "Archive", UIDValidity: 1, LastUID: 1}))
+	require.NoError(t, s.SaveEmail(&storage.Email{
+		UID:        1,
+		Mailbox:    "Archive",
+		Subject:    "Restore me",
+		Date:       time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		RawMessage: []byte("Message-ID: <restore@example.com>\r\nSubject: Restore me\r\n\r\nBody."),
+	}))
+	s.Close()
+
+	old := CfgFile
+	CfgFile = writeValidConfig(t, host, port, dbPath)
+	defer func() { CfgFile = old }()
+
+	cmd := &cobra.Command{}
+	cmd.Flags().String("mailbox", "", "")
+	cmd.Flags().Bool("dry-run", false, "")
+
+	assert.NoError(t, RunRestore(cmd, nil))
+}
+
+func TestRunWatch_MissingConfig(t *testing.T) {
+	old := CfgFile
+	CfgFile = writeInvalidConfig(t)
+	defer func() { CfgFile = old }()
+
+	cmd := &cobra.Command{}
+	cmd.Flags().String("mailbox", "INBOX", "")
+	cmd.Flags().Duration("interval", time.Minute, "")
+
+	err := RunWatch(cmd, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to load config")
+}
+
+func TestRunWatch_UntilInterrupted(t *testing.T) {
+	host, port, cleanup := newMainTestServer(t)
+	defer cleanup()
+
+	old := CfgFile
+	CfgFile = writeValidConfig(t, host, port, filepath.Join(t.TempDir(), "test.db"))
+	defer func() { CfgFile = old }()
+
+	cmd := &cobra.Command{}
+	cmd.Flags().String("mailbox", "INBOX", "")
+	cmd.Flags().Duration("interval", time.Minute, "")
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		_ = syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+	}()
+
+	err := RunWatch(cmd, nil)
+	assert.NoError(t, err)
+}
+
+func TestRunExport(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "export.db")
+	s, err := storage.New(dbPath, Log)
+	require.NoError(t, err)
+	require.NoError(t, s.SaveMailboxState(&storage.MailboxState{Name: "INBOX", UIDValidity: 1, LastUID: 1}))
+	require.NoError(t, s.SaveEmail(&storage.Email{
+		UID:        1,
+		Mailbox:    "INBOX",
+		From:       "sender@example.com",
+		Date:       time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		RawMessage: []byte("Subject: Export me\r\n\r\nBody."),
+	}))
+	s.Close()
+
+	old := CfgFile
+	CfgFile = writeValidConfig(t, "127.0.0.1", 993, dbPath)
+	defer func() { CfgFile = old }()
+
+	newCmd := func(format, mailbox, out string) *cobra.Command {
+		cmd := &cobra.Command{}
+		cmd.Flags().String("format", format, "")
+		cmd.Flags().String("mailbox", mailbox, "")
+		cmd.Flags().String("out", out, "")
+		return cmd
+	}
+
+	t.Run("mbox", func(t *testing.T) {
+		out := filepath.Join(dir, "inbox.mbox")
+		require.NoError(t, RunExport(newCmd("mbox", "INBOX", out), nil))
+
+		data, err := os.ReadFile(out)
+		require.NoError(t, err)
+		assert.Equal(t, "From sender@example.com Wed May  1 10:00:00 2024\nSubject: Export me\n\nBody.\n\n", string(data))
+	})
+
+	t.Run("maildir", func(t *testing.T) {
+		out := filepath.Join(dir, "maildir")
+		require.NoError(t, RunExport(newCmd("maildir", "INBOX", out), nil))
+
+		entries, err := os.ReadDir(filepath.Join(out, "cur"))
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		assert.Regexp(t, `:2,$`, entries[0].Name())
+	})
+
+	t.Run("unknown mailbox", func(t *testing.T) {
+		out := filepath.Join(dir, "missing.mbox")
+		err := RunExport(newCmd("mbox", "Missing", out), nil)
+		assert.ErrorContains(t, err, `mailbox "Missing" not found`)
+		assert.NoFileExists(t, out)
+	})
+
+	t.Run("unsupported format", func(t *testing.T) {
+		err := RunExport(newCmd("pst", "INBOX", filepath.Join(dir, "x")), nil)
+		assert.ErrorContains(t, err, "unsupported export format")
+	})
+
+	t.Run("missing out", func(t *testing.T) {
+		assert.ErrorContains(t, RunExport(newCmd("mbox", "INBOX", ""), nil), "--out is required")
+	})
+}
+
+func TestRunImport(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "import.db")
+	mbox := filepath.Join(dir, "old.mbox")
+	require.NoError(t, os.WriteFile(mbox, []byte("From a@example.com Wed May  1 10:00:00 2024\nSubject: One\n\n1\n\n"+
+		"From b@example.com Thu May  2 10:00:00 2024\nSubject: Two\n\n2\n"), 0o600))
+
+	old := CfgFile
+	CfgFile = writeValidConfig(t, "127.0.0.1", 993, dbPath)
+	defer func() { CfgFile = old }()
+
+	newCmd := func(format, mailbox string) *cobra.Command {
+		cmd := &cobra.Command{}
+		cmd.Flags().String("format", format, "")
+		cmd.Flags().String("mailbox", mailbox, "")
+		return cmd
+	}
+
+	require.NoError(t, RunImport(newCmd("mbox", "Archive"), []string{mbox}))
+
+	s, err := storage.New(dbPath, Log, storage.WithReadOnly(true))
+	require.NoError(t, err)
+	defer s.Close()
+	count, err := s.CountMessages("Archive")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	t.Run("configured compression", func(t *testing.T) {
+		dbPath := filepath.Join(dir, "plain.db")
+		eml := filepath.Join(dir, "one.eml")
+		raw := "From: a@example.com\r\nSubject: Plain\r\n\r\nStored as is.\r\n"
+		require.NoError(t, os.WriteFile(eml, []byte(raw), 0o600))
+
+		old := CfgFile
+		CfgFile = writeValidConfig(t, "127.0.0.1", 993, dbPath)
+		defer func() { CfgFile = old }()
+		f, err := os.OpenFile(CfgFile, os.O_APPEND|os.O_WRONLY, 0)
+		require.NoError(t, err)
+		_, err = f.WriteString("  compression: none\n")
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		require.NoError(t, RunImport(newCmd("eml", "Archive"), []string{eml}))
+
+		db, err := sql.Open("sqlite", dbPath)
+		require.NoError(t, err)
+		defer db.Close()
+		var blob []byte
+		require.NoError(t, db.QueryRow(`SELECT raw_message FROM email_content WHERE mailbox = 'Archive'`).Scan(&blob))
+		assert.Equal(t, append([]byte{0x00}, raw...), blob, "uncompressed, behind the none marker")
+	})
+
+	assert.ErrorContains(t, RunImport(newCmd("pst", "Archive"), []string{mbox}), "unsupported import format")
+	assert.ErrorContains(t, RunImport(newCmd("mbox", ""), []string{mbox}), "--mailbox is required")
+	assert.ErrorContains(t, RunImport(newCmd("mbox", "Archive"), []string{filepath.Join(dir, "missing.mbox")}), "import failed")
+}
+
+func TestVersion(t *testing.T) {
+	oldVersion, oldCommit, oldDate := Version, Commit, BuildDate
+	defer func() { Version, Commit, BuildDate = oldVersion, oldCommit, oldDate }()
+
+	SetBuildInfo("1.2.3", "", "2024-05-01")
+	assert.Equal(t, "imapsync 1.2.3 (commit none, built 2024-05-01)", VersionString())
+
+	t.Run("command", func(t *testing.T) {
+		var out bytes.Buffer
+		cmd := &cobra.Command{}
+		cmd.SetOut(&out)
+
+		require.NoError(t, RunVersion(cmd, nil))
+		assert.Equal(t, "imapsync 1.2.3 (commit none, built 2024-05-01)\n", out.String())
+	})
+
+	t.Run("flag", func(t *testing.T) {
+		oldExit := exit
+		defer func() { exit = oldExit }()
+
+		code := -1
+		exit = func(c int) { code = c }
+
+		var out bytes.Buffer
+		cmd := &cobra.Command{}
+		cmd.Flags().Bool("version", true, "")
+		cmd.SetOut(&out)
+
+		require.NoError(t, handleVersionFlag(cmd, nil))
+		assert.Equal(t, 0, code)
+		assert.Contains(t, out.String(), "imapsync 1.2.3")
+
+		code = -1
+		cmd = &cobra.Command{}
+		cmd.Flags().Bool("version", false, "")
+		require.NoError(t, handleVersionFlag(cmd, nil))
+		assert.Equal(t, -1, code, "no exit without the flag")
+	})
+}
+
+func TestRunStats(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "stats.db")
+	s, err := storage.New(dbPath, Log)
+	require.NoError(t, err)
+	require.NoError(t, s.SaveEmailBatch([]*storage.Email{
+		{UID: 1, Mailbox: "INBOX", Date: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC), Size: 2048, Flags: []string{}},
+		{UID: 1, Mailbox: "Sent", Date: time.Date(2023, 4, 5, 0, 0, 0, 0, time.UTC), Size: 100, Flags: []string{}},
+	}))
+	s.Close()
+
+	old := CfgFile
+	CfgFile = writeValidConfig(t, "127.0.0.1", 993, dbPath)
+	defer func() { CfgFile = old }()
+
+	newCmd := func(asJSON bool) (*cobra.Command, *bytes.Buffer) {
+		var out bytes.Buffer
+		cmd := &cobra.Command{}
+		cmd.Flags().Bool("json", asJSON, "")
+		cmd.SetOut(&out)
+		return cmd, &out
+	}
+
+	t.Run("human readable", func(t *testing.T) {
+		cmd, out := newCmd(false)
+		require.NoError(t, RunStats(cmd, nil))
+		assert.Contains(t, out.String(), "Messages:        2 in 2 mailboxes")
+		assert.Contains(t, out.String(), "Date range:      2020-01-02 to 2023-04-05")
+		assert.Regexp(t, `INBOX\s+1\s+2.0 KiB`, out.String())
+		assert.Regexp(t, `Sent\s+1\s+100 B`, out.String())
+	})
+
+	t.Run("json", func(t *testing.T) {
+		cmd, out := newCmd(true)
+		require.NoError(t, RunStats(cmd, nil))
+
+		var stats storage.StorageStats
+		require.NoError(t, json.Unmarshal(out.Bytes(), &stats))
+		assert.Equal(t, 2, stats.Messages)
+		assert.Len(t, stats.Mailboxes, 2)
+		assert.Equal(t, int64(2148), stats.MessageSize)
+	})
+
+	t.Run("missing config", func(t *testing.T) {
+		CfgFile = writeInvalidConfig(t)
+		cmd, _ := newCmd(false)
+		assert.ErrorContains(t, RunStats(cmd, nil), "failed to load config")
+	})
+}
+
+func TestRunConfigValidate(t *testing.T) {
+	old := CfgFile
+	defer func() { CfgFile = old }()
+
+	t.Run("valid", func(t *testing.T) {
+		CfgFile = writeValidConfig(t, "127.0.0.1", 1, filepath.Join(t.TempDir(), "test.db"))
+
+		var out bytes.Buffer
+		cmd := &cobra.Command{}
+		cmd.SetOut(&out)
+		require.NoError(t, RunConfigValidate(cmd, nil))
+		assert.Equal(t, CfgFile+" is valid (accounts: default)\n", out.String())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		CfgFile = writeValidConfig(t, "", -1, filepath.Join(t.TempDir(), "missing", "test.db"))
+
+		err := RunConfigValidate(&cobra.Command{}, nil)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "imap.host is required")
+		assert.Contains(t, err.Error(), "imap.port must be between 1 and 65535, got -1")
+		assert.Contains(t, err.Error(), "is not writable")
+	})
+}
+
+func TestRunConfigInit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+
+	newCmd := func(force bool) (*cobra.Command, *bytes.Buffer) {
+		var out bytes.Buffer
+		cmd := &cobra.Command{}
+		cmd.Flags().Bool("force", force, "")
+		cmd.SetOut(&out)
+		return cmd, &out
+	}
+
+	cmd, out := newCmd(false)
+	require.NoError(t, RunConfigInit(cmd, []string{path}))
+	assert.Contains(t, out.String(), "Wrote "+path)
+
+	cmd, _ = newCmd(false)
+	assert.ErrorContains(t, RunConfigInit(cmd, []string{path}), "already exists")
+
+	cmd, _ = newCmd(true)
+	require.NoError(t, RunConfigInit(cmd, []string{path}))
+
+	// Without an argument the --config path is used.
+	old := CfgFile
+	CfgFile = filepath.Join(t.TempDir(), "default.yaml")
+	defer func() { CfgFile = old }()
+
+	cmd, _ = newCmd(false)
+	require.NoError(t, RunConfigInit(cmd, nil))
+	assert.FileExists(t, CfgFile)
+}
+
+func TestRunPrune(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "prune.db")
+	s, err := storage.New(dbPath, Log)
+	require.NoError(t, err)
+	old := time.Now().AddDate(-3, 0, 0)
+	require.NoError(t, s.SaveEmailBatch([]*storage.Email{
+		{UID: 1, Mailbox: "INBOX", Date: old, Flags: []string{}},
+		{UID: 2, Mailbox: "INBOX", Date: time.Now(), Flags: []string{}},
+		{UID: 1, Mailbox: "Sent", Date: old, Flags: []string{}},
+	}))
+	for _, mailbox := range []string{"INBOX", "Sent"} {
+		require.NoError(t, s.SaveMailboxState(&storage.MailboxState{Name: mailbox}))
+	}
+	s.Close()
+
+	oldCfg := CfgFile
+	CfgFile = writeValidConfig(t, "127.0.0.1", 993, dbPath)
+	defer func() { CfgFile = oldCfg }()
+
+	newCmd := func(olderThan, mailbox string, yes bool, input string) (*cobra.Command, *bytes.Buffer) {
+		var out bytes.Buffer
+		cmd := &cobra.Command{}
+		cmd.Flags().String("older-than", olderThan, "")
+		cmd.Flags().String("mailbox", mailbox, "")
+		cmd.Flags().Bool("vacuum", true, "")
+		cmd.Flags().Bool("yes", yes, "")
+		cmd.SetOut(&out)
+		cmd.SetIn(bytes.NewBufferString(input))
+		return cmd, &out
+	}
+
+	remaining := func() map[string][]uint32 {
+		s, err := storage.New(dbPath, Log)
+		require.NoError(t, err)
+		defer s.Close()
+		result := map[string][]uint32{}
+		for _, mailbox := range []string{"INBOX", "Sent"} {
+			uids, err := s.ListLiveUIDs(mailbox)
+			require.NoError(t, err)
+			result[mailbox] = uids
+		}
+		return result
+	}
+
+	cmd, _ := newCmd("", "", true, "")
+	assert.ErrorContains(t, RunPrune(cmd, nil), "--older-than is required")
+
+	cmd, _ = newCmd("2y", "Drafts", true, "")
+	assert.ErrorContains(t, RunPrune(cmd, nil), `mailbox "Drafts" not found`)
+
+	cmd, _ = newCmd("2y", "INBOX", false, "n\n")
+	assert.ErrorContains(t, RunPrune(cmd, nil), "prune aborted")
+	assert.Len(t, remaining()["INBOX"], 2)
+
+	cmd, out := newCmd("2y", "INBOX", false, "y\n")
+	require.NoError(t, RunPrune(cmd, nil))
+	assert.Contains(t, out.String(), "[y/N]")
+	assert.Contains(t, out.String(), "Deleted 1 messages")
+	assert.Equal(t, map[string][]uint32{"INBOX": {2}, "Sent": {1}}, remaining())
+
+	cmd, out = newCmd("2y", "", true, "")
+	require.NoError(t, RunPrune(cmd, nil))
+	assert.NotContains(t, out.String(), "[y/N]")
+	assert.Contains(t, out.String(), "Deleted 1 messages")
+	assert.Empty(t, remaining()["Sent"])
+}
+
+func TestRunDeleteMailbox(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "delete.db")
+	s, err := storage.New(dbPath, Log)
+	require.NoError(t, err)
+	require.NoError(t, s.SaveEmailBatch([]*storage.Email{
+		{UID: 1, Mailbox: "INBOX", Flags: []string{}},
+		{UID: 1, Mailbox: "Sent", Flags: []string{}},
+		{UID: 2, Mailbox: "Sent", Flags: []string{}},
+	}))
+	for _, mailbox := range []string{"INBOX", "Sent"} {
+		require.NoError(t, s.SaveMailboxState(&storage.MailboxState{Name: mailbox}))
+	}
+	s.Close()
+
+	oldCfg := CfgFile
+	CfgFile = writeValidConfig(t, "127.0.0.1", 993, dbPath)
+	defer func() { CfgFile = oldCfg }()
+
+	newCmd := func(yes bool, input string) (*cobra.Command, *bytes.Buffer) {
+		var out bytes.Buffer
+		cmd := &cobra.Command{}
+		cmd.Flags().Bool("yes", yes, "")
+		cmd.SetOut(&out)
+		cmd.SetIn(bytes.NewBufferString(input))
+		return cmd, &out
+	}
+
+	mailboxes := func() []string {
+		s, err := storage.New(dbPath, Log)
+		require.NoError(t, err)
+		defer s.Close()
+		mailboxes, err := s.ListMailboxes()
+		require.NoError(t, err)
+		return mailboxes
+	}
+
+	cmd, _ := newCmd(true, "")
+	assert.ErrorContains(t, RunDeleteMailbox(cmd, []string{"Drafts"}), `mailbox "Drafts" not found`)
+
+	cmd, _ = newCmd(false, "\n")
+	assert.ErrorContains(t, RunDeleteMailbox(cmd, []string{"Sent"}), "delete-mailbox aborted")
+	assert.Equal(t, []string{"INBOX", "Sent"}, mailboxes())
+
+	cmd, out := newCmd(false, "yes\n")
+	require.NoError(t, RunDeleteMailbox(cmd, []string{"Sent"}))
+	assert.Contains(t, out.String(), "[y/N]")
+	assert.Contains(t, out.String(), "Deleted mailbox Sent with 2 messages")
+	assert.Equal(t, []string{"INBOX"}, mailboxes())
+}
+
+func TestRunCompact(t *testing.T) {
+	old := CfgFile
+	defer func() { CfgFile = old }()
+
+	CfgFile = writeValidConfig(t, "127.0.0.1", 993, filepath.Join(t.TempDir(), "compact.db"))
+	require.NoError(t, RunCompact(&cobra.Command{}, nil))
+
+	CfgFile = writeInvalidConfig(t)
+	assert.ErrorContains(t, RunCompact(&cobra.Command{}, nil), "failed to load config")
+}
+
+func TestRunDedup(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "dedup.db")
+	s, err := storage.New(dbPath, Log)
+	require.NoError(t, err)
+	raw := []byte("Message-ID: <dup@example.com>\r\n\r\nbody")
+	require.NoError(t, s.SaveEmailBatch([]*storage.Email{
+		{UID: 1, Mailbox: "INBOX", Size: 3000, RawMessage: raw, Flags: []string{}},
+		{UID: 4, Mailbox: "[Gmail]/All Mail", Size: 3000, RawMessage: raw, Flags: []string{}},
+		{UID: 2, Mailbox: "INBOX", Size: 10, RawMessage: []byte("Message-ID: <solo@example.com>\r\n\r\nx"), Flags: []string{}},
+	}))
+	s.Close()
+
+	old := CfgFile
+	CfgFile = writeValidConfig(t, "127.0.0.1", 993, dbPath)
+	defer func() { CfgFile = old }()
+
+	var out bytes.Buffer
+	cmd := &cobra.Command{}
+	cmd.Flags().Bool("list", true, "")
+	cmd.SetOut(&out)
+	require.NoError(t, RunDedup(cmd, nil))
+	assert.Contains(t, out.String(), "1 messages are stored more than once: 1 extra copies using 2.9 KiB")
+	assert.Regexp(t, `<dup@example.com>\s+2\s+2.9 KiB\s+INBOX:1, \[Gmail\]/All Mail:4`, out.String())
+	assert.NotContains(t, out.String(), "solo@example.com")
+}
+
+func TestRunSearch(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "search.db")
+	s, err := storage.New(dbPath, Log)
+	require.NoError(t, err)
+	require.NoError(t, s.SaveEmailBatch([]*storage.Email{
+		{UID: 1, Mailbox: "INBOX", Subject: "Quarterly report", From: "boss@example.com", Flags: []string{}},
+		{UID: 2, Mailbox: "INBOX", Subject: "Lunch", From: "friend@example.com", Flags: []string{}},
+		{UID: 3, Mailbox: "Archive", Subject: "Last quarterly report", From: "boss@example.com", Flags: []string{}},
+	}))
+	s.Close()
+
+	old := CfgFile
+	CfgFile = writeValidConfig(t, "127.0.0.1", 993, dbPath)
+	defer func() { CfgFile = old }()
+
+	newCmd := func(mailbox string, asJSON bool) (*cobra.Command, *bytes.Buffer) {
+		var out bytes.Buffer
+		cmd := &cobra.Command{}
+		cmd.Flags().String("mailbox", mailbox, "")
+		cmd.Flags().Int("limit", 20, "")
+		cmd.Flags().Bool("json", asJSON, "")
+		cmd.SetOut(&out)
+		return cmd, &out
+	}
+
+	t.Run("table", func(t *testing.T) {
+		cmd, out := newCmd("INBOX", false)
+		require.NoError(t, RunSearch(cmd, []string{"quarterly"}))
+		assert.Regexp(t, `1\s+\S+ \S+\s+boss@example.com\s+Quarterly report`, out.String())
+		assert.NotContains(t, out.String(), "Last quarterly report", "other mailboxes aren't searched")
+		assert.NotContains(t, out.String(), "Lunch")
+	})
+
+	t.Run("all mailboxes", func(t *testing.T) {
+		cmd, out := newCmd("", false)
+		require.NoError(t, RunSearch(cmd, []string{"quarterly"}))
+		assert.Regexp(t, `Archive\s+3\s+`, out.String())
+		assert.Regexp(t, `INBOX\s+1\s+`, out.String())
+	})
+
+	t.Run("json", func(t *testing.T) {
+		cmd, out := newCmd("", true)
+		require.NoError(t, RunSearch(cmd, []string{"lunch"}))
+
+		var emails []storage.Email
+		require.NoError(t, json.Unmarshal(out.Bytes(), &emails))
+		require.Len(t, emails, 1)
+		assert.Equal(t, "Lunch", emails[0].Subject)
+	})
+
+	t.Run("no matches", func(t *testing.T) {
+		cmd, out := newCmd("", false)
+		require.NoError(t, RunSearch(cmd, []string{"invoice"}))
+		assert.Equal(t, "No messages match \"invoice\"\n", out.String())
+	})
+}
+
+func TestRunVerify(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "verify.db")
+	s, err := storage.New(dbPath, Log)
+	require.NoError(t, err)
+	require.NoError(t, s.SaveEmail(&storage.Email{UID: 1, Mailbox: "INBOX", RawMessage: []byte("Subject: x\r\n\r\nbody"), Flags: []string{}}))
+	s.Close()
+
+	old := CfgFile
+	CfgFile = writeValidConfig(t, "127.0.0.1", 993, dbPath)
+	defer func() { CfgFile = old }()
+
+	var out bytes.Buffer
+	cmd := &cobra.Command{}
+	cmd.SetOut(&out)
+	require.NoError(t, RunVerify(cmd, nil))
+	assert.Contains(t, out.String(), "no problems found")
+
+	db, err := sql.Open("sqlite", dbPath)
+	require.NoError(t, err)
+	_, err = db.Exec(`UPDATE email_content SET body = x'01ffff'`)
+	require.NoError(t, err)
+	db.Close()
+
+	out.Reset()
+	assert.ErrorContains(t, RunVerify(cmd, nil), "found 1 problems")
+	assert.Contains(t, out.String(), "INBOX UID 1: body: ")
+}
+
+func TestRunQuota_Unsupported(t *testing.T) {
+	host, port, cleanup := newMainTestServer(t)
+	defer cleanup()
+
+	old := CfgFile
+	CfgFile = writeValidConfig(t, host, port, filepath.Join(t.TempDir(), "quota.db"))
+	defer func() { CfgFile = old }()
+
+	var out bytes.Buffer
+	cmd := &cobra.Command{}
+	cmd.SetOut(&out)
+	require.NoError(t, RunQuota(cmd, nil))
+	assert.Equal(t, host+" doesn't report quotas\n", out.String())
+}
+
+func TestAgeCutoff(t *testing.T) {
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		age  string
+		want time.Time
+	}{
+		{"10d", time.Date(2024, 3, 21, 12, 0, 0, 0, time.UTC)},
+		{"2w", time.Date(2024, 3, 17, 12, 0, 0, 0, time.UTC)},
+		{"1m", time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC)},
+		{"2y", time.Date(2022, 3, 31, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := ageCutoff(tt.age, now)
+		require.NoError(t, err, tt.age)
+		assert.Equal(t, tt.want, got, tt.age)
+	}
+
+	for _, age := range []string{"", "y", "2", "0d", "-1y", "2h", "1.5y"} {
+		_, err := ageCutoff(age, now)
+		assert.Error(t, err, age)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "0 B", formatBytes(0))
+	assert.Equal(t, "1023 B", formatBytes(1023))
+	assert.Equal(t, "1.0 KiB", formatBytes(1024))
+	assert.Equal(t, "1.5 MiB", formatBytes(3*1024*1024/2))
+	assert.Equal(t, "2.0 GiB", formatBytes(2<<30))
+}
+
+func TestRunMailboxes(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "mailboxes.db")
+	s, err := storage.New(dbPath, Log)
+	require.NoError(t, err)
+	require.NoError(t, s.SaveEmailBatch([]*storage.Email{
+		{UID: 1, Mailbox: "INBOX", Flags: []string{}},
+		{UID: 4, Mailbox: "INBOX", Flags: []string{}},
+		{UID: 2, Mailbox: "Archive", Flags: []string{}},
+	}))
+	lastSync := time.Date(2025, 3, 4, 5, 6, 7, 0, time.Local)
+	require.NoError(t, s.SaveMailboxState(&storage.MailboxState{Name: "INBOX", UIDValidity: 1, LastUID: 4, LastSync: lastSync}))
+	require.NoError(t, s.SaveMailboxState(&storage.MailboxState{Name: "Archive", UIDValidity: 1, LastUID: 2, LastSync: lastSync}))
+	s.Close()
+
+	old := CfgFile
+	CfgFile = writeValidConfig(t, "127.0.0.1", 993, dbPath)
+	defer func() { CfgFile = old }()
+
+	newCmd := func(asJSON bool) (*cobra.Command, *bytes.Buffer) {
+		var out bytes.Buffer
+		cmd := &cobra.Command{}
+		cmd.Flags().Bool("json", asJSON, "")
+		cmd.SetOut(&out)
+		return cmd, &out
+	}
+
+	t.Run("table", func(t *testing.T) {
+		cmd, out := newCmd(false)
+		require.NoError(t, RunMailboxes(cmd, nil))
+		assert.Regexp(t, `MAILBOX\s+MESSAGES\s+LAST UID\s+LAST SYNC\nArchive\s+1\s+2\s+2025-03-04 05:06:07\nINBOX\s+2\s+4\s+2025-03-04 05:06:07\n`, out.String())
+	})
+
+	t.Run("json", func(t *testing.T) {
+		cmd, out := newCmd(true)
+		require.NoError(t, RunMailboxes(cmd, nil))
+
+		var mailboxes []mailboxInfo
+		require.NoError(t, json.Unmarshal(out.Bytes(), &mailboxes))
+		require.Len(t, mailboxes, 2)
+		assert.Equal(t, "INBOX", mailboxes[1].Name)
+		assert.Equal(t, 2, mailboxes[1].Messages)
+		assert.Equal(t, uint32(4), mailboxes[1].LastUID)
+		assert.True(t, lastSync.Equal(mailboxes[1].LastSync))
+	})
+}
@@ -0,0 +1,29 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/newsamples/imapsync/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var compactCmd = &cobra.Command{
+	Use:   "compact",
+	Short: "Rebuild the database file to reclaim space freed by deletions",
+	RunE:  RunCompact,
+}
+
+func RunCompact(cmd *cobra.Command, _ []string) error {
+	cfg, err := loadAccountConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	store, err := storage.New(cfg.Storage.Path, Log, storageOptions(cfg)...)
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	defer store.Close()
+
+	return store.Compact()
+}
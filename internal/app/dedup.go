@@ -0,0 +1,85 @@
+package app
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/newsamples/imapsync/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var dedupCmd = &cobra.Command{
+	Use:   "dedup",
+	Short: "Report messages stored in more than one mailbox",
+	RunE:  RunDedup,
+}
+
+type duplicateGroup struct {
+	messageID string
+	refs      []storage.EmailRef
+	wasted    int64
+}
+
+func RunDedup(cmd *cobra.Command, _ []string) error {
+	cfg, err := loadAccountConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	store, err := storage.New(cfg.Storage.Path, Log, storageOptions(cfg, storage.WithReadOnly(true))...)
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	defer store.Close()
+
+	duplicates, err := store.FindDuplicates()
+	if err != nil {
+		return err
+	}
+
+	groups := make([]duplicateGroup, 0, len(duplicates))
+	var copies int
+	var wasted int64
+	for id, refs := range duplicates {
+		g := duplicateGroup{messageID: id, refs: refs, wasted: wastedBytes(refs)}
+		groups = append(groups, g)
+		copies += len(refs) - 1
+		wasted += g.wasted
+	}
+	slices.SortFunc(groups, func(a, b duplicateGroup) int {
+		return cmp.Or(cmp.Compare(b.wasted, a.wasted), strings.Compare(a.messageID, b.messageID))
+	})
+
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "%d messages are stored more than once: %d extra copies using %s\n",
+		len(groups), copies, formatBytes(wasted))
+
+	if list, _ := cmd.Flags().GetBool("list"); list && len(groups) > 0 {
+		fmt.Fprintln(out)
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "MESSAGE-ID\tCOPIES\tWASTED\tLOCATIONS")
+		for _, g := range groups {
+			locations := make([]string, len(g.refs))
+			for i, r := range g.refs {
+				locations[i] = fmt.Sprintf("%s:%d", r.Mailbox, r.UID)
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", g.messageID, len(g.refs), formatBytes(g.wasted), strings.Join(locations, ", "))
+		}
+		tw.Flush()
+	}
+
+	return nil
+}
+
+// wastedBytes is the space taken by all but the largest copy of a message.
+func wastedBytes(refs []storage.EmailRef) int64 {
+	var total, largest int64
+	for _, r := range refs {
+		total += int64(r.Size)
+		largest = max(largest, int64(r.Size))
+	}
+	return total - largest
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/newsamples/imapsync/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var deleteMailboxCmd = &cobra.Command{
+	Use:   "delete-mailbox <name>",
+	Short: "Permanently delete a mailbox and all its messages from storage",
+	Args:  cobra.ExactArgs(1),
+	RunE:  RunDeleteMailbox,
+}
+
+func RunDeleteMailbox(cmd *cobra.Command, args []string) error {
+	mailbox := args[0]
+	yes, _ := cmd.Flags().GetBool("yes")
+
+	cfg, err := loadAccountConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	store, err := storage.New(cfg.Storage.Path, Log, storageOptions(cfg)...)
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	defer store.Close()
+
+	mailboxes, err := store.ListMailboxes()
+	if err != nil {
+		return fmt.Errorf("failed to list mailboxes: %w", err)
+	}
+	if !slices.Contains(mailboxes, mailbox) {
+		return fmt.Errorf("mailbox %q not found in storage", mailbox)
+	}
+
+	out := cmd.OutOrStdout()
+
+	if !yes {
+		fmt.Fprintf(out, "Permanently delete mailbox %s and all its messages from %s? [y/N] ", mailbox, cfg.Storage.Path)
+		answer, _ := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			return fmt.Errorf("delete-mailbox aborted; pass --yes to skip the prompt")
+		}
+	}
+
+	n, err := store.DeleteMailbox(mailbox)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Deleted mailbox %s with %d messages\n", mailbox, n)
+	return nil
+}
@@ -0,0 +1,67 @@
+package app
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/newsamples/imapsync/internal/importer"
+	"github.com/newsamples/imapsync/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import <path>",
+	Short: "Import messages from an mbox file or .eml files into the backup",
+	Args:  cobra.ExactArgs(1),
+	RunE:  RunImport,
+}
+
+// RunImport stores the messages of an mbox file, an .eml file or a
+// directory of .eml files in a mailbox of the backup.
+func RunImport(cmd *cobra.Command, args []string) error {
+	cfg, err := loadAccountConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	format, _ := cmd.Flags().GetString("format")
+	mailbox, _ := cmd.Flags().GetString("mailbox")
+
+	if format != "mbox" && format != "eml" {
+		return fmt.Errorf("unsupported import format: %s", format)
+	}
+	if mailbox == "" {
+		return fmt.Errorf("--mailbox is required")
+	}
+
+	store, err := storage.New(cfg.Storage.Path, Log, storageOptions(cfg)...)
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	defer store.Close()
+
+	var stats *importer.Stats
+	if format == "eml" {
+		stats, err = importer.Eml(store, mailbox, args[0], Log)
+	} else {
+		stats, err = importMboxFile(store, mailbox, args[0])
+	}
+	if err != nil {
+		if stats != nil && stats.Imported > 0 {
+			Log.Warnf("Imported %d messages into %s before failing", stats.Imported, mailbox)
+		}
+		return fmt.Errorf("import failed: %w", err)
+	}
+
+	Log.Infof("Imported %d messages from %s into %s (%d skipped)", stats.Imported, args[0], mailbox, stats.Skipped)
+	return nil
+}
+
+func importMboxFile(store *storage.Storage, mailbox, path string) (*importer.Stats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return importer.Mbox(store, mailbox, f, Log)
+}
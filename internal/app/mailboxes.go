@@ -0,0 +1,81 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/newsamples/imapsync/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var mailboxesCmd = &cobra.Command{
+	Use:   "mailboxes",
+	Short: "List the mailboxes in the backup",
+	RunE:  RunMailboxes,
+}
+
+// mailboxInfo is one element of the mailboxes --json array.
+type mailboxInfo struct {
+	Name     string    `json:"name"`
+	Messages int       `json:"messages"`
+	LastUID  uint32    `json:"last_uid"`
+	LastSync time.Time `json:"last_sync"`
+}
+
+// RunMailboxes prints every stored mailbox, by name, with its message count
+// and how far it was synced.
+func RunMailboxes(cmd *cobra.Command, _ []string) error {
+	cfg, err := loadAccountConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	store, err := storage.New(cfg.Storage.Path, Log, storageOptions(cfg, storage.WithReadOnly(true))...)
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	defer store.Close()
+
+	names, err := store.ListMailboxes()
+	if err != nil {
+		return fmt.Errorf("failed to list mailboxes: %w", err)
+	}
+
+	mailboxes := make([]mailboxInfo, 0, len(names))
+	for _, name := range names {
+		count, err := store.CountMessages(name)
+		if err != nil {
+			return fmt.Errorf("failed to count messages in %s: %w", name, err)
+		}
+		info := mailboxInfo{Name: name, Messages: count}
+		state, err := store.GetMailboxState(name)
+		if err != nil {
+			return err
+		}
+		if state != nil {
+			info.LastUID, info.LastSync = state.LastUID, state.LastSync
+		}
+		mailboxes = append(mailboxes, info)
+	}
+
+	out := cmd.OutOrStdout()
+
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(mailboxes)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "MAILBOX\tMESSAGES\tLAST UID\tLAST SYNC")
+	for _, m := range mailboxes {
+		lastSync := "never"
+		if m.LastSync.Unix() > 0 {
+			lastSync = m.LastSync.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", m.Name, m.Messages, m.LastUID, lastSync)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,110 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/newsamples/imapsync/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Permanently delete stored messages older than a given age",
+	RunE:  RunPrune,
+}
+
+func RunPrune(cmd *cobra.Command, _ []string) error {
+	olderThan, _ := cmd.Flags().GetString("older-than")
+	if olderThan == "" {
+		return fmt.Errorf("--older-than is required")
+	}
+	cutoff, err := ageCutoff(olderThan, time.Now())
+	if err != nil {
+		return err
+	}
+
+	mailbox, _ := cmd.Flags().GetString("mailbox")
+	vacuum, _ := cmd.Flags().GetBool("vacuum")
+	yes, _ := cmd.Flags().GetBool("yes")
+
+	cfg, err := loadAccountConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	store, err := storage.New(cfg.Storage.Path, Log, storageOptions(cfg)...)
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	defer store.Close()
+
+	scope := "all mailboxes"
+	if mailbox != "" {
+		mailboxes, err := store.ListMailboxes()
+		if err != nil {
+			return fmt.Errorf("failed to list mailboxes: %w", err)
+		}
+		if !slices.Contains(mailboxes, mailbox) {
+			return fmt.Errorf("mailbox %q not found in storage", mailbox)
+		}
+		scope = mailbox
+	}
+
+	out := cmd.OutOrStdout()
+
+	if !yes {
+		fmt.Fprintf(out, "Permanently delete messages dated before %s from %s in %s? [y/N] ",
+			cutoff.Format(time.DateOnly), scope, cfg.Storage.Path)
+		answer, _ := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			return fmt.Errorf("prune aborted; pass --yes to skip the prompt")
+		}
+	}
+
+	n, err := store.DeleteOlderThan(mailbox, cutoff)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Deleted %d messages dated before %s from %s\n", n, cutoff.Format(time.DateOnly), scope)
+
+	if vacuum {
+		if err := store.Compact(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ageCutoff returns the time an age such as 90d, 6w, 18m or 2y before now;
+// m is months. Months and years follow the calendar.
+func ageCutoff(age string, now time.Time) (time.Time, error) {
+	invalid := fmt.Errorf("invalid age %q: use a number followed by d, w, m or y, e.g. 2y", age)
+
+	age = strings.TrimSpace(age)
+	if len(age) < 2 {
+		return time.Time{}, invalid
+	}
+	n, err := strconv.Atoi(age[:len(age)-1])
+	if err != nil || n < 1 {
+		return time.Time{}, invalid
+	}
+
+	switch age[len(age)-1] {
+	case 'd':
+		return now.AddDate(0, 0, -n), nil
+	case 'w':
+		return now.AddDate(0, 0, -7*n), nil
+	case 'm':
+		return now.AddDate(0, -n, 0), nil
+	case 'y':
+		return now.AddDate(-n, 0, 0), nil
+	default:
+		return time.Time{}, invalid
+	}
+}
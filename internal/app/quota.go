@@ -0,0 +1,64 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/newsamples/imapsync/internal/imap"
+	"github.com/spf13/cobra"
+)
+
+var quotaCmd = &cobra.Command{
+	Use:   "quota",
+	Short: "Show how much of the server's storage quota is used",
+	RunE:  RunQuota,
+}
+
+// RunQuota prints the quota of the account's INBOX. A server without
+// quotas isn't an error.
+func RunQuota(cmd *cobra.Command, _ []string) error {
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	cfg, err := loadAccountConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	client, err := imap.Connect(connectOptions(cfg))
+	if err != nil {
+		return fmt.Errorf("failed to connect to IMAP server: %w", err)
+	}
+	defer client.Close()
+
+	out := cmd.OutOrStdout()
+
+	quota, err := client.GetQuota(ctx)
+	if errors.Is(err, imap.ErrQuotaUnsupported) {
+		fmt.Fprintf(out, "%s doesn't report quotas\n", cfg.IMAP.Host)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if quota.Root != "" {
+		fmt.Fprintf(out, "Quota root:  %s\n", quota.Root)
+	}
+	switch {
+	case quota.HasStorage && quota.TotalBytes > 0:
+		fmt.Fprintf(out, "Storage:     %s of %s (%.1f%%)\n", formatBytes(quota.UsedBytes), formatBytes(quota.TotalBytes), quota.UsedPercent())
+	case quota.HasStorage:
+		fmt.Fprintf(out, "Storage:     %s, unlimited\n", formatBytes(quota.UsedBytes))
+	}
+	switch {
+	case quota.HasMessages && quota.MessageLimit > 0:
+		fmt.Fprintf(out, "Messages:    %d of %d\n", quota.Messages, quota.MessageLimit)
+	case quota.HasMessages:
+		fmt.Fprintf(out, "Messages:    %d, unlimited\n", quota.Messages)
+	}
+	if !quota.HasStorage && !quota.HasMessages {
+		fmt.Fprintln(out, "No storage or message limits")
+	}
+	return nil
+}
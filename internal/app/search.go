@@ -0,0 +1,74 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/newsamples/imapsync/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var searchCmd = &cobra.Command{
+	Use:   "search <query>",
+	Short: "Search stored messages without the web UI",
+	Args:  cobra.ExactArgs(1),
+	RunE:  RunSearch,
+}
+
+// RunSearch prints the messages matching a full-text query, best matches
+// first. Messages are listed with their mailbox unless --mailbox narrows the
+// search to one.
+func RunSearch(cmd *cobra.Command, args []string) error {
+	cfg, err := loadAccountConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	mailbox, _ := cmd.Flags().GetString("mailbox")
+	limit, _ := cmd.Flags().GetInt("limit")
+	if limit <= 0 {
+		return fmt.Errorf("--limit must be positive, got %d", limit)
+	}
+
+	store, err := storage.New(cfg.Storage.Path, Log, storageOptions(cfg, storage.WithReadOnly(true))...)
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	defer store.Close()
+
+	emails, err := store.SearchEmails(args[0], mailbox, limit, 0)
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		if emails == nil {
+			emails = []*storage.Email{}
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(emails)
+	}
+
+	if len(emails) == 0 {
+		fmt.Fprintf(out, "No messages match %q\n", args[0])
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	if mailbox == "" {
+		fmt.Fprint(tw, "MAILBOX\t")
+	}
+	fmt.Fprintln(tw, "UID\tDATE\tFROM\tSUBJECT")
+	for _, e := range emails {
+		if mailbox == "" {
+			fmt.Fprintf(tw, "%s\t", e.Mailbox)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", e.UID, e.Date.Local().Format(time.DateTime), e.From, e.Subject)
+	}
+	return tw.Flush()
+}
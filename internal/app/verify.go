@@ -0,0 +1,46 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/newsamples/imapsync/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check the backup for corruption",
+	RunE:  RunVerify,
+}
+
+// RunVerify reports every problem found in storage and fails if there was
+// any, so it can gate scripts and CI jobs.
+func RunVerify(cmd *cobra.Command, _ []string) error {
+	cfg, err := loadAccountConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	store, err := storage.New(cfg.Storage.Path, Log, storageOptions(cfg, storage.WithReadOnly(true))...)
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	defer store.Close()
+
+	issues, err := store.Verify()
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	for _, issue := range issues {
+		fmt.Fprintln(out, issue)
+	}
+
+	if len(issues) > 0 {
+		return fmt.Errorf("found %d problems in %s", len(issues), cfg.Storage.Path)
+	}
+
+	fmt.Fprintf(out, "%s: no problems found\n", cfg.Storage.Path)
+	return nil
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// Build metadata, set from main via SetBuildInfo.
+var (
+	Version   = "dev"
+	Commit    = "none"
+	BuildDate = "unknown"
+)
+
+// exit is replaced in tests so --version doesn't end the test binary.
+var exit = os.Exit
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Print version information",
+	RunE:  RunVersion,
+}
+
+// SetBuildInfo records the version metadata injected at build time. Empty
+// values keep the defaults.
+func SetBuildInfo(version, commit, date string) {
+	if version != "" {
+		Version = version
+	}
+	if commit != "" {
+		Commit = commit
+	}
+	if date != "" {
+		BuildDate = date
+	}
+}
+
+// VersionString returns the version line printed by `version` and --version.
+func VersionString() string {
+	return fmt.Sprintf("imapsync %s (commit %s, built %s)", Version, Commit, BuildDate)
+}
+
+func RunVersion(cmd *cobra.Command, _ []string) error {
+	printVersion(cmd.OutOrStdout())
+	return nil
+}
+
+// handleVersionFlag prints the version and exits when --version is set, so
+// it works on the root command and every subcommand.
+func handleVersionFlag(cmd *cobra.Command, _ []string) error {
+	if v, _ := cmd.Flags().GetBool("version"); v {
+		printVersion(cmd.OutOrStdout())
+		exit(0)
+	}
+	return nil
+}
+
+func printVersion(w io.Writer) {
+	fmt.Fprintln(w, VersionString())
+}
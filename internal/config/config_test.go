@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -89,6 +90,625 @@ gmail:
 		assert.Contains(t, cfg.Gmail.ExcludeFolders, "[Gmail]/Spam")
 		assert.Contains(t, cfg.Gmail.ExcludeFolders, "[Gmail]/Trash")
 	})
+
+	t.Run("with server tls", func(t *testing.T) {
+		configFile := filepath.Join(t.TempDir(), "config.yaml")
+
+		configContent := `imap:
+  host: imap.example.com
+  port: 993
+  username: test@example.com
+  password: secret
+storage:
+  path: /tmp/emails
+server:
+  tls_cert: /etc/imapsync/cert.pem
+  tls_key: /etc/imapsync/key.pem
+`
+		require.NoError(t, os.WriteFile(configFile, []byte(configContent), 0600))
+
+		cfg, err := Load(configFile)
+		require.NoError(t, err)
+		assert.Equal(t, "/etc/imapsync/cert.pem", cfg.Server.TLSCert)
+		assert.Equal(t, "/etc/imapsync/key.pem", cfg.Server.TLSKey)
+	})
+}
+
+func TestLoad_Accounts(t *testing.T) {
+	load := func(t *testing.T, content string) (*Config, error) {
+		t.Helper()
+		configFile := filepath.Join(t.TempDir(), "config.yaml")
+		require.NoError(t, os.WriteFile(configFile, []byte(content), 0600))
+		return Load(configFile)
+	}
+
+	t.Run("single account shape", func(t *testing.T) {
+		cfg, err := load(t, `imap:
+  host: imap.example.com
+  port: 993
+  username: test@example.com
+  password: secret
+storage:
+  path: /tmp/emails
+`)
+		require.NoError(t, err)
+
+		accounts := cfg.AccountsOrDefault()
+		require.Len(t, accounts, 1)
+		assert.Equal(t, DefaultAccountName, accounts[0].Name)
+		assert.Equal(t, "imap.example.com", accounts[0].IMAP.Host)
+		assert.Equal(t, "/tmp/emails", accounts[0].Storage.Path)
+
+		account, err := cfg.Account(DefaultAccountName)
+		require.NoError(t, err)
+		assert.Equal(t, accounts[0], *account)
+	})
+
+	t.Run("accounts list", func(t *testing.T) {
+		cfg, err := load(t, `accounts:
+  - name: work
+    imap:
+      host: imap.work.example.com
+      port: 993
+      username: me@work.example.com
+      password: secret
+      tls: true
+    storage:
+      path: /backup/work.sqlite3
+  - name: personal
+    imap:
+      host: imap.example.com
+      port: 143
+      username: me@example.com
+      password: secret
+    storage:
+      path: /backup/personal.sqlite3
+      purge_after_days: 30
+sync:
+  batch_size: 20
+`)
+		require.NoError(t, err)
+
+		accounts := cfg.AccountsOrDefault()
+		require.Len(t, accounts, 2)
+		assert.Equal(t, "work", accounts[0].Name)
+		assert.Equal(t, "personal", accounts[1].Name)
+
+		account, err := cfg.Account("personal")
+		require.NoError(t, err)
+
+		narrowed := cfg.ForAccount(*account)
+		assert.Equal(t, "imap.example.com", narrowed.IMAP.Host)
+		assert.Equal(t, 143, narrowed.IMAP.Port)
+		assert.Equal(t, "/backup/personal.sqlite3", narrowed.Storage.Path)
+		assert.Equal(t, 30, narrowed.Storage.PurgeAfterDaysOrDefault())
+		assert.Equal(t, 20, narrowed.Sync.BatchSizeOrDefault())
+		assert.Empty(t, narrowed.Accounts)
+		assert.Len(t, cfg.Accounts, 2, "ForAccount doesn't modify the original")
+
+		_, err = cfg.Account("missing")
+		assert.ErrorContains(t, err, `account "missing" not found in config (available: work, personal)`)
+	})
+
+	invalid := map[string]string{
+		"mixed shapes": `imap:
+  host: imap.example.com
+accounts:
+  - name: work
+    storage:
+      path: /backup/work.sqlite3
+`,
+		"missing name": `accounts:
+  - storage:
+      path: /backup/work.sqlite3
+`,
+		"duplicate name": `accounts:
+  - name: work
+    storage:
+      path: /backup/a.sqlite3
+  - name: work
+    storage:
+      path: /backup/b.sqlite3
+`,
+		"missing storage": `accounts:
+  - name: work
+`,
+		"shared storage": `accounts:
+  - name: work
+    storage:
+      path: /backup/mail.sqlite3
+  - name: personal
+    storage:
+      path: /backup/mail.sqlite3
+`,
+	}
+	for name, content := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := load(t, content)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoad_PasswordSources(t *testing.T) {
+	load := func(t *testing.T, imap string) (*Config, error) {
+		t.Helper()
+		configFile := filepath.Join(t.TempDir(), "config.yaml")
+		content := "imap:\n  host: imap.example.com\n  port: 993\n  username: me@example.com\n" + imap + "storage:\n  path: /tmp/emails\n"
+		require.NoError(t, os.WriteFile(configFile, []byte(content), 0600))
+		return Load(configFile)
+	}
+
+	t.Run("plain password", func(t *testing.T) {
+		cfg, err := load(t, "  password: secret\n")
+		require.NoError(t, err)
+		assert.Equal(t, "secret", cfg.IMAP.Password)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		passwordFile := filepath.Join(t.TempDir(), "password")
+		require.NoError(t, os.WriteFile(passwordFile, []byte("from file \n\n"), 0600))
+
+		cfg, err := load(t, "  password_file: "+passwordFile+"\n")
+		require.NoError(t, err)
+		assert.Equal(t, "from file ", cfg.IMAP.Password, "only trailing newlines are trimmed")
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := load(t, "  password_file: /non/existent/password\n")
+		assert.ErrorContains(t, err, "failed to read password_file")
+	})
+
+	t.Run("env", func(t *testing.T) {
+		t.Setenv("IMAPSYNC_TEST_PASSWORD", "from env")
+
+		cfg, err := load(t, "  password_env: IMAPSYNC_TEST_PASSWORD\n")
+		require.NoError(t, err)
+		assert.Equal(t, "from env", cfg.IMAP.Password)
+	})
+
+	t.Run("unset env", func(t *testing.T) {
+		_, err := load(t, "  password_env: IMAPSYNC_TEST_UNSET_PASSWORD\n")
+		assert.ErrorContains(t, err, "IMAPSYNC_TEST_UNSET_PASSWORD is not set")
+	})
+
+	t.Run("command", func(t *testing.T) {
+		cfg, err := load(t, "  password_command: \"echo from command\"\n")
+		require.NoError(t, err)
+		assert.Equal(t, "from command", cfg.IMAP.Password)
+	})
+
+	t.Run("failing command", func(t *testing.T) {
+		_, err := load(t, "  password_command: \"exit 1\"\n")
+		assert.ErrorContains(t, err, "password_command failed")
+	})
+
+	t.Run("conflicting sources", func(t *testing.T) {
+		_, err := load(t, "  password: secret\n  password_env: HOME\n")
+		assert.ErrorContains(t, err, "imap: only one password source may be set, got password, password_env")
+	})
+
+	t.Run("per account", func(t *testing.T) {
+		t.Setenv("IMAPSYNC_TEST_PASSWORD", "work secret")
+
+		configFile := filepath.Join(t.TempDir(), "config.yaml")
+		content := "accounts:\n  - name: work\n    imap:\n      host: imap.example.com\n      port: 993\n      username: me@example.com\n      password_env: IMAPSYNC_TEST_PASSWORD\n    storage:\n      path: /tmp/work\n"
+		require.NoError(t, os.WriteFile(configFile, []byte(content), 0600))
+
+		cfg, err := Load(configFile)
+		require.NoError(t, err)
+		assert.Equal(t, "work secret", cfg.Accounts[0].IMAP.Password)
+	})
+}
+
+func TestLoad_EncryptionKey(t *testing.T) {
+	load := func(t *testing.T, storage string) (*Config, error) {
+		t.Helper()
+		configFile := filepath.Join(t.TempDir(), "config.yaml")
+		content := "imap:\n  host: imap.example.com\n  port: 993\n  username: me@example.com\n  password: secret\nstorage:\n  path: /tmp/emails\n" + storage
+		require.NoError(t, os.WriteFile(configFile, []byte(content), 0600))
+		return Load(configFile)
+	}
+
+	t.Run("file", func(t *testing.T) {
+		keyFile := filepath.Join(t.TempDir(), "storage.key")
+		require.NoError(t, os.WriteFile(keyFile, []byte("passphrase\n"), 0600))
+
+		cfg, err := load(t, "  encryption_key_file: "+keyFile+"\n")
+		require.NoError(t, err)
+		assert.Equal(t, "passphrase", cfg.Storage.EncryptionKey)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := load(t, "  encryption_key_file: /non/existent/key\n")
+		assert.ErrorContains(t, err, "failed to read encryption_key_file")
+	})
+
+	t.Run("both set", func(t *testing.T) {
+		_, err := load(t, "  encryption_key: passphrase\n  encryption_key_file: /non/existent/key\n")
+		assert.ErrorContains(t, err, "storage.encryption_key and storage.encryption_key_file are mutually exclusive")
+	})
+}
+
+func TestLoad_StoragePathTemplate(t *testing.T) {
+	load := func(t *testing.T, content string, opts ...LoadOption) (*Config, error) {
+		t.Helper()
+		configFile := filepath.Join(t.TempDir(), "config.yaml")
+		require.NoError(t, os.WriteFile(configFile, []byte(content), 0600))
+		return Load(configFile, opts...)
+	}
+	dir := t.TempDir()
+	year := time.Now().Format("2006")
+
+	t.Run("accounts", func(t *testing.T) {
+		account := func(name string) string {
+			return `  - name: ` + name + `
+    imap:
+      host: imap.example.com
+      port: 993
+      username: me@example.com
+      password: secret
+    storage:
+      path: ` + dir + `/{account}/{year}.db
+`
+		}
+
+		cfg, err := load(t, "accounts:\n"+account("work")+account("personal"), WithWritableStorage())
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(dir, "work", year+".db"), cfg.Accounts[0].Storage.Path)
+		assert.Equal(t, filepath.Join(dir, "personal", year+".db"), cfg.Accounts[1].Storage.Path)
+		assert.DirExists(t, filepath.Join(dir, "work"), "directories named by the template are created")
+
+		_, err = load(t, "accounts:\n"+account("../etc"))
+		assert.ErrorContains(t, err, `account ../etc: storage.path: account name "../etc" can't be used in a path`)
+	})
+
+	t.Run("single account", func(t *testing.T) {
+		config := func(path string) string {
+			return "imap:\n  host: imap.example.com\n  port: 993\n  username: me\n  password: secret\nstorage:\n  path: " + path + "\n"
+		}
+
+		cfg, err := load(t, config("/backups/{account}-{date}.db"))
+		require.NoError(t, err)
+		assert.Equal(t, "/backups/default-"+time.Now().Format(time.DateOnly)+".db", cfg.Storage.Path)
+
+		_, err = load(t, config("/backups/{month}.db"))
+		assert.ErrorContains(t, err, "storage.path: unknown variable {month}, expected {account}, {date} or {year}")
+	})
+}
+
+func TestLoad_Validation(t *testing.T) {
+	load := func(t *testing.T, content string, opts ...LoadOption) (*Config, error) {
+		t.Helper()
+		configFile := filepath.Join(t.TempDir(), "config.yaml")
+		require.NoError(t, os.WriteFile(configFile, []byte(content), 0600))
+		return Load(configFile, opts...)
+	}
+
+	t.Run("reports every problem", func(t *testing.T) {
+		_, err := load(t, `imap:
+  host: imap.example.com
+  port: 99999
+  password: secret
+  auth:
+    token: ya29.token
+storage:
+  path: /tmp/emails
+  compression: brotli
+  write_retries: -1
+`)
+		var verr *ValidationError
+		require.ErrorAs(t, err, &verr)
+		assert.Equal(t, []string{
+			"imap.port must be between 1 and 65535, got 99999",
+			"imap.username is required",
+			"imap.auth.token and imap.auth.token_command need imap.auth.method xoauth2",
+			`storage.compression must be gzip, zstd or none, got "brotli"`,
+			"storage.write_retries must not be negative, got -1",
+		}, verr.Problems)
+		assert.Equal(t, "invalid config:\n"+
+			"  - imap.port must be between 1 and 65535, got 99999\n"+
+			"  - imap.username is required\n"+
+			"  - imap.auth.token and imap.auth.token_command need imap.auth.method xoauth2\n"+
+			"  - storage.compression must be gzip, zstd or none, got \"brotli\"\n"+
+			"  - storage.write_retries must not be negative, got -1", err.Error())
+	})
+
+	t.Run("accounts are prefixed", func(t *testing.T) {
+		_, err := load(t, `accounts:
+  - name: work
+    imap:
+      port: 993
+      username: me@example.com
+      password: secret
+    storage:
+      path: /tmp/work
+  - imap:
+      host: imap.example.com
+      port: 993
+      username: me@example.com
+      password: secret
+`)
+		var verr *ValidationError
+		require.ErrorAs(t, err, &verr)
+		assert.Equal(t, []string{
+			"account work: imap.host is required",
+			"account 2: name is required",
+			"account 2: storage.path is required",
+		}, verr.Problems)
+	})
+
+	t.Run("xoauth2 settings", func(t *testing.T) {
+		_, err := load(t, `imap:
+  host: imap.gmail.com
+  port: 993
+  username: me@gmail.com
+  password: secret
+  auth:
+    method: xoauth2
+    token: ya29.token
+    token_command: oauth2-helper
+storage:
+  path: /tmp/emails
+`)
+		var verr *ValidationError
+		require.ErrorAs(t, err, &verr)
+		assert.Equal(t, []string{
+			"imap.password can't be used with imap.auth.method xoauth2",
+			"imap.auth.token and imap.auth.token_command are mutually exclusive",
+		}, verr.Problems)
+
+		_, err = load(t, `imap:
+  host: imap.gmail.com
+  port: 993
+  username: me@gmail.com
+  auth:
+    method: oauth
+storage:
+  path: /tmp/emails
+`)
+		assert.ErrorContains(t, err, `imap.auth.method must be password or xoauth2, got "oauth"`)
+	})
+
+	t.Run("keepalive", func(t *testing.T) {
+		cfg, err := load(t, `imap:
+  host: imap.example.com
+  port: 993
+  username: me
+  password: secret
+  keepalive: 4m30s
+storage:
+  path: /tmp/emails
+`)
+		require.NoError(t, err)
+		assert.Equal(t, 4*time.Minute+30*time.Second, cfg.IMAP.KeepAlive)
+
+		_, err = load(t, `imap:
+  host: imap.example.com
+  port: 993
+  username: me
+  password: secret
+  keepalive: -1m
+storage:
+  path: /tmp/emails
+`)
+		assert.ErrorContains(t, err, "imap.keepalive must not be negative, got -1m0s")
+	})
+
+	t.Run("log", func(t *testing.T) {
+		config := func(log string) string {
+			return `imap:
+  host: imap.example.com
+  port: 993
+  username: me
+  password: secret
+storage:
+  path: /tmp/emails
+log:
+` + log
+		}
+
+		cfg, err := load(t, config("  level: debug\n  format: json\n"))
+		require.NoError(t, err)
+		assert.Equal(t, LogConfig{Level: "debug", Format: "json"}, cfg.Log)
+
+		_, err = load(t, config("  level: loud\n  format: xml\n"))
+		assert.ErrorContains(t, err, `log.level must be debug, info, warn or error, got "loud"`)
+		assert.ErrorContains(t, err, `log.format must be text or json, got "xml"`)
+
+		cfg, err = load(t, config("  file: /var/log/imapsync.log\n  max_size_mb: 10\n  max_backups: 3\n"))
+		require.NoError(t, err)
+		assert.Equal(t, LogConfig{File: "/var/log/imapsync.log", MaxSizeMB: 10, MaxBackups: 3}, cfg.Log)
+
+		_, err = load(t, config("  max_size_mb: 10\n"))
+		assert.ErrorContains(t, err, "log.max_size_mb and log.max_backups require log.file")
+
+		_, err = load(t, config("  file: app.log\n  max_backups: -1\n"))
+		assert.ErrorContains(t, err, "log.max_backups must not be negative, got -1")
+	})
+
+	t.Run("default port", func(t *testing.T) {
+		config := func(imap string) string {
+			return `imap:
+  host: imap.example.com
+  username: me
+  password: secret
+` + imap + `
+storage:
+  path: /tmp/emails
+`
+		}
+		for _, tt := range []struct {
+			name string
+			imap string
+			port int
+		}{
+			{"implicit TLS", "  tls: true", 993},
+			{"plain", "  tls: false", 143},
+			{"tls left out", "", 143},
+			{"explicit port kept", "  tls: true\n  port: 1993", 1993},
+			{"explicit standard port kept", "  tls: false\n  port: 993", 993},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				cfg, err := load(t, config(tt.imap))
+				require.NoError(t, err)
+				assert.Equal(t, tt.port, cfg.IMAP.Port)
+			})
+		}
+
+		cfg, err := load(t, `accounts:
+  - name: work
+    imap:
+      host: imap.example.com
+      username: me
+      password: secret
+      tls: true
+    storage:
+      path: /tmp/work
+`)
+		require.NoError(t, err)
+		assert.Equal(t, 993, cfg.Accounts[0].IMAP.Port, "accounts get the default too")
+	})
+
+	t.Run("retries", func(t *testing.T) {
+		config := func(extra string) string {
+			return `imap:
+  host: imap.example.com
+  port: 993
+  username: me
+  password: secret
+` + extra + `
+storage:
+  path: /tmp/emails
+`
+		}
+
+		cfg, err := load(t, config(""))
+		require.NoError(t, err)
+		assert.Nil(t, cfg.IMAP.MaxRetries, "the client default applies")
+		assert.Zero(t, cfg.IMAP.MaxBackoff, "the client default applies")
+
+		cfg, err = load(t, config("  max_retries: 0\n  max_backoff: 5s"))
+		require.NoError(t, err)
+		require.NotNil(t, cfg.IMAP.MaxRetries)
+		assert.Equal(t, 0, *cfg.IMAP.MaxRetries)
+		assert.Equal(t, 5*time.Second, cfg.IMAP.MaxBackoff)
+
+		_, err = load(t, config("  max_retries: -1"))
+		assert.ErrorContains(t, err, "imap.max_retries must not be negative, got -1")
+
+		_, err = load(t, config("  max_backoff: -5s"))
+		assert.ErrorContains(t, err, "imap.max_backoff must not be negative, got -5s")
+	})
+
+	t.Run("omitted options use their defaults", func(t *testing.T) {
+		cfg, err := load(t, `imap:
+  host: imap.example.com
+  username: me
+  password: secret
+storage:
+  path: /tmp/emails
+  write_retries: 0
+`)
+		require.NoError(t, err)
+		assert.Nil(t, cfg.IMAP.MaxRetries)
+		assert.Equal(t, 90, cfg.Storage.PurgeAfterDaysOrDefault())
+		assert.True(t, cfg.Gmail.IsEnabled())
+		assert.True(t, cfg.Gmail.ShouldSkipAllMail())
+		require.NotNil(t, cfg.Storage.WriteRetries, "set to zero, not left out")
+		assert.Equal(t, 0, *cfg.Storage.WriteRetries)
+	})
+
+	t.Run("mailbox listing", func(t *testing.T) {
+		config := func(extra string) string {
+			return `imap:
+  host: imap.example.com
+  port: 993
+  username: me
+  password: secret
+` + extra + `
+storage:
+  path: /tmp/emails
+`
+		}
+
+		cfg, err := load(t, config("  list_reference: \"Archive/\"\n  list_pattern: \"20%\"\n  subscribed_only: true"))
+		require.NoError(t, err)
+		assert.Equal(t, "Archive/", cfg.IMAP.ListReference)
+		assert.Equal(t, "20%", cfg.IMAP.ListPattern)
+		assert.True(t, cfg.IMAP.SubscribedOnly)
+
+		_, err = load(t, config("  list_pattern: \"*\\r\\nLOGOUT\""))
+		assert.ErrorContains(t, err, "imap.list_pattern and imap.list_reference must not contain line breaks")
+	})
+
+	t.Run("mailbox policies", func(t *testing.T) {
+		config := func(policies string) string {
+			return `imap:
+  host: imap.example.com
+  port: 993
+  username: me
+  password: secret
+storage:
+  path: /tmp/emails
+sync:
+  mailbox_policies:
+` + policies
+		}
+
+		cfg, err := load(t, config("    \"Lists/*\": headers\n    Spam: metadata\n    Lists/Important: full\n"))
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"Lists/*": "headers", "Spam": "metadata", "Lists/Important": "full"}, cfg.Sync.MailboxPolicies)
+
+		_, err = load(t, config("    Spam: envelope\n"))
+		assert.ErrorContains(t, err, `sync.mailbox_policies: policy of "Spam" must be full, headers or metadata, got "envelope"`)
+	})
+
+	t.Run("proxy", func(t *testing.T) {
+		config := func(proxy string) string {
+			return `imap:
+  host: imap.internal
+  port: 993
+  username: me
+  password: secret
+  proxy: "` + proxy + `"
+storage:
+  path: /tmp/emails
+`
+		}
+
+		cfg, err := load(t, config("socks5://user:pw@bastion:1080"))
+		require.NoError(t, err)
+		assert.Equal(t, "socks5://user:pw@bastion:1080", cfg.IMAP.Proxy)
+
+		_, err = load(t, config("https://user:pw@bastion:3128"))
+		assert.ErrorContains(t, err, `imap.proxy must be a socks5://, socks5h:// or http:// URL, got scheme "https"`)
+		assert.NotContains(t, err.Error(), "pw", "credentials are not echoed")
+
+		_, err = load(t, config("socks5://"))
+		assert.ErrorContains(t, err, "imap.proxy must include a host")
+	})
+
+	t.Run("writable storage", func(t *testing.T) {
+		content := func(path string) string {
+			return "imap:\n  host: imap.example.com\n  port: 993\n  username: me\n  password: secret\nstorage:\n  path: " + path + "\n"
+		}
+
+		missing := filepath.Join(t.TempDir(), "missing", "emails.db")
+		_, err := load(t, content(missing))
+		require.NoError(t, err, "only checked when asked for")
+
+		_, err = load(t, content(missing), WithWritableStorage())
+		assert.ErrorContains(t, err, "storage.path: directory "+filepath.Dir(missing)+" is not writable")
+
+		dir := t.TempDir()
+		_, err = load(t, content(filepath.Join(dir, "emails.db")), WithWritableStorage())
+		require.NoError(t, err)
+
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Empty(t, entries, "the check leaves no file behind")
+	})
 }
 
 func TestGmailConfig_IsEnabled(t *testing.T) {
@@ -203,3 +823,106 @@ func TestPurgeAfterDaysOrDefault(t *testing.T) {
 		assert.Equal(t, 0, s.PurgeAfterDaysOrDefault())
 	})
 }
+
+func TestBatchSizeOrDefault(t *testing.T) {
+	s := SyncConfig{}
+	assert.Equal(t, 5, s.BatchSizeOrDefault())
+
+	s.BatchSize = 50
+	assert.Equal(t, 50, s.BatchSizeOrDefault())
+
+	s.BatchSize = -1
+	assert.Equal(t, 5, s.BatchSizeOrDefault())
+}
+
+func TestParseSize(t *testing.T) {
+	valid := map[string]int64{
+		"0":       0,
+		"1048576": 1048576,
+		"512B":    512,
+		"500KB":   500000,
+		"25MB":    25000000,
+		"25mb":    25000000,
+		"10 MiB":  10 << 20,
+		"1.5GiB":  3 << 29,
+		"2G":      2000000000,
+		" 64KiB ": 64 << 10,
+	}
+	for in, want := range valid {
+		got, err := ParseSize(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+
+	for _, in := range []string{"", "MB", "25XB", "-1MB", "1.2.3MB"} {
+		_, err := ParseSize(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestMaxMessageSizeBytes(t *testing.T) {
+	s := SyncConfig{}
+	n, err := s.MaxMessageSizeBytes()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+
+	s.MaxMessageSize = "25MB"
+	n, err = s.MaxMessageSizeBytes()
+	require.NoError(t, err)
+	assert.Equal(t, int64(25000000), n)
+
+	s.MaxMessageSize = "huge"
+	_, err = s.MaxMessageSizeBytes()
+	assert.ErrorContains(t, err, "sync.max_message_size")
+}
+
+func TestAuthConfig(t *testing.T) {
+	t.Run("method defaults to password", func(t *testing.T) {
+		a := AuthConfig{}
+		assert.Equal(t, "password", a.MethodOrDefault())
+
+		a.Method = "xoauth2"
+		assert.Equal(t, "xoauth2", a.MethodOrDefault())
+	})
+
+	t.Run("static token", func(t *testing.T) {
+		a := AuthConfig{Token: "static"}
+		token, err := a.AccessToken()
+		require.NoError(t, err)
+		assert.Equal(t, "static", token)
+	})
+
+	t.Run("token command takes precedence", func(t *testing.T) {
+		a := AuthConfig{Token: "static", TokenCommand: "echo fresh-token"}
+		token, err := a.AccessToken()
+		require.NoError(t, err)
+		assert.Equal(t, "fresh-token", token)
+	})
+
+	t.Run("failing token command", func(t *testing.T) {
+		a := AuthConfig{TokenCommand: "exit 3"}
+		_, err := a.AccessToken()
+		assert.ErrorContains(t, err, "token command failed")
+	})
+
+	t.Run("parsed from config", func(t *testing.T) {
+		configFile := filepath.Join(t.TempDir(), "config.yaml")
+		configContent := `imap:
+  host: imap.gmail.com
+  port: 993
+  username: test@gmail.com
+  tls: true
+  auth:
+    method: xoauth2
+    token_command: "oauth2-helper --account test"
+storage:
+  path: /tmp/emails
+`
+		require.NoError(t, os.WriteFile(configFile, []byte(configContent), 0600))
+
+		cfg, err := Load(configFile)
+		require.NoError(t, err)
+		assert.Equal(t, "xoauth2", cfg.IMAP.Auth.MethodOrDefault())
+		assert.Equal(t, "oauth2-helper --account test", cfg.IMAP.Auth.TokenCommand)
+	})
+}
@@ -0,0 +1,353 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/vitalvas/gokit/xconfig"
+	"gopkg.in/yaml.v3"
+)
+
+// ValidationError lists every problem found in a config, so they can all be
+// fixed in one go.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid config:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+type LoadOption func(*loadOptions)
+
+type loadOptions struct {
+	writableStorage bool
+}
+
+// WithWritableStorage makes Load also check that the directory of every
+// storage path is writable, for commands that write to storage.
+func WithWritableStorage() LoadOption {
+	return func(o *loadOptions) {
+		o.writableStorage = true
+	}
+}
+
+// LoadLogConfig reads only the log section of the config at path, without
+// validating the rest, so logging can be set up before a command loads the
+// config.
+func LoadLogConfig(path string) (LogConfig, error) {
+	var cfg struct {
+		Log LogConfig `yaml:"log"`
+	}
+	if err := xconfig.Load(&cfg, xconfig.WithFiles(path)); err != nil {
+		return LogConfig{}, err
+	}
+	return cfg.Log, nil
+}
+
+// Load reads and validates the config at path, resolving password sources
+// into IMAPConfig.Password. Validation problems are returned together as a
+// *ValidationError.
+func Load(path string, opts ...LoadOption) (*Config, error) {
+	var o loadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var cfg Config
+	if err := xconfig.Load(&cfg, xconfig.WithFiles(path)); err != nil {
+		return nil, err
+	}
+	if err := dropUnsetPointers(&cfg, path); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(o.writableStorage); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// dropUnsetPointers resets the pointer options the file at path leaves out
+// to nil. xconfig allocates every nil pointer before reading the file, which
+// would turn an omitted option into an explicit zero, while nil is what
+// selects the default of each.
+func dropUnsetPointers(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	var set Config
+	if err := yaml.Unmarshal(data, &set); err != nil {
+		return fmt.Errorf("failed to parse config: %w", err)
+	}
+	clearUnset(reflect.ValueOf(cfg).Elem(), reflect.ValueOf(&set).Elem())
+	return nil
+}
+
+// clearUnset sets each pointer in loaded to nil where the same pointer in
+// set, decoded from the file alone, is nil.
+func clearUnset(loaded, set reflect.Value) {
+	switch loaded.Kind() {
+	case reflect.Pointer:
+		if set.IsNil() {
+			loaded.SetZero()
+		} else if !loaded.IsNil() {
+			clearUnset(loaded.Elem(), set.Elem())
+		}
+	case reflect.Struct:
+		for i := range loaded.NumField() {
+			if loaded.Type().Field(i).IsExported() {
+				clearUnset(loaded.Field(i), set.Field(i))
+			}
+		}
+	case reflect.Slice:
+		for i := range min(loaded.Len(), set.Len()) {
+			clearUnset(loaded.Index(i), set.Index(i))
+		}
+	}
+}
+
+func (c *Config) validate(writableStorage bool) error {
+	var problems []string
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	now := time.Now()
+
+	if len(c.Accounts) == 0 {
+		expandStoragePath("", DefaultAccountName, &c.Storage, now, writableStorage, add)
+		validateAccount("", &c.IMAP, &c.Storage, writableStorage, add)
+	} else {
+		if c.IMAP.Host != "" || c.Storage.Path != "" {
+			add("imap and storage must be set per account when accounts is used")
+		}
+
+		names := make(map[string]bool)
+		paths := make(map[string]string)
+		for i := range c.Accounts {
+			a := &c.Accounts[i]
+
+			prefix := fmt.Sprintf("account %s: ", a.Name)
+			switch {
+			case a.Name == "":
+				prefix = fmt.Sprintf("account %d: ", i+1)
+				add("account %d: name is required", i+1)
+			case names[a.Name]:
+				add("duplicate account name %q", a.Name)
+			}
+			names[a.Name] = true
+
+			expandStoragePath(prefix, a.Name, &a.Storage, now, writableStorage, add)
+			if other, ok := paths[a.Storage.Path]; ok && a.Storage.Path != "" {
+				add("accounts %s and %s share storage path %s", other, a.Name, a.Storage.Path)
+			}
+			paths[a.Storage.Path] = a.Name
+
+			validateAccount(prefix, &a.IMAP, &a.Storage, writableStorage, add)
+		}
+	}
+
+	if _, err := c.Sync.MaxMessageSizeBytes(); err != nil {
+		add("%v", err)
+	}
+	for _, pattern := range slices.Sorted(maps.Keys(c.Sync.MailboxPolicies)) {
+		switch policy := c.Sync.MailboxPolicies[pattern]; {
+		case pattern == "":
+			add("sync.mailbox_policies: mailbox pattern must not be empty")
+		case policy != "full" && policy != "headers" && policy != "metadata":
+			add("sync.mailbox_policies: policy of %q must be full, headers or metadata, got %q", pattern, policy)
+		}
+	}
+
+	switch c.Log.Level {
+	case "", "debug", "info", "warn", "error":
+	default:
+		add("log.level must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+	switch c.Log.Format {
+	case "", "text", "json":
+	default:
+		add("log.format must be text or json, got %q", c.Log.Format)
+	}
+	if c.Log.MaxSizeMB < 0 {
+		add("log.max_size_mb must not be negative, got %d", c.Log.MaxSizeMB)
+	}
+	if c.Log.MaxBackups < 0 {
+		add("log.max_backups must not be negative, got %d", c.Log.MaxBackups)
+	}
+	if c.Log.File == "" && (c.Log.MaxSizeMB > 0 || c.Log.MaxBackups > 0) {
+		add("log.max_size_mb and log.max_backups require log.file")
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// validateAccount checks one imap and storage pair, resolving its password.
+// Problems are reported through add, prefixed to name the account.
+func validateAccount(prefix string, imap *IMAPConfig, storage *StorageConfig, writableStorage bool, add func(string, ...any)) {
+	if imap.Host == "" {
+		add("%simap.host is required", prefix)
+	}
+	if imap.Port == 0 {
+		imap.Port = imap.DefaultPort()
+	}
+	if imap.Port < 1 || imap.Port > 65535 {
+		add("%simap.port must be between 1 and 65535, got %d", prefix, imap.Port)
+	}
+	if imap.Username == "" {
+		add("%simap.username is required", prefix)
+	}
+
+	sources := imap.passwordSources()
+	if len(sources) > 1 {
+		add("%simap: only one password source may be set, got %s", prefix, strings.Join(sources, ", "))
+	}
+
+	switch method := imap.Auth.MethodOrDefault(); method {
+	case "password":
+		if imap.Auth.Token != "" || imap.Auth.TokenCommand != "" {
+			add("%simap.auth.token and imap.auth.token_command need imap.auth.method xoauth2", prefix)
+		}
+		if len(sources) == 0 {
+			add("%simap.password is required (or password_file, password_env, password_command)", prefix)
+		}
+	case "xoauth2":
+		if len(sources) > 0 {
+			add("%simap.%s can't be used with imap.auth.method xoauth2", prefix, sources[0])
+		}
+		if imap.Auth.Token != "" && imap.Auth.TokenCommand != "" {
+			add("%simap.auth.token and imap.auth.token_command are mutually exclusive", prefix)
+		}
+		if imap.Auth.Token == "" && imap.Auth.TokenCommand == "" {
+			add("%simap.auth.token or imap.auth.token_command is required for xoauth2", prefix)
+		}
+	default:
+		add("%simap.auth.method must be password or xoauth2, got %q", prefix, method)
+	}
+
+	if len(sources) == 1 {
+		if err := imap.resolvePassword(); err != nil {
+			add("%simap: %v", prefix, err)
+		}
+	}
+
+	if imap.KeepAlive < 0 {
+		add("%simap.keepalive must not be negative, got %s", prefix, imap.KeepAlive)
+	}
+	if imap.MaxRetries != nil && *imap.MaxRetries < 0 {
+		add("%simap.max_retries must not be negative, got %d", prefix, *imap.MaxRetries)
+	}
+	if imap.MaxBackoff < 0 {
+		add("%simap.max_backoff must not be negative, got %s", prefix, imap.MaxBackoff)
+	}
+
+	if strings.ContainsAny(imap.ListPattern+imap.ListReference, "\r\n") {
+		add("%simap.list_pattern and imap.list_reference must not contain line breaks", prefix)
+	}
+
+	// The URL may hold proxy credentials, so it is never echoed back.
+	if imap.Proxy != "" {
+		switch u, err := url.Parse(imap.Proxy); {
+		case err != nil:
+			add("%simap.proxy is not a valid URL", prefix)
+		case u.Scheme != "socks5" && u.Scheme != "socks5h" && u.Scheme != "http":
+			add("%simap.proxy must be a socks5://, socks5h:// or http:// URL, got scheme %q", prefix, u.Scheme)
+		case u.Hostname() == "":
+			add("%simap.proxy must include a host", prefix)
+		}
+	}
+
+	if storage.Path == "" {
+		add("%sstorage.path is required", prefix)
+	} else if writableStorage {
+		if err := checkWritableDir(filepath.Dir(storage.Path)); err != nil {
+			add("%sstorage.path: %v", prefix, err)
+		}
+	}
+
+	switch compression := storage.CompressionOrDefault(); compression {
+	case "gzip", "zstd", "none":
+	default:
+		add("%sstorage.compression must be gzip, zstd or none, got %q", prefix, compression)
+	}
+	if storage.WriteRetries != nil && *storage.WriteRetries < 0 {
+		add("%sstorage.write_retries must not be negative, got %d", prefix, *storage.WriteRetries)
+	}
+
+	switch {
+	case storage.EncryptionKey != "" && storage.EncryptionKeyFile != "":
+		add("%sstorage.encryption_key and storage.encryption_key_file are mutually exclusive", prefix)
+	case storage.EncryptionKeyFile != "":
+		data, err := os.ReadFile(storage.EncryptionKeyFile)
+		if err != nil {
+			add("%sstorage: failed to read encryption_key_file: %v", prefix, err)
+			break
+		}
+		storage.EncryptionKey = strings.TrimRight(string(data), "\r\n")
+		if storage.EncryptionKey == "" {
+			add("%sstorage.encryption_key_file is empty", prefix)
+		}
+	}
+}
+
+// pathVariableRe matches a variable of a storage.path template.
+var pathVariableRe = regexp.MustCompile(`\{[^{}/]*\}`)
+
+// expandStoragePath replaces the variables of a storage.path template:
+// {account} with the account name, and {date} and {year} with the local date
+// at now, as 2006-01-02 and 2006. Directories only a template names, such as
+// one per account or year, can't be made in advance, so they are created for
+// commands that write to storage.
+func expandStoragePath(prefix, account string, storage *StorageConfig, now time.Time, writableStorage bool, add func(string, ...any)) {
+	if !pathVariableRe.MatchString(storage.Path) {
+		return
+	}
+
+	var problems []string
+	storage.Path = pathVariableRe.ReplaceAllStringFunc(storage.Path, func(variable string) string {
+		switch variable {
+		case "{account}":
+			if account == "." || account == ".." || strings.ContainsAny(account, `/\`) {
+				problems = append(problems, fmt.Sprintf("account name %q can't be used in a path", account))
+			}
+			return account
+		case "{date}":
+			return now.Format(time.DateOnly)
+		case "{year}":
+			return now.Format("2006")
+		}
+		problems = append(problems, fmt.Sprintf("unknown variable %s, expected {account}, {date} or {year}", variable))
+		return variable
+	})
+	for _, problem := range problems {
+		add("%sstorage.path: %s", prefix, problem)
+	}
+
+	if len(problems) == 0 && writableStorage {
+		// A failure is reported by the writable check of validateAccount.
+		os.MkdirAll(filepath.Dir(storage.Path), 0o700)
+	}
+}
+
+// checkWritableDir reports whether files can be created in dir, by creating
+// and removing one.
+func checkWritableDir(dir string) error {
+	f, err := os.CreateTemp(dir, ".imapsync-check-*")
+	if err != nil {
+		return fmt.Errorf("directory %s is not writable: %w", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+)
+
+// Template is a commented starter config covering every supported key. It
+// loads as-is, with placeholder credentials.
+//
+//go:embed template.yaml
+var Template string
+
+// WriteTemplate writes Template to path, readable only by the owner since it
+// holds a password. An existing file is only replaced when force is set.
+func WriteTemplate(path string, force bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(path, flags, 0600)
+	if os.IsExist(err) {
+		return fmt.Errorf("%s already exists, use --force to overwrite it", path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create config file: %w", err)
+	}
+
+	// OpenFile keeps the mode of a file it truncates.
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to set config file permissions: %w", err)
+	}
+
+	if _, err := f.WriteString(Template); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	return f.Close()
+}
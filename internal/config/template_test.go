@@ -0,0 +1,48 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+
+	require.NoError(t, WriteTemplate(path, false))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, "imap.example.com", cfg.IMAP.Host)
+	assert.Equal(t, 993, cfg.IMAP.Port)
+	assert.True(t, cfg.IMAP.TLS)
+	assert.Equal(t, "./emails-backup.sqlite3", cfg.Storage.Path)
+
+	t.Run("refuses to overwrite", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte("mine"), 0644))
+
+		assert.ErrorContains(t, WriteTemplate(path, false), "already exists, use --force")
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "mine", string(data))
+	})
+
+	t.Run("force overwrites", func(t *testing.T) {
+		require.NoError(t, WriteTemplate(path, true))
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, Template, string(data))
+
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	})
+}
@@ -0,0 +1,9 @@
+// Package export writes stored emails to standard mailbox formats that other
+// mail clients can import.
+package export
+
+// Stats reports the outcome of exporting a mailbox.
+type Stats struct {
+	Exported int
+	Skipped  int
+}
@@ -0,0 +1,97 @@
+package export
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/newsamples/imapsync/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// maildirFlags maps IMAP system flags to Maildir info flags.
+var maildirFlags = map[string]byte{
+	`\answered`: 'R',
+	`\deleted`:  'T',
+	`\flagged`:  'F',
+	`\seen`:     'S',
+}
+
+// Maildir writes every stored email of a mailbox to dir as a Maildir tree,
+// one file per message. Messages are written to tmp/ and moved to cur/ with
+// their flags in the filename, so a partially written file is never visible.
+// Emails stored without a raw message are skipped with a warning.
+func Maildir(store *storage.Storage, mailbox, dir string, log *logrus.Logger) (*Stats, error) {
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create maildir: %w", err)
+		}
+	}
+
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	host = maildirHost(host)
+	pid := os.Getpid()
+
+	stats := &Stats{}
+
+	err = store.StreamRawMessages(mailbox, func(email *storage.Email) error {
+		if len(email.RawMessage) == 0 {
+			log.Warnf("Skipping UID %d in %s: no raw message stored", email.UID, mailbox)
+			stats.Skipped++
+			return nil
+		}
+
+		name := maildirName(email, pid, host)
+		tmpPath := filepath.Join(dir, "tmp", name)
+		if err := os.WriteFile(tmpPath, email.RawMessage, 0o600); err != nil {
+			return fmt.Errorf("failed to write UID %d: %w", email.UID, err)
+		}
+
+		// Mail clients show the file time when a message has no Date header.
+		if !email.Date.IsZero() {
+			_ = os.Chtimes(tmpPath, email.Date, email.Date)
+		}
+
+		curPath := filepath.Join(dir, "cur", name+":2,"+maildirInfo(email.Flags))
+		if err := os.Rename(tmpPath, curPath); err != nil {
+			os.Remove(tmpPath)
+			return fmt.Errorf("failed to move UID %d into cur: %w", email.UID, err)
+		}
+
+		stats.Exported++
+		return nil
+	})
+
+	return stats, err
+}
+
+// maildirName returns the unique part of a message filename, following the
+// time.pid.host convention. The UID in the delivery identifier keeps names
+// unique within one export.
+func maildirName(email *storage.Email, pid int, host string) string {
+	return fmt.Sprintf("%d.P%dQ%d.%s", email.Date.Unix(), pid, email.UID, host)
+}
+
+// maildirInfo converts IMAP flags to Maildir info flags in ASCII order, as
+// the format requires. Flags without a Maildir equivalent are dropped.
+func maildirInfo(flags []string) string {
+	var info []byte
+	for _, flag := range flags {
+		if c, ok := maildirFlags[strings.ToLower(flag)]; ok && !slices.Contains(info, c) {
+			info = append(info, c)
+		}
+	}
+	slices.Sort(info)
+	return string(info)
+}
+
+// maildirHost escapes the characters that are significant in Maildir
+// filenames, as the format specifies.
+func maildirHost(host string) string {
+	return strings.NewReplacer("/", `\057`, ":", `\072`).Replace(host)
+}
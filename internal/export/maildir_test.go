@@ -0,0 +1,70 @@
+package export
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/newsamples/imapsync/internal/storage"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaildir(t *testing.T) {
+	store := newTestStorage(t)
+
+	date := time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)
+	require.NoError(t, store.SaveEmailBatch([]*storage.Email{
+		{UID: 1, Mailbox: "INBOX", Date: date, Flags: []string{`\Seen`, `\Flagged`, `\Answered`, `$Label1`}, RawMessage: []byte("Subject: one\r\n\r\n1")},
+		{UID: 2, Mailbox: "INBOX", Date: date, Flags: []string{}, RawMessage: []byte("Subject: two\r\n\r\n2")},
+		{UID: 3, Mailbox: "INBOX", Date: date, Flags: []string{`\Seen`}},
+	}))
+
+	dir := filepath.Join(t.TempDir(), "backup")
+	stats, err := Maildir(store, "INBOX", dir, logrus.New())
+	require.NoError(t, err)
+	assert.Equal(t, &Stats{Exported: 2, Skipped: 1}, stats)
+
+	for _, sub := range []string{"new", "tmp"} {
+		entries, err := os.ReadDir(filepath.Join(dir, sub))
+		require.NoError(t, err)
+		assert.Empty(t, entries, sub)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "cur"))
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	nameRe := regexp.MustCompile(`^(\d+)\.P\d+Q(\d+)\.[^/:]+:2,([A-Z]*)$`)
+	infos := map[string]string{}
+	for _, entry := range entries {
+		m := nameRe.FindStringSubmatch(entry.Name())
+		require.NotNil(t, m, entry.Name())
+		assert.Equal(t, "1706933106", m[1])
+		infos[m[2]] = m[3]
+
+		path := filepath.Join(dir, "cur", entry.Name())
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "Subject: ")
+
+		fi, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.True(t, date.Equal(fi.ModTime()))
+	}
+	assert.Equal(t, map[string]string{"1": "FRS", "2": ""}, infos)
+}
+
+func TestMaildirInfo(t *testing.T) {
+	assert.Equal(t, "", maildirInfo(nil))
+	assert.Equal(t, "FRST", maildirInfo([]string{`\Seen`, `\Deleted`, `\Answered`, `\Flagged`}))
+	assert.Equal(t, "S", maildirInfo([]string{`\SEEN`, `\seen`, `\Recent`}))
+}
+
+func TestMaildirHost(t *testing.T) {
+	assert.Equal(t, "mail.example.com", maildirHost("mail.example.com"))
+	assert.Equal(t, `a\057b\072c`, maildirHost("a/b:c"))
+}
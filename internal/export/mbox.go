@@ -0,0 +1,125 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/mail"
+	"time"
+
+	"github.com/newsamples/imapsync/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// mboxDateLayout is the asctime format used in mbox "From " separator lines.
+const mboxDateLayout = "Mon Jan _2 15:04:05 2006"
+
+// MboxWriter writes emails to an mbox file in the mboxrd variant: lines of a
+// message that start with "From ", optionally preceded by '>' characters, get
+// one more '>' so readers can undo the quoting unambiguously.
+type MboxWriter struct {
+	w *bufio.Writer
+}
+
+// NewMboxWriter returns an MboxWriter writing to w. Call Flush when done.
+func NewMboxWriter(w io.Writer) *MboxWriter {
+	return &MboxWriter{w: bufio.NewWriter(w)}
+}
+
+// Write appends an email's raw message, preceded by a separator line built
+// from its sender and date. Lines are written with LF endings.
+func (m *MboxWriter) Write(email *storage.Email) error {
+	if len(email.RawMessage) == 0 {
+		return fmt.Errorf("email UID %d has no raw message", email.UID)
+	}
+
+	if _, err := fmt.Fprintf(m.w, "From %s %s\n", envelopeSender(email.From), mboxDate(email.Date)); err != nil {
+		return err
+	}
+
+	raw := bytes.ReplaceAll(email.RawMessage, []byte("\r\n"), []byte("\n"))
+	raw = bytes.TrimSuffix(raw, []byte("\n"))
+
+	for _, line := range bytes.Split(raw, []byte("\n")) {
+		if isFromLine(line) {
+			if err := m.w.WriteByte('>'); err != nil {
+				return err
+			}
+		}
+		if _, err := m.w.Write(line); err != nil {
+			return err
+		}
+		if err := m.w.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+
+	// A blank line separates the message from the next "From " line.
+	return m.w.WriteByte('\n')
+}
+
+// Flush writes any buffered data to the underlying writer.
+func (m *MboxWriter) Flush() error {
+	return m.w.Flush()
+}
+
+// Mbox writes every stored email of a mailbox to w as a single mbox file.
+// Emails stored without a raw message are skipped with a warning.
+func Mbox(store *storage.Storage, mailbox string, w io.Writer, log *logrus.Logger) (*Stats, error) {
+	return MboxAfter(store, mailbox, 0, w, log)
+}
+
+// MboxAfter is like Mbox but only writes the emails with UIDs above
+// afterUID. Emails are written in UID order, so appending its output to an
+// export cut short after afterUID gives the whole mailbox.
+func MboxAfter(store *storage.Storage, mailbox string, afterUID uint32, w io.Writer, log *logrus.Logger) (*Stats, error) {
+	mw := NewMboxWriter(w)
+	stats := &Stats{}
+
+	err := store.StreamRawMessagesAfter(mailbox, afterUID, func(email *storage.Email) error {
+		if len(email.RawMessage) == 0 {
+			log.Warnf("Skipping UID %d in %s: no raw message stored", email.UID, mailbox)
+			stats.Skipped++
+			return nil
+		}
+		if err := mw.Write(email); err != nil {
+			return fmt.Errorf("failed to write UID %d: %w", email.UID, err)
+		}
+		stats.Exported++
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	if err := mw.Flush(); err != nil {
+		return stats, fmt.Errorf("failed to write mbox: %w", err)
+	}
+
+	return stats, nil
+}
+
+// isFromLine reports whether line matches ^>*From , which must be quoted.
+func isFromLine(line []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From "))
+}
+
+// envelopeSender returns the bare address of a From header value, or
+// MAILER-DAEMON when there isn't a usable one.
+func envelopeSender(from string) string {
+	addr, err := mail.ParseAddress(from)
+	if err != nil || addr.Address == "" {
+		return "MAILER-DAEMON"
+	}
+	return addr.Address
+}
+
+// mboxDate formats the separator date in UTC. Emails without a date get the
+// Unix epoch, which readers accept.
+func mboxDate(t time.Time) string {
+	if t.IsZero() {
+		t = time.Unix(0, 0)
+	}
+	return t.UTC().Format(mboxDateLayout)
+}
@@ -0,0 +1,127 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/newsamples/imapsync/internal/storage"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mboxMessage is a message read back from an mbox file.
+type mboxMessage struct {
+	separator string
+	raw       string
+}
+
+// parseMbox splits an mboxrd file into messages, undoing the >From quoting.
+func parseMbox(t *testing.T, data []byte) []mboxMessage {
+	t.Helper()
+
+	var msgs []mboxMessage
+	var body []string
+	flush := func() {
+		if len(msgs) == 0 {
+			return
+		}
+		// Drop the blank line that separates messages.
+		if n := len(body); n > 0 && body[n-1] == "" {
+			body = body[:n-1]
+		}
+		msgs[len(msgs)-1].raw = strings.Join(body, "\n")
+		body = nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "From ") {
+			flush()
+			msgs = append(msgs, mboxMessage{separator: line})
+			continue
+		}
+		require.NotEmpty(t, msgs, "content before the first separator: %q", line)
+		if strings.HasPrefix(strings.TrimLeft(line, ">"), "From ") {
+			line = line[1:]
+		}
+		body = append(body, line)
+	}
+	require.NoError(t, scanner.Err())
+	flush()
+
+	return msgs
+}
+
+func newTestStorage(t *testing.T) *storage.Storage {
+	t.Helper()
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+	store, err := storage.New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestMbox_RoundTrip(t *testing.T) {
+	store := newTestStorage(t)
+
+	first := "From: Alice <alice@example.com>\r\nSubject: Hello\r\n\r\nFrom the top.\r\n>From quoted already\r\nNot a From line\r\n"
+	second := "From: bob@example.com\nSubject: Second\n\nbody without trailing newline"
+	date := time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)
+
+	require.NoError(t, store.SaveEmailBatch([]*storage.Email{
+		{UID: 1, Mailbox: "INBOX", From: "Alice <alice@example.com>", Date: date, Flags: []string{}, RawMessage: []byte(first)},
+		{UID: 2, Mailbox: "INBOX", From: "not an address", Date: date.Add(time.Hour), Flags: []string{}},
+		{UID: 3, Mailbox: "INBOX", From: "bob@example.com", Date: date.Add(2 * time.Hour), Flags: []string{}, RawMessage: []byte(second)},
+	}))
+
+	var buf bytes.Buffer
+	stats, err := Mbox(store, "INBOX", &buf, logrus.New())
+	require.NoError(t, err)
+	assert.Equal(t, &Stats{Exported: 2, Skipped: 1}, stats)
+
+	assert.Contains(t, buf.String(), "\n>From the top.\n")
+	assert.Contains(t, buf.String(), "\n>>From quoted already\n")
+	assert.NotContains(t, buf.String(), "\r")
+
+	msgs := parseMbox(t, buf.Bytes())
+	require.Len(t, msgs, 2)
+
+	assert.Equal(t, "From alice@example.com Sat Feb  3 04:05:06 2024", msgs[0].separator)
+	assert.Equal(t, strings.TrimSuffix(strings.ReplaceAll(first, "\r\n", "\n"), "\n"), msgs[0].raw)
+
+	assert.Equal(t, "From bob@example.com Sat Feb  3 06:05:06 2024", msgs[1].separator)
+	assert.Equal(t, second, msgs[1].raw)
+}
+
+func TestMbox_ClosedStorage(t *testing.T) {
+	store := newTestStorage(t)
+	store.Close()
+
+	_, err := Mbox(store, "INBOX", &bytes.Buffer{}, logrus.New())
+	assert.Error(t, err)
+}
+
+func TestMboxWriter_NoRawMessage(t *testing.T) {
+	mw := NewMboxWriter(&bytes.Buffer{})
+	assert.Error(t, mw.Write(&storage.Email{UID: 7}))
+}
+
+func TestEnvelopeSender(t *testing.T) {
+	assert.Equal(t, "alice@example.com", envelopeSender("Alice <alice@example.com>"))
+	assert.Equal(t, "bob@example.com", envelopeSender("bob@example.com"))
+	assert.Equal(t, "MAILER-DAEMON", envelopeSender(""))
+	assert.Equal(t, "MAILER-DAEMON", envelopeSender("undisclosed"))
+}
+
+func TestMboxDate(t *testing.T) {
+	loc := time.FixedZone("EST", -5*3600)
+	assert.Equal(t, "Mon Jan  1 05:00:00 2024", mboxDate(time.Date(2024, 1, 1, 0, 0, 0, 0, loc)))
+	assert.Equal(t, "Thu Jan  1 00:00:00 1970", mboxDate(time.Time{}))
+}
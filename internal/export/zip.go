@@ -0,0 +1,74 @@
+package export
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/newsamples/imapsync/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// Zip writes every stored email of mailboxes to w as a zip archive with one
+// mailbox/uid.eml entry per message. Messages are streamed one at a time, so
+// memory use doesn't grow with the backup. Emails stored without a raw
+// message are skipped with a warning.
+func Zip(store *storage.Storage, mailboxes []string, w io.Writer, log *logrus.Logger) (*Stats, error) {
+	return ZipAfter(store, mailboxes, 0, w, log)
+}
+
+// ZipAfter is like Zip but only adds the emails with UIDs above afterUID,
+// in UID order, so a single-mailbox archive cut short can be completed by a
+// second one.
+func ZipAfter(store *storage.Storage, mailboxes []string, afterUID uint32, w io.Writer, log *logrus.Logger) (*Stats, error) {
+	zw := zip.NewWriter(w)
+	stats := &Stats{}
+
+	for _, mailbox := range mailboxes {
+		dir := zipDir(mailbox)
+		err := store.StreamRawMessagesAfter(mailbox, afterUID, func(email *storage.Email) error {
+			if len(email.RawMessage) == 0 {
+				log.Warnf("Skipping UID %d in %s: no raw message stored", email.UID, mailbox)
+				stats.Skipped++
+				return nil
+			}
+
+			f, err := zw.CreateHeader(&zip.FileHeader{
+				Name:     fmt.Sprintf("%s/%d.eml", dir, email.UID),
+				Method:   zip.Deflate,
+				Modified: email.Date,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to add UID %d: %w", email.UID, err)
+			}
+			if _, err := f.Write(email.RawMessage); err != nil {
+				return fmt.Errorf("failed to write UID %d: %w", email.UID, err)
+			}
+			stats.Exported++
+			return nil
+		})
+		if err != nil {
+			return stats, fmt.Errorf("failed to export %s: %w", mailbox, err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return stats, fmt.Errorf("failed to write zip: %w", err)
+	}
+
+	return stats, nil
+}
+
+// zipDir returns the archive directory for a mailbox. Hierarchy levels
+// become directories; empty, "." and ".." levels are replaced so entries
+// can't land outside the archive when it's extracted.
+func zipDir(mailbox string) string {
+	parts := strings.Split(mailbox, "/")
+	for i, part := range parts {
+		if part == "" || part == "." || part == ".." {
+			parts[i] = "_"
+		}
+	}
+	return strings.Join(parts, "/")
+}
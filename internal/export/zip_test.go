@@ -0,0 +1,63 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/newsamples/imapsync/internal/storage"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZip(t *testing.T) {
+	store := newTestStorage(t)
+
+	date := time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)
+	require.NoError(t, store.SaveEmailBatch([]*storage.Email{
+		{UID: 1, Mailbox: "INBOX", Date: date, Flags: []string{}, RawMessage: []byte("Subject: One\r\n\r\nfirst\r\n")},
+		{UID: 2, Mailbox: "INBOX", Date: date, Flags: []string{}},
+		{UID: 7, Mailbox: "Archive/2024", Date: date, Flags: []string{}, RawMessage: []byte("Subject: Seven\r\n\r\nseventh\r\n")},
+	}))
+
+	var buf bytes.Buffer
+	stats, err := Zip(store, []string{"INBOX", "Archive/2024"}, &buf, logrus.New())
+	require.NoError(t, err)
+	assert.Equal(t, &Stats{Exported: 2, Skipped: 1}, stats)
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	entries := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		entries[f.Name] = string(data)
+		assert.True(t, date.Equal(f.Modified), "entries carry the message date")
+	}
+	assert.Equal(t, map[string]string{
+		"INBOX/1.eml":        "Subject: One\r\n\r\nfirst\r\n",
+		"Archive/2024/7.eml": "Subject: Seven\r\n\r\nseventh\r\n",
+	}, entries)
+}
+
+func TestZip_ClosedStorage(t *testing.T) {
+	store := newTestStorage(t)
+	store.Close()
+
+	_, err := Zip(store, []string{"INBOX"}, &bytes.Buffer{}, logrus.New())
+	assert.Error(t, err)
+}
+
+func TestZipDir(t *testing.T) {
+	assert.Equal(t, "INBOX", zipDir("INBOX"))
+	assert.Equal(t, "Archive/2024", zipDir("Archive/2024"))
+	assert.Equal(t, "_/_/etc", zipDir("../../etc"))
+	assert.Equal(t, "_/root", zipDir("/root"))
+}
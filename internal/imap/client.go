@@ -11,10 +11,13 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/emersion/go-imap/v2"
 	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/newsamples/imapsync/internal/mailparse"
+	"github.com/newsamples/imapsync/internal/metrics"
 	"github.com/sirupsen/logrus"
 )
 
@@ -23,9 +26,72 @@ type Client struct {
 	opts             ConnectOptions
 	log              *logrus.Logger
 	retries          int
+	maxBackoff       time.Duration
 	fetchGmailLabels bool
 	mu               sync.Mutex
 	unilateralNotify func()
+
+	// cmdMu serializes use of client between commands and the keepalive
+	// goroutine. lastUsed is guarded by it.
+	cmdMu    sync.Mutex
+	lastUsed time.Time
+
+	stopKeepAlive chan struct{}
+	keepAliveDone chan struct{}
+
+	// selected is the mailbox last selected on client, guarded by cmdMu.
+	selected string
+
+	// compressed is set when the connection negotiated COMPRESS=DEFLATE.
+	compressed bool
+
+	// isGmail caches IsGmail, guarded by mu.
+	isGmail *bool
+
+	// delimiter is the personal namespace's hierarchy delimiter, 0 for a
+	// flat namespace. It is refreshed on every connect, guarded by mu.
+	delimiter rune
+
+	// gmail is the side connection fetching Gmail IDs, opened on first use.
+	// It and gmailUnavailable are guarded by gmailMu.
+	gmailMu          sync.Mutex
+	gmail            *gmailConn
+	gmailUnavailable bool
+
+	// reconnectAttempts and reconnects back RetryStats.
+	reconnectAttempts atomic.Int64
+	reconnects        atomic.Int64
+
+	// appendFunc replaces appendOnce in tests, to lose the connection at a
+	// chosen point of an APPEND.
+	appendFunc func(c *Client, mailbox string, flags []imap.Flag, internalDate time.Time, raw []byte) (bool, error)
+}
+
+// RetryStats counts how often a Client had to reconnect after a network
+// error.
+type RetryStats struct {
+	// ReconnectAttempts is the number of new connections tried, including
+	// failed ones.
+	ReconnectAttempts int64 `json:"reconnect_attempts"`
+	// Reconnects is the number of attempts that succeeded.
+	Reconnects int64 `json:"reconnects"`
+}
+
+// Sub returns the counts since earlier, a RetryStats of the same Client.
+func (s RetryStats) Sub(earlier RetryStats) RetryStats {
+	return RetryStats{
+		ReconnectAttempts: s.ReconnectAttempts - earlier.ReconnectAttempts,
+		Reconnects:        s.Reconnects - earlier.Reconnects,
+	}
+}
+
+// RetryStats returns the reconnections since the client connected. It is
+// safe to call while commands run.
+func (c *Client) RetryStats() RetryStats {
+	return RetryStats{
+		ReconnectAttempts: c.reconnectAttempts.Load(),
+		Reconnects:        c.reconnects.Load(),
+	}
 }
 
 type ConnectOptions struct {
@@ -35,17 +101,62 @@ type ConnectOptions struct {
 	Password string
 	TLS      bool
 	Logger   *logrus.Logger
+
+	// AuthMethod is AuthPassword (default) or AuthXOAuth2.
+	AuthMethod string
+	// AccessToken is the OAuth2 access token used with AuthXOAuth2.
+	AccessToken string
+	// TokenFunc, when set, is called on every (re)connect to obtain a fresh
+	// access token and takes precedence over AccessToken.
+	TokenFunc func() (string, error)
+
+	// Proxy is a socks5://, socks5h:// or http:// URL to connect through,
+	// optionally with user:password. Empty connects directly.
+	Proxy string
+
+	// KeepAlive, when positive, sends a NOOP whenever the connection has
+	// been idle this long, so slow syncs aren't logged out by the server.
+	KeepAlive time.Duration
+
+	// Compress enables COMPRESS=DEFLATE after login when the server
+	// supports it, which speeds up fetches over slow links.
+	Compress bool
+
+	// ListReference and ListPattern select the mailboxes ListMailboxes
+	// returns, as the arguments of LIST. An empty pattern lists them all.
+	ListReference string
+	ListPattern   string
+	// SubscribedOnly limits ListMailboxes to subscribed mailboxes.
+	SubscribedOnly bool
+
+	// MaxRetries is how many times a command that failed on a network
+	// error is retried, and how many reconnects each retry attempts. 0
+	// disables retries; nil uses DefaultMaxRetries.
+	MaxRetries *int
+	// MaxBackoff caps the wait between reconnect attempts, which doubles
+	// from a second. 0 uses DefaultMaxBackoff.
+	MaxBackoff time.Duration
 }
 
+// Defaults for ConnectOptions.MaxRetries and MaxBackoff.
+const (
+	DefaultMaxRetries = 3
+	DefaultMaxBackoff = 30 * time.Second
+)
+
 type Message struct {
-	UID         uint32
-	Flags       []imap.Flag
-	Size        uint32
-	Envelope    *imap.Envelope
-	Body        []byte
-	Headers     []byte
-	RawMessage  []byte
-	GmailLabels []string // Gmail labels from X-GM-LABELS extension
+	UID          uint32
+	Flags        []imap.Flag
+	Size         uint32
+	Envelope     *imap.Envelope
+	InternalDate time.Time // when the server received the message
+	Headers      []byte
+	RawMessage   []byte
+	GmailLabels  []string // Gmail labels from X-GM-LABELS extension
+	// GmailMsgID and GmailThreadID are Gmail's X-GM-MSGID and X-GM-THRID,
+	// fetched alongside labels; 0 when unknown.
+	GmailMsgID    uint64
+	GmailThreadID uint64
 }
 
 func Connect(opts ConnectOptions) (*Client, error) {
@@ -56,18 +167,33 @@ func Connect(opts ConnectOptions) (*Client, error) {
 	client := &Client{
 		opts:             opts,
 		log:              opts.Logger,
-		retries:          3,
+		retries:          DefaultMaxRetries,
+		maxBackoff:       DefaultMaxBackoff,
 		fetchGmailLabels: false,
 	}
+	if opts.MaxRetries != nil {
+		if *opts.MaxRetries < 0 {
+			return nil, fmt.Errorf("max retries must not be negative, got %d", *opts.MaxRetries)
+		}
+		client.retries = *opts.MaxRetries
+	}
+	if opts.MaxBackoff > 0 {
+		client.maxBackoff = opts.MaxBackoff
+	}
 
 	if err := client.connect(); err != nil {
 		return nil, err
 	}
 
+	if opts.KeepAlive > 0 {
+		client.startKeepAlive(opts.KeepAlive)
+	}
+
 	return client, nil
 }
 
-// SetFetchGmailLabels enables or disables fetching Gmail labels via X-GM-LABELS extension.
+// SetFetchGmailLabels enables or disables fetching Gmail labels via X-GM-LABELS
+// extension, along with the X-GM-MSGID and X-GM-THRID of each message.
 func (c *Client) SetFetchGmailLabels(enabled bool) {
 	c.fetchGmailLabels = enabled
 }
@@ -88,27 +214,65 @@ func (c *Client) connect() error {
 		},
 	}
 
+	var client *imapclient.Client
+	var err error
+	if c.opts.Compress {
+		c.compressed = false
+		client, err = c.dialCompressed(opts)
+	} else {
+		client, err = c.dialLogin(addr, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.loadNamespace(client)
+
+	c.client = client
+	c.selected = ""
+	c.lastUsed = time.Now()
+	return nil
+}
+
+// dialLogin connects with imapclient and logs in.
+func (c *Client) dialLogin(addr string, opts *imapclient.Options) (*imapclient.Client, error) {
 	var client *imapclient.Client
 	var err error
 
 	if c.opts.TLS {
 		opts.TLSConfig = &tls.Config{ServerName: c.opts.Host}
+	}
+
+	switch {
+	case c.opts.Proxy != "":
+		client, err = c.dialProxy(addr, opts)
+	case c.opts.TLS:
 		client, err = imapclient.DialTLS(addr, opts)
-	} else {
+	default:
 		client, err = imapclient.DialInsecure(addr, opts)
 	}
 
 	if err != nil {
-		return fmt.Errorf("failed to connect: %w", err)
+		return nil, fmt.Errorf("failed to connect: %w", err)
 	}
 
-	if err := client.Login(c.opts.Username, c.opts.Password).Wait(); err != nil {
+	saslClient, err := c.saslClient()
+	if err != nil {
 		client.Close()
-		return fmt.Errorf("failed to login: %w", err)
+		return nil, err
 	}
 
-	c.client = client
-	return nil
+	if saslClient != nil {
+		if err := client.Authenticate(saslClient); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("failed to authenticate: %w", authFailure(err))
+		}
+	} else if err := client.Login(c.opts.Username, c.opts.Password).Wait(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to login: %w", authFailure(err))
+	}
+
+	return client, nil
 }
 
 func (c *Client) reconnect(ctx context.Context) error {
@@ -118,7 +282,7 @@ func (c *Client) reconnect(ctx context.Context) error {
 	}
 
 	maxRetries := c.retries
-	backoff := time.Second
+	backoff := min(time.Second, c.maxBackoff)
 
 	for attempt := 1; attempt <= maxRetries; attempt++ {
 		select {
@@ -128,18 +292,19 @@ func (c *Client) reconnect(ctx context.Context) error {
 		}
 
 		c.log.Infof("Attempting to reconnect (attempt %d/%d)...", attempt, maxRetries)
+		c.reconnectAttempts.Add(1)
 
 		if err := c.connect(); err != nil {
+			if errors.Is(err, ErrAuthFailed) {
+				return fmt.Errorf("authentication failed, not retrying: %w", err)
+			}
 			c.log.WithError(err).Warnf("Reconnection attempt %d failed", attempt)
 
 			if attempt < maxRetries {
 				c.log.Infof("Waiting %v before retry...", backoff)
 				select {
 				case <-time.After(backoff):
-					backoff *= 2
-					if backoff > 30*time.Second {
-						backoff = 30 * time.Second
-					}
+					backoff = min(backoff*2, c.maxBackoff)
 				case <-ctx.Done():
 					return ctx.Err()
 				}
@@ -148,12 +313,41 @@ func (c *Client) reconnect(ctx context.Context) error {
 		}
 
 		c.log.Info("Reconnected successfully")
+		c.reconnects.Add(1)
+		metrics.Reconnects.Inc()
 		return nil
 	}
 
 	return fmt.Errorf("failed to reconnect after %d attempts", maxRetries)
 }
 
+// ErrAuthFailed matches connection errors where the server rejected the
+// login, which retrying won't fix.
+var ErrAuthFailed = errors.New("authentication failed")
+
+// authError is a login or authentication the server answered with NO or
+// BAD. It reads as the server's response and matches ErrAuthFailed.
+type authError struct {
+	err error
+}
+
+func (e *authError) Error() string { return e.err.Error() }
+
+func (e *authError) Unwrap() error { return e.err }
+
+func (e *authError) Is(target error) bool { return target == ErrAuthFailed }
+
+// authFailure marks err as an authentication failure if the server
+// rejected the command, as opposed to the connection failing.
+func authFailure(err error) error {
+	var imapErr *imap.Error
+	var statusErr *statusError
+	if errors.As(err, &imapErr) || errors.As(err, &statusErr) {
+		return &authError{err: err}
+	}
+	return err
+}
+
 func isNetworkError(err error) bool {
 	if err == nil {
 		return false
@@ -193,6 +387,10 @@ func (c *Client) withRetry(ctx context.Context, operation func() error) error {
 		return ctx.Err()
 	}
 
+	c.cmdMu.Lock()
+	defer c.cmdMu.Unlock()
+	defer func() { c.lastUsed = time.Now() }()
+
 	var lastErr error
 
 	for attempt := 0; attempt <= c.retries; attempt++ {
@@ -228,6 +426,12 @@ func (c *Client) withRetry(ctx context.Context, operation func() error) error {
 }
 
 func (c *Client) Close() error {
+	c.stopKeepAliveLoop()
+	c.closeGmail()
+
+	c.cmdMu.Lock()
+	defer c.cmdMu.Unlock()
+
 	if c.client != nil {
 		return c.client.Logout().Wait()
 	}
@@ -239,20 +443,24 @@ func (c *Client) ListMailboxes() ([]string, error) {
 }
 
 func (c *Client) ListMailboxesWithContext(ctx context.Context) ([]string, error) {
+	pattern := c.opts.ListPattern
+	if pattern == "" {
+		pattern = "*"
+	}
+
 	var result []string
 
 	err := c.withRetry(ctx, func() error {
-		mboxes := c.client.List("", "*", nil)
+		mboxes, err := c.list(c.opts.ListReference, pattern)
+		if err != nil {
+			return fmt.Errorf("failed to list mailboxes: %w", err)
+		}
 
 		result = nil
-		for {
-			mbox := mboxes.Next()
-			if mbox == nil {
-				break
-			}
-
-			// Skip non-selectable mailboxes (like [Gmail] namespace folder)
-			if slices.Contains(mbox.Attrs, imap.MailboxAttrNoSelect) {
+		for _, mbox := range mboxes {
+			// Skip non-selectable mailboxes (like [Gmail] namespace folder),
+			// and subscriptions to mailboxes that were deleted
+			if hasAttr(mbox.Attrs, imap.MailboxAttrNoSelect) || hasAttr(mbox.Attrs, imap.MailboxAttrNonExistent) {
 				c.log.Debugf("Skipping non-selectable mailbox: %s", mbox.Mailbox)
 				continue
 			}
@@ -260,10 +468,6 @@ func (c *Client) ListMailboxesWithContext(ctx context.Context) ([]string, error)
 			result = append(result, mbox.Mailbox)
 		}
 
-		if err := mboxes.Close(); err != nil {
-			return fmt.Errorf("failed to list mailboxes: %w", err)
-		}
-
 		sort.Strings(result)
 		return nil
 	})
@@ -271,6 +475,28 @@ func (c *Client) ListMailboxesWithContext(ctx context.Context) ([]string, error)
 	return result, err
 }
 
+// list runs LIST with ref and pattern, selecting subscribed mailboxes only
+// when configured to. Servers without LIST-EXTENDED are asked with LSUB.
+func (c *Client) list(ref, pattern string) ([]*imap.ListData, error) {
+	if !c.opts.SubscribedOnly {
+		return c.client.List(ref, pattern, nil).Collect()
+	}
+
+	caps := c.client.Caps()
+	if !caps.Has(imap.CapListExtended) && !caps.Has(imap.CapIMAP4rev2) {
+		return c.lsub(ref, pattern)
+	}
+	return c.client.List(ref, pattern, &imap.ListOptions{SelectSubscribed: true}).Collect()
+}
+
+// hasAttr reports whether attrs holds attr. Attributes are
+// case-insensitive, and LSUB replies are parsed as sent.
+func hasAttr(attrs []imap.MailboxAttr, attr imap.MailboxAttr) bool {
+	return slices.ContainsFunc(attrs, func(a imap.MailboxAttr) bool {
+		return strings.EqualFold(string(a), string(attr))
+	})
+}
+
 func (c *Client) SelectMailbox(name string) (*imap.SelectData, error) {
 	return c.SelectMailboxWithContext(context.Background(), name)
 }
@@ -284,6 +510,7 @@ func (c *Client) SelectMailboxWithContext(ctx context.Context, name string) (*im
 		if err != nil {
 			return fmt.Errorf("failed to select mailbox: %w", err)
 		}
+		c.selected = name
 		return nil
 	})
 
@@ -295,18 +522,41 @@ func (c *Client) FetchMessages(numSet imap.NumSet) ([]*Message, error) {
 }
 
 func (c *Client) FetchMessagesWithContext(ctx context.Context, numSet imap.NumSet) ([]*Message, error) {
+	return c.fetchMessages(ctx, numSet, true, true)
+}
+
+// FetchEnvelopes fetches everything FetchMessagesWithContext does except the
+// message body, so the size of messages can be checked before downloading
+// them. RawMessage is left empty.
+func (c *Client) FetchEnvelopes(ctx context.Context, numSet imap.NumSet) ([]*Message, error) {
+	return c.fetchMessages(ctx, numSet, true, false)
+}
+
+// FetchMetadata fetches the flags, envelope, size and dates of messages,
+// leaving Headers and RawMessage empty.
+func (c *Client) FetchMetadata(ctx context.Context, numSet imap.NumSet) ([]*Message, error) {
+	return c.fetchMessages(ctx, numSet, false, false)
+}
+
+func (c *Client) fetchMessages(ctx context.Context, numSet imap.NumSet, withHeaders, withBody bool) ([]*Message, error) {
 	var messages []*Message
+	var mailbox string
 
 	err := c.withRetry(ctx, func() error {
+		mailbox = c.selected
+
 		fetchOptions := &imap.FetchOptions{
-			Flags:    true,
-			Envelope: true,
-			BodySection: []*imap.FetchItemBodySection{
-				{Specifier: imap.PartSpecifierHeader, Peek: true},
-				{Peek: true},
-			},
-			RFC822Size: true,
-			UID:        true,
+			Flags:        true,
+			Envelope:     true,
+			RFC822Size:   true,
+			InternalDate: true,
+			UID:          true,
+		}
+		if withHeaders {
+			fetchOptions.BodySection = append(fetchOptions.BodySection, &imap.FetchItemBodySection{Specifier: imap.PartSpecifierHeader, Peek: true})
+		}
+		if withBody {
+			fetchOptions.BodySection = append(fetchOptions.BodySection, &imap.FetchItemBodySection{Peek: true})
 		}
 
 		cmd := c.client.Fetch(numSet, fetchOptions)
@@ -326,10 +576,11 @@ func (c *Client) FetchMessagesWithContext(ctx context.Context, numSet imap.NumSe
 			}
 
 			message := &Message{
-				UID:      uint32(buf.UID),
-				Flags:    buf.Flags,
-				Size:     uint32(buf.RFC822Size),
-				Envelope: buf.Envelope,
+				UID:          uint32(buf.UID),
+				Flags:        buf.Flags,
+				Size:         uint32(buf.RFC822Size),
+				Envelope:     buf.Envelope,
+				InternalDate: buf.InternalDate,
 			}
 
 			for _, section := range buf.BodySection {
@@ -337,7 +588,6 @@ func (c *Client) FetchMessagesWithContext(ctx context.Context, numSet imap.NumSe
 				case imap.PartSpecifierHeader:
 					message.Headers = section.Bytes
 				case imap.PartSpecifierNone:
-					message.Body = section.Bytes
 					message.RawMessage = section.Bytes
 				}
 			}
@@ -358,9 +608,94 @@ func (c *Client) FetchMessagesWithContext(ctx context.Context, numSet imap.NumSe
 		return nil
 	})
 
+	// X-GM-MSGID and X-GM-THRID go over a side connection, since imapclient
+	// can't fetch them.
+	if err == nil && c.fetchGmailLabels {
+		c.addGmailIDs(mailbox, messages)
+	}
+
 	return messages, err
 }
 
+// FetchFlags returns the current flags of the messages in uidSet, keyed by UID.
+func (c *Client) FetchFlags(ctx context.Context, uidSet imap.UIDSet) (map[uint32][]imap.Flag, error) {
+	var result map[uint32][]imap.Flag
+
+	err := c.withRetry(ctx, func() error {
+		cmd := c.client.Fetch(uidSet, &imap.FetchOptions{Flags: true, UID: true})
+		defer cmd.Close()
+
+		result = make(map[uint32][]imap.Flag)
+
+		for {
+			msg := cmd.Next()
+			if msg == nil {
+				break
+			}
+
+			buf, err := msg.Collect()
+			if err != nil {
+				return fmt.Errorf("failed to collect message: %w", err)
+			}
+
+			result[uint32(buf.UID)] = buf.Flags
+		}
+
+		if err := cmd.Close(); err != nil {
+			return fmt.Errorf("failed to fetch flags: %w", err)
+		}
+
+		return nil
+	})
+
+	return result, err
+}
+
+// StoreFlags adds and removes flags of one message on the server, selecting
+// its mailbox if needed. Either list may be empty.
+func (c *Client) StoreFlags(ctx context.Context, mailbox string, uid uint32, add, remove []imap.Flag) error {
+	uidSet := imap.UIDSetNum(imap.UID(uid))
+
+	return c.withRetry(ctx, func() error {
+		if c.selected != mailbox {
+			if _, err := c.client.Select(mailbox, nil).Wait(); err != nil {
+				return fmt.Errorf("failed to select mailbox: %w", err)
+			}
+			c.selected = mailbox
+		}
+
+		for _, change := range []struct {
+			op    imap.StoreFlagsOp
+			flags []imap.Flag
+		}{{imap.StoreFlagsAdd, add}, {imap.StoreFlagsDel, remove}} {
+			if len(change.flags) == 0 {
+				continue
+			}
+			cmd := c.client.Store(uidSet, &imap.StoreFlags{Op: change.op, Silent: true, Flags: change.flags}, nil)
+			if err := cmd.Close(); err != nil {
+				return fmt.Errorf("failed to store flags: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// UIDValidity returns the UIDVALIDITY of a mailbox without selecting it.
+func (c *Client) UIDValidity(ctx context.Context, mailbox string) (uint32, error) {
+	var validity uint32
+
+	err := c.withRetry(ctx, func() error {
+		data, err := c.client.Status(mailbox, &imap.StatusOptions{UIDValidity: true}).Wait()
+		if err != nil {
+			return fmt.Errorf("failed to get mailbox status: %w", err)
+		}
+		validity = data.UIDValidity
+		return nil
+	})
+
+	return validity, err
+}
+
 // extractGmailLabels extracts Gmail label information from IMAP flags.
 // Gmail exposes labels through custom flags in the format: \Label or similar.
 func extractGmailLabels(flags []imap.Flag) []string {
@@ -395,15 +730,38 @@ func isStandardIMAPFlag(flag string) bool {
 	return standardFlags[flag]
 }
 
+// DateRange limits a search by message internal date. Since is inclusive and
+// Before exclusive; IMAP compares dates only, ignoring the time of day. A
+// zero bound is open.
+type DateRange struct {
+	Since  time.Time
+	Before time.Time
+}
+
+// IsZero reports whether the range matches every message.
+func (r DateRange) IsZero() bool {
+	return r.Since.IsZero() && r.Before.IsZero()
+}
+
+// SearchCriteria returns the UID SEARCH criteria selecting the range.
+func (r DateRange) SearchCriteria() *imap.SearchCriteria {
+	return &imap.SearchCriteria{
+		Since:  r.Since,
+		Before: r.Before,
+	}
+}
+
 func (c *Client) SearchAll() ([]uint32, error) {
-	return c.SearchAllWithContext(context.Background())
+	return c.SearchAllWithContext(context.Background(), DateRange{})
 }
 
-func (c *Client) SearchAllWithContext(ctx context.Context) ([]uint32, error) {
+// SearchAllWithContext returns the UIDs of the messages in the selected
+// mailbox within the date range. The server does the filtering.
+func (c *Client) SearchAllWithContext(ctx context.Context, dateRange DateRange) ([]uint32, error) {
 	var result []uint32
 
 	err := c.withRetry(ctx, func() error {
-		criteria := &imap.SearchCriteria{}
+		criteria := dateRange.SearchCriteria()
 
 		data, err := c.client.UIDSearch(criteria, nil).Wait()
 		if err != nil {
@@ -422,6 +780,215 @@ func (c *Client) SearchAllWithContext(ctx context.Context) ([]uint32, error) {
 	return result, err
 }
 
+// CreateMailbox creates a mailbox on the server.
+func (c *Client) CreateMailbox(ctx context.Context, name string) error {
+	return c.withRetry(ctx, func() error {
+		if err := c.client.Create(name, nil).Wait(); err != nil {
+			return fmt.Errorf("failed to create mailbox: %w", err)
+		}
+		return nil
+	})
+}
+
+// ErrAppendUnconfirmed is returned by AppendMessage when the connection
+// dropped while uploading a message without a Message-ID: the server may
+// have stored it, and without an ID to look for, retrying could upload it
+// twice.
+var ErrAppendUnconfirmed = errors.New("connection lost during APPEND of a message without Message-ID, not retrying")
+
+// AppendMessage uploads a raw RFC822 message to the given mailbox with the
+// provided flags and internal date. When the connection drops once the
+// message was sent, the mailbox is searched for its Message-ID before it is
+// uploaded again, since the server may have stored it before the response
+// was lost.
+func (c *Client) AppendMessage(ctx context.Context, mailbox string, flags []imap.Flag, internalDate time.Time, raw []byte) error {
+	messageID := MessageID(raw)
+	appendOnce := c.appendFunc
+	if appendOnce == nil {
+		appendOnce = (*Client).appendOnce
+	}
+	sent := false
+
+	return c.withRetry(ctx, func() error {
+		if sent {
+			if messageID == "" {
+				return ErrAppendUnconfirmed
+			}
+			stored, err := c.hasMessageID(mailbox, messageID)
+			if err != nil || stored {
+				return err
+			}
+		}
+		written, err := appendOnce(c, mailbox, flags, internalDate, raw)
+		sent = sent || written
+		return err
+	})
+}
+
+// appendOnce runs a single APPEND command. It reports whether the message
+// was written to the connection, after which the server may store it even
+// if the command then fails.
+func (c *Client) appendOnce(mailbox string, flags []imap.Flag, internalDate time.Time, raw []byte) (bool, error) {
+	cmd := c.client.Append(mailbox, int64(len(raw)), &imap.AppendOptions{
+		Flags: flags,
+		Time:  internalDate,
+	})
+
+	if _, err := cmd.Write(raw); err != nil {
+		cmd.Close()
+		return false, fmt.Errorf("failed to write message: %w", err)
+	}
+	if err := cmd.Close(); err != nil {
+		return true, fmt.Errorf("failed to append message: %w", err)
+	}
+	if _, err := cmd.Wait(); err != nil {
+		return true, fmt.Errorf("failed to append message: %w", err)
+	}
+	return true, nil
+}
+
+// hasMessageID selects mailbox and reports whether a message in it has the
+// Message-ID header messageID.
+func (c *Client) hasMessageID(mailbox, messageID string) (bool, error) {
+	if err := c.keepSelected(&mailbox); err != nil {
+		return false, err
+	}
+	data, err := c.client.UIDSearch(&imap.SearchCriteria{
+		Header: []imap.SearchCriteriaHeaderField{{Key: "Message-Id", Value: messageID}},
+	}, nil).Wait()
+	if err != nil {
+		return false, fmt.Errorf("failed to search for Message-ID: %w", err)
+	}
+	return len(data.AllUIDs()) > 0, nil
+}
+
+// ErrNoMailboxSelected is returned by CopyMessages and MoveMessages when no
+// mailbox has been selected to take the messages from.
+var ErrNoMailboxSelected = errors.New("no mailbox selected")
+
+// CopyMessages copies messages of the selected mailbox to dest. uids holds
+// UIDs or sequence numbers.
+func (c *Client) CopyMessages(ctx context.Context, uids imap.NumSet, dest string) error {
+	var mailbox string
+
+	return c.withRetry(ctx, func() error {
+		if err := c.keepSelected(&mailbox); err != nil {
+			return err
+		}
+		if _, err := c.client.Copy(uids, dest).Wait(); err != nil {
+			return fmt.Errorf("failed to copy messages: %w", err)
+		}
+		return nil
+	})
+}
+
+// ErrMoveUnsupported is returned by MoveMessages when the server has
+// neither MOVE nor UIDPLUS. The messages were copied to dest but are still
+// in the selected mailbox.
+var ErrMoveUnsupported = errors.New("server supports neither MOVE nor UIDPLUS, messages were copied but not removed")
+
+// MoveMessages moves messages of the selected mailbox to dest. Servers
+// without MOVE get a COPY, a STORE of \Deleted and a UID EXPUNGE of the
+// moved UIDs instead. Without UIDPLUS there is no way to expunge only
+// those, and a plain EXPUNGE would also remove every other message flagged
+// \Deleted, so the messages are only copied and ErrMoveUnsupported is
+// returned.
+func (c *Client) MoveMessages(ctx context.Context, uids imap.NumSet, dest string) error {
+	var mailbox string
+
+	return c.withRetry(ctx, func() error {
+		if err := c.keepSelected(&mailbox); err != nil {
+			return err
+		}
+		if caps := c.client.Caps(); !caps.Has(imap.CapMove) && !caps.Has(imap.CapUIDPlus) {
+			if _, err := c.client.Copy(uids, dest).Wait(); err != nil {
+				return fmt.Errorf("failed to copy messages: %w", err)
+			}
+			return ErrMoveUnsupported
+		}
+		if _, err := c.client.Move(uids, dest).Wait(); err != nil {
+			return fmt.Errorf("failed to move messages: %w", err)
+		}
+		return nil
+	})
+}
+
+// keepSelected records the selected mailbox in mailbox on a command's first
+// attempt and selects it again on retries, since reconnecting unselects it.
+func (c *Client) keepSelected(mailbox *string) error {
+	if *mailbox == "" {
+		*mailbox = c.selected
+	}
+	if *mailbox == "" {
+		return ErrNoMailboxSelected
+	}
+	if c.selected != *mailbox {
+		if _, err := c.client.Select(*mailbox, nil).Wait(); err != nil {
+			return fmt.Errorf("failed to select mailbox: %w", err)
+		}
+		c.selected = *mailbox
+	}
+	return nil
+}
+
+// ListMessageIDs selects the mailbox and returns the set of Message-ID header
+// values of the messages it contains. Messages without a Message-ID are
+// ignored.
+func (c *Client) ListMessageIDs(ctx context.Context, mailbox string) (map[string]struct{}, error) {
+	selectData, err := c.SelectMailboxWithContext(ctx, mailbox)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]struct{})
+	if selectData.NumMessages == 0 {
+		return ids, nil
+	}
+
+	err = c.withRetry(ctx, func() error {
+		var seqSet imap.SeqSet
+		seqSet.AddRange(1, selectData.NumMessages)
+
+		cmd := c.client.Fetch(seqSet, &imap.FetchOptions{
+			BodySection: []*imap.FetchItemBodySection{
+				{Specifier: imap.PartSpecifierHeader, HeaderFields: []string{"Message-Id"}, Peek: true},
+			},
+		})
+		defer cmd.Close()
+
+		for {
+			msg := cmd.Next()
+			if msg == nil {
+				break
+			}
+
+			buf, err := msg.Collect()
+			if err != nil {
+				return fmt.Errorf("failed to collect message: %w", err)
+			}
+
+			for _, section := range buf.BodySection {
+				if id := MessageID(section.Bytes); id != "" {
+					ids[id] = struct{}{}
+				}
+			}
+		}
+
+		if err := cmd.Close(); err != nil {
+			return fmt.Errorf("failed to fetch message ids: %w", err)
+		}
+		return nil
+	})
+
+	return ids, err
+}
+
+// MessageID returns the Message-ID header of a raw message or header block,
+// or an empty string if there is none.
+func MessageID(raw []byte) string {
+	return mailparse.MessageID(raw)
+}
+
 func ParseEnvelopeDate(envelope *imap.Envelope) time.Time {
 	if envelope != nil && !envelope.Date.IsZero() {
 		return envelope.Date
@@ -448,29 +1015,72 @@ func (c *Client) IsGmailServer(ctx context.Context) (bool, error) {
 	return slices.ContainsFunc(mailboxes, IsGmailFolder), nil
 }
 
+// IsGmail reports whether the server is Gmail. Gmail advertises its IMAP
+// extensions as X-GM-EXT-1 after login; a server that doesn't, e.g. behind a
+// proxy that rewrites capabilities, is still recognised by its hostname. The
+// result is cached for the life of the client.
+func (c *Client) IsGmail() bool {
+	c.mu.Lock()
+	cached := c.isGmail
+	c.mu.Unlock()
+	if cached != nil {
+		return *cached
+	}
+
+	var caps imap.CapSet
+	c.cmdMu.Lock()
+	if c.client != nil {
+		caps = c.client.Caps()
+	}
+	c.cmdMu.Unlock()
+
+	isGmail := detectGmail(caps, c.opts.Host)
+
+	c.mu.Lock()
+	c.isGmail = &isGmail
+	c.mu.Unlock()
+
+	return isGmail
+}
+
+const capGmailExt = imap.Cap("X-GM-EXT-1")
+
+// detectGmail decides whether a server with the given capabilities and
+// hostname is Gmail.
+func detectGmail(caps imap.CapSet, host string) bool {
+	if caps.Has(capGmailExt) {
+		return true
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	return host == "imap.gmail.com" || host == "imap.googlemail.com"
+}
+
 // IsGmailFolder returns true if the folder name is a Gmail system folder.
 // Gmail system folders start with [Gmail]/ or [Google Mail]/.
 func IsGmailFolder(name string) bool {
 	return strings.HasPrefix(name, "[Gmail]/") || strings.HasPrefix(name, "[Google Mail]/")
 }
 
-// IdleMailbox selects the given mailbox and enters IMAP IDLE mode.
-// It returns when a server-side unilateral update is received or the context is cancelled.
-// Returns true if a mailbox update was received (new/deleted messages).
-func (c *Client) IdleMailbox(ctx context.Context, mailbox string) (bool, error) {
-	if _, err := c.SelectMailboxWithContext(ctx, mailbox); err != nil {
-		return false, err
+// SupportsIdle reports whether the server advertises the IDLE capability.
+func (c *Client) SupportsIdle() bool {
+	if c.client == nil {
+		return false
 	}
+	return c.client.Caps().Has(imap.CapIdle)
+}
 
-	notified := make(chan struct{}, 1)
+// Idle selects the given mailbox and stays in IMAP IDLE mode until the context
+// is cancelled, calling onUpdate for every server-side mailbox update
+// (new/deleted messages). onUpdate runs on the connection's reader goroutine
+// and must not issue IMAP commands; cancel the context to leave IDLE instead.
+// An error is returned if IDLE terminates before the context is done.
+func (c *Client) Idle(ctx context.Context, mailbox string, onUpdate func()) error {
+	if _, err := c.SelectMailboxWithContext(ctx, mailbox); err != nil {
+		return err
+	}
 
 	c.mu.Lock()
-	c.unilateralNotify = func() {
-		select {
-		case notified <- struct{}{}:
-		default:
-		}
-	}
+	c.unilateralNotify = onUpdate
 	c.mu.Unlock()
 
 	defer func() {
@@ -479,23 +1089,50 @@ func (c *Client) IdleMailbox(ctx context.Context, mailbox string) (bool, error)
 		c.mu.Unlock()
 	}()
 
+	// IDLE occupies the connection until it is closed below, and keeps the
+	// session alive by itself.
+	c.cmdMu.Lock()
+	defer c.cmdMu.Unlock()
+	defer func() { c.lastUsed = time.Now() }()
+
 	idleCmd, err := c.client.Idle()
 	if err != nil {
-		return false, fmt.Errorf("failed to start IDLE: %w", err)
+		return fmt.Errorf("failed to start IDLE: %w", err)
 	}
 
-	var updated bool
+	done := make(chan error, 1)
+	go func() { done <- idleCmd.Wait() }()
+
 	select {
 	case <-ctx.Done():
-	case <-notified:
-		updated = true
+	case err := <-done:
+		if err == nil {
+			err = io.EOF
+		}
+		return fmt.Errorf("IDLE terminated: %w", err)
 	}
 
 	if err := idleCmd.Close(); err != nil {
-		return updated, fmt.Errorf("failed to stop IDLE: %w", err)
+		return fmt.Errorf("failed to stop IDLE: %w", err)
 	}
 
-	return updated, nil
+	return nil
+}
+
+// IdleMailbox selects the given mailbox and enters IMAP IDLE mode.
+// It returns when a server-side unilateral update is received or the context is cancelled.
+// Returns true if a mailbox update was received (new/deleted messages).
+func (c *Client) IdleMailbox(ctx context.Context, mailbox string) (bool, error) {
+	idleCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var updated atomic.Bool
+	err := c.Idle(idleCtx, mailbox, func() {
+		updated.Store(true)
+		cancel()
+	})
+
+	return updated.Load(), err
 }
 
 // IsGmailAllMail returns true if the folder is Gmail's All Mail folder.
@@ -5,7 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net"
+	"strings"
 	"testing"
 	"time"
 
@@ -176,6 +178,7 @@ func TestReconnect_Failure(t *testing.T) {
 
 	err := c.reconnect(context.Background())
 	assert.Error(t, err)
+	assert.Equal(t, RetryStats{ReconnectAttempts: 1}, c.RetryStats())
 }
 
 func TestReconnect_ContextCancelled(t *testing.T) {
@@ -201,6 +204,45 @@ func TestReconnect_ContextCancelled(t *testing.T) {
 	assert.ErrorIs(t, err, context.Canceled)
 }
 
+func TestReconnect_AuthFailed(t *testing.T) {
+	// LOGIN isn't scripted, so it is answered with NO. The fake server
+	// only accepts one connection: a second attempt couldn't connect.
+	port, cmds := newFakeGmail(t, map[string][]string{
+		"CAPABILITY": {"* CAPABILITY IMAP4rev1 AUTH=PLAIN"},
+	})
+
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+	c := &Client{
+		opts:       ConnectOptions{Host: "127.0.0.1", Port: port, Username: "user", Password: "wrong", Logger: log},
+		log:        log,
+		retries:    3,
+		maxBackoff: time.Minute,
+	}
+
+	start := time.Now()
+	err := c.reconnect(context.Background())
+	assert.ErrorIs(t, err, ErrAuthFailed)
+	assert.ErrorContains(t, err, "authentication failed, not retrying")
+	assert.Less(t, time.Since(start), time.Second, "no backoff before giving up")
+	assert.Contains(t, receivedCmds(cmds), `LOGIN "user" "wrong"`)
+}
+
+func TestConnect_AuthFailed(t *testing.T) {
+	opts, cleanup := newTestIMAPServer(t)
+	defer cleanup()
+
+	opts.Password = "wrong"
+	_, err := Connect(opts)
+	assert.ErrorIs(t, err, ErrAuthFailed)
+}
+
+func TestAuthFailure(t *testing.T) {
+	assert.ErrorIs(t, authFailure(&imap2.Error{Type: imap2.StatusResponseTypeNo, Text: "bad credentials"}), ErrAuthFailed)
+	assert.ErrorIs(t, authFailure(&statusError{verb: "LOGIN", status: "BAD syntax"}), ErrAuthFailed)
+	assert.NotErrorIs(t, authFailure(io.EOF), ErrAuthFailed, "a dropped connection can be retried")
+}
+
 func TestWithRetry_Reconnects(t *testing.T) {
 	opts, cleanup := newTestIMAPServer(t)
 	defer cleanup()
@@ -209,6 +251,8 @@ func TestWithRetry_Reconnects(t *testing.T) {
 	require.NoError(t, err)
 	defer c.Close()
 
+	assert.Zero(t, c.RetryStats())
+
 	// Force-close the underlying connection to simulate a network drop.
 	c.client.Close() //nolint:errcheck
 
@@ -216,6 +260,50 @@ func TestWithRetry_Reconnects(t *testing.T) {
 	mailboxes, err := c.ListMailboxesWithContext(context.Background())
 	require.NoError(t, err)
 	assert.NotEmpty(t, mailboxes)
+	assert.Equal(t, RetryStats{ReconnectAttempts: 1, Reconnects: 1}, c.RetryStats())
+}
+
+func TestWithRetry_NoRetries(t *testing.T) {
+	opts, cleanup := newTestIMAPServer(t)
+	defer cleanup()
+
+	noRetries := 0
+	opts.MaxRetries = &noRetries
+	c, err := Connect(opts)
+	require.NoError(t, err)
+	defer c.Close()
+
+	attempts := 0
+	err = c.withRetry(context.Background(), func() error {
+		attempts++
+		return io.EOF
+	})
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, 1, attempts, "a single attempt without reconnecting")
+}
+
+func TestConnect_MaxRetries(t *testing.T) {
+	opts, cleanup := newTestIMAPServer(t)
+	defer cleanup()
+
+	c, err := Connect(opts)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultMaxRetries, c.retries)
+	assert.Equal(t, DefaultMaxBackoff, c.maxBackoff)
+	c.Close()
+
+	retries := 5
+	opts.MaxRetries = &retries
+	opts.MaxBackoff = time.Minute
+	c, err = Connect(opts)
+	require.NoError(t, err)
+	assert.Equal(t, 5, c.retries)
+	assert.Equal(t, time.Minute, c.maxBackoff)
+	c.Close()
+
+	retries = -1
+	_, err = Connect(opts)
+	assert.ErrorContains(t, err, "max retries must not be negative")
 }
 
 func TestListMailboxes(t *testing.T) {
@@ -262,6 +350,78 @@ func TestListMailboxesWithContext_NonNetworkError(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestListMailboxes_Options(t *testing.T) {
+	connect := func(t *testing.T, caps string, list map[string][]string, opts ConnectOptions) *Client {
+		script := map[string][]string{
+			"CAPABILITY":          {"* CAPABILITY IMAP4rev1 AUTH=PLAIN " + caps},
+			`LOGIN "user" "pass"`: nil,
+			"LOGOUT":              {"* BYE"},
+		}
+		maps.Copy(script, list)
+		port, _ := newFakeGmail(t, script)
+
+		opts.Host, opts.Port, opts.Username, opts.Password = "127.0.0.1", port, imapTestUser, imapTestPass
+		c, err := Connect(opts)
+		require.NoError(t, err)
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+
+	t.Run("skips noselect", func(t *testing.T) {
+		c := connect(t, "", map[string][]string{
+			`LIST "" "*"`: {
+				`* LIST (\HasNoChildren) "/" INBOX`,
+				`* LIST (\Noselect \HasChildren) "/" "[Gmail]"`,
+				`* LIST (\HasNoChildren) "/" "[Gmail]/Sent Mail"`,
+				`* LIST (\NoSelect) "/" Shared`,
+			},
+		}, ConnectOptions{})
+
+		mailboxes, err := c.ListMailboxes()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"INBOX", "[Gmail]/Sent Mail"}, mailboxes)
+	})
+
+	t.Run("reference and pattern", func(t *testing.T) {
+		c := connect(t, "", map[string][]string{
+			`LIST "Archive/" "20%"`: {`* LIST () "/" Archive/2024`},
+		}, ConnectOptions{ListReference: "Archive/", ListPattern: "20%"})
+
+		mailboxes, err := c.ListMailboxes()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Archive/2024"}, mailboxes)
+	})
+
+	t.Run("subscribed with LIST-EXTENDED", func(t *testing.T) {
+		c := connect(t, "LIST-EXTENDED", map[string][]string{
+			`LIST (SUBSCRIBED) "" "*"`: {
+				`* LIST (\Subscribed) "/" INBOX`,
+				`* LIST (\Subscribed \NonExistent) "/" Deleted`,
+			},
+		}, ConnectOptions{SubscribedOnly: true})
+
+		mailboxes, err := c.ListMailboxes()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"INBOX"}, mailboxes)
+	})
+
+	t.Run("subscribed with LSUB", func(t *testing.T) {
+		c := connect(t, "", map[string][]string{
+			"ENABLE UTF8=ACCEPT": nil,
+			`LSUB "" "*"`: {
+				`* LSUB () "/" inbox`,
+				`* LSUB (\Noselect) "/" Lists`,
+				`* LSUB () "/" "Lists/Go &- Rust"`,
+				`* LSUB () "/" Entw&APw-rfe`,
+			},
+		}, ConnectOptions{SubscribedOnly: true})
+
+		mailboxes, err := c.ListMailboxes()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Entwürfe", "INBOX", "Lists/Go & Rust"}, mailboxes)
+	})
+}
+
 func TestSelectMailbox(t *testing.T) {
 	opts, cleanup := newTestIMAPServer(t)
 	defer cleanup()
@@ -319,11 +479,47 @@ func TestSearchAllWithContext(t *testing.T) {
 	_, err = c.SelectMailboxWithContext(context.Background(), "INBOX")
 	require.NoError(t, err)
 
-	uids, err := c.SearchAllWithContext(context.Background())
+	uids, err := c.SearchAllWithContext(context.Background(), DateRange{})
 	require.NoError(t, err)
 	assert.Len(t, uids, 3)
 }
 
+func TestSearchAllWithContext_DateRange(t *testing.T) {
+	opts, cleanup := newTestIMAPServer(t)
+	defer cleanup()
+
+	c, err := Connect(opts)
+	require.NoError(t, err)
+	defer c.Close()
+
+	ctx := context.Background()
+	for _, d := range []time.Time{
+		time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC),
+		time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		time.Date(2025, 8, 1, 12, 0, 0, 0, time.UTC),
+	} {
+		require.NoError(t, c.AppendMessage(ctx, "INBOX", nil, d, []byte(imapTestMsg)))
+	}
+
+	_, err = c.SelectMailboxWithContext(ctx, "INBOX")
+	require.NoError(t, err)
+
+	uids, err := c.SearchAllWithContext(ctx, DateRange{Since: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)})
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{2, 3}, uids)
+
+	uids, err = c.SearchAllWithContext(ctx, DateRange{Before: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)})
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{1}, uids)
+
+	uids, err = c.SearchAllWithContext(ctx, DateRange{
+		Since:  time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		Before: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{2}, uids)
+}
+
 func TestSearchAllWithContext_Empty(t *testing.T) {
 	opts, cleanup := newTestIMAPServer(t)
 	defer cleanup()
@@ -394,6 +590,28 @@ func TestFetchMessagesWithContext(t *testing.T) {
 	assert.Equal(t, "Test Email", msgs[0].Envelope.Subject)
 }
 
+func TestFetchEnvelopes(t *testing.T) {
+	opts, cleanup := newTestIMAPServer(t)
+	defer cleanup()
+
+	c, err := Connect(opts)
+	require.NoError(t, err)
+	defer c.Close()
+
+	appendTestMsgs(t, opts, "INBOX", 1)
+
+	_, err = c.SelectMailbox("INBOX")
+	require.NoError(t, err)
+
+	msgs, err := c.FetchEnvelopes(context.Background(), imap2.UIDSetNum(1))
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, "Test Email", msgs[0].Envelope.Subject)
+	assert.NotZero(t, msgs[0].Size)
+	assert.NotEmpty(t, msgs[0].Headers)
+	assert.Empty(t, msgs[0].RawMessage)
+}
+
 func TestIdleMailbox_UpdateReceived(t *testing.T) {
 	opts, cleanup := newTestIMAPServer(t)
 	defer cleanup()
@@ -458,6 +676,53 @@ func TestIdleMailbox_ContextCancelled(t *testing.T) {
 	assert.False(t, gotUpdate)
 }
 
+func TestIdle_CallsOnUpdateUntilCancelled(t *testing.T) {
+	opts, cleanup := newTestIMAPServer(t)
+	defer cleanup()
+
+	client, err := Connect(opts)
+	require.NoError(t, err)
+	defer client.Close()
+
+	assert.True(t, client.SupportsIdle())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates := make(chan struct{}, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Idle(ctx, "INBOX", func() { updates <- struct{}{} })
+	}()
+
+	// Give the client time to enter IDLE before appending.
+	time.Sleep(100 * time.Millisecond)
+	appendTestMsgs(t, opts, "INBOX", 1)
+
+	select {
+	case <-updates:
+	case <-time.After(2 * time.Second):
+		t.Fatal("onUpdate was not called")
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Idle did not return after context cancel")
+	}
+
+	// The connection is usable again after leaving IDLE.
+	uids, err := client.SearchAll()
+	require.NoError(t, err)
+	assert.Len(t, uids, 1)
+}
+
+func TestSupportsIdle_NotConnected(t *testing.T) {
+	assert.False(t, (&Client{}).SupportsIdle())
+}
+
 func TestSetFetchGmailLabels(t *testing.T) {
 	opts, cleanup := newTestIMAPServer(t)
 	defer cleanup()
@@ -482,3 +747,255 @@ func TestIsGmailServer_NotGmail(t *testing.T) {
 	require.NoError(t, err)
 	assert.False(t, isGmail)
 }
+
+func TestIsGmail(t *testing.T) {
+	t.Run("generic server", func(t *testing.T) {
+		opts, cleanup := newTestIMAPServer(t)
+		defer cleanup()
+
+		c, err := Connect(opts)
+		require.NoError(t, err)
+		defer c.Close()
+
+		assert.False(t, c.IsGmail())
+	})
+
+	t.Run("advertises X-GM-EXT-1", func(t *testing.T) {
+		// imapserver won't advertise unknown capabilities, so the server is
+		// scripted.
+		port, _ := newFakeGmail(t, map[string][]string{
+			"CAPABILITY":          {"* CAPABILITY IMAP4rev1 X-GM-EXT-1 AUTH=PLAIN"},
+			`LOGIN "user" "pass"`: nil,
+			"LOGOUT":              {"* BYE"},
+		})
+
+		c, err := Connect(ConnectOptions{Host: "127.0.0.1", Port: port, Username: imapTestUser, Password: imapTestPass})
+		require.NoError(t, err)
+		defer c.Close()
+
+		assert.True(t, c.IsGmail())
+		assert.True(t, c.IsGmail(), "cached")
+	})
+}
+
+func TestAppendMessage_AndListMessageIDs(t *testing.T) {
+	opts, cleanup := newTestIMAPServer(t)
+	defer cleanup()
+
+	client, err := Connect(opts)
+	require.NoError(t, err)
+	defer client.Close()
+
+	ctx := context.Background()
+
+	ids, err := client.ListMessageIDs(ctx, "INBOX")
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+
+	date := time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)
+	raw := []byte("Message-ID: <append@example.com>\r\nSubject: Appended\r\n\r\nHello.")
+	require.NoError(t, client.AppendMessage(ctx, "INBOX", []imap2.Flag{imap2.FlagSeen}, date, raw))
+	require.NoError(t, client.AppendMessage(ctx, "INBOX", nil, date, []byte("Subject: No id\r\n\r\nHi.")))
+
+	ids, err = client.ListMessageIDs(ctx, "INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]struct{}{"<append@example.com>": {}}, ids)
+
+	msgs, err := client.FetchMessagesWithContext(ctx, imap2.SeqSetNum(1))
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	assert.Contains(t, msgs[0].Flags, imap2.FlagSeen)
+	assert.Equal(t, raw, msgs[0].RawMessage)
+}
+
+func TestAppendMessage_ResponseLost(t *testing.T) {
+	opts, cleanup := newTestIMAPServer(t)
+	defer cleanup()
+
+	client, err := Connect(opts)
+	require.NoError(t, err)
+	defer client.Close()
+
+	// The first APPEND reaches the server, then the connection drops
+	// before its response is read.
+	appends := 0
+	client.appendFunc = func(c *Client, mailbox string, flags []imap2.Flag, date time.Time, raw []byte) (bool, error) {
+		appends++
+		written, err := c.appendOnce(mailbox, flags, date, raw)
+		if err != nil {
+			return written, err
+		}
+		if appends == 1 {
+			c.client.Close() //nolint:errcheck
+			return true, io.EOF
+		}
+		return true, nil
+	}
+
+	ctx := context.Background()
+	count := func() int {
+		data, err := client.SelectMailboxWithContext(ctx, "INBOX")
+		require.NoError(t, err)
+		return int(data.NumMessages)
+	}
+
+	raw := []byte("Message-ID: <lost@example.com>\r\nSubject: Lost\r\n\r\nHello.")
+	require.NoError(t, client.AppendMessage(ctx, "INBOX", nil, time.Now(), raw))
+	assert.Equal(t, 1, appends, "found by its Message-ID, so not uploaded again")
+	assert.Equal(t, 1, count())
+
+	appends = 0
+	err = client.AppendMessage(ctx, "INBOX", nil, time.Now(), []byte("Subject: No id\r\n\r\nHi."))
+	assert.ErrorIs(t, err, ErrAppendUnconfirmed)
+	assert.Equal(t, 1, appends)
+	assert.Equal(t, 2, count(), "stored once")
+
+	t.Run("dropped before the message was written", func(t *testing.T) {
+		appends := 0
+		client.appendFunc = func(c *Client, mailbox string, flags []imap2.Flag, date time.Time, raw []byte) (bool, error) {
+			appends++
+			if appends == 1 {
+				c.client.Close() //nolint:errcheck
+				return false, io.EOF
+			}
+			return c.appendOnce(mailbox, flags, date, raw)
+		}
+
+		require.NoError(t, client.AppendMessage(ctx, "INBOX", nil, time.Now(), []byte("Subject: Stale\r\n\r\nHi.")),
+			"nothing reached the server, so it is safe to retry without a Message-ID")
+		assert.Equal(t, 2, appends)
+		assert.Equal(t, 3, count())
+	})
+}
+
+func TestCreateMailbox(t *testing.T) {
+	opts, cleanup := newTestIMAPServer(t)
+	defer cleanup()
+
+	client, err := Connect(opts)
+	require.NoError(t, err)
+	defer client.Close()
+
+	require.NoError(t, client.CreateMailbox(context.Background(), "Archive"))
+
+	mailboxes, err := client.ListMailboxes()
+	require.NoError(t, err)
+	assert.Contains(t, mailboxes, "Archive")
+
+	assert.Error(t, client.CreateMailbox(context.Background(), "Archive"))
+}
+
+func TestStoreFlags(t *testing.T) {
+	opts, cleanup := newTestIMAPServer(t)
+	defer cleanup()
+	appendTestMsgs(t, opts, "INBOX", 2)
+
+	client, err := Connect(opts)
+	require.NoError(t, err)
+	defer client.Close()
+	ctx := context.Background()
+
+	require.NoError(t, client.StoreFlags(ctx, "INBOX", 2, []imap2.Flag{imap2.FlagSeen, imap2.FlagFlagged}, nil))
+	require.NoError(t, client.StoreFlags(ctx, "INBOX", 2, nil, []imap2.Flag{imap2.FlagFlagged}))
+
+	flags, err := client.FetchFlags(ctx, imap2.UIDSetNum(1, 2))
+	require.NoError(t, err)
+	assert.NotContains(t, flags[1], imap2.FlagSeen, "other messages are left alone")
+	assert.Equal(t, []imap2.Flag{imap2.FlagSeen}, flags[2])
+
+	validity, err := client.UIDValidity(ctx, "INBOX")
+	require.NoError(t, err)
+	selectData, err := client.SelectMailbox("INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, selectData.UIDValidity, validity)
+
+	assert.Error(t, client.StoreFlags(ctx, "Missing", 1, []imap2.Flag{imap2.FlagSeen}, nil))
+}
+
+func TestCopyMoveMessages(t *testing.T) {
+	opts, cleanup := newTestIMAPServer(t)
+	defer cleanup()
+	appendTestMsgs(t, opts, "INBOX", 3)
+
+	client, err := Connect(opts)
+	require.NoError(t, err)
+	defer client.Close()
+	ctx := context.Background()
+
+	assert.ErrorIs(t, client.CopyMessages(ctx, imap2.UIDSetNum(1), "Sent"), ErrNoMailboxSelected)
+
+	_, err = client.SelectMailbox("INBOX")
+	require.NoError(t, err)
+	require.NoError(t, client.CopyMessages(ctx, imap2.UIDSetNum(1), "Sent"))
+	// The test server has neither MOVE nor UIDPLUS, so the messages are
+	// only copied.
+	assert.ErrorIs(t, client.MoveMessages(ctx, imap2.UIDSetNum(2, 3), "Sent"), ErrMoveUnsupported)
+
+	inbox, err := client.SelectMailbox("INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(3), inbox.NumMessages)
+	sent, err := client.SelectMailbox("Sent")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(3), sent.NumMessages)
+
+	assert.Error(t, client.MoveMessages(ctx, imap2.UIDSetNum(1), "Missing"))
+}
+
+func TestMoveMessages_Commands(t *testing.T) {
+	move := func(t *testing.T, caps string, wantErr error) []string {
+		port, cmds := newFakeGmail(t, map[string][]string{
+			"CAPABILITY":                             {"* CAPABILITY IMAP4rev1 AUTH=PLAIN " + caps},
+			`LOGIN "user" "pass"`:                    nil,
+			`SELECT INBOX`:                           {"* 2 EXISTS", "* OK [UIDVALIDITY 1] UIDs valid"},
+			`UID MOVE 4:5 "Archive"`:                 {"* 1 EXPUNGE", "* 1 EXPUNGE"},
+			`UID COPY 4:5 "Archive"`:                 nil,
+			`UID STORE 4:5 +FLAGS.SILENT (\Deleted)`: nil,
+			`UID EXPUNGE 4:5`:                        {"* 1 EXPUNGE", "* 1 EXPUNGE"},
+			`EXPUNGE`:                                {"* 1 EXPUNGE", "* 1 EXPUNGE"},
+			"LOGOUT":                                 {"* BYE"},
+		})
+
+		c, err := Connect(ConnectOptions{Host: "127.0.0.1", Port: port, Username: imapTestUser, Password: imapTestPass})
+		require.NoError(t, err)
+		defer c.Close()
+
+		_, err = c.SelectMailbox("INBOX")
+		require.NoError(t, err)
+		err = c.MoveMessages(context.Background(), imap2.UIDSetNum(4, 5), "Archive")
+		if wantErr != nil {
+			require.ErrorIs(t, err, wantErr)
+		} else {
+			require.NoError(t, err)
+		}
+
+		var sent []string
+		for len(cmds) > 0 {
+			cmd := <-cmds
+			if cmd != "CAPABILITY" && !strings.HasPrefix(cmd, "LOGIN ") && !strings.HasPrefix(cmd, "SELECT ") {
+				sent = append(sent, cmd)
+			}
+		}
+		return sent
+	}
+
+	t.Run("MOVE", func(t *testing.T) {
+		assert.Equal(t, []string{`UID MOVE 4:5 "Archive"`}, move(t, "MOVE", nil))
+	})
+
+	t.Run("COPY and UID EXPUNGE", func(t *testing.T) {
+		assert.Equal(t, []string{`UID COPY 4:5 "Archive"`, `UID STORE 4:5 +FLAGS.SILENT (\Deleted)`, `UID EXPUNGE 4:5`}, move(t, "UIDPLUS", nil))
+	})
+
+	t.Run("COPY only", func(t *testing.T) {
+		sent := move(t, "", ErrMoveUnsupported)
+		assert.Equal(t, []string{`UID COPY 4:5 "Archive"`}, sent)
+		assert.NotContains(t, sent, `EXPUNGE`, "a bare EXPUNGE would remove other messages flagged \\Deleted")
+	})
+}
+
+func TestMessageID(t *testing.T) {
+	assert.Equal(t, "<a@b>", MessageID([]byte("Message-Id:  <a@b> \r\n\r\n")))
+	assert.Equal(t, "<a@b>", MessageID([]byte("Subject: x\r\nMessage-ID: <a@b>\r\n\r\nbody")))
+	assert.Empty(t, MessageID([]byte("Subject: x\r\n\r\nbody")))
+	assert.Empty(t, MessageID(nil))
+}
@@ -105,6 +105,32 @@ func TestIsGmailFolder(t *testing.T) {
 	}
 }
 
+func TestDetectGmail(t *testing.T) {
+	gmailCaps := imap.CapSet{imap.CapIMAP4rev1: {}, imap.CapIdle: {}, "X-GM-EXT-1": {}, "XLIST": {}}
+	genericCaps := imap.CapSet{imap.CapIMAP4rev1: {}, imap.CapIdle: {}}
+
+	tests := []struct {
+		name     string
+		caps     imap.CapSet
+		host     string
+		expected bool
+	}{
+		{"Gmail capabilities", gmailCaps, "imap.gmail.com", true},
+		{"Gmail capabilities behind a custom hostname", gmailCaps, "mail.example.com", true},
+		{"Gmail hostname without the capability", genericCaps, "imap.gmail.com", true},
+		{"Googlemail hostname", genericCaps, "IMAP.GoogleMail.com.", true},
+		{"No capabilities known", nil, "imap.gmail.com", true},
+		{"Generic server", genericCaps, "imap.example.com", false},
+		{"Lookalike hostname", genericCaps, "imap.gmail.com.example.org", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, detectGmail(tt.caps, tt.host))
+		})
+	}
+}
+
 func TestIsGmailAllMail(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -206,3 +232,18 @@ func TestIsStandardIMAPFlag(t *testing.T) {
 		})
 	}
 }
+
+func TestDateRange(t *testing.T) {
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	before := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	assert.True(t, DateRange{}.IsZero())
+	assert.Equal(t, &imap.SearchCriteria{}, DateRange{}.SearchCriteria())
+
+	r := DateRange{Since: since}
+	assert.False(t, r.IsZero())
+	assert.Equal(t, &imap.SearchCriteria{Since: since}, r.SearchCriteria())
+
+	r = DateRange{Since: since, Before: before}
+	assert.Equal(t, &imap.SearchCriteria{Since: since, Before: before}, r.SearchCriteria())
+}
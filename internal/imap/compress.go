@@ -0,0 +1,117 @@
+package imap
+
+import (
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// capCompressDeflate is the COMPRESS extension (RFC 4978) with DEFLATE.
+const capCompressDeflate = "COMPRESS=DEFLATE"
+
+// dialCompressed connects for imapclient with COMPRESS=DEFLATE enabled when
+// the server supports it. imapclient can't send COMPRESS, so login and the
+// switch happen on a raw connection that is then handed over as an already
+// authenticated session.
+func (c *Client) dialCompressed(opts *imapclient.Options) (*imapclient.Client, error) {
+	raw, err := c.dialRaw()
+	if err != nil {
+		return nil, err
+	}
+
+	var conn net.Conn = &bufferedConn{Conn: raw.conn, r: raw.r}
+
+	caps, err := raw.command("CAPABILITY")
+	if err != nil {
+		raw.conn.Close()
+		return nil, fmt.Errorf("failed to get capabilities: %w", err)
+	}
+
+	if !hasCapability(caps, capCompressDeflate) {
+		c.log.Debug("Server doesn't support COMPRESS=DEFLATE, continuing uncompressed")
+	} else if _, err := raw.command("COMPRESS DEFLATE"); err != nil {
+		var statusErr *statusError
+		if !errors.As(err, &statusErr) {
+			raw.conn.Close()
+			return nil, fmt.Errorf("failed to enable compression: %w", err)
+		}
+		c.log.WithError(err).Warn("Server refused COMPRESS, continuing uncompressed")
+	} else {
+		conn = newDeflateConn(conn)
+		c.compressed = true
+		c.log.Debug("COMPRESS=DEFLATE enabled")
+	}
+
+	// The deadline only bounded the raw commands.
+	raw.conn.SetDeadline(time.Time{})
+
+	return imapclient.New(newPreauthConn(conn), opts), nil
+}
+
+// hasCapability reports whether untagged CAPABILITY responses list cap.
+func hasCapability(lines []string, cap string) bool {
+	for _, line := range lines {
+		if fields, ok := strings.CutPrefix(line, "* CAPABILITY "); ok {
+			if slices.ContainsFunc(strings.Fields(fields), func(f string) bool { return strings.EqualFold(f, cap) }) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// deflateConn compresses both directions of a connection as negotiated by
+// COMPRESS DEFLATE: raw deflate streams, flushed after every write so
+// commands aren't held back.
+type deflateConn struct {
+	net.Conn
+	r io.ReadCloser
+	w *flate.Writer
+}
+
+func newDeflateConn(conn net.Conn) *deflateConn {
+	w, _ := flate.NewWriter(conn, flate.DefaultCompression)
+	return &deflateConn{Conn: conn, r: flate.NewReader(conn), w: w}
+}
+
+func (c *deflateConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *deflateConn) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, c.w.Flush()
+}
+
+func (c *deflateConn) Close() error {
+	c.r.Close()
+	return c.Conn.Close()
+}
+
+// preauthGreeting tells imapclient the session is already authenticated.
+const preauthGreeting = "* PREAUTH logged in\r\n"
+
+// preauthConn replays a PREAUTH greeting before the rest of the connection,
+// for handing a logged-in raw connection to imapclient.
+type preauthConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func newPreauthConn(conn net.Conn) *preauthConn {
+	return &preauthConn{Conn: conn, r: io.MultiReader(strings.NewReader(preauthGreeting), conn)}
+}
+
+func (c *preauthConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,109 @@
+package imap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnect_Compress(t *testing.T) {
+	port, cmds := newFakeGmail(t, map[string][]string{
+		"CAPABILITY":          {"* CAPABILITY IMAP4rev1 COMPRESS=DEFLATE AUTH=PLAIN"},
+		`LOGIN "user" "pass"`: nil,
+		"COMPRESS DEFLATE":    nil,
+		`LIST "" "*"`:         {`* LIST () "/" INBOX`, `* LIST () "/" "Archive/2024"`},
+		"LOGOUT":              {"* BYE"},
+	})
+
+	c, err := Connect(ConnectOptions{Host: "127.0.0.1", Port: port, Username: imapTestUser, Password: imapTestPass, Compress: true})
+	require.NoError(t, err)
+
+	assert.True(t, c.compressed)
+	mailboxes, err := c.ListMailboxes()
+	require.NoError(t, err, "commands work over the compressed stream")
+	assert.Equal(t, []string{"Archive/2024", "INBOX"}, mailboxes)
+	require.NoError(t, c.Close())
+
+	assert.Equal(t, []string{`LOGIN "user" "pass"`, "CAPABILITY", "COMPRESS DEFLATE"}, receivedCmds(cmds)[:3])
+}
+
+func TestConnect_CompressLiteralPassword(t *testing.T) {
+	// 8-bit characters don't fit in a quoted string, so the password goes
+	// as a literal.
+	password := "pässwörd"
+	port, cmds := newFakeGmail(t, map[string][]string{
+		"CAPABILITY":                         {"* CAPABILITY IMAP4rev1 COMPRESS=DEFLATE AUTH=PLAIN"},
+		"LOGIN \"user\" {10}\r\n" + password: nil,
+		"COMPRESS DEFLATE":                   nil,
+		`LIST "" "*"`:                        {`* LIST () "/" INBOX`},
+		"LOGOUT":                             {"* BYE"},
+	})
+
+	c, err := Connect(ConnectOptions{Host: "127.0.0.1", Port: port, Username: imapTestUser, Password: password, Compress: true})
+	require.NoError(t, err)
+
+	assert.True(t, c.compressed)
+	mailboxes, err := c.ListMailboxes()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"INBOX"}, mailboxes)
+	require.NoError(t, c.Close())
+
+	assert.Equal(t, "LOGIN \"user\" {10}\r\n"+password, receivedCmds(cmds)[0])
+}
+
+func TestConnect_CompressUnsupported(t *testing.T) {
+	t.Run("not advertised", func(t *testing.T) {
+		port, cmds := newFakeGmail(t, map[string][]string{
+			"CAPABILITY":          {"* CAPABILITY IMAP4rev1 AUTH=PLAIN"},
+			`LOGIN "user" "pass"`: nil,
+			`LIST "" "*"`:         {`* LIST () "/" INBOX`},
+			"LOGOUT":              {"* BYE"},
+		})
+
+		c, err := Connect(ConnectOptions{Host: "127.0.0.1", Port: port, Username: imapTestUser, Password: imapTestPass, Compress: true})
+		require.NoError(t, err)
+
+		assert.False(t, c.compressed)
+		mailboxes, err := c.ListMailboxes()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"INBOX"}, mailboxes)
+		require.NoError(t, c.Close())
+
+		assert.NotContains(t, receivedCmds(cmds), "COMPRESS DEFLATE")
+	})
+
+	t.Run("memory server", func(t *testing.T) {
+		opts, cleanup := newTestIMAPServer(t)
+		defer cleanup()
+
+		opts.Compress = true
+		c, err := Connect(opts)
+		require.NoError(t, err)
+		defer c.Close()
+
+		assert.False(t, c.compressed)
+		_, err = c.ListMailboxes()
+		assert.NoError(t, err)
+	})
+}
+
+func TestHasCapability(t *testing.T) {
+	lines := []string{"* CAPABILITY IMAP4rev1 compress=deflate IDLE", "* OK hello"}
+	assert.True(t, hasCapability(lines, capCompressDeflate))
+	assert.False(t, hasCapability(lines, "QUOTA"))
+	assert.False(t, hasCapability([]string{"* OK [CAPABILITY COMPRESS=DEFLATE]"}, capCompressDeflate))
+}
+
+// receivedCmds drains the commands a fake server has received so far.
+func receivedCmds(cmds <-chan string) []string {
+	var got []string
+	for {
+		select {
+		case cmd := <-cmds:
+			got = append(got, cmd)
+		default:
+			return got
+		}
+	}
+}
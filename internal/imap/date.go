@@ -0,0 +1,63 @@
+package imap
+
+import (
+	"bytes"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+// MessageDate returns the best date known for msg. See bestEffortDate.
+func MessageDate(msg *Message) time.Time {
+	return bestEffortDate(msg.Envelope, msg.InternalDate, msg.Headers)
+}
+
+// bestEffortDate returns the envelope date, falling back to the INTERNALDATE,
+// then to the timestamp of the topmost Received header, and to the current
+// time only if none of them is available.
+func bestEffortDate(envelope *imap.Envelope, internalDate time.Time, headers []byte) time.Time {
+	if envelope != nil && !envelope.Date.IsZero() {
+		return envelope.Date
+	}
+	if !internalDate.IsZero() {
+		return internalDate
+	}
+	if t, ok := receivedDate(headers); ok {
+		return t
+	}
+	return time.Now()
+}
+
+// receivedDate parses the timestamp of the topmost Received header, which was
+// added by the last server to handle the message. The timestamp follows the
+// final semicolon of the header value.
+func receivedDate(headers []byte) (time.Time, bool) {
+	if len(headers) == 0 {
+		return time.Time{}, false
+	}
+
+	// The stored header block may lack the terminating blank line.
+	raw := append(bytes.Clone(headers), "\r\n\r\n"...)
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	received := msg.Header["Received"]
+	if len(received) == 0 {
+		return time.Time{}, false
+	}
+
+	i := strings.LastIndexByte(received[0], ';')
+	if i < 0 {
+		return time.Time{}, false
+	}
+
+	t, err := mail.ParseDate(strings.TrimSpace(received[0][i+1:]))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
@@ -0,0 +1,60 @@
+package imap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+const receivedHeaders = "Received: from mx.example.com (mx.example.com [192.0.2.1])\r\n" +
+	"\tby mail.example.org with ESMTPS id abc123;\r\n" +
+	"\tThu, 02 Jan 2025 09:15:00 +0000 (UTC)\r\n" +
+	"Received: from client.example.com by mx.example.com; Thu, 02 Jan 2025 09:14:00 +0000\r\n" +
+	"Subject: No date\r\n"
+
+func TestBestEffortDate(t *testing.T) {
+	envelopeDate := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	internalDate := time.Date(2025, 1, 2, 8, 30, 0, 0, time.UTC)
+	receivedAt := time.Date(2025, 1, 2, 9, 15, 0, 0, time.UTC)
+
+	t.Run("prefers envelope date", func(t *testing.T) {
+		got := bestEffortDate(&imap.Envelope{Date: envelopeDate}, internalDate, []byte(receivedHeaders))
+		assert.Equal(t, envelopeDate, got)
+	})
+
+	t.Run("falls back to internal date", func(t *testing.T) {
+		assert.Equal(t, internalDate, bestEffortDate(&imap.Envelope{}, internalDate, []byte(receivedHeaders)))
+		assert.Equal(t, internalDate, bestEffortDate(nil, internalDate, nil))
+	})
+
+	t.Run("falls back to topmost received header", func(t *testing.T) {
+		got := bestEffortDate(&imap.Envelope{}, time.Time{}, []byte(receivedHeaders))
+		assert.True(t, receivedAt.Equal(got), "got %v", got)
+	})
+
+	t.Run("header block with terminating blank line", func(t *testing.T) {
+		got := bestEffortDate(nil, time.Time{}, []byte(receivedHeaders+"\r\n"))
+		assert.True(t, receivedAt.Equal(got), "got %v", got)
+	})
+
+	t.Run("falls back to now", func(t *testing.T) {
+		for name, headers := range map[string]string{
+			"no headers":         "",
+			"no received header": "Subject: No date\r\n",
+			"no timestamp":       "Received: from mx.example.com by mail.example.org\r\n",
+			"bad timestamp":      "Received: from mx.example.com; yesterday\r\n",
+		} {
+			got := bestEffortDate(nil, time.Time{}, []byte(headers))
+			assert.WithinDuration(t, time.Now(), got, time.Second, name)
+		}
+	})
+}
+
+func TestMessageDate(t *testing.T) {
+	internalDate := time.Date(2025, 1, 2, 8, 30, 0, 0, time.UTC)
+
+	msg := &Message{Envelope: &imap.Envelope{}, InternalDate: internalDate, Headers: []byte(receivedHeaders)}
+	assert.Equal(t, internalDate, MessageDate(msg))
+}
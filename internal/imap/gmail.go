@@ -0,0 +1,141 @@
+package imap
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+// gmailIDs are Gmail's stable identifiers of a message and its thread.
+type gmailIDs struct {
+	msgID    uint64
+	threadID uint64
+}
+
+// gmailConn is the raw side connection fetching Gmail IDs. selected is the
+// mailbox it last examined.
+type gmailConn struct {
+	*rawConn
+	selected string
+}
+
+// dialGmail opens the side connection.
+func (c *Client) dialGmail() (*gmailConn, error) {
+	raw, err := c.dialRaw()
+	if err != nil {
+		return nil, err
+	}
+
+	// Lets mailbox names be sent as UTF-8 quoted strings. Gmail supports
+	// it; a refusal only matters for non-ASCII names.
+	raw.command("ENABLE UTF8=ACCEPT")
+
+	return &gmailConn{rawConn: raw}, nil
+}
+
+var (
+	fetchUIDRe      = regexp.MustCompile(`[( ]UID (\d+)`)
+	fetchGmMsgIDRe  = regexp.MustCompile(`[( ]X-GM-MSGID (\d+)`)
+	fetchGmThreadRe = regexp.MustCompile(`[( ]X-GM-THRID (\d+)`)
+)
+
+// fetchIDs returns the Gmail IDs of the messages in uids, keyed by UID.
+// Messages the server omits are missing from the result.
+func (g *gmailConn) fetchIDs(mailbox string, uids imap.UIDSet) (map[uint32]gmailIDs, error) {
+	if g.selected != mailbox {
+		g.selected = ""
+		if _, err := g.command("EXAMINE " + quoteString(mailbox)); err != nil {
+			return nil, err
+		}
+		g.selected = mailbox
+	}
+
+	lines, err := g.command("UID FETCH " + uids.String() + " (X-GM-MSGID X-GM-THRID)")
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[uint32]gmailIDs)
+	for _, line := range lines {
+		if !strings.Contains(line, " FETCH (") {
+			continue
+		}
+		uid, ok := fetchNumber(fetchUIDRe, line)
+		if !ok {
+			continue
+		}
+		var id gmailIDs
+		id.msgID, _ = fetchNumber(fetchGmMsgIDRe, line)
+		id.threadID, _ = fetchNumber(fetchGmThreadRe, line)
+		ids[uint32(uid)] = id
+	}
+	return ids, nil
+}
+
+func fetchNumber(re *regexp.Regexp, line string) (uint64, bool) {
+	m := re.FindStringSubmatch(line)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(m[1], 10, 64)
+	return n, err == nil
+}
+
+// addGmailIDs fills in the Gmail IDs of messages fetched from mailbox. They
+// are best-effort: on failure the messages are kept without them. A server
+// that refuses the side connection or the fetch isn't asked again.
+func (c *Client) addGmailIDs(mailbox string, messages []*Message) {
+	c.gmailMu.Lock()
+	defer c.gmailMu.Unlock()
+
+	if c.gmailUnavailable || mailbox == "" || len(messages) == 0 {
+		return
+	}
+
+	if c.gmail == nil {
+		g, err := c.dialGmail()
+		if err != nil {
+			c.log.WithError(err).Warn("Gmail message IDs unavailable, threading by headers instead")
+			c.gmailUnavailable = true
+			return
+		}
+		c.gmail = g
+	}
+
+	var uids imap.UIDSet
+	for _, m := range messages {
+		uids.AddNum(imap.UID(m.UID))
+	}
+
+	ids, err := c.gmail.fetchIDs(mailbox, uids)
+	if err != nil {
+		c.log.WithError(err).Warn("Failed to fetch Gmail message IDs")
+		c.gmail.close()
+		c.gmail = nil
+		// A connection problem is retried with the next batch.
+		var statusErr *statusError
+		c.gmailUnavailable = errors.As(err, &statusErr)
+		return
+	}
+
+	for _, m := range messages {
+		if id, ok := ids[m.UID]; ok {
+			m.GmailMsgID = id.msgID
+			m.GmailThreadID = id.threadID
+		}
+	}
+}
+
+// closeGmail logs out of the Gmail side connection, if open.
+func (c *Client) closeGmail() {
+	c.gmailMu.Lock()
+	defer c.gmailMu.Unlock()
+
+	if c.gmail != nil {
+		c.gmail.close()
+		c.gmail = nil
+	}
+}
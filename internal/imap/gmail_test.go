@@ -0,0 +1,192 @@
+package imap
+
+import (
+	"bufio"
+	"compress/flate"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeGmail starts a scripted IMAP server, to play Gmail or other servers
+// where imapserver can't. Every connection follows the same script: respond
+// maps a command, without its tag, to the untagged responses sent before OK;
+// commands it lacks are answered with NO. A scripted COMPRESS DEFLATE
+// switches the connection to deflate after its OK. Received commands are
+// sent to cmds.
+func newFakeGmail(t *testing.T, respond map[string][]string) (port int, cmds <-chan string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	received := make(chan string, 32)
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+
+		var w io.Writer = conn
+		var fw *flate.Writer
+		send := func(format string, args ...any) {
+			fmt.Fprintf(w, format, args...)
+			if fw != nil {
+				fw.Flush()
+			}
+		}
+
+		send("* OK Gimap ready\r\n")
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			// Literals are kept inline, after their {n} and CRLF.
+			line = strings.TrimRight(line, "\r\n")
+			for m := literalRe.FindStringSubmatch(line); m != nil; m = literalRe.FindStringSubmatch(line) {
+				n, _ := strconv.Atoi(m[1])
+				send("+ go ahead\r\n")
+				literal := make([]byte, n)
+				if _, err := io.ReadFull(r, literal); err != nil {
+					return
+				}
+				rest, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				line += "\r\n" + string(literal) + strings.TrimRight(rest, "\r\n")
+			}
+			tag, cmd, _ := strings.Cut(line, " ")
+			received <- cmd
+
+			lines, ok := respond[cmd]
+			if !ok {
+				send("%s NO unknown command\r\n", tag)
+				continue
+			}
+			for _, l := range lines {
+				send("%s\r\n", l)
+			}
+			send("%s OK done\r\n", tag)
+
+			if cmd == "COMPRESS DEFLATE" {
+				r = bufio.NewReader(flate.NewReader(r))
+				fw, _ = flate.NewWriter(conn, flate.DefaultCompression)
+				w = fw
+			}
+		}
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+
+	return l.Addr().(*net.TCPAddr).Port, received
+}
+
+func TestAddGmailIDs(t *testing.T) {
+	port, cmds := newFakeGmail(t, map[string][]string{
+		`LOGIN "user@gmail.com" "p\"w"`: nil,
+		`ENABLE UTF8=ACCEPT`:            {"* ENABLED UTF8=ACCEPT"},
+		`EXAMINE "[Gmail]/All Mail"`:    {"* 3 EXISTS", "* OK [UIDVALIDITY 1] UIDs valid"},
+		`UID FETCH 7:9 (X-GM-MSGID X-GM-THRID)`: {
+			"* 1 FETCH (X-GM-THRID 1278455344230334865 X-GM-MSGID 1278455344230334866 UID 7)",
+			"* 2 FETCH (UID 9 X-GM-MSGID 1278455344230334870 X-GM-THRID 1278455344230334865)",
+		},
+		`UID FETCH 9 (X-GM-MSGID X-GM-THRID)`: {
+			"* 2 FETCH (UID 9 X-GM-MSGID 1278455344230334870 X-GM-THRID 1278455344230334865)",
+		},
+		`LOGOUT`: {"* BYE"},
+	})
+
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+	c := &Client{
+		opts: ConnectOptions{Host: "127.0.0.1", Port: port, Username: "user@gmail.com", Password: `p"w`},
+		log:  log,
+	}
+
+	messages := []*Message{{UID: 7}, {UID: 8}, {UID: 9}}
+	c.addGmailIDs("[Gmail]/All Mail", messages)
+
+	assert.Equal(t, uint64(1278455344230334866), messages[0].GmailMsgID)
+	assert.Equal(t, uint64(1278455344230334865), messages[0].GmailThreadID)
+	assert.Zero(t, messages[1].GmailMsgID, "message missing from the response")
+	assert.Equal(t, uint64(1278455344230334870), messages[2].GmailMsgID)
+	assert.Equal(t, uint64(1278455344230334865), messages[2].GmailThreadID)
+
+	// The connection and its selected mailbox are reused.
+	more := []*Message{{UID: 9}}
+	c.addGmailIDs("[Gmail]/All Mail", more)
+	assert.Equal(t, uint64(1278455344230334870), more[0].GmailMsgID)
+	c.closeGmail()
+
+	var got []string
+	for range 6 {
+		got = append(got, <-cmds)
+	}
+	assert.Equal(t, []string{
+		`LOGIN "user@gmail.com" "p\"w"`,
+		`ENABLE UTF8=ACCEPT`,
+		`EXAMINE "[Gmail]/All Mail"`,
+		`UID FETCH 7:9 (X-GM-MSGID X-GM-THRID)`,
+		`UID FETCH 9 (X-GM-MSGID X-GM-THRID)`,
+		`LOGOUT`,
+	}, got)
+}
+
+func TestAddGmailIDs_LoginRefused(t *testing.T) {
+	port, _ := newFakeGmail(t, nil)
+
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+	c := &Client{opts: ConnectOptions{Host: "127.0.0.1", Port: port}, log: log}
+
+	messages := []*Message{{UID: 1}}
+	c.addGmailIDs("INBOX", messages)
+
+	assert.Zero(t, messages[0].GmailMsgID)
+	assert.True(t, c.gmailUnavailable, "a refused login isn't retried")
+	assert.Nil(t, c.gmail)
+}
+
+func TestAddGmailIDs_FetchRefused(t *testing.T) {
+	// A server that isn't Gmail rejects the X-GM fetch items.
+	port, _ := newFakeGmail(t, map[string][]string{
+		`LOGIN "user" "pw"`:  nil,
+		`EXAMINE "INBOX"`:    nil,
+		`LOGOUT`:             {"* BYE"},
+		`ENABLE UTF8=ACCEPT`: nil,
+	})
+
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+	c := &Client{opts: ConnectOptions{Host: "127.0.0.1", Port: port, Username: "user", Password: "pw"}, log: log}
+
+	messages := []*Message{{UID: 1}}
+	c.addGmailIDs("INBOX", messages)
+
+	assert.Zero(t, messages[0].GmailMsgID)
+	assert.True(t, c.gmailUnavailable)
+	assert.Nil(t, c.gmail)
+}
+
+func TestRawConnReadLine_Literal(t *testing.T) {
+	g := &rawConn{r: bufio.NewReader(strings.NewReader("* 1 FETCH (X-GM-LABELS ({5}\r\nInbox) UID 4)\r\n"))}
+
+	line, err := g.readLine()
+	require.NoError(t, err)
+	assert.Equal(t, "* 1 FETCH (X-GM-LABELS ({5}Inbox) UID 4)", line)
+}
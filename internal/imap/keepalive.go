@@ -0,0 +1,57 @@
+package imap
+
+import "time"
+
+// startKeepAlive runs a goroutine that sends NOOP once the connection has
+// been idle for interval. It never waits for a running command: that command
+// keeps the session alive already.
+func (c *Client) startKeepAlive(interval time.Duration) {
+	c.stopKeepAlive = make(chan struct{})
+	c.keepAliveDone = make(chan struct{})
+
+	go func() {
+		defer close(c.keepAliveDone)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.stopKeepAlive:
+				return
+			case <-ticker.C:
+				c.keepAlive(interval)
+			}
+		}
+	}()
+}
+
+func (c *Client) keepAlive(interval time.Duration) {
+	if !c.cmdMu.TryLock() {
+		return
+	}
+	defer c.cmdMu.Unlock()
+
+	if c.client == nil || time.Since(c.lastUsed) < interval {
+		return
+	}
+
+	// A failure here means the connection is gone; the next command notices
+	// and reconnects.
+	if err := c.client.Noop().Wait(); err != nil {
+		c.log.WithError(err).Debug("Keepalive NOOP failed")
+		return
+	}
+	c.lastUsed = time.Now()
+}
+
+// stopKeepAliveLoop stops the keepalive goroutine, if any, and waits for it
+// to exit so it can't use the connection after Close.
+func (c *Client) stopKeepAliveLoop() {
+	if c.stopKeepAlive == nil {
+		return
+	}
+	close(c.stopKeepAlive)
+	<-c.keepAliveDone
+	c.stopKeepAlive = nil
+}
@@ -0,0 +1,76 @@
+package imap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeepAlive_SendsNoopWhenIdle(t *testing.T) {
+	opts, cleanup := newTestIMAPServer(t)
+	defer cleanup()
+
+	opts.KeepAlive = 20 * time.Millisecond
+	c, err := Connect(opts)
+	require.NoError(t, err)
+	defer c.Close()
+
+	c.cmdMu.Lock()
+	connected := c.lastUsed
+	c.cmdMu.Unlock()
+
+	assert.Eventually(t, func() bool {
+		c.cmdMu.Lock()
+		defer c.cmdMu.Unlock()
+		return c.lastUsed.After(connected)
+	}, time.Second, 10*time.Millisecond)
+
+	// Commands still work alongside the keepalive.
+	_, err = c.ListMailboxes()
+	require.NoError(t, err)
+}
+
+func TestKeepAlive_SkipsWhileCommandRuns(t *testing.T) {
+	opts, cleanup := newTestIMAPServer(t)
+	defer cleanup()
+
+	c, err := Connect(opts)
+	require.NoError(t, err)
+	defer c.Close()
+
+	c.cmdMu.Lock()
+	c.lastUsed = time.Time{}
+	done := make(chan struct{})
+	go func() {
+		c.keepAlive(time.Millisecond)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("keepalive waited for the running command")
+	}
+	assert.True(t, c.lastUsed.IsZero(), "no NOOP was sent")
+	c.cmdMu.Unlock()
+}
+
+func TestKeepAlive_StopsOnClose(t *testing.T) {
+	opts, cleanup := newTestIMAPServer(t)
+	defer cleanup()
+
+	opts.KeepAlive = 10 * time.Millisecond
+	c, err := Connect(opts)
+	require.NoError(t, err)
+
+	done := c.keepAliveDone
+	require.NoError(t, c.Close())
+
+	select {
+	case <-done:
+	default:
+		t.Fatal("keepalive goroutine still running after Close")
+	}
+}
@@ -0,0 +1,179 @@
+package imap
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+var lsubRe = regexp.MustCompile(`^\* LSUB \(([^)]*)\) (NIL|"(?:[^"\\]|\\.)*") (.+)$`)
+
+// lsub lists the subscribed mailboxes matching ref and pattern with LSUB,
+// for servers without LIST-EXTENDED. imapclient doesn't send LSUB, so it
+// runs on a raw connection.
+func (c *Client) lsub(ref, pattern string) ([]*imap.ListData, error) {
+	raw, err := c.dialRaw()
+	if err != nil {
+		return nil, err
+	}
+	defer raw.close()
+
+	// With UTF8=ACCEPT names are sent as UTF-8 rather than modified UTF-7.
+	lines, err := raw.command("ENABLE UTF8=ACCEPT")
+	utf8Names := err == nil && slices.ContainsFunc(lines, func(line string) bool {
+		line = strings.ToUpper(line)
+		return strings.HasPrefix(line, "* ENABLED ") && strings.Contains(line, "UTF8=ACCEPT")
+	})
+	if !utf8Names {
+		ref, pattern = encodeMailboxName(ref), encodeMailboxName(pattern)
+	}
+
+	lines, err = raw.command("LSUB " + quoteString(ref) + " " + quoteString(pattern))
+	if err != nil {
+		return nil, err
+	}
+
+	var mboxes []*imap.ListData
+	for _, line := range lines {
+		mbox, err := parseLSUB(line, utf8Names)
+		if err != nil {
+			return nil, err
+		}
+		if mbox != nil {
+			mboxes = append(mboxes, mbox)
+		}
+	}
+	return mboxes, nil
+}
+
+// parseLSUB parses an LSUB response, returning nil for other responses.
+func parseLSUB(line string, utf8Names bool) (*imap.ListData, error) {
+	if !strings.HasPrefix(line, "* LSUB ") {
+		return nil, nil
+	}
+	m := lsubRe.FindStringSubmatch(line)
+	if m == nil {
+		return nil, fmt.Errorf("malformed LSUB response: %s", line)
+	}
+
+	mbox := &imap.ListData{}
+	for _, attr := range strings.Fields(m[1]) {
+		mbox.Attrs = append(mbox.Attrs, imap.MailboxAttr(attr))
+	}
+	if m[2] != "NIL" {
+		mbox.Delim, _ = utf8.DecodeRuneInString(unquoteString(m[2]))
+	}
+
+	name := m[3]
+	switch {
+	case strings.HasPrefix(name, `"`):
+		name = unquoteString(name)
+	case literalPrefixRe.MatchString(name):
+		// readLine inlines literals after their {n} announcement.
+		name = literalPrefixRe.ReplaceAllString(name, "")
+	}
+	if !utf8Names {
+		decoded, err := decodeMailboxName(name)
+		if err != nil {
+			return nil, fmt.Errorf("malformed mailbox name %q: %w", name, err)
+		}
+		name = decoded
+	}
+	if strings.EqualFold(name, "INBOX") {
+		name = "INBOX"
+	}
+	mbox.Mailbox = name
+	return mbox, nil
+}
+
+var literalPrefixRe = regexp.MustCompile(`^\{\d+\+?\}`)
+
+// unquoteString reverses quoteString.
+func unquoteString(s string) string {
+	s = strings.TrimSuffix(strings.TrimPrefix(s, `"`), `"`)
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// mailboxBase64 is the base64 alphabet of modified UTF-7 (RFC 3501, section
+// 5.1.3), which uses "," instead of "/" and no padding.
+var mailboxBase64 = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+,").WithPadding(base64.NoPadding)
+
+// encodeMailboxName encodes name in modified UTF-7, the mailbox name
+// encoding of servers that don't accept UTF-8.
+func encodeMailboxName(name string) string {
+	var sb strings.Builder
+	var pending []rune
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		units := utf16.Encode(pending)
+		b := make([]byte, 0, 2*len(units))
+		for _, u := range units {
+			b = append(b, byte(u>>8), byte(u))
+		}
+		sb.WriteString("&" + mailboxBase64.EncodeToString(b) + "-")
+		pending = nil
+	}
+
+	for _, r := range name {
+		if r < 0x20 || r > 0x7e {
+			pending = append(pending, r)
+			continue
+		}
+		flush()
+		if r == '&' {
+			sb.WriteString("&-")
+		} else {
+			sb.WriteRune(r)
+		}
+	}
+	flush()
+	return sb.String()
+}
+
+// decodeMailboxName reverses encodeMailboxName.
+func decodeMailboxName(name string) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] != '&' {
+			sb.WriteByte(name[i])
+			continue
+		}
+
+		end := strings.IndexByte(name[i+1:], '-')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated shift")
+		}
+		chunk := name[i+1 : i+1+end]
+		i += end + 1
+		if chunk == "" {
+			sb.WriteByte('&')
+			continue
+		}
+
+		b, err := mailboxBase64.DecodeString(chunk)
+		if err != nil || len(b)%2 != 0 {
+			return "", fmt.Errorf("invalid shifted sequence %q", chunk)
+		}
+		units := make([]uint16, len(b)/2)
+		for j := range units {
+			units[j] = uint16(b[2*j])<<8 | uint16(b[2*j+1])
+		}
+		sb.WriteString(string(utf16.Decode(units)))
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,49 @@
+package imap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMailboxNameEncoding(t *testing.T) {
+	for name, encoded := range map[string]string{
+		"INBOX":         "INBOX",
+		"Tom & Jerry":   "Tom &- Jerry",
+		"Entwürfe":      "Entw&APw-rfe",
+		"日本語":           "&ZeVnLIqe-",
+		"Mail/🙂/Archiv": "Mail/&2D3eQg-/Archiv",
+	} {
+		assert.Equal(t, encoded, encodeMailboxName(name), name)
+
+		decoded, err := decodeMailboxName(encoded)
+		require.NoError(t, err, encoded)
+		assert.Equal(t, name, decoded, encoded)
+	}
+
+	for _, invalid := range []string{"&ZeVn", "&Z!-", "&AP-"} {
+		_, err := decodeMailboxName(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestParseLSUB(t *testing.T) {
+	mbox, err := parseLSUB(`* LSUB (\HasChildren) "." {11}Work.Q1 2025`, true)
+	require.NoError(t, err)
+	assert.Equal(t, "Work.Q1 2025", mbox.Mailbox)
+	assert.Equal(t, '.', mbox.Delim)
+	assert.True(t, hasAttr(mbox.Attrs, `\hasChildren`))
+
+	mbox, err = parseLSUB(`* LSUB () NIL "Say \"hi\""`, true)
+	require.NoError(t, err)
+	assert.Equal(t, `Say "hi"`, mbox.Mailbox)
+	assert.Zero(t, mbox.Delim)
+
+	mbox, err = parseLSUB(`* OK still here`, true)
+	assert.NoError(t, err)
+	assert.Nil(t, mbox)
+
+	_, err = parseLSUB(`* LSUB garbage`, true)
+	assert.Error(t, err)
+}
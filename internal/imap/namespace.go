@@ -0,0 +1,57 @@
+package imap
+
+import (
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// defaultDelimiter is the hierarchy delimiter assumed when the server
+// doesn't say, which is what Gmail and most servers use.
+const defaultDelimiter = '/'
+
+// loadNamespace records the hierarchy delimiter of the server's personal
+// namespace. Servers without NAMESPACE, or that fail it, keep the default.
+func (c *Client) loadNamespace(client *imapclient.Client) {
+	delim := rune(defaultDelimiter)
+
+	caps := client.Caps()
+	if caps.Has(imap.CapNamespace) || caps.Has(imap.CapIMAP4rev2) {
+		data, err := client.Namespace().Wait()
+		switch {
+		case err != nil:
+			c.log.WithError(err).Debug("NAMESPACE failed, assuming / as hierarchy delimiter")
+		case len(data.Personal) > 0:
+			delim = data.Personal[0].Delim
+		}
+	}
+
+	c.mu.Lock()
+	c.delimiter = delim
+	c.mu.Unlock()
+}
+
+// HierarchyDelimiter returns the separator between the levels of mailbox
+// names in the personal namespace, e.g. "." on a default Dovecot. It is
+// empty if the server has a flat namespace.
+func (c *Client) HierarchyDelimiter() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.delimiter == 0 {
+		return ""
+	}
+	return string(c.delimiter)
+}
+
+// MailboxPath converts a mailbox name whose levels are separated by / to
+// the server's delimiter, so "Archive/2024" is created as "Archive.2024" on
+// a server using ".". Names are left alone on a flat namespace.
+func (c *Client) MailboxPath(name string) string {
+	delim := c.HierarchyDelimiter()
+	if delim == "" || delim == "/" {
+		return name
+	}
+	return strings.ReplaceAll(name, "/", delim)
+}
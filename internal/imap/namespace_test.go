@@ -0,0 +1,58 @@
+package imap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHierarchyDelimiter(t *testing.T) {
+	t.Run("dot-delimited namespace", func(t *testing.T) {
+		port, _ := newFakeGmail(t, map[string][]string{
+			"CAPABILITY":          {"* CAPABILITY IMAP4rev1 NAMESPACE AUTH=PLAIN"},
+			`LOGIN "user" "pass"`: nil,
+			"NAMESPACE":           {`* NAMESPACE (("" ".")) (("Other Users." ".")) NIL`},
+			"LOGOUT":              {"* BYE"},
+		})
+
+		c, err := Connect(ConnectOptions{Host: "127.0.0.1", Port: port, Username: imapTestUser, Password: imapTestPass})
+		require.NoError(t, err)
+		defer c.Close()
+
+		assert.Equal(t, ".", c.HierarchyDelimiter())
+		assert.Equal(t, "Archive.2024", c.MailboxPath("Archive/2024"))
+	})
+
+	t.Run("without NAMESPACE", func(t *testing.T) {
+		port, _ := newFakeGmail(t, map[string][]string{
+			"CAPABILITY":          {"* CAPABILITY IMAP4rev1 AUTH=PLAIN"},
+			`LOGIN "user" "pass"`: nil,
+			"LOGOUT":              {"* BYE"},
+		})
+
+		c, err := Connect(ConnectOptions{Host: "127.0.0.1", Port: port, Username: imapTestUser, Password: imapTestPass})
+		require.NoError(t, err)
+		defer c.Close()
+
+		assert.Equal(t, "/", c.HierarchyDelimiter())
+	})
+
+	t.Run("memory server", func(t *testing.T) {
+		opts, cleanup := newTestIMAPServer(t)
+		defer cleanup()
+
+		c, err := Connect(opts)
+		require.NoError(t, err)
+		defer c.Close()
+
+		assert.Equal(t, "/", c.HierarchyDelimiter())
+		assert.Equal(t, "Archive/2024", c.MailboxPath("Archive/2024"))
+	})
+}
+
+func TestMailboxPath_FlatNamespace(t *testing.T) {
+	c := &Client{}
+	assert.Empty(t, c.HierarchyDelimiter())
+	assert.Equal(t, "Archive/2024", c.MailboxPath("Archive/2024"))
+}
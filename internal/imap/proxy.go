@@ -0,0 +1,149 @@
+package imap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/emersion/go-imap/v2/imapclient"
+	"golang.org/x/net/proxy"
+)
+
+// proxyDialTimeout bounds connecting to the proxy itself, matching the
+// default imapclient uses for direct connections.
+const proxyDialTimeout = 30 * time.Second
+
+func init() {
+	proxy.RegisterDialerType("http", newHTTPConnectDialer)
+}
+
+// newDialer returns the dialer for reaching the server: direct when proxyURL
+// is empty, otherwise through a socks5, socks5h or http (CONNECT) proxy.
+// Credentials are taken from the URL's user info.
+func newDialer(proxyURL string) (proxy.Dialer, error) {
+	forward := &net.Dialer{Timeout: proxyDialTimeout}
+	if proxyURL == "" {
+		return forward, nil
+	}
+
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+
+	d, err := proxy.FromURL(u, forward)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	return d, nil
+}
+
+// dialProxy connects through the configured proxy and performs the TLS
+// handshake itself, since imapclient's Dial functions only dial directly.
+func (c *Client) dialProxy(addr string, opts *imapclient.Options) (*imapclient.Client, error) {
+	conn, err := c.dialConn(addr, opts.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	return imapclient.New(conn, opts), nil
+}
+
+// dialConn opens a raw connection to addr, through the proxy when one is
+// configured, and performs the TLS handshake when tlsConfig is set.
+func (c *Client) dialConn(addr string, tlsConfig *tls.Config) (net.Conn, error) {
+	d, err := newDialer(c.opts.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := d.Dial("tcp", addr)
+	if err != nil {
+		if c.opts.Proxy != "" {
+			return nil, fmt.Errorf("proxy: %w", err)
+		}
+		return nil, err
+	}
+
+	if tlsConfig != nil {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.NextProtos = []string{"imap"}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	return conn, nil
+}
+
+// httpConnectDialer tunnels connections through an HTTP proxy with the
+// CONNECT method.
+type httpConnectDialer struct {
+	proxyAddr string
+	auth      string
+	forward   proxy.Dialer
+}
+
+func newHTTPConnectDialer(u *url.URL, forward proxy.Dialer) (proxy.Dialer, error) {
+	d := &httpConnectDialer{proxyAddr: u.Host, forward: forward}
+	if u.Port() == "" {
+		d.proxyAddr = net.JoinHostPort(u.Hostname(), "80")
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		d.auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":"+password))
+	}
+	return d, nil
+}
+
+func (d *httpConnectDialer) Dial(network, addr string) (net.Conn, error) {
+	conn, err := d.forward.Dial(network, d.proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if d.auth != "" {
+		req.Header.Set("Proxy-Authorization", d.auth)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send CONNECT: %w", err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read CONNECT response: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused CONNECT to %s: %s", addr, resp.Status)
+	}
+
+	// The server may have sent its greeting along with the response.
+	return &bufferedConn{Conn: conn, r: br}, nil
+}
+
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,107 @@
+package imap
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDialer(t *testing.T) {
+	d, err := newDialer("")
+	require.NoError(t, err)
+	assert.IsType(t, &net.Dialer{}, d, "no proxy dials directly")
+
+	for _, u := range []string{"socks5://bastion:1080", "socks5h://user:pw@bastion:1080"} {
+		d, err = newDialer(u)
+		require.NoError(t, err, u)
+		_, direct := d.(*net.Dialer)
+		assert.False(t, direct, u)
+	}
+
+	d, err = newDialer("http://user:pw@bastion")
+	require.NoError(t, err)
+	require.IsType(t, &httpConnectDialer{}, d)
+	assert.Equal(t, "bastion:80", d.(*httpConnectDialer).proxyAddr)
+	assert.Equal(t, "Basic dXNlcjpwdw==", d.(*httpConnectDialer).auth)
+
+	_, err = newDialer("ftp://bastion")
+	assert.Error(t, err)
+}
+
+// newConnectProxy starts an HTTP CONNECT proxy that answers with status,
+// tunnelling only when it is 200. Proxy-Authorization headers are sent to auth.
+func newConnectProxy(t *testing.T, status int) (addr string, auth <-chan string) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() }) //nolint:errcheck
+
+	authCh := make(chan string, 1)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				authCh <- req.Header.Get("Proxy-Authorization")
+
+				if status != http.StatusOK {
+					fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n\r\n", status, http.StatusText(status))
+					return
+				}
+				upstream, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+				fmt.Fprint(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+				go io.Copy(upstream, conn) //nolint:errcheck
+				io.Copy(conn, upstream)    //nolint:errcheck
+			}()
+		}
+	}()
+
+	return ln.Addr().String(), authCh
+}
+
+func TestConnect_HTTPProxy(t *testing.T) {
+	opts, cleanup := newTestIMAPServer(t)
+	defer cleanup()
+
+	proxyAddr, auth := newConnectProxy(t, http.StatusOK)
+	opts.Proxy = "http://bob:secret@" + proxyAddr
+
+	c, err := Connect(opts)
+	require.NoError(t, err)
+	defer c.Close()
+
+	assert.Equal(t, "Basic Ym9iOnNlY3JldA==", <-auth)
+
+	mailboxes, err := c.ListMailboxes()
+	require.NoError(t, err)
+	assert.Contains(t, mailboxes, "INBOX")
+}
+
+func TestConnect_HTTPProxyRefused(t *testing.T) {
+	opts, cleanup := newTestIMAPServer(t)
+	defer cleanup()
+
+	proxyAddr, _ := newConnectProxy(t, http.StatusProxyAuthRequired)
+	opts.Proxy = "http://" + proxyAddr
+
+	_, err := Connect(opts)
+	assert.ErrorContains(t, err, "proxy refused CONNECT")
+}
@@ -0,0 +1,91 @@
+package imap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// ErrQuotaUnsupported is returned by GetQuota when the server doesn't
+// advertise the QUOTA extension.
+var ErrQuotaUnsupported = errors.New("server does not support QUOTA")
+
+// QuotaInfo is the usage of the quota root that INBOX belongs to.
+type QuotaInfo struct {
+	Root string
+
+	// UsedBytes and TotalBytes are the STORAGE usage and limit. They are
+	// only meaningful when HasStorage is set: a root may limit messages
+	// only.
+	UsedBytes  int64
+	TotalBytes int64
+	HasStorage bool
+
+	// Messages and MessageLimit are the MESSAGE usage and limit, when
+	// HasMessages is set.
+	Messages     int64
+	MessageLimit int64
+	HasMessages  bool
+}
+
+// UsedPercent returns the share of the storage limit in use, or 0 when
+// there is none.
+func (q *QuotaInfo) UsedPercent() float64 {
+	if !q.HasStorage || q.TotalBytes <= 0 {
+		return 0
+	}
+	return float64(q.UsedBytes) / float64(q.TotalBytes) * 100
+}
+
+// GetQuota returns the quota of the account's INBOX using GETQUOTAROOT
+// (RFC 9208), or ErrQuotaUnsupported if the server has no quotas.
+func (c *Client) GetQuota(ctx context.Context) (*QuotaInfo, error) {
+	var info *QuotaInfo
+
+	err := c.withRetry(ctx, func() error {
+		if !c.client.Caps().Has(imap.CapQuota) {
+			return ErrQuotaUnsupported
+		}
+
+		data, err := c.client.GetQuotaRoot("INBOX").Wait()
+		if err != nil {
+			return fmt.Errorf("failed to get quota: %w", err)
+		}
+		info = quotaInfo(data)
+		return nil
+	})
+
+	return info, err
+}
+
+// quotaInfo picks the first quota root limiting storage, falling back to
+// the first root reported. STORAGE is counted in units of 1024 octets.
+func quotaInfo(data []imapclient.QuotaData) *QuotaInfo {
+	if len(data) == 0 {
+		return &QuotaInfo{}
+	}
+
+	root := data[0]
+	for _, d := range data {
+		if _, ok := d.Resources[imap.QuotaResourceStorage]; ok {
+			root = d
+			break
+		}
+	}
+
+	info := &QuotaInfo{Root: root.Root}
+	if res, ok := root.Resources[imap.QuotaResourceStorage]; ok {
+		info.UsedBytes = res.Usage * 1024
+		info.TotalBytes = res.Limit * 1024
+		info.HasStorage = true
+	}
+	if res, ok := root.Resources[imap.QuotaResourceMessage]; ok {
+		info.Messages = res.Usage
+		info.MessageLimit = res.Limit
+		info.HasMessages = true
+	}
+	return info
+}
@@ -0,0 +1,58 @@
+package imap
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetQuota(t *testing.T) {
+	port, _ := newFakeGmail(t, map[string][]string{
+		"CAPABILITY":          {"* CAPABILITY IMAP4rev1 QUOTA AUTH=PLAIN"},
+		`LOGIN "user" "pass"`: nil,
+		"GETQUOTAROOT INBOX": {
+			`* QUOTAROOT INBOX "" "user.shared"`,
+			`* QUOTA "user.shared" (MESSAGE 10 100)`,
+			`* QUOTA "" (STORAGE 512000 1048576 MESSAGE 4200 0)`,
+		},
+		"LOGOUT": {"* BYE"},
+	})
+
+	c, err := Connect(ConnectOptions{Host: "127.0.0.1", Port: port, Username: imapTestUser, Password: imapTestPass})
+	require.NoError(t, err)
+	defer c.Close()
+
+	quota, err := c.GetQuota(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, &QuotaInfo{
+		Root:         "",
+		UsedBytes:    512000 * 1024,
+		TotalBytes:   1048576 * 1024,
+		HasStorage:   true,
+		Messages:     4200,
+		MessageLimit: 0,
+		HasMessages:  true,
+	}, quota, "the root limiting storage is preferred")
+	assert.InDelta(t, 48.8, quota.UsedPercent(), 0.1)
+}
+
+func TestGetQuota_Unsupported(t *testing.T) {
+	opts, cleanup := newTestIMAPServer(t)
+	defer cleanup()
+
+	c, err := Connect(opts)
+	require.NoError(t, err)
+	defer c.Close()
+
+	_, err = c.GetQuota(context.Background())
+	assert.ErrorIs(t, err, ErrQuotaUnsupported)
+}
+
+func TestQuotaInfo_UsedPercent(t *testing.T) {
+	assert.Zero(t, (&QuotaInfo{}).UsedPercent())
+	assert.Zero(t, (&QuotaInfo{HasStorage: true, UsedBytes: 10}).UsedPercent(), "no limit")
+	assert.Equal(t, 25.0, (&QuotaInfo{HasStorage: true, UsedBytes: 1, TotalBytes: 4}).UsedPercent())
+}
@@ -0,0 +1,218 @@
+package imap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rawCommandTimeout bounds each command on a raw connection.
+const rawCommandTimeout = time.Minute
+
+// maxRawLiteral caps the literals accepted on a raw connection, which only
+// ever reads short responses.
+const maxRawLiteral = 1 << 20
+
+// rawConn is a bare IMAP connection for what imapclient can't do: fetching
+// Gmail's X-GM-MSGID and X-GM-THRID attributes, negotiating COMPRESS and
+// listing subscriptions with LSUB. It only speaks the handful of commands
+// needed for that.
+type rawConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+}
+
+// dialRaw opens and authenticates a raw connection to the server the same
+// way the main connection is.
+func (c *Client) dialRaw() (*rawConn, error) {
+	addr := fmt.Sprintf("%s:%d", c.opts.Host, c.opts.Port)
+
+	var tlsConfig *tls.Config
+	if c.opts.TLS {
+		tlsConfig = &tls.Config{ServerName: c.opts.Host}
+	}
+
+	conn, err := c.dialConn(addr, tlsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	g := &rawConn{conn: conn, r: bufio.NewReader(conn)}
+
+	conn.SetDeadline(time.Now().Add(rawCommandTimeout))
+	greeting, err := g.readLine()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read greeting: %w", err)
+	}
+	if !strings.HasPrefix(greeting, "* OK") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected greeting: %s", greeting)
+	}
+
+	if err := g.login(c); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return g, nil
+}
+
+func (g *rawConn) login(c *Client) error {
+	saslClient, err := c.saslClient()
+	if err != nil {
+		return err
+	}
+
+	if saslClient == nil {
+		if _, err := g.command("LOGIN", c.opts.Username, c.opts.Password); err != nil {
+			return fmt.Errorf("failed to login: %w", authFailure(err))
+		}
+		return nil
+	}
+
+	mech, ir, err := saslClient.Start()
+	if err != nil {
+		return fmt.Errorf("failed to authenticate: %w", err)
+	}
+	if _, err := g.command("AUTHENTICATE " + mech + " " + base64.StdEncoding.EncodeToString(ir)); err != nil {
+		return fmt.Errorf("failed to authenticate: %w", authFailure(err))
+	}
+	return nil
+}
+
+// command sends a command and returns its untagged responses, or an error
+// unless the server completes it with OK. Each of args is appended as a
+// quoted string, or as a literal when it can't be quoted, as imapclient does
+// for credentials with CR, LF or 8-bit characters.
+func (g *rawConn) command(cmd string, args ...string) ([]string, error) {
+	g.tag++
+	tag := fmt.Sprintf("g%d", g.tag)
+	verb, _, _ := strings.Cut(cmd, " ")
+
+	g.conn.SetDeadline(time.Now().Add(rawCommandTimeout))
+	line := tag + " " + cmd
+	var untagged []string
+	for _, arg := range args {
+		if quotable(arg) {
+			line += " " + quoteString(arg)
+			continue
+		}
+		if _, err := fmt.Fprintf(g.conn, "%s {%d}\r\n", line, len(arg)); err != nil {
+			return nil, err
+		}
+		// The server asks for the literal with a continuation, or refuses
+		// the command already.
+		for {
+			resp, err := g.readLine()
+			if err != nil {
+				return nil, err
+			}
+			if strings.HasPrefix(resp, "+") {
+				break
+			}
+			if status, ok := strings.CutPrefix(resp, tag+" "); ok {
+				return nil, &statusError{verb: verb, status: status}
+			}
+			untagged = append(untagged, resp)
+		}
+		line = arg
+	}
+	if _, err := io.WriteString(g.conn, line+"\r\n"); err != nil {
+		return nil, err
+	}
+
+	for {
+		line, err := g.readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case strings.HasPrefix(line, "+"):
+			// A challenge we can't answer, such as an XOAUTH2 error
+			// description: an empty response makes the server fail the
+			// command.
+			if _, err := io.WriteString(g.conn, "\r\n"); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(line, tag+" "):
+			status := strings.TrimPrefix(line, tag+" ")
+			if !strings.HasPrefix(status, "OK") {
+				return nil, &statusError{verb: verb, status: status}
+			}
+			return untagged, nil
+		default:
+			untagged = append(untagged, line)
+		}
+	}
+}
+
+// statusError is a command the server completed with NO or BAD.
+type statusError struct {
+	verb   string
+	status string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s failed: %s", e.verb, e.status)
+}
+
+var literalRe = regexp.MustCompile(`\{(\d+)\+?\}$`)
+
+// readLine reads a response line, with any literals it carries inlined.
+func (g *rawConn) readLine() (string, error) {
+	var sb strings.Builder
+	for {
+		line, err := g.r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		sb.WriteString(line)
+
+		m := literalRe.FindStringSubmatch(line)
+		if m == nil {
+			return sb.String(), nil
+		}
+		n, err := strconv.Atoi(m[1])
+		if err != nil || n > maxRawLiteral {
+			return "", fmt.Errorf("literal too large: %s", m[0])
+		}
+		if _, err := io.CopyN(&sb, g.r, int64(n)); err != nil {
+			return "", err
+		}
+	}
+}
+
+func (g *rawConn) close() {
+	g.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	g.command("LOGOUT")
+	g.conn.Close()
+}
+
+// quotable reports whether s can be sent as an IMAP quoted string, which
+// holds 7-bit characters other than NUL, CR and LF.
+func quotable(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if b := s[i]; b == 0 || b == '\r' || b == '\n' || b >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// quoteString encodes s as an IMAP quoted string.
+func quoteString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
@@ -0,0 +1,94 @@
+package imap
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSASLClient(t *testing.T) {
+	t.Run("password uses login", func(t *testing.T) {
+		for _, method := range []string{"", AuthPassword} {
+			c := &Client{opts: ConnectOptions{AuthMethod: method, Username: "u", Password: "p"}}
+			sc, err := c.saslClient()
+			require.NoError(t, err)
+			assert.Nil(t, sc)
+		}
+	})
+
+	t.Run("xoauth2 with static token", func(t *testing.T) {
+		c := &Client{opts: ConnectOptions{AuthMethod: AuthXOAuth2, Username: "me@gmail.com", AccessToken: "tok123"}}
+		sc, err := c.saslClient()
+		require.NoError(t, err)
+		require.IsType(t, &xoauth2Client{}, sc)
+
+		mech, ir, err := sc.Start()
+		require.NoError(t, err)
+		assert.Equal(t, "XOAUTH2", mech)
+		assert.Equal(t, "user=me@gmail.com\x01auth=Bearer tok123\x01\x01", string(ir))
+
+		resp, err := sc.Next([]byte(`{"status":"401"}`))
+		require.NoError(t, err)
+		assert.Empty(t, resp)
+	})
+
+	t.Run("xoauth2 token func takes precedence", func(t *testing.T) {
+		c := &Client{opts: ConnectOptions{
+			AuthMethod:  AuthXOAuth2,
+			Username:    "me@outlook.com",
+			AccessToken: "stale",
+			TokenFunc:   func() (string, error) { return "fresh", nil },
+		}}
+		sc, err := c.saslClient()
+		require.NoError(t, err)
+		_, ir, err := sc.Start()
+		require.NoError(t, err)
+		assert.Contains(t, string(ir), "auth=Bearer fresh")
+	})
+
+	t.Run("xoauth2 token func error", func(t *testing.T) {
+		c := &Client{opts: ConnectOptions{
+			AuthMethod: AuthXOAuth2,
+			TokenFunc:  func() (string, error) { return "", errors.New("boom") },
+		}}
+		_, err := c.saslClient()
+		assert.ErrorContains(t, err, "failed to obtain access token")
+	})
+
+	t.Run("xoauth2 without token", func(t *testing.T) {
+		c := &Client{opts: ConnectOptions{AuthMethod: AuthXOAuth2}}
+		_, err := c.saslClient()
+		assert.ErrorContains(t, err, "requires an access token")
+	})
+
+	t.Run("unknown method", func(t *testing.T) {
+		c := &Client{opts: ConnectOptions{AuthMethod: "kerberos"}}
+		_, err := c.saslClient()
+		assert.ErrorContains(t, err, "unsupported auth method")
+	})
+}
+
+func TestConnect_XOAuth2RefreshesTokenOnEveryConnect(t *testing.T) {
+	opts, cleanup := newTestIMAPServer(t)
+	defer cleanup()
+
+	calls := 0
+	opts.AuthMethod = AuthXOAuth2
+	opts.TokenFunc = func() (string, error) {
+		calls++
+		return "token", nil
+	}
+
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+	c := &Client{opts: opts, log: log}
+
+	// The in-memory server doesn't support XOAUTH2, so authentication fails,
+	// but each connection attempt must have fetched a fresh token.
+	assert.Error(t, c.connect())
+	assert.Error(t, c.connect())
+	assert.Equal(t, 2, calls)
+}
@@ -0,0 +1,53 @@
+package importer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/newsamples/imapsync/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// Eml stores the message of the .eml file at path in mailbox or, if path is
+// a directory, every .eml file in it, in name order. Subdirectories are not
+// read.
+func Eml(store *storage.Storage, mailbox, path string, log *logrus.Logger) (*Stats, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		files = files[:0]
+		for _, entry := range entries {
+			if entry.Type().IsRegular() && strings.EqualFold(filepath.Ext(entry.Name()), ".eml") {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+		slices.Sort(files)
+	}
+
+	im, err := newImporter(store, mailbox, log)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return im.stats, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		if err := im.add(file, raw, time.Time{}); err != nil {
+			return im.stats, err
+		}
+	}
+	return im.finish()
+}
@@ -0,0 +1,45 @@
+package importer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEml(t *testing.T) {
+	store := newTestStorage(t)
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"b.eml":     "Subject: Second\r\n\r\nb",
+		"a.EML":     "Subject: First\n\na",
+		"notes.txt": "Subject: Not mail\n\nx",
+		"bad.eml":   "no header here",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+	}
+
+	stats, err := Eml(store, "Old", dir, log)
+	require.NoError(t, err)
+	assert.Equal(t, &Stats{Imported: 2, Skipped: 1}, stats)
+
+	for uid, subject := range map[uint32]string{1: "First", 2: "Second"} {
+		email, err := store.GetEmail("Old", uid)
+		require.NoError(t, err)
+		require.NotNil(t, email)
+		assert.Equal(t, subject, email.Subject)
+	}
+
+	stats, err = Eml(store, "Old", filepath.Join(dir, "b.eml"), log)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Imported)
+	count, err := store.CountMessages("Old")
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+}
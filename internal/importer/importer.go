@@ -0,0 +1,191 @@
+// Package importer reads messages from mbox and .eml files into storage, for
+// mail that was backed up by other tools or is no longer on any server.
+package importer
+
+import (
+	"bytes"
+	"fmt"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/newsamples/imapsync/internal/imap"
+	"github.com/newsamples/imapsync/internal/mailparse"
+	"github.com/newsamples/imapsync/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// batchSize is how many messages are stored per transaction.
+const batchSize = 100
+
+// Stats reports the outcome of an import.
+type Stats struct {
+	Imported int
+	Skipped  int
+}
+
+// importer stores messages in a mailbox under UIDs it makes up, since they
+// were never assigned by a server.
+type importer struct {
+	store   *storage.Storage
+	mailbox string
+	log     *logrus.Logger
+
+	nextUID uint32
+	batch   []*storage.Email
+	stats   *Stats
+}
+
+// newImporter starts numbering above every UID the mailbox has used, so
+// importing never replaces a stored message, and importing again appends.
+func newImporter(store *storage.Storage, mailbox string, log *logrus.Logger) (*importer, error) {
+	var last uint32
+	state, err := store.GetMailboxState(mailbox)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get mailbox state: %w", err)
+	}
+	if state != nil {
+		last = state.LastUID
+	}
+
+	uids, err := store.ListUIDs(mailbox)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stored UIDs: %w", err)
+	}
+	if len(uids) > 0 {
+		last = max(last, slices.Max(uids))
+	}
+
+	return &importer{store: store, mailbox: mailbox, log: log, nextUID: last + 1, stats: &Stats{}}, nil
+}
+
+// add queues a raw message for storing. received is when it was delivered,
+// if known. Messages whose header can't be read are skipped with a warning;
+// name identifies the message in it.
+func (im *importer) add(name string, raw []byte, received time.Time) error {
+	email, err := parseEmail(im.mailbox, im.nextUID, toCRLF(raw), received)
+	if err != nil {
+		im.log.Warnf("Skipping %s: %v", name, err)
+		im.stats.Skipped++
+		return nil
+	}
+	im.nextUID++
+
+	im.batch = append(im.batch, email)
+	if len(im.batch) >= batchSize {
+		return im.flush()
+	}
+	return nil
+}
+
+// flush stores the queued messages.
+func (im *importer) flush() error {
+	if len(im.batch) == 0 {
+		return nil
+	}
+	if err := im.store.SaveEmailBatch(im.batch); err != nil {
+		return fmt.Errorf("failed to store messages: %w", err)
+	}
+	im.stats.Imported += len(im.batch)
+	im.batch = im.batch[:0]
+	return nil
+}
+
+// finish stores the last messages and lists the mailbox, which storage only
+// does for mailboxes with a state. An existing state is left alone, as it
+// belongs to the sync.
+func (im *importer) finish() (*Stats, error) {
+	if err := im.flush(); err != nil {
+		return im.stats, err
+	}
+	if im.stats.Imported == 0 {
+		return im.stats, nil
+	}
+
+	state, err := im.store.GetMailboxState(im.mailbox)
+	if err != nil {
+		return im.stats, fmt.Errorf("failed to get mailbox state: %w", err)
+	}
+	if state == nil {
+		state = &storage.MailboxState{Name: im.mailbox, LastUID: im.nextUID - 1, LastSync: time.Now()}
+		if err := im.store.SaveMailboxState(state); err != nil {
+			return im.stats, fmt.Errorf("failed to save mailbox state: %w", err)
+		}
+	}
+	return im.stats, nil
+}
+
+// parseEmail builds the stored form of a raw message from its header, which
+// stands in for the envelope a server would have sent.
+func parseEmail(mailbox string, uid uint32, raw []byte, received time.Time) (*storage.Email, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	headers := mailparse.HeaderBlock(raw)
+
+	email := &storage.Email{
+		UID:        uid,
+		Mailbox:    mailbox,
+		Subject:    mailparse.DecodeHeader(msg.Header.Get("Subject")),
+		To:         addresses(msg.Header, "To"),
+		Cc:         addresses(msg.Header, "Cc"),
+		Bcc:        addresses(msg.Header, "Bcc"),
+		Size:       uint32(len(raw)),
+		Flags:      statusFlags(msg.Header),
+		Headers:    headers,
+		RawMessage: raw,
+		Synced:     time.Now(),
+	}
+	if from, err := msg.Header.AddressList("From"); err == nil && len(from) > 0 {
+		email.From = from[0].Address
+		email.FromName = from[0].Name
+	}
+	if !received.IsZero() {
+		email.InternalDate = &received
+	}
+	if date, err := msg.Header.Date(); err == nil {
+		email.Date = date
+	} else {
+		email.Date = imap.MessageDate(&imap.Message{InternalDate: received, Headers: headers})
+	}
+	return email, nil
+}
+
+// addresses returns the bare addresses of an address header, nil if it is
+// missing or can't be parsed.
+func addresses(header mail.Header, key string) []string {
+	list, err := header.AddressList(key)
+	if err != nil {
+		return nil
+	}
+	var result []string
+	for _, addr := range list {
+		result = append(result, addr.Address)
+	}
+	return result
+}
+
+// statusFlags maps the Status and X-Status headers that mbox readers such as
+// mutt and Thunderbird write to IMAP flags.
+func statusFlags(header mail.Header) []string {
+	flags := []string{}
+	status := header.Get("Status") + header.Get("X-Status")
+	for _, f := range []struct {
+		letter string
+		flag   string
+	}{{"R", `\Seen`}, {"A", `\Answered`}, {"F", `\Flagged`}} {
+		if strings.Contains(status, f.letter) {
+			flags = append(flags, f.flag)
+		}
+	}
+	return flags
+}
+
+// toCRLF gives every line of raw a CRLF ending, as IMAP servers send them and
+// restore uploads them.
+func toCRLF(raw []byte) []byte {
+	raw = bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(raw, []byte("\n"), []byte("\r\n"))
+}
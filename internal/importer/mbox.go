@@ -0,0 +1,102 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/newsamples/imapsync/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// mboxDateLayout is the asctime format of "From " separator lines, as written
+// by export.
+const mboxDateLayout = "Mon Jan _2 15:04:05 2006"
+
+// Mbox stores every message of the mbox file read from r in mailbox. Messages
+// start at "From " lines that open the file or follow a blank line, and
+// ">From " quoting is undone as in mboxrd, the variant export writes. The date
+// of the separator is kept as the message's INTERNALDATE.
+func Mbox(store *storage.Storage, mailbox string, r io.Reader, log *logrus.Logger) (*Stats, error) {
+	im, err := newImporter(store, mailbox, log)
+	if err != nil {
+		return nil, err
+	}
+
+	var n int
+	err = ReadMbox(r, func(raw []byte, received time.Time) error {
+		n++
+		return im.add(fmt.Sprintf("message %d", n), raw, received)
+	})
+	if err != nil {
+		return im.stats, err
+	}
+	return im.finish()
+}
+
+// ReadMbox calls fn with each message of an mbox file and the date of its
+// separator line, zero if it has none that can be parsed. Lines of raw end
+// with LF.
+func ReadMbox(r io.Reader, fn func(raw []byte, received time.Time) error) error {
+	br := bufio.NewReader(r)
+
+	var raw []byte
+	var received time.Time
+	started, blank := false, true
+	emit := func() error {
+		if !started {
+			return nil
+		}
+		// Drop the blank line that separates messages.
+		return fn(bytes.TrimSuffix(raw, []byte("\n")), received)
+	}
+
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+			switch {
+			case blank && bytes.HasPrefix(line, []byte("From ")):
+				if err := emit(); err != nil {
+					return err
+				}
+				raw, received, started = nil, separatorDate(string(line)), true
+			case !started:
+				if len(line) > 0 {
+					return errors.New("not an mbox file: it doesn't start with a From line")
+				}
+			default:
+				if len(line) > 0 && line[0] == '>' && bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From ")) {
+					line = line[1:]
+				}
+				raw = append(append(raw, line...), '\n')
+			}
+			blank = len(line) == 0
+		}
+		if err == io.EOF {
+			return emit()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read mbox: %w", err)
+		}
+	}
+}
+
+// separatorDate parses the date following the sender of a "From " line.
+func separatorDate(line string) time.Time {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return time.Time{}
+	}
+	date := strings.Join(fields[2:], " ")
+	for _, layout := range []string{mboxDateLayout, "Mon Jan _2 15:04:05 -0700 2006", "Mon Jan _2 15:04:05 MST 2006"} {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
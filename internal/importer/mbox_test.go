@@ -0,0 +1,97 @@
+package importer
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/newsamples/imapsync/internal/storage"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStorage(t *testing.T) *storage.Storage {
+	t.Helper()
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+	store, err := storage.New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+const twoMessages = `From alice@example.com Sat Feb  3 04:05:06 2024
+From: Alice <alice@example.com>
+To: bob@example.com, Carol <carol@example.com>
+Subject: =?UTF-8?Q?Caf=C3=A9?=
+Date: Fri, 2 Feb 2024 10:00:00 +0000
+Message-ID: <one@example.com>
+Status: RO
+
+>From the top.
+>>From quoted twice
+
+From MAILER-DAEMON Sun Feb  4 08:00:00 2024
+From: bob@example.com
+Subject: No date
+X-Status: F
+
+Second body.
+
+`
+
+func TestMbox(t *testing.T) {
+	store := newTestStorage(t)
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	stats, err := Mbox(store, "Archive", strings.NewReader(twoMessages), log)
+	require.NoError(t, err)
+	assert.Equal(t, &Stats{Imported: 2}, stats)
+
+	count, err := store.CountMessages("Archive")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	mailboxes, err := store.ListMailboxes()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Archive"}, mailboxes)
+
+	first, err := store.GetEmail("Archive", 1)
+	require.NoError(t, err)
+	require.NotNil(t, first)
+	assert.Equal(t, "Café", first.Subject)
+	assert.Equal(t, "alice@example.com", first.From)
+	assert.Equal(t, "Alice", first.FromName)
+	assert.Equal(t, []string{"bob@example.com", "carol@example.com"}, first.To)
+	assert.True(t, time.Date(2024, 2, 2, 10, 0, 0, 0, time.UTC).Equal(first.Date))
+	assert.Equal(t, []string{`\Seen`}, first.Flags)
+	assert.True(t, strings.HasSuffix(string(first.RawMessage), "\r\n\r\nFrom the top.\r\n>From quoted twice\r\n"), string(first.RawMessage))
+
+	second, err := store.GetEmail("Archive", 2)
+	require.NoError(t, err)
+	require.NotNil(t, second)
+	received := time.Date(2024, 2, 4, 8, 0, 0, 0, time.UTC)
+	assert.True(t, received.Equal(second.Date), "dated by the separator: %v", second.Date)
+	require.NotNil(t, second.InternalDate)
+	assert.True(t, received.Equal(*second.InternalDate))
+	assert.Equal(t, []string{`\Flagged`}, second.Flags)
+	assert.Equal(t, "From: bob@example.com\r\nSubject: No date\r\nX-Status: F\r\n\r\nSecond body.\r\n", string(second.RawMessage))
+
+	t.Run("importing again appends", func(t *testing.T) {
+		require.NoError(t, store.SaveMailboxState(&storage.MailboxState{Name: "Archive", LastUID: 10}))
+		stats, err := Mbox(store, "Archive", strings.NewReader(twoMessages), log)
+		require.NoError(t, err)
+		assert.Equal(t, 2, stats.Imported)
+
+		uids, err := store.ListUIDs("Archive")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []uint32{1, 2, 11, 12}, uids)
+	})
+
+	t.Run("not an mbox", func(t *testing.T) {
+		_, err := Mbox(store, "Other", strings.NewReader("Subject: hi\n\nbody\n"), log)
+		assert.ErrorContains(t, err, "not an mbox file")
+	})
+}
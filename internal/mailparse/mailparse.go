@@ -0,0 +1,309 @@
+// Package mailparse walks the MIME tree of raw RFC822 messages. It is shared by
+// storage (search indexing, attachment extraction) and the web server's email
+// viewer.
+package mailparse
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/emersion/go-message/charset"
+)
+
+// Attachment is a decoded attachment part of a message.
+type Attachment struct {
+	// Index is the position of the part among all leaf parts of the message,
+	// in depth-first order. It is stable for a given raw message and is used
+	// to address the attachment.
+	Index       int
+	Filename    string
+	ContentType string
+	Content     []byte
+}
+
+// part is a decoded leaf part of the MIME tree.
+type part struct {
+	index       int
+	mediaType   string
+	disposition string
+	filename    string
+	body        []byte
+}
+
+// isAttachment reports whether the part is a file rather than message text.
+func (p *part) isAttachment() bool {
+	if p.disposition == "attachment" || p.filename != "" {
+		return true
+	}
+	return !strings.HasPrefix(p.mediaType, "text/")
+}
+
+// ParsedMessage is the decoded content of a message.
+type ParsedMessage struct {
+	// Text and HTML are the first text/plain and text/html parts that
+	// aren't attachments, empty if there is none. A multipart/alternative
+	// message usually has both.
+	Text string
+	HTML string
+
+	Attachments []Attachment
+}
+
+// Parse decodes the text, HTML and attachment parts of a raw message. Only
+// a message whose header can't be read is an error; broken parts further
+// down are skipped.
+func Parse(raw []byte) (*ParsedMessage, error) {
+	msg := &ParsedMessage{}
+
+	err := walk(raw, func(p *part) {
+		switch {
+		case p.isAttachment():
+			msg.Attachments = append(msg.Attachments, Attachment{
+				Index:       p.index,
+				Filename:    p.filename,
+				ContentType: p.mediaType,
+				Content:     p.body,
+			})
+		case p.mediaType == "text/html":
+			if msg.HTML == "" {
+				msg.HTML = string(p.body)
+			}
+		case p.mediaType == "text/plain":
+			if msg.Text == "" {
+				msg.Text = string(p.body)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// Attachments returns the attachment parts of a raw message with their
+// transfer encoding decoded. Unparsable messages have no attachments.
+func Attachments(raw []byte) []Attachment {
+	msg, err := Parse(raw)
+	if err != nil {
+		return nil
+	}
+	return msg.Attachments
+}
+
+var (
+	htmlSkipRe = regexp.MustCompile(`(?is)<(script|style|head)[^>]*>.*?</(script|style|head)>`)
+	htmlTagRe  = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// Text returns the human-readable text of a raw message: all text/plain
+// parts, plus text/html parts stripped of markup. A message that can't be
+// parsed is returned as-is.
+func Text(raw []byte) string {
+	if len(raw) == 0 {
+		return ""
+	}
+
+	var parts []string
+	err := walk(raw, func(p *part) {
+		switch {
+		case p.mediaType == "text/html":
+			parts = append(parts, StripHTML(string(p.body)))
+		case strings.HasPrefix(p.mediaType, "text/"):
+			parts = append(parts, string(p.body))
+		}
+	})
+	if err != nil {
+		return string(raw)
+	}
+
+	return strings.Join(parts, "\n")
+}
+
+// MessageID returns the Message-ID header of a raw message or header block,
+// or an empty string if there is none.
+func MessageID(raw []byte) string {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(msg.Header.Get("Message-Id"))
+}
+
+// HeaderBlock returns the header section of a raw message, up to and
+// including the blank line that ends it. A message without a body is all
+// headers.
+func HeaderBlock(raw []byte) []byte {
+	for i := 0; i < len(raw); i++ {
+		if raw[i] != '\n' {
+			continue
+		}
+		switch {
+		case bytes.HasPrefix(raw[i+1:], []byte("\r\n")):
+			return raw[:i+3]
+		case bytes.HasPrefix(raw[i+1:], []byte("\n")):
+			return raw[:i+2]
+		}
+	}
+	return raw
+}
+
+var msgIDRe = regexp.MustCompile(`<[^<>\s]+>`)
+
+// References returns the message IDs a raw message or header block replies
+// to: those of its References header, oldest first, followed by its
+// In-Reply-To if not already listed.
+func References(raw []byte) []string {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil
+	}
+
+	ids := msgIDRe.FindAllString(msg.Header.Get("References"), -1)
+	for _, id := range msgIDRe.FindAllString(msg.Header.Get("In-Reply-To"), -1) {
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// StripHTML removes tags, scripts and styles from HTML and unescapes entities.
+func StripHTML(s string) string {
+	s = htmlSkipRe.ReplaceAllString(s, " ")
+	s = htmlTagRe.ReplaceAllString(s, " ")
+	return html.UnescapeString(s)
+}
+
+// DecodeTransfer decodes a quoted-printable or base64 Content-Transfer-Encoding.
+// Other encodings, and data that fails to decode, are returned unchanged.
+func DecodeTransfer(data []byte, encoding string) []byte {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		decoded, err := io.ReadAll(quotedprintable.NewReader(bytes.NewReader(data)))
+		if err != nil {
+			return data
+		}
+		return decoded
+	case "base64":
+		cleaned := strings.Join(strings.Fields(string(data)), "")
+		decoded, err := base64.StdEncoding.DecodeString(cleaned)
+		if err != nil {
+			return data
+		}
+		return decoded
+	default:
+		return data
+	}
+}
+
+// walk calls visit for every leaf part of a raw message in depth-first order.
+// It fails only if the message header can't be read.
+func walk(raw []byte, visit func(*part)) error {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return fmt.Errorf("failed to parse message: %w", err)
+	}
+
+	index := 0
+	walkPart(textproto.MIMEHeader(msg.Header), msg.Body, &index, visit)
+	return nil
+}
+
+func walkPart(header textproto.MIMEHeader, body io.Reader, index *int, visit func(*part)) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+		params = map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextRawPart()
+			if err != nil {
+				return
+			}
+			walkPart(p.Header, p, index, visit)
+		}
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return
+	}
+
+	p := &part{
+		index:     *index,
+		mediaType: mediaType,
+		body:      DecodeTransfer(data, header.Get("Content-Transfer-Encoding")),
+	}
+	*index++
+
+	if disposition, dparams, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil {
+		p.disposition = disposition
+		p.filename = dparams["filename"]
+	}
+	if p.filename == "" {
+		p.filename = params["name"]
+	}
+	p.filename = DecodeHeader(p.filename)
+
+	// Attached files are kept byte for byte; only message text is converted.
+	if strings.HasPrefix(mediaType, "text/") && !p.isAttachment() {
+		p.body = toUTF8(p.body, params["charset"])
+	}
+
+	visit(p)
+}
+
+// toUTF8 converts text in the named charset to UTF-8. Text without a
+// charset, or in one that isn't known, is assumed to be UTF-8 already.
+func toUTF8(data []byte, name string) []byte {
+	switch strings.ToLower(name) {
+	case "", "utf-8", "utf8", "us-ascii":
+		return data
+	}
+
+	r, err := charset.Reader(name, bytes.NewReader(data))
+	if err != nil {
+		return data
+	}
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		return data
+	}
+	return decoded
+}
+
+// wordDecoder decodes encoded words in any charset go-message knows, not
+// only the UTF-8 and ISO-8859-1 handled by mime.WordDecoder itself.
+var wordDecoder = &mime.WordDecoder{CharsetReader: charset.Reader}
+
+// DecodeHeader decodes RFC 2047 encoded words such as =?UTF-8?B?...?= in a
+// header value. Values that fail to decode are returned unchanged.
+func DecodeHeader(s string) string {
+	if !strings.Contains(s, "=?") {
+		return s
+	}
+	decoded, err := wordDecoder.DecodeHeader(s)
+	if err != nil {
+		return s
+	}
+	return decoded
+}
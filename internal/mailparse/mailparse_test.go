@@ -0,0 +1,218 @@
+package mailparse
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const mixedWithPDF = "Content-Type: multipart/mixed; boundary=\"outer\"\r\n\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=\"inner\"\r\n\r\n" +
+	"--inner\r\nContent-Type: text/plain\r\n\r\nplain words\r\n" +
+	"--inner\r\nContent-Type: text/html\r\n\r\n<p>html words</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/pdf; name=\"ignored.pdf\"\r\n" +
+	"Content-Disposition: attachment; filename=\"report.pdf\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n\r\n" +
+	"JVBERi0xLjQK\r\nJSBmYWtl\r\n" +
+	"--outer--\r\n"
+
+func TestAttachments(t *testing.T) {
+	t.Run("base64 attachment in nested multipart", func(t *testing.T) {
+		attachments := Attachments([]byte(mixedWithPDF))
+		require.Len(t, attachments, 1)
+		assert.Equal(t, 2, attachments[0].Index, "index counts leaf parts depth-first")
+		assert.Equal(t, "report.pdf", attachments[0].Filename)
+		assert.Equal(t, "application/pdf", attachments[0].ContentType)
+		assert.Equal(t, "%PDF-1.4\n% fake", string(attachments[0].Content))
+	})
+
+	t.Run("filename from content-type name", func(t *testing.T) {
+		raw := "Content-Type: multipart/mixed; boundary=\"b\"\r\n\r\n--b\r\nContent-Type: text/plain\r\n\r\nhi\r\n--b\r\nContent-Type: image/png; name=\"pic.png\"\r\n\r\nPNGDATA\r\n--b--\r\n"
+		attachments := Attachments([]byte(raw))
+		require.Len(t, attachments, 1)
+		assert.Equal(t, "pic.png", attachments[0].Filename)
+		assert.Equal(t, 1, attachments[0].Index)
+	})
+
+	t.Run("encoded filenames", func(t *testing.T) {
+		raw := "Content-Type: multipart/mixed; boundary=\"b\"\r\n\r\n" +
+			"--b\r\nContent-Type: application/octet-stream\r\nContent-Disposition: attachment; filename=\"=?UTF-8?B?cmVzdW3DqS5kb2M=?=\"\r\n\r\nx\r\n" +
+			"--b\r\nContent-Type: application/octet-stream\r\nContent-Disposition: attachment; filename*=UTF-8''na%C3%AFve.txt\r\n\r\ny\r\n" +
+			"--b--\r\n"
+		attachments := Attachments([]byte(raw))
+		require.Len(t, attachments, 2)
+		assert.Equal(t, "resumé.doc", attachments[0].Filename)
+		assert.Equal(t, "naïve.txt", attachments[1].Filename)
+	})
+
+	t.Run("text attachment", func(t *testing.T) {
+		raw := "Content-Type: multipart/mixed; boundary=\"b\"\r\n\r\n--b\r\nContent-Type: text/plain\r\n\r\nbody\r\n--b\r\nContent-Type: text/csv\r\nContent-Disposition: attachment; filename=\"data.csv\"\r\n\r\na,b\r\n--b--\r\n"
+		attachments := Attachments([]byte(raw))
+		require.Len(t, attachments, 1)
+		assert.Equal(t, "data.csv", attachments[0].Filename)
+		assert.Equal(t, "a,b", string(attachments[0].Content))
+	})
+
+	t.Run("plain message has none", func(t *testing.T) {
+		assert.Empty(t, Attachments([]byte("Subject: hi\r\n\r\nJust text.")))
+		assert.Empty(t, Attachments(nil))
+	})
+}
+
+func TestParse(t *testing.T) {
+	t.Run("alternative inside mixed", func(t *testing.T) {
+		msg, err := Parse([]byte(mixedWithPDF))
+		require.NoError(t, err)
+		assert.Equal(t, "plain words", msg.Text)
+		assert.Equal(t, "<p>html words</p>", msg.HTML)
+		require.Len(t, msg.Attachments, 1)
+		assert.Equal(t, 2, msg.Attachments[0].Index)
+		assert.Equal(t, "report.pdf", msg.Attachments[0].Filename)
+		assert.Equal(t, "%PDF-1.4\n% fake", string(msg.Attachments[0].Content))
+	})
+
+	t.Run("first body parts win", func(t *testing.T) {
+		raw := "Content-Type: multipart/mixed; boundary=\"b\"\r\n\r\n" +
+			"--b\r\nContent-Type: text/plain\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nfirst=20body\r\n" +
+			"--b\r\nContent-Type: text/plain\r\nContent-Disposition: attachment; filename=\"notes.txt\"\r\n\r\nattached text\r\n" +
+			"--b\r\nContent-Type: text/plain\r\n\r\nsecond body\r\n" +
+			"--b--\r\n"
+		msg, err := Parse([]byte(raw))
+		require.NoError(t, err)
+		assert.Equal(t, "first body", msg.Text)
+		assert.Empty(t, msg.HTML)
+		require.Len(t, msg.Attachments, 1)
+		assert.Equal(t, "notes.txt", msg.Attachments[0].Filename)
+	})
+
+	t.Run("single part html", func(t *testing.T) {
+		msg, err := Parse([]byte("Content-Type: text/html; charset=utf-8\r\nContent-Transfer-Encoding: base64\r\n\r\nPGI+aGk8L2I+\r\n"))
+		require.NoError(t, err)
+		assert.Empty(t, msg.Text)
+		assert.Equal(t, "<b>hi</b>", msg.HTML)
+	})
+
+	t.Run("charsets", func(t *testing.T) {
+		latin1 := "Content-Type: multipart/alternative; boundary=\"b\"\r\n\r\n" +
+			"--b\r\nContent-Type: text/plain; charset=ISO-8859-1\r\n\r\nCaf\xe9 cr\xe8me br\xfbl\xe9e\r\n" +
+			"--b\r\nContent-Type: text/html; charset=\"iso-8859-1\"\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n<p>Caf=E9</p>\r\n" +
+			"--b--\r\n"
+		msg, err := Parse([]byte(latin1))
+		require.NoError(t, err)
+		assert.Equal(t, "Café crème brûlée", msg.Text)
+		assert.Equal(t, "<p>Café</p>", msg.HTML)
+		assert.Contains(t, Text([]byte(latin1)), "crème", "indexed text is converted too")
+
+		msg, err = Parse([]byte("Content-Type: text/plain; charset=Shift_JIS\r\n\r\n\x82\xb1\x82\xf1\x82\xc9\x82\xbf\x82\xcd"))
+		require.NoError(t, err)
+		assert.Equal(t, "こんにちは", msg.Text)
+
+		msg, err = Parse([]byte("Content-Type: text/plain; charset=x-klingon\r\n\r\nkept as is"))
+		require.NoError(t, err)
+		assert.Equal(t, "kept as is", msg.Text, "unknown charsets are read as UTF-8")
+
+		attachment := "Content-Type: multipart/mixed; boundary=\"b\"\r\n\r\n" +
+			"--b\r\nContent-Type: text/plain; charset=ISO-8859-1\r\nContent-Disposition: attachment; filename=\"menu.txt\"\r\n\r\nCaf\xe9\r\n" +
+			"--b--\r\n"
+		msg, err = Parse([]byte(attachment))
+		require.NoError(t, err)
+		require.Len(t, msg.Attachments, 1)
+		assert.Equal(t, "Caf\xe9", string(msg.Attachments[0].Content), "attached files keep their bytes")
+	})
+
+	t.Run("no content type", func(t *testing.T) {
+		msg, err := Parse([]byte("Subject: x\r\n\r\nJust text."))
+		require.NoError(t, err)
+		assert.Equal(t, "Just text.", msg.Text)
+		assert.Empty(t, msg.Attachments)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		msg, err := Parse(nil)
+		require.NoError(t, err)
+		assert.Equal(t, &ParsedMessage{}, msg)
+	})
+
+	t.Run("unparsable", func(t *testing.T) {
+		_, err := Parse([]byte("not a header line"))
+		assert.Error(t, err)
+	})
+}
+
+func TestText(t *testing.T) {
+	t.Run("multipart alternative", func(t *testing.T) {
+		raw := []byte("Content-Type: multipart/alternative; boundary=\"b\"\r\n\r\n--b\r\nContent-Type: text/plain\r\n\r\nplain words\r\n--b\r\nContent-Type: text/html\r\n\r\n<p>html&amp;words</p>\r\n--b--\r\n")
+		text := Text(raw)
+		assert.Contains(t, text, "plain words")
+		assert.Contains(t, text, "html&words")
+		assert.NotContains(t, text, "<p>")
+	})
+
+	t.Run("quoted printable", func(t *testing.T) {
+		raw := []byte("Content-Type: text/plain\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nsoft=\r\nbreak =3D equals")
+		assert.Equal(t, "softbreak = equals", Text(raw))
+	})
+
+	t.Run("quoted printable part", func(t *testing.T) {
+		raw := []byte("Content-Type: multipart/mixed; boundary=\"b\"\r\n\r\n--b\r\nContent-Type: text/plain\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\ncaf=C3=A9\r\n--b--\r\n")
+		assert.Equal(t, "café", Text(raw))
+	})
+
+	t.Run("attachments are skipped", func(t *testing.T) {
+		text := Text([]byte(mixedWithPDF))
+		assert.Contains(t, text, "plain words")
+		assert.NotContains(t, text, "PDF")
+	})
+
+	t.Run("unparsable message is returned as-is", func(t *testing.T) {
+		assert.Equal(t, "no headers here", Text([]byte("no headers here")))
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		assert.Empty(t, Text(nil))
+	})
+}
+
+func TestDecodeTransfer(t *testing.T) {
+	assert.Equal(t, "Hello World", string(DecodeTransfer([]byte("Hello=20World"), "quoted-printable")))
+	assert.Equal(t, "Hello, World!", string(DecodeTransfer([]byte("SGVsbG8s\r\nIFdvcmxkIQ=="), "BASE64")))
+	assert.Equal(t, "not!!!base64", string(DecodeTransfer([]byte("not!!!base64"), "base64")))
+	assert.Equal(t, "plain", string(DecodeTransfer([]byte("plain"), "7bit")))
+	assert.Equal(t, "plain", string(DecodeTransfer([]byte("plain"), "")))
+}
+
+func TestReferences(t *testing.T) {
+	t.Run("references then in-reply-to", func(t *testing.T) {
+		raw := []byte("References: <root@example.com>\r\n <parent@example.com>\r\nIn-Reply-To: <parent@example.com> (Alice's message)\r\n\r\n")
+		assert.Equal(t, []string{"<root@example.com>", "<parent@example.com>"}, References(raw))
+	})
+
+	t.Run("in-reply-to only", func(t *testing.T) {
+		raw := []byte("In-Reply-To: <parent@example.com>\r\nSubject: Re: hi\r\n\r\nbody")
+		assert.Equal(t, []string{"<parent@example.com>"}, References(raw))
+	})
+
+	t.Run("no references", func(t *testing.T) {
+		assert.Empty(t, References([]byte("Subject: hi\r\n\r\nbody")))
+		assert.Empty(t, References(nil))
+	})
+}
+
+func TestHeaderBlock(t *testing.T) {
+	assert.Equal(t, "Subject: hi\r\nFrom: a@example.com\r\n\r\n",
+		string(HeaderBlock([]byte("Subject: hi\r\nFrom: a@example.com\r\n\r\nbody\r\n\r\nmore"))))
+	assert.Equal(t, "Subject: hi\n\n", string(HeaderBlock([]byte("Subject: hi\n\nbody"))))
+	assert.Equal(t, "Subject: hi\r\n", string(HeaderBlock([]byte("Subject: hi\r\n"))))
+	assert.Empty(t, HeaderBlock(nil))
+}
+
+func TestDecodeHeader(t *testing.T) {
+	assert.Equal(t, "Grüße", DecodeHeader("=?UTF-8?Q?Gr=C3=BC=C3=9Fe?="))
+	assert.Equal(t, "Привет, мир", DecodeHeader("=?KOI8-R?B?8NLJ18XULCDNydI=?="))
+	assert.Equal(t, "plain subject", DecodeHeader("plain subject"))
+	assert.Equal(t, "=?UTF-8?B?not base64!?=", DecodeHeader("=?UTF-8?B?not base64!?="))
+}
@@ -0,0 +1,66 @@
+// Package metrics defines the Prometheus metrics exported by imapsync. The
+// collectors are always updated; they are only exposed when a metrics
+// endpoint is enabled.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// MessagesSynced counts messages saved to storage, by mailbox.
+	MessagesSynced = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "imapsync_messages_synced_total",
+		Help: "Messages saved to storage.",
+	}, []string{"mailbox"})
+
+	// BytesFetched counts message bytes downloaded from the IMAP server.
+	BytesFetched = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "imapsync_bytes_fetched_total",
+		Help: "Message bytes downloaded from the IMAP server.",
+	})
+
+	// SyncDuration observes how long each mailbox sync takes.
+	SyncDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "imapsync_mailbox_sync_duration_seconds",
+		Help:    "Time taken to sync a mailbox.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 14),
+	}, []string{"mailbox"})
+
+	// Reconnects counts successful reconnections to the IMAP server.
+	Reconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "imapsync_imap_reconnects_total",
+		Help: "Successful reconnections to the IMAP server.",
+	})
+
+	// HTTPRequestDuration observes web server latency by route template, so
+	// mailbox names and UIDs don't create a series each.
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "imapsync_http_request_duration_seconds",
+		Help:    "Latency of web server requests.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "code"})
+)
+
+var registry = prometheus.NewRegistry()
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		MessagesSynced,
+		BytesFetched,
+		SyncDuration,
+		Reconnects,
+		HTTPRequestDuration,
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
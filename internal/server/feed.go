@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/newsamples/imapsync/internal/storage"
+)
+
+// atomFeed is the subset of RFC 4287 needed to list a mailbox in a feed
+// reader.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated time.Time   `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Updated   time.Time  `xml:"updated"`
+	Published time.Time  `xml:"published"`
+	Author    atomAuthor `xml:"author"`
+	Link      atomLink   `xml:"link"`
+}
+
+type atomAuthor struct {
+	Name  string `xml:"name"`
+	Email string `xml:"email,omitempty"`
+}
+
+// mailboxFeed renders the most recent emails of a mailbox as an Atom feed.
+// Each entry links to the JSON email endpoint; ?limit= caps the entries like
+// it caps a page of the email list.
+func (s *Server) mailboxFeed(w http.ResponseWriter, r *http.Request) {
+	mailbox := mux.Vars(r)["name"]
+	_, limit, _ := parsePagination(r)
+
+	emails, err := s.storage.ListEmails(mailbox, storage.SortByDate, storage.SortDesc, limit, 0)
+	if err != nil {
+		s.log.WithError(err).Error("Failed to list emails")
+		http.Error(w, "Failed to list emails", http.StatusInternalServerError)
+		return
+	}
+
+	base := requestBaseURL(r) + "/api/v1/mailboxes/" + url.PathEscape(mailbox)
+	feed := atomFeed{
+		ID:      base + "/feed.atom",
+		Title:   mailbox,
+		Updated: time.Now().UTC(),
+		Link:    atomLink{Href: base + "/feed.atom", Rel: "self", Type: "application/atom+xml"},
+		Entries: make([]atomEntry, 0, len(emails)),
+	}
+	if len(emails) > 0 {
+		feed.Updated = emails[0].Date.UTC()
+	}
+
+	for _, email := range emails {
+		link := fmt.Sprintf("%s/emails/%d", base, email.UID)
+		title := email.Subject
+		if title == "" {
+			title = "(No Subject)"
+		}
+		author := atomAuthor{Name: email.FromName, Email: email.From}
+		if author.Name == "" {
+			author.Name = email.From
+		}
+		if author.Name == "" {
+			author.Name = "Unknown sender"
+		}
+
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:        link,
+			Title:     title,
+			Updated:   email.Date.UTC(),
+			Published: email.Date.UTC(),
+			Author:    author,
+			Link:      atomLink{Href: link, Type: "application/json"},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	if err := enc.Encode(feed); err != nil {
+		s.log.WithError(err).Error("Failed to write feed")
+	}
+}
+
+// requestBaseURL returns the scheme and host the request was made to.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
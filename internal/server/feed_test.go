@@ -0,0 +1,61 @@
+package server
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/newsamples/imapsync/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMailboxFeed(t *testing.T) {
+	server, store := setupTestServer(t)
+	defer store.Close()
+
+	for uid := uint32(1); uid <= 3; uid++ {
+		require.NoError(t, store.SaveEmail(&storage.Email{
+			UID:      uid,
+			Mailbox:  "Archive/2024",
+			Subject:  []string{"", "Quarterly report", "Lunch & learn"}[uid-1],
+			From:     "alice@example.com",
+			FromName: []string{"", "Alice", "Alice"}[uid-1],
+			Date:     time.Date(2024, 1, int(uid), 9, 0, 0, 0, time.UTC),
+		}))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://mail.example.com/api/v1/mailboxes/Archive%2F2024/feed.atom?limit=2", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/atom+xml; charset=utf-8", w.Header().Get("Content-Type"))
+
+	var feed atomFeed
+	require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &feed), "feed is well-formed XML")
+	assert.Equal(t, "Archive/2024", feed.Title)
+	assert.True(t, feed.Updated.Equal(time.Date(2024, 1, 3, 9, 0, 0, 0, time.UTC)))
+
+	// Newest first, capped by ?limit=.
+	require.Len(t, feed.Entries, 2)
+	entry := feed.Entries[0]
+	assert.Equal(t, "Lunch & learn", entry.Title)
+	assert.Equal(t, atomAuthor{Name: "Alice", Email: "alice@example.com"}, entry.Author)
+	assert.True(t, entry.Published.Equal(time.Date(2024, 1, 3, 9, 0, 0, 0, time.UTC)))
+	assert.Equal(t, "http://mail.example.com/api/v1/mailboxes/Archive%2F2024/emails/3", entry.Link.Href)
+	assert.Equal(t, "Quarterly report", feed.Entries[1].Title)
+
+	t.Run("empty mailbox", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/Empty/feed.atom", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		var feed atomFeed
+		require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &feed))
+		assert.Empty(t, feed.Entries)
+	})
+}
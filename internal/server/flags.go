@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/gorilla/mux"
+)
+
+// FlagClient changes flags on the IMAP server the backup was taken from.
+// imap.Client implements it.
+type FlagClient interface {
+	UIDValidity(ctx context.Context, mailbox string) (uint32, error)
+	StoreFlags(ctx context.Context, mailbox string, uid uint32, add, remove []imap.Flag) error
+}
+
+// WithFlagClient lets the API change flags on the live server through client.
+// Without it, flag changes are refused. The storage must be writable, since
+// successful changes are also stored.
+func WithFlagClient(client FlagClient) Option {
+	return func(s *Server) {
+		s.flagClient = client
+	}
+}
+
+// flagChange is the body of a flag update.
+type flagChange struct {
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
+}
+
+// updateFlags adds and removes flags of an email on the IMAP server, then
+// stores its new flags. UIDs are only meaningful while the mailbox keeps the
+// UIDVALIDITY it was synced with, so a changed one is refused.
+func (s *Server) updateFlags(w http.ResponseWriter, r *http.Request) {
+	if s.flagClient == nil {
+		http.Error(w, "Changing flags on the server is disabled", http.StatusForbidden)
+		return
+	}
+	if !s.authorized(w, r) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	mailbox := vars["name"]
+
+	uid, err := strconv.ParseUint(vars["uid"], 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid UID", http.StatusBadRequest)
+		return
+	}
+
+	if !requireJSON(w, r) {
+		return
+	}
+
+	var change flagChange
+	if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(change.Add) == 0 && len(change.Remove) == 0 {
+		http.Error(w, "No flags to add or remove", http.StatusBadRequest)
+		return
+	}
+	for _, flag := range slices.Concat(change.Add, change.Remove) {
+		if !validFlag(flag) {
+			http.Error(w, "Invalid flag "+strconv.Quote(flag), http.StatusBadRequest)
+			return
+		}
+	}
+
+	email, err := s.storage.GetEmail(mailbox, uint32(uid))
+	if err != nil {
+		s.log.WithError(err).Error("Failed to get email")
+		http.Error(w, "Failed to get email", http.StatusInternalServerError)
+		return
+	}
+	if email == nil {
+		http.Error(w, "Email not found", http.StatusNotFound)
+		return
+	}
+
+	state, err := s.storage.GetMailboxState(mailbox)
+	if err != nil {
+		s.log.WithError(err).Error("Failed to get mailbox state")
+		http.Error(w, "Failed to get mailbox state", http.StatusInternalServerError)
+		return
+	}
+	validity, err := s.flagClient.UIDValidity(r.Context(), mailbox)
+	if err != nil {
+		s.log.WithError(err).Error("Failed to get mailbox status from the IMAP server")
+		http.Error(w, "Failed to reach the IMAP server", http.StatusBadGateway)
+		return
+	}
+	if state == nil || state.UIDValidity != validity {
+		http.Error(w, "Mailbox changed on the server since the last sync", http.StatusConflict)
+		return
+	}
+
+	if err := s.flagClient.StoreFlags(r.Context(), mailbox, uint32(uid), toIMAPFlags(change.Add), toIMAPFlags(change.Remove)); err != nil {
+		s.log.WithError(err).Error("Failed to store flags on the IMAP server")
+		http.Error(w, "Failed to store flags on the IMAP server", http.StatusBadGateway)
+		return
+	}
+
+	flags := applyFlagChange(email.Flags, change)
+	if err := s.storage.UpdateFlags(mailbox, uint32(uid), flags); err != nil {
+		s.log.WithError(err).Error("Failed to update stored flags")
+		http.Error(w, "Flags changed on the server but not in the backup", http.StatusInternalServerError)
+		return
+	}
+
+	s.writeJSON(w, map[string]interface{}{
+		"uid":   uid,
+		"flags": flags,
+	})
+}
+
+// requireJSON refuses requests that aren't sent as JSON. Forms can't send
+// JSON, so another site can't post to the API from the user's browser
+// without a CORS preflight.
+func requireJSON(w http.ResponseWriter, r *http.Request) bool {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return false
+	}
+	return true
+}
+
+// authorized refuses requests without the configured API token. Requests
+// are let through when no token is configured.
+func (s *Server) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if s.apiToken == "" {
+		return true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.apiToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Missing or invalid API token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// validFlag reports whether flag can be sent as an IMAP flag: a keyword
+// atom, optionally prefixed with a backslash. \Recent is set by the server
+// only.
+func validFlag(flag string) bool {
+	keyword := strings.TrimPrefix(flag, `\`)
+	if keyword == "" || strings.EqualFold(flag, `\Recent`) {
+		return false
+	}
+	return !strings.ContainsFunc(keyword, func(r rune) bool {
+		return r <= ' ' || r >= 0x7f || strings.ContainsRune(`(){%*"\]`, r)
+	})
+}
+
+func toIMAPFlags(flags []string) []imap.Flag {
+	out := make([]imap.Flag, len(flags))
+	for i, flag := range flags {
+		out[i] = imap.Flag(flag)
+	}
+	return out
+}
+
+// applyFlagChange returns flags with the change applied. Flags compare
+// case-insensitively, as in IMAP.
+func applyFlagChange(flags []string, change flagChange) []string {
+	result := make([]string, 0, len(flags)+len(change.Add))
+	for _, flag := range flags {
+		if !containsFold(change.Remove, flag) && !containsFold(result, flag) {
+			result = append(result, flag)
+		}
+	}
+	for _, flag := range change.Add {
+		if !containsFold(change.Remove, flag) && !containsFold(result, flag) {
+			result = append(result, flag)
+		}
+	}
+	return result
+}
+
+func containsFold(list []string, s string) bool {
+	return slices.ContainsFunc(list, func(v string) bool { return strings.EqualFold(v, s) })
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/newsamples/imapsync/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeFlagClient records flag changes instead of sending them to a server.
+type fakeFlagClient struct {
+	uidValidity uint32
+	err         error
+	stored      []string
+}
+
+func (f *fakeFlagClient) UIDValidity(_ context.Context, _ string) (uint32, error) {
+	return f.uidValidity, nil
+}
+
+func (f *fakeFlagClient) StoreFlags(_ context.Context, mailbox string, uid uint32, add, remove []imap.Flag) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.stored = append(f.stored, fmt.Sprintf("%s %d +%s -%s", mailbox, uid, joinFlags(add), joinFlags(remove)))
+	return nil
+}
+
+func joinFlags(flags []imap.Flag) string {
+	s := make([]string, len(flags))
+	for i, f := range flags {
+		s[i] = string(f)
+	}
+	return strings.Join(s, ",")
+}
+
+func TestUpdateFlags(t *testing.T) {
+	client := &fakeFlagClient{uidValidity: 7}
+	server, store := setupTestServer(t, WithFlagClient(client))
+	defer store.Close()
+
+	require.NoError(t, store.SaveMailboxState(&storage.MailboxState{Name: "INBOX", UIDValidity: 7, LastUID: 1, LastSync: time.Now()}))
+	require.NoError(t, store.SaveEmail(&storage.Email{UID: 1, Mailbox: "INBOX", Subject: "Hello", Flags: []string{`\Flagged`}}))
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/mailboxes/INBOX/emails/"+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("mark seen", func(t *testing.T) {
+		w := post("1/flags", `{"add":["\\Seen"],"remove":["\\flagged"]}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var response map[string]interface{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, []interface{}{`\Seen`}, response["flags"])
+		assert.Equal(t, []string{`INBOX 1 +\Seen -\flagged`}, client.stored)
+
+		email, err := store.GetEmail("INBOX", 1)
+		require.NoError(t, err)
+		assert.Equal(t, []string{`\Seen`}, email.Flags, "the backup is updated")
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		for body, code := range map[string]int{
+			`{"add":[]}`:             http.StatusBadRequest,
+			`not json`:               http.StatusBadRequest,
+			`{"add":["\\Recent"]}`:   http.StatusBadRequest,
+			`{"add":["two words"]}`:  http.StatusBadRequest,
+			`{"remove":["(paren"]}`:  http.StatusBadRequest,
+			`{"add":["$Important"]}`: http.StatusOK,
+		} {
+			assert.Equal(t, code, post("1/flags", body).Code, body)
+		}
+		assert.Equal(t, http.StatusNotFound, post("2/flags", `{"add":["\\Seen"]}`).Code)
+		assert.Equal(t, http.StatusBadRequest, post("x/flags", `{"add":["\\Seen"]}`).Code)
+
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/mailboxes/INBOX/emails/1/flags", strings.NewReader(`{"add":["\\Seen"]}`))
+		req.Header.Set("Content-Type", "text/plain")
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnsupportedMediaType, w.Code, "form posts are refused")
+	})
+
+	t.Run("uidvalidity changed", func(t *testing.T) {
+		client.uidValidity = 8
+		defer func() { client.uidValidity = 7 }()
+
+		before := len(client.stored)
+		assert.Equal(t, http.StatusConflict, post("1/flags", `{"add":["\\Seen"]}`).Code)
+		assert.Len(t, client.stored, before, "nothing is sent to the server")
+	})
+
+	t.Run("server error", func(t *testing.T) {
+		client.err = errors.New("connection reset")
+		defer func() { client.err = nil }()
+
+		assert.Equal(t, http.StatusBadGateway, post("1/flags", `{"remove":["\\Seen"]}`).Code)
+		email, err := store.GetEmail("INBOX", 1)
+		require.NoError(t, err)
+		assert.Contains(t, email.Flags, `\Seen`, "the backup is left as is")
+	})
+}
+
+func TestUpdateFlags_Disabled(t *testing.T) {
+	server, store := setupTestServer(t)
+	defer store.Close()
+
+	require.NoError(t, store.SaveEmail(&storage.Email{UID: 1, Mailbox: "INBOX"}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/mailboxes/INBOX/emails/1/flags", strings.NewReader(`{"add":["\\Seen"]}`))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Contains(t, w.Body.String(), `data-live-flags="false"`)
+}
+
+func TestUpdateFlags_APIToken(t *testing.T) {
+	client := &fakeFlagClient{uidValidity: 7}
+	server, store := setupTestServer(t, WithFlagClient(client), WithAPIToken("s3cret"))
+	defer store.Close()
+
+	require.NoError(t, store.SaveMailboxState(&storage.MailboxState{Name: "INBOX", UIDValidity: 7, LastUID: 1, LastSync: time.Now()}))
+	require.NoError(t, store.SaveEmail(&storage.Email{UID: 1, Mailbox: "INBOX"}))
+
+	post := func(authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/mailboxes/INBOX/emails/1/flags", strings.NewReader(`{"add":["\\Seen"]}`))
+		req.Header.Set("Content-Type", "application/json")
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, authorization := range []string{"", "Bearer wrong", "s3cret", "Basic s3cret"} {
+		w := post(authorization)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, authorization)
+		assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+	}
+	assert.Empty(t, client.stored, "nothing is sent to the server")
+
+	assert.Equal(t, http.StatusOK, post("Bearer s3cret").Code)
+	assert.Len(t, client.stored, 1)
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/newsamples/imapsync/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGzipMiddleware(t *testing.T) {
+	server, store := setupTestServer(t)
+	defer store.Close()
+
+	raw := []byte("Subject: Files\r\n" +
+		"Content-Type: multipart/mixed; boundary=\"b\"\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nSee attached.\r\n" +
+		"--b\r\nContent-Type: text/html\r\n" +
+		"Content-Disposition: attachment; filename=\"page.html\"\r\n\r\n" +
+		"<p>attached page</p>\r\n" +
+		"--b--\r\n")
+	for uid := uint32(1); uid <= 20; uid++ {
+		require.NoError(t, store.SaveEmail(&storage.Email{
+			UID:        uid,
+			Mailbox:    "INBOX",
+			Subject:    fmt.Sprintf("Message %d", uid),
+			From:       "sender@example.com",
+			Date:       time.Now(),
+			RawMessage: raw,
+		}))
+	}
+
+	get := func(path string, gzipped bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if gzipped {
+			req.Header.Set("Accept-Encoding", "gzip, deflate")
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("json compressed", func(t *testing.T) {
+		w := get("/api/v1/mailboxes/INBOX/emails", true)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+		assert.Empty(t, w.Header().Get("Content-Length"))
+		assert.Contains(t, w.Header().Values("Vary"), "Accept-Encoding")
+
+		zr, err := gzip.NewReader(w.Body)
+		require.NoError(t, err)
+		var response map[string]interface{}
+		require.NoError(t, json.NewDecoder(zr).Decode(&response))
+		assert.Equal(t, float64(20), response["total"])
+	})
+
+	t.Run("ui compressed", func(t *testing.T) {
+		w := get("/", true)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+
+		zr, err := gzip.NewReader(w.Body)
+		require.NoError(t, err)
+		body, err := io.ReadAll(zr)
+		require.NoError(t, err)
+		assert.Contains(t, string(body), "<title>Email Browser</title>")
+	})
+
+	t.Run("not requested", func(t *testing.T) {
+		w := get("/api/v1/mailboxes/INBOX/emails", false)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+
+		var response map[string]interface{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	})
+
+	t.Run("download not compressed", func(t *testing.T) {
+		w := get("/api/v1/mailboxes/INBOX/emails/1/download", true)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, raw, w.Body.Bytes())
+	})
+
+	t.Run("attachment not compressed", func(t *testing.T) {
+		w := get("/api/v1/mailboxes/INBOX/emails/1/attachments/1", true)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "text/html", w.Header().Get("Content-Type"))
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "<p>attached page</p>", w.Body.String())
+	})
+
+	t.Run("errors not compressed", func(t *testing.T) {
+		w := get("/api/v1/mailboxes/INBOX/emails/999", true)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+	})
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip;q=1.0, *;q=0.5", true},
+		{"GZIP", true},
+		{"gzip;q=0", false},
+		{"deflate, br", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Accept-Encoding", tt.header)
+			}
+			assert.Equal(t, tt.expected, acceptsGzip(req))
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package server
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// allowedTags are the elements kept by sanitizeHTML. Other elements are
+// dropped but their text content is kept.
+var allowedTags = map[string]bool{
+	"a": true, "abbr": true, "address": true, "b": true, "big": true,
+	"blockquote": true, "body": true, "br": true, "caption": true, "center": true,
+	"cite": true, "code": true, "col": true, "colgroup": true, "dd": true,
+	"del": true, "div": true, "dl": true, "dt": true, "em": true,
+	"font": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "head": true, "hr": true, "html": true,
+	"i": true, "img": true, "ins": true, "kbd": true, "li": true,
+	"link": true, "ol": true, "p": true, "pre": true, "q": true,
+	"s": true, "small": true, "span": true, "strike": true, "strong": true,
+	"style": true, "sub": true, "sup": true, "table": true, "tbody": true,
+	"td": true, "tfoot": true, "th": true, "thead": true, "title": true,
+	"tr": true, "tt": true, "u": true, "ul": true,
+}
+
+// droppedTags are removed together with everything up to their end tag.
+var droppedTags = map[string]bool{
+	"applet": true, "embed": true, "frame": true, "frameset": true,
+	"iframe": true, "math": true, "noscript": true, "object": true,
+	"script": true, "svg": true, "template": true,
+}
+
+var voidTags = map[string]bool{
+	"br": true, "col": true, "hr": true, "img": true, "link": true,
+}
+
+// selfClosingTags are the dropped elements a trailing slash closes, as
+// browsers honor it only on void and foreign elements. On the others, such
+// as <script/>, it is ignored and their content follows.
+var selfClosingTags = map[string]bool{
+	"embed": true, "frame": true, "math": true, "svg": true,
+}
+
+// allowedAttrs are the attributes kept on any allowed element. Event
+// handlers are never listed, so on* attributes are always removed.
+var allowedAttrs = map[string]bool{
+	"align": true, "alt": true, "bgcolor": true, "border": true,
+	"cellpadding": true, "cellspacing": true, "class": true, "color": true,
+	"colspan": true, "dir": true, "face": true, "height": true,
+	"hspace": true, "lang": true, "rowspan": true, "size": true,
+	"span": true, "start": true, "style": true, "title": true,
+	"type": true, "valign": true, "vspace": true, "width": true,
+}
+
+var (
+	cssURLRe    = regexp.MustCompile(`(?i)url\s*\(\s*['"]?([^'")]*)['"]?\s*\)`)
+	cssImportRe = regexp.MustCompile(`(?i)@import[^;]*;?`)
+	cssUnsafeRe = regexp.MustCompile(`(?i)expression\s*\(|javascript:|behavior\s*:|-moz-binding`)
+)
+
+// sanitizeHTML rewrites an HTML email body keeping only allowlisted elements
+// and attributes. Scripts, event handlers and javascript: URLs are always
+// removed; remote images, stylesheets and CSS url() references are removed
+// unless allowRemote is set, so viewing an email can't notify the sender.
+//
+// The body is read with the HTML5 tokenizer, so tags, attributes and raw
+// text end where a browser would end them, and the output is re-serialized
+// from the tokens with all text and attribute values escaped.
+func sanitizeHTML(s string, allowRemote bool) string {
+	var b strings.Builder
+	b.Grow(len(s))
+
+	z := html.NewTokenizer(strings.NewReader(s))
+	var skipping string // the dropped element being skipped
+	skipDepth := 0
+	inStyle := false
+
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return b.String()
+		}
+
+		if skipping != "" {
+			switch name, _ := z.TagName(); {
+			case tt == html.StartTagToken && string(name) == skipping:
+				skipDepth++
+			case tt == html.EndTagToken && string(name) == skipping:
+				if skipDepth--; skipDepth == 0 {
+					skipping = ""
+				}
+			}
+			continue
+		}
+
+		switch tt {
+		case html.TextToken:
+			if inStyle {
+				// Raw text, ended by the tokenizer at </style.
+				b.WriteString(sanitizeCSS(string(z.Text()), allowRemote))
+			} else {
+				b.WriteString(html.EscapeString(string(z.Text())))
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := readTag(z, tt == html.SelfClosingTagToken)
+			if droppedTags[t.name] {
+				if !t.selfClosing || !selfClosingTags[t.name] {
+					skipping, skipDepth = t.name, 1
+				}
+				continue
+			}
+			if !allowedTags[t.name] {
+				continue
+			}
+
+			attrs, keep := sanitizeAttrs(t, allowRemote)
+			if !keep {
+				continue
+			}
+
+			b.WriteString("<" + t.name)
+			for _, a := range attrs {
+				b.WriteString(" " + a.name + `="` + html.EscapeString(a.value) + `"`)
+			}
+			b.WriteString(">")
+			inStyle = t.name == "style"
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			if t := string(name); allowedTags[t] && !voidTags[t] {
+				b.WriteString("</" + t + ">")
+			}
+			inStyle = false
+		}
+		// Comments and doctypes are dropped.
+	}
+}
+
+type htmlAttr struct {
+	name  string
+	value string
+}
+
+type htmlTag struct {
+	name        string
+	selfClosing bool
+	attrs       []htmlAttr
+}
+
+// readTag reads the current start tag of z. Of repeated attributes only the
+// first is kept, as browsers do.
+func readTag(z *html.Tokenizer, selfClosing bool) htmlTag {
+	name, hasAttr := z.TagName()
+	t := htmlTag{name: string(name), selfClosing: selfClosing}
+	seen := make(map[string]bool)
+	for hasAttr {
+		var key, val []byte
+		key, val, hasAttr = z.TagAttr()
+		if seen[string(key)] {
+			continue
+		}
+		seen[string(key)] = true
+		t.attrs = append(t.attrs, htmlAttr{name: string(key), value: string(val)})
+	}
+	return t
+}
+
+// sanitizeAttrs returns the attributes to keep on an allowed element, or
+// keep=false if the element itself must be removed.
+func sanitizeAttrs(t htmlTag, allowRemote bool) (attrs []htmlAttr, keep bool) {
+	var href, src, rel string
+	for _, a := range t.attrs {
+		switch a.name {
+		case "href":
+			href = a.value
+		case "src":
+			src = a.value
+		case "rel":
+			rel = a.value
+		}
+	}
+
+	switch t.name {
+	case "img":
+		if !isSafeImageURL(src, allowRemote) {
+			return nil, false
+		}
+	case "link":
+		if !allowRemote || !strings.EqualFold(strings.TrimSpace(rel), "stylesheet") || !isRemoteURL(href) {
+			return nil, false
+		}
+		return []htmlAttr{{"rel", "stylesheet"}, {"href", href}}, true
+	}
+
+	for _, a := range t.attrs {
+		switch {
+		case a.name == "style":
+			if css := sanitizeCSS(a.value, allowRemote); css != "" {
+				attrs = append(attrs, htmlAttr{a.name, css})
+			}
+		case allowedAttrs[a.name]:
+			attrs = append(attrs, a)
+		case t.name == "img" && a.name == "src":
+			attrs = append(attrs, a)
+		case t.name == "a" && a.name == "name":
+			attrs = append(attrs, a)
+		}
+	}
+
+	// Links open outside the viewer frame.
+	if t.name == "a" && isSafeLinkURL(href) {
+		attrs = append(attrs,
+			htmlAttr{"href", href},
+			htmlAttr{"target", "_blank"},
+			htmlAttr{"rel", "noopener noreferrer"},
+		)
+	}
+
+	return attrs, true
+}
+
+// sanitizeCSS removes script-capable constructs from a stylesheet or style
+// attribute, and remote references unless allowRemote is set. CSS escapes can
+// hide any of those, so styles containing backslashes are dropped entirely.
+func sanitizeCSS(css string, allowRemote bool) string {
+	if strings.Contains(css, `\`) || cssUnsafeRe.MatchString(css) {
+		return ""
+	}
+	if allowRemote {
+		return css
+	}
+
+	css = cssImportRe.ReplaceAllString(css, "")
+	return cssURLRe.ReplaceAllStringFunc(css, func(m string) string {
+		url := cssURLRe.FindStringSubmatch(m)[1]
+		if isInlineImageURL(url) {
+			return m
+		}
+		return "none"
+	})
+}
+
+func isSafeLinkURL(u string) bool {
+	switch urlScheme(u) {
+	case "", "http", "https", "mailto":
+		return u != ""
+	default:
+		return false
+	}
+}
+
+func isSafeImageURL(u string, allowRemote bool) bool {
+	if isInlineImageURL(u) {
+		return true
+	}
+	return allowRemote && isRemoteURL(u)
+}
+
+// isInlineImageURL reports whether u references image data carried in the
+// message itself.
+func isInlineImageURL(u string) bool {
+	switch urlScheme(u) {
+	case "cid":
+		return true
+	case "data":
+		return strings.HasPrefix(strings.ToLower(strings.TrimSpace(u)), "data:image/")
+	default:
+		return false
+	}
+}
+
+func isRemoteURL(u string) bool {
+	scheme := urlScheme(u)
+	return scheme == "http" || scheme == "https" || strings.HasPrefix(strings.TrimSpace(u), "//")
+}
+
+// urlScheme returns the lowercased scheme of u, ignoring the whitespace and
+// control characters browsers strip before resolving it.
+func urlScheme(u string) string {
+	u = strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, u)
+
+	i := strings.IndexAny(u, ":/?#")
+	if i <= 0 || u[i] != ':' {
+		return ""
+	}
+	return strings.ToLower(u[:i])
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		allowRemote bool
+		expected    string
+	}{
+		{
+			name:     "plain markup",
+			input:    `<p>Hello <b>world</b></p>`,
+			expected: `<p>Hello <b>world</b></p>`,
+		},
+		{
+			name:     "script removed with content",
+			input:    `<p>a</p><script>alert(1)</script><p>b</p>`,
+			expected: `<p>a</p><p>b</p>`,
+		},
+		{
+			name:     "uppercase script",
+			input:    `<SCRIPT type="text/javascript">alert(1)</SCRIPT >ok`,
+			expected: `ok`,
+		},
+		{
+			name:     "unterminated script",
+			input:    `ok<script>alert(1)`,
+			expected: `ok`,
+		},
+		{
+			name:     "event handlers removed",
+			input:    `<div onclick="alert(1)" class="x" OnMouseOver='alert(2)'>hi</div>`,
+			expected: `<div class="x">hi</div>`,
+		},
+		{
+			name:     "javascript link",
+			input:    `<a href="javascript:alert(1)">x</a>`,
+			expected: `<a>x</a>`,
+		},
+		{
+			name:     "obfuscated javascript link",
+			input:    `<a href=" java&#x09;script&#58;alert(1)">x</a>`,
+			expected: `<a>x</a>`,
+		},
+		{
+			name:     "safe link",
+			input:    `<a href="https://example.com/?a=1&amp;b=2">x</a>`,
+			expected: `<a href="https://example.com/?a=1&amp;b=2" target="_blank" rel="noopener noreferrer">x</a>`,
+		},
+		{
+			name:     "remote image blocked",
+			input:    `<img src="https://tracker.example.com/p.gif" width="1">text`,
+			expected: `text`,
+		},
+		{
+			name:        "remote image allowed",
+			input:       `<img src="https://example.com/logo.png" alt="logo">`,
+			allowRemote: true,
+			expected:    `<img src="https://example.com/logo.png" alt="logo">`,
+		},
+		{
+			name:     "inline image kept",
+			input:    `<img src="cid:logo@example.com">`,
+			expected: `<img src="cid:logo@example.com">`,
+		},
+		{
+			name:     "image onerror",
+			input:    `<img src="cid:x" onerror="alert(1)">`,
+			expected: `<img src="cid:x">`,
+		},
+		{
+			name:     "remote stylesheet blocked",
+			input:    `<link rel="stylesheet" href="https://example.com/a.css"><p>x</p>`,
+			expected: `<p>x</p>`,
+		},
+		{
+			name:        "remote stylesheet allowed",
+			input:       `<link rel="stylesheet" href="https://example.com/a.css" onload="alert(1)">`,
+			allowRemote: true,
+			expected:    `<link rel="stylesheet" href="https://example.com/a.css">`,
+		},
+		{
+			name:     "css url blocked",
+			input:    `<div style="background: url('https://example.com/p.gif'); color: red">x</div>`,
+			expected: `<div style="background: none; color: red">x</div>`,
+		},
+		{
+			name:     "css expression removed",
+			input:    `<div style="width: expression(alert(1))">x</div>`,
+			expected: `<div>x</div>`,
+		},
+		{
+			name:     "style element import removed",
+			input:    `<style>@import url(https://example.com/a.css); p { color: red }</style>`,
+			expected: `<style> p { color: red }</style>`,
+		},
+		{
+			name:     "unknown tags dropped",
+			input:    `<form action="https://evil.example.com"><input name="p">text</form>`,
+			expected: `text`,
+		},
+		{
+			name:     "iframe and comments removed",
+			input:    `<!-- hidden --><iframe src="https://evil.example.com"></iframe>ok`,
+			expected: `ok`,
+		},
+		{
+			name:     "meta refresh removed",
+			input:    `<html><head><meta http-equiv="refresh" content="0;url=javascript:alert(1)"></head><body>x</body></html>`,
+			expected: `<html><head></head><body>x</body></html>`,
+		},
+		{
+			name:     "stray angle bracket escaped",
+			input:    `1 < 2`,
+			expected: `1 &lt; 2`,
+		},
+		{
+			name:     "abruptly closed comment",
+			input:    `<!--><script>alert(1)</script>-->ok`,
+			expected: `--&gt;ok`,
+		},
+		{
+			name:     "self-closing script keeps its content out",
+			input:    `<script/>alert(1)</script>ok`,
+			expected: `ok`,
+		},
+		{
+			name:     "slash between attributes",
+			input:    `<img src="cid:x"/onerror=alert(1)>`,
+			expected: `<img src="cid:x">`,
+		},
+		{
+			name:     "first of repeated attributes wins",
+			input:    `<a href="https://example.com" href="javascript:alert(1)">x</a>`,
+			expected: `<a href="https://example.com" target="_blank" rel="noopener noreferrer">x</a>`,
+		},
+		{
+			name:     "markup in raw text stays text",
+			input:    `<textarea><img src=x onerror=alert(1)></textarea>`,
+			expected: `&lt;img src=x onerror=alert(1)&gt;`,
+		},
+		{
+			name:     "foreign content dropped",
+			input:    `<svg><style><img src=x onerror=alert(1)></style></svg>ok`,
+			expected: `ok`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, sanitizeHTML(tt.input, tt.allowRemote))
+		})
+	}
+}
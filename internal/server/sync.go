@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/newsamples/imapsync/internal/syncer"
+)
+
+// SyncFunc syncs every mailbox into the storage the server reads, passing
+// progress events to progress.
+type SyncFunc func(ctx context.Context, progress func(syncer.ProgressEvent)) (*syncer.SyncReport, error)
+
+// WithSync lets the API start a sync in the background with run, one at a
+// time. Without it, sync requests are refused. The storage must be
+// writable.
+func WithSync(run SyncFunc) Option {
+	return func(s *Server) {
+		s.syncFunc = run
+	}
+}
+
+// syncRun is the state of the last sync started through the API.
+type syncRun struct {
+	mu       sync.Mutex
+	running  bool
+	started  time.Time
+	finished time.Time
+	progress *syncer.ProgressEvent
+	report   *syncer.SyncReport
+	err      error
+}
+
+// startSync starts a sync unless one is already running.
+func (s *Server) startSync(w http.ResponseWriter, r *http.Request) {
+	if s.syncFunc == nil {
+		http.Error(w, "Syncing from the web UI is disabled", http.StatusForbidden)
+		return
+	}
+	if !s.authorized(w, r) {
+		return
+	}
+	if !requireJSON(w, r) {
+		return
+	}
+
+	run := &s.syncRun
+	run.mu.Lock()
+	if run.running {
+		run.mu.Unlock()
+		http.Error(w, "A sync is already running", http.StatusConflict)
+		return
+	}
+	run.running = true
+	run.started, run.finished = time.Now(), time.Time{}
+	run.progress, run.report, run.err = nil, nil, nil
+	s.background.Add(1)
+	run.mu.Unlock()
+
+	go s.runSync()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	s.writeJSON(w, s.syncStatusResponse())
+}
+
+func (s *Server) runSync() {
+	run := &s.syncRun
+	defer s.background.Done()
+
+	s.log.Info("Starting sync requested from the web UI")
+	report, err := s.syncFunc(s.ctx, func(ev syncer.ProgressEvent) {
+		run.mu.Lock()
+		run.progress = &ev
+		run.mu.Unlock()
+	})
+	if err != nil {
+		s.log.WithError(err).Error("Sync requested from the web UI failed")
+	} else {
+		s.log.Info("Sync requested from the web UI completed")
+	}
+
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	run.running = false
+	run.finished = time.Now()
+	run.report, run.err = report, err
+}
+
+// getSyncStatus reports whether a sync is running, how far it got, and how
+// the last one ended.
+func (s *Server) getSyncStatus(w http.ResponseWriter, _ *http.Request) {
+	if s.syncFunc == nil {
+		http.Error(w, "Syncing from the web UI is disabled", http.StatusForbidden)
+		return
+	}
+	s.writeJSON(w, s.syncStatusResponse())
+}
+
+func (s *Server) syncStatusResponse() map[string]interface{} {
+	run := &s.syncRun
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	response := map[string]interface{}{"running": run.running}
+	if !run.started.IsZero() {
+		response["started_at"] = run.started
+	}
+	if !run.finished.IsZero() {
+		response["finished_at"] = run.finished
+	}
+	if ev := run.progress; ev != nil {
+		response["progress"] = map[string]interface{}{
+			"mailbox":         ev.Mailbox,
+			"mailbox_index":   ev.MailboxIndex,
+			"mailboxes":       ev.Mailboxes,
+			"done":            ev.Done,
+			"total":           ev.Total,
+			"bytes":           ev.Bytes,
+			"overall_done":    ev.OverallDone,
+			"overall_total":   ev.OverallTotal,
+			"overall_percent": ev.OverallPercent(),
+		}
+	}
+	if run.report != nil {
+		response["report"] = run.report
+	}
+	if run.err != nil {
+		response["error"] = run.err.Error()
+	}
+	return response
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/newsamples/imapsync/internal/syncer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartSync(t *testing.T) {
+	release := make(chan struct{})
+	var runs int
+	fakeSync := func(ctx context.Context, progress func(syncer.ProgressEvent)) (*syncer.SyncReport, error) {
+		runs++
+		progress(syncer.ProgressEvent{Kind: syncer.ProgressBatch, Mailbox: "INBOX", Done: 2, Total: 4,
+			MailboxIndex: 1, Mailboxes: 2, OverallDone: 2, OverallTotal: 8})
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if runs > 1 {
+			return nil, errors.New("login failed")
+		}
+		return &syncer.SyncReport{Totals: syncer.Stats{NewMessages: 4}}, nil
+	}
+	server, store := setupTestServer(t, WithSync(fakeSync))
+	defer store.Close()
+
+	post := func(contentType string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/sync", nil)
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+	status := func() map[string]interface{} {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/sync/status", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		var response map[string]interface{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		return response
+	}
+
+	assert.Equal(t, false, status()["running"], "nothing ran yet")
+	assert.Equal(t, http.StatusUnsupportedMediaType, post("text/plain").Code, "form posts are refused")
+
+	w := post("application/json")
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"running":true`)
+	assert.Equal(t, http.StatusConflict, post("application/json").Code, "one sync at a time")
+
+	assert.Eventually(t, func() bool { return status()["progress"] != nil }, time.Second, 10*time.Millisecond)
+	progress := status()["progress"].(map[string]interface{})
+	assert.Equal(t, "INBOX", progress["mailbox"])
+	assert.Equal(t, float64(2), progress["mailboxes"])
+	assert.Equal(t, float64(25), progress["overall_percent"])
+
+	release <- struct{}{}
+	assert.Eventually(t, func() bool { return status()["running"] == false }, time.Second, 10*time.Millisecond)
+	response := status()
+	assert.NotNil(t, response["finished_at"])
+	assert.Nil(t, response["error"])
+	report := response["report"].(map[string]interface{})
+	assert.Equal(t, float64(4), report["totals"].(map[string]interface{})["new_messages"])
+
+	// A new sync replaces the outcome of the last one.
+	require.Equal(t, http.StatusAccepted, post("application/json").Code)
+	release <- struct{}{}
+	assert.Eventually(t, func() bool { return status()["running"] == false }, time.Second, 10*time.Millisecond)
+	response = status()
+	assert.Equal(t, "login failed", response["error"])
+	assert.Nil(t, response["report"])
+	assert.Equal(t, 2, runs)
+}
+
+func TestStartSync_APIToken(t *testing.T) {
+	var runs int
+	fakeSync := func(ctx context.Context, progress func(syncer.ProgressEvent)) (*syncer.SyncReport, error) {
+		runs++
+		return &syncer.SyncReport{}, nil
+	}
+	server, store := setupTestServer(t, WithSync(fakeSync), WithAPIToken("s3cret"))
+	defer store.Close()
+
+	post := func(authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/sync", nil)
+		req.Header.Set("Content-Type", "application/json")
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, http.StatusUnauthorized, post("Bearer wrong").Code)
+
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/sync/status", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "the status needs no token")
+
+	assert.Equal(t, http.StatusAccepted, post("Bearer s3cret").Code)
+	server.stopBackground()
+	assert.Equal(t, 1, runs)
+}
+
+func TestStartSync_Disabled(t *testing.T) {
+	server, store := setupTestServer(t)
+	defer store.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/sync", nil)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/sync/status", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Contains(t, w.Body.String(), `data-live-sync="false"`)
+	assert.NotContains(t, w.Body.String(), `id="sync-now"`)
+}
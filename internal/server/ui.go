@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// apiBase is the path prefix of the JSON API.
+const apiBase = "/api/v1"
+
+//go:embed ui
+var uiFiles embed.FS
+
+var indexTemplate = template.Must(template.ParseFS(uiFiles, "ui/index.html"))
+
+// uiVersion is a hash of the embedded UI, added to asset URLs so browsers
+// fetch them again after an upgrade.
+var uiVersion = func() string {
+	h := sha256.New()
+	fs.WalkDir(uiFiles, "ui", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := uiFiles.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		h.Write([]byte(name))
+		h.Write(data)
+		return nil
+	})
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}()
+
+// uiPage is what index.html is rendered with.
+type uiPage struct {
+	APIBase string
+	Version string
+	// LiveFlags shows controls that change flags on the IMAP server.
+	LiveFlags bool
+	// LiveSync shows the button that starts a sync.
+	LiveSync bool
+}
+
+func (s *Server) serveUI(w http.ResponseWriter, _ *http.Request) {
+	var buf bytes.Buffer
+	if err := indexTemplate.Execute(&buf, uiPage{APIBase: apiBase, Version: uiVersion, LiveFlags: s.flagClient != nil, LiveSync: s.syncFunc != nil}); err != nil {
+		s.log.WithError(err).Error("Failed to render UI")
+		http.Error(w, "Failed to render UI", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// uiAssets serves the UI's scripts and styles under /ui/. The index is only
+// served rendered, at /. Requests carrying the current version may be
+// cached for good.
+func uiAssets() http.Handler {
+	files := http.FileServer(http.FS(uiFiles))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/") || path.Ext(r.URL.Path) == ".html" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("v") == uiVersion {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		}
+		files.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,403 @@
+// apiBase is the API's path prefix, set by the server on the page.
+const apiBase = document.body.dataset.apiBase;
+// liveFlags is set when the server may change flags on the IMAP server.
+const liveFlags = document.body.dataset.liveFlags === 'true';
+// liveSync is set when the server may start a sync.
+const liveSync = document.body.dataset.liveSync === 'true';
+
+let currentMailbox = null;
+let currentSearch = null;
+// showingRecent is set while the list shows the newest emails of all
+// mailboxes.
+let showingRecent = false;
+let currentEmail = null;
+let currentPage = 1;
+let totalPages = 1;
+let nextPage = null;
+let pageLimit = 50;
+
+// postJSON posts body to url as JSON with the API token, asking for the
+// token and trying again when the server refuses the stored one.
+async function postJSON(url, body) {
+    const post = () => fetch(url, {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json',
+            'Authorization': `Bearer ${localStorage.getItem('apiToken') || ''}`,
+        },
+        body: body === undefined ? undefined : JSON.stringify(body),
+    });
+    const res = await post();
+    if (res.status !== 401) return res;
+    const token = prompt('API token:');
+    if (token === null) return res;
+    localStorage.setItem('apiToken', token);
+    return post();
+}
+
+async function loadMailboxes() {
+    const res = await fetch(`${apiBase}/mailboxes`);
+    const mailboxes = await res.json();
+
+    const container = document.getElementById('mailboxes');
+    container.innerHTML = mailboxes.map(mb => `
+        <div class="mailbox-item${mb.name === currentMailbox ? ' active' : ''}" data-mailbox="${escapeHtml(mb.name)}">
+            <div class="mailbox-name">${escapeHtml(mb.name)}</div>
+            <div class="mailbox-count">${mb.count || 0}</div>
+        </div>
+    `).join('');
+
+    container.querySelectorAll('.mailbox-item').forEach(el => {
+        el.addEventListener('click', () => {
+            loadEmails(el.dataset.mailbox, 1);
+        });
+    });
+}
+
+async function loadEmails(mailbox, page = 1) {
+    currentMailbox = mailbox;
+    currentSearch = null;
+    showingRecent = false;
+    currentPage = page;
+    document.getElementById('list-title').textContent = mailbox;
+    document.getElementById('search-input').value = '';
+    document.getElementById('list-sort').style.display = 'block';
+
+    document.querySelectorAll('.mailbox-item').forEach(el => {
+        el.classList.toggle('active', el.dataset.mailbox === mailbox);
+    });
+
+    const container = document.getElementById('emails');
+    container.innerHTML = '<div class="loading">Loading...</div>';
+
+    const sort = document.getElementById('sort-field').value;
+    const order = document.getElementById('sort-order').value;
+    const flag = document.getElementById('flag-filter').value;
+    const res = await fetch(`${apiBase}/mailboxes/${encodeURIComponent(mailbox)}/emails?page=${page}&limit=${pageLimit}&sort=${sort}&order=${order}${flag ? '&flag=' + flag : ''}`);
+    const data = await res.json();
+
+    renderEmailList(data, mailbox);
+}
+
+async function searchEmails(query, page = 1) {
+    currentSearch = query;
+    showingRecent = false;
+    currentPage = page;
+    document.getElementById('list-title').textContent = `Search: ${query}`;
+    document.getElementById('list-sort').style.display = 'none';
+
+    document.querySelectorAll('.mailbox-item').forEach(el => el.classList.remove('active'));
+
+    const container = document.getElementById('emails');
+    container.innerHTML = '<div class="loading">Searching...</div>';
+
+    const res = await fetch(`${apiBase}/search?q=${encodeURIComponent(query)}&page=${page}&limit=${pageLimit}`);
+    const data = await res.json();
+
+    renderEmailList(data, null);
+}
+
+async function loadRecent(page = 1) {
+    currentMailbox = null;
+    currentSearch = null;
+    showingRecent = true;
+    currentPage = page;
+    document.getElementById('list-title').textContent = 'Recent';
+    document.getElementById('search-input').value = '';
+    document.getElementById('list-sort').style.display = 'none';
+
+    document.querySelectorAll('.mailbox-item').forEach(el => el.classList.remove('active'));
+    document.getElementById('recent-item').classList.add('active');
+
+    const container = document.getElementById('emails');
+    container.innerHTML = '<div class="loading">Loading...</div>';
+
+    const res = await fetch(`${apiBase}/recent?page=${page}&limit=${pageLimit}`);
+    const data = await res.json();
+
+    renderEmailList(data, null);
+}
+
+function renderEmailList(data, mailbox) {
+    const container = document.getElementById('emails');
+
+    if (!data.emails || data.emails.length === 0) {
+        container.innerHTML = '<div class="loading">No emails</div>';
+        document.getElementById('pagination').style.display = 'none';
+        return;
+    }
+
+    totalPages = data.total_pages || 1;
+    nextPage = data.has_more ? data.next_page : null;
+    updatePagination();
+
+    container.innerHTML = groupByThread(data.emails).map(email => `
+        <div class="email-item${email.reply ? ' thread-reply' : ''}" data-mailbox="${escapeHtml(email.mailbox || mailbox)}" data-uid="${email.uid}">
+            <div class="email-subject">${escapeHtml(email.subject || '(No Subject)')}${email.threadSize > 1 ? `<span class="thread-count">${email.threadSize}</span>` : ''}</div>
+            <div class="email-from">${escapeHtml(formatSender(email) || '(Unknown)')}</div>
+            <div class="email-date">${new Date(email.date).toLocaleString()}${mailbox ? '' : ' &middot; ' + escapeHtml(email.mailbox)}</div>
+        </div>
+    `).join('');
+
+    document.querySelectorAll('.email-item').forEach(el => {
+        el.addEventListener('click', function() {
+            loadEmail(this.dataset.mailbox, parseInt(this.dataset.uid));
+        });
+    });
+
+    if (totalPages > 1) {
+        document.getElementById('pagination').style.display = 'flex';
+    } else {
+        document.getElementById('pagination').style.display = 'none';
+    }
+}
+
+// groupByThread keeps the list order of each thread's first email and
+// moves the rest of the thread on the page right below it. Search
+// results carry no thread_id and are left as they are.
+function groupByThread(emails) {
+    const threads = new Map();
+    emails.forEach(email => {
+        const key = email.thread_id ? 'thread:' + email.thread_id : 'uid:' + email.mailbox + ':' + email.uid;
+        if (!threads.has(key)) threads.set(key, []);
+        threads.get(key).push(email);
+    });
+    return [...threads.values()].flatMap(thread => thread.map((email, i) => ({
+        ...email,
+        reply: i > 0,
+        threadSize: i === 0 ? thread.length : 0,
+    })));
+}
+
+function goToPage(page) {
+    if (!page || page < 1 || page > totalPages) return;
+    if (currentSearch) {
+        searchEmails(currentSearch, page);
+    } else if (showingRecent) {
+        loadRecent(page);
+    } else if (currentMailbox) {
+        loadEmails(currentMailbox, page);
+    }
+}
+
+function updatePagination() {
+    document.getElementById('page-info').textContent = `Page ${currentPage} of ${totalPages}`;
+    document.getElementById('first-page').disabled = currentPage === 1;
+    document.getElementById('prev-page').disabled = currentPage === 1;
+    document.getElementById('next-page').disabled = nextPage === null;
+    document.getElementById('last-page').disabled = nextPage === null;
+}
+
+async function loadEmail(mailbox, uid) {
+    document.querySelectorAll('.email-item').forEach(el => {
+        el.classList.remove('active');
+        if (el.dataset.mailbox === mailbox && parseInt(el.dataset.uid) === uid) {
+            el.classList.add('active');
+        }
+    });
+
+    const res = await fetch(`${apiBase}/mailboxes/${encodeURIComponent(mailbox)}/emails/${uid}`);
+    const email = await res.json();
+
+    const viewer = document.querySelector('.email-viewer');
+    viewer.innerHTML = `
+        <div class="email-header">
+            <div class="email-header-top">
+                <h1>${escapeHtml(email.subject || '(No Subject)')}</h1>
+                <a href="${apiBase}/mailboxes/${encodeURIComponent(mailbox)}/emails/${uid}/download"
+                   class="download-btn"
+                   download="${escapeHtml(mailbox)}_${uid}.eml">
+                    Download EML
+                </a>
+                <button class="download-btn" id="toggle-headers">View headers</button>
+                ${liveFlags ? `<button class="download-btn" id="toggle-seen">${isSeen(email) ? 'Mark unread' : 'Mark read'} on server</button>` : ''}
+            </div>
+            <div class="email-meta">
+                <div><strong>From:</strong> ${escapeHtml(formatSender(email))}</div>
+                <div><strong>To:</strong> ${escapeHtml(email.to.join(', '))}</div>
+                ${email.cc && email.cc.length ? `<div><strong>Cc:</strong> ${escapeHtml(email.cc.join(', '))}</div>` : ''}
+                ${email.bcc && email.bcc.length ? `<div><strong>Bcc:</strong> ${escapeHtml(email.bcc.join(', '))}</div>` : ''}
+                <div><strong>Date:</strong> ${new Date(email.date).toLocaleString()}</div>
+                <div><strong>Size:</strong> ${email.size} bytes</div>
+                ${email.gmail_labels && email.gmail_labels.length ? `<div><strong>Labels:</strong> ${escapeHtml(email.gmail_labels.join(', '))}</div>` : ''}
+                <div class="email-attachments" id="email-attachments"></div>
+            </div>
+            <pre class="email-raw-headers" id="email-raw-headers" hidden></pre>
+        </div>
+        <div class="email-body" id="email-body-content"></div>
+    `;
+
+    document.getElementById('toggle-headers').addEventListener('click', () => toggleHeaders(mailbox, uid));
+    if (liveFlags) {
+        document.getElementById('toggle-seen').addEventListener('click', () => toggleSeen(mailbox, email));
+    }
+    renderEmailBody(email);
+    loadAttachments(mailbox, uid);
+}
+
+async function toggleHeaders(mailbox, uid) {
+    const pre = document.getElementById('email-raw-headers');
+    const button = document.getElementById('toggle-headers');
+    if (!pre.hidden) {
+        pre.hidden = true;
+        button.textContent = 'View headers';
+        return;
+    }
+
+    if (!pre.textContent) {
+        const res = await fetch(`${apiBase}/mailboxes/${encodeURIComponent(mailbox)}/emails/${uid}/headers`);
+        pre.textContent = res.ok ? await res.text() : 'Headers not available';
+    }
+    pre.hidden = false;
+    button.textContent = 'Hide headers';
+}
+
+function isSeen(email) {
+    return (email.flags || []).some(f => f.toLowerCase() === '\\seen');
+}
+
+async function toggleSeen(mailbox, email) {
+    const button = document.getElementById('toggle-seen');
+    const change = isSeen(email) ? {add: [], remove: ['\\Seen']} : {add: ['\\Seen'], remove: []};
+    button.disabled = true;
+    const res = await postJSON(`${apiBase}/mailboxes/${encodeURIComponent(mailbox)}/emails/${email.uid}/flags`, change);
+    button.disabled = false;
+    if (!res.ok) {
+        alert(`Failed to change flags: ${(await res.text()).trim()}`);
+        return;
+    }
+    email.flags = (await res.json()).flags;
+    button.textContent = `${isSeen(email) ? 'Mark unread' : 'Mark read'} on server`;
+}
+
+async function loadAttachments(mailbox, uid) {
+    const base = `${apiBase}/mailboxes/${encodeURIComponent(mailbox)}/emails/${uid}/attachments`;
+    const res = await fetch(base);
+    if (!res.ok) return;
+    const attachments = await res.json();
+    if (!attachments.length) return;
+
+    document.getElementById('email-attachments').innerHTML = '<strong>Attachments:</strong> ' +
+        attachments.map(a => `<a href="${base}/${a.index}" download="${escapeHtml(a.filename)}">${escapeHtml(a.filename)}</a> (${a.size} bytes)`).join(', ');
+}
+
+function renderEmailBody(email) {
+    const container = document.getElementById('email-body-content');
+
+    if (email.bodySkipped) {
+        const note = document.createElement('p');
+        note.style.fontStyle = 'italic';
+        note.style.color = '#666';
+        note.textContent = 'Body skipped (too large)';
+        container.appendChild(note);
+    } else if (email.bodyHTMLSanitized) {
+        // The HTML is sanitized server-side; the sandbox (no
+        // allow-scripts, and an opaque origin without allow-same-origin)
+        // is a second line of defense. With an opaque origin the frame
+        // can't be measured, so it gets a fixed height and scrolls.
+        const iframe = document.createElement('iframe');
+        iframe.sandbox = 'allow-popups allow-popups-to-escape-sandbox';
+        iframe.style.width = '100%';
+        iframe.style.border = 'none';
+        iframe.style.height = '70vh';
+        iframe.style.minHeight = '400px';
+        iframe.srcdoc = email.bodyHTMLSanitized;
+        container.appendChild(iframe);
+    } else {
+        const pre = document.createElement('pre');
+        pre.style.whiteSpace = 'pre-wrap';
+        pre.style.wordWrap = 'break-word';
+        pre.style.fontFamily = 'monospace';
+        pre.style.padding = '10px';
+        pre.textContent = email.bodyText || email.body || '';
+        container.appendChild(pre);
+    }
+}
+
+function formatSender(email) {
+    return email.from_name ? `${email.from_name} <${email.from}>` : email.from;
+}
+
+function escapeHtml(text) {
+    const div = document.createElement('div');
+    div.textContent = text;
+    return div.innerHTML;
+}
+
+document.getElementById('search-form').addEventListener('submit', (e) => {
+    e.preventDefault();
+    const query = document.getElementById('search-input').value.trim();
+    if (query) searchEmails(query, 1);
+});
+
+async function startSync() {
+    const res = await postJSON(`${apiBase}/sync`);
+    if (!res.ok && res.status !== 409) {
+        alert(`Failed to start sync: ${(await res.text()).trim()}`);
+        return;
+    }
+    pollSync();
+}
+
+// syncing is set while pollSync follows a running sync.
+let syncing = false;
+
+// pollSync shows the progress of a running sync until it ends, then
+// reloads the mailboxes.
+async function pollSync() {
+    const res = await fetch(`${apiBase}/sync/status`);
+    if (!res.ok) return;
+    const status = await res.json();
+
+    const button = document.getElementById('sync-now');
+    const text = document.getElementById('sync-status');
+    button.disabled = status.running;
+    if (status.running) {
+        const p = status.progress;
+        text.textContent = p && p.mailboxes
+            ? `Syncing ${p.mailbox} (${p.mailbox_index}/${p.mailboxes}, ${p.overall_percent}%)`
+            : 'Syncing...';
+        syncing = true;
+        setTimeout(pollSync, 1000);
+        return;
+    }
+
+    if (status.error) {
+        text.textContent = `Sync failed: ${status.error}`;
+    } else if (status.report) {
+        text.textContent = `Synced ${status.report.totals.new_messages} new messages`;
+    }
+    if (syncing) loadMailboxes();
+    syncing = false;
+}
+
+// followArrivals refreshes the mailboxes, and the open list, as the server
+// stores new emails. Arrivals are batched, since syncs store many at once.
+function followArrivals() {
+    const proto = location.protocol === 'https:' ? 'wss:' : 'ws:';
+    const ws = new WebSocket(`${proto}//${location.host}/ws`);
+    const mailboxes = new Set();
+    let timer = null;
+
+    ws.onmessage = (msg) => {
+        mailboxes.add(JSON.parse(msg.data).mailbox);
+        clearTimeout(timer);
+        timer = setTimeout(() => {
+            loadMailboxes();
+            if (showingRecent) loadRecent(currentPage);
+            if (mailboxes.has(currentMailbox) && !currentSearch) loadEmails(currentMailbox, currentPage);
+            mailboxes.clear();
+        }, 500);
+    };
+    ws.onclose = () => setTimeout(followArrivals, 5000);
+}
+
+if (liveSync) {
+    document.getElementById('sync-now').addEventListener('click', startSync);
+    pollSync();
+}
+
+document.getElementById('recent-item').addEventListener('click', () => loadRecent(1));
+
+loadMailboxes();
+followArrivals();
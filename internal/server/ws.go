@@ -0,0 +1,148 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/newsamples/imapsync/internal/storage"
+)
+
+// arrival is the event pushed to WebSocket clients when an email is stored.
+type arrival struct {
+	Mailbox string `json:"mailbox"`
+	UID     uint32 `json:"uid"`
+	Subject string `json:"subject"`
+}
+
+// arrivalBuffer is how many events a client may fall behind by before it is
+// disconnected.
+const arrivalBuffer = 256
+
+// Timeouts of WebSocket connections. Pings keep idle connections open
+// through proxies and detect clients that went away.
+const (
+	wsWriteTimeout = 10 * time.Second
+	wsPingInterval = 30 * time.Second
+)
+
+// arrivalHub fans out stored emails to the connected WebSocket clients.
+type arrivalHub struct {
+	mu      sync.Mutex
+	clients map[chan arrival]struct{}
+}
+
+func (h *arrivalHub) subscribe() chan arrival {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.clients == nil {
+		h.clients = make(map[chan arrival]struct{})
+	}
+	ch := make(chan arrival, arrivalBuffer)
+	h.clients[ch] = struct{}{}
+	return ch
+}
+
+func (h *arrivalHub) unsubscribe(ch chan arrival) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[ch]; ok {
+		delete(h.clients, ch)
+		close(ch)
+	}
+}
+
+// count returns the number of connected clients.
+func (h *arrivalHub) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients)
+}
+
+// publish is the storage's save observer. It must not block the save, so a
+// client too slow to keep up is dropped: its channel is closed.
+func (h *arrivalHub) publish(emails []*storage.Email) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+clients:
+	for ch := range h.clients {
+		for _, email := range emails {
+			select {
+			case ch <- arrival{Mailbox: email.Mailbox, UID: email.UID, Subject: email.Subject}:
+			default:
+				delete(h.clients, ch)
+				close(ch)
+				continue clients
+			}
+		}
+	}
+}
+
+// upgrader refuses cross-origin requests, so other sites can't follow the
+// backup from the user's browser.
+var upgrader = websocket.Upgrader{}
+
+// serveArrivals pushes an arrival event over a WebSocket for every email the
+// server stores, until the client disconnects or the server shuts down.
+// Messages from the client are read and ignored.
+func (s *Server) serveArrivals(w http.ResponseWriter, r *http.Request) {
+	// Counted before the connection is hijacked, while shutting down still
+	// waits for the request.
+	s.background.Add(1)
+	defer s.background.Done()
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already answered the request.
+		s.log.WithError(err).Debug("WebSocket upgrade failed")
+		return
+	}
+	defer conn.Close()
+
+	events := s.arrivals.subscribe()
+	defer s.arrivals.unsubscribe(events)
+
+	// Reading handles pings and close frames, and notices the client going
+	// away.
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	closeWith := func(code int, text string) {
+		msg := websocket.FormatCloseMessage(code, text)
+		conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteTimeout))
+	}
+
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				closeWith(websocket.ClosePolicyViolation, "too slow to keep up")
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteJSON(ev); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		case <-s.ctx.Done():
+			closeWith(websocket.CloseGoingAway, "server shutting down")
+			return
+		}
+	}
+}
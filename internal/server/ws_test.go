@@ -0,0 +1,84 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/newsamples/imapsync/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeArrivals(t *testing.T) {
+	server, store := setupTestServer(t)
+	defer store.Close()
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	// Wait for the handler to subscribe before saving.
+	require.Eventually(t, func() bool { return server.arrivals.count() == 1 }, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, store.SaveEmailBatch([]*storage.Email{
+		{UID: 1, Mailbox: "INBOX", Subject: "Hello"},
+		{UID: 2, Mailbox: "INBOX", Subject: "World"},
+	}))
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, want := range []arrival{{Mailbox: "INBOX", UID: 1, Subject: "Hello"}, {Mailbox: "INBOX", UID: 2, Subject: "World"}} {
+		var got arrival
+		require.NoError(t, conn.ReadJSON(&got))
+		assert.Equal(t, want, got)
+	}
+
+	t.Run("client disconnects", func(t *testing.T) {
+		require.NoError(t, conn.Close())
+		assert.Eventually(t, func() bool { return server.arrivals.count() == 0 }, time.Second, 10*time.Millisecond)
+		assert.NoError(t, store.SaveEmail(&storage.Email{UID: 3, Mailbox: "INBOX"}), "saves don't wait for gone clients")
+	})
+
+	t.Run("cross-origin", func(t *testing.T) {
+		_, resp, err := websocket.DefaultDialer.Dial(url, map[string][]string{"Origin": {"https://evil.example"}})
+		assert.Error(t, err)
+		require.NotNil(t, resp)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("server shutdown", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+		require.Eventually(t, func() bool { return server.arrivals.count() == 1 }, time.Second, 10*time.Millisecond)
+
+		server.stopBackground()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, _, err = conn.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), err)
+	})
+}
+
+func TestArrivalHub_DropsSlowClients(t *testing.T) {
+	var hub arrivalHub
+	slow := hub.subscribe()
+
+	emails := make([]*storage.Email, arrivalBuffer+1)
+	for i := range emails {
+		emails[i] = &storage.Email{UID: uint32(i + 1), Mailbox: "INBOX"}
+	}
+	hub.publish(emails)
+
+	assert.Zero(t, hub.count())
+	for range arrivalBuffer {
+		<-slow
+	}
+	_, ok := <-slow
+	assert.False(t, ok, "the channel of a dropped client is closed")
+	hub.unsubscribe(slow)
+}
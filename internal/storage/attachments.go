@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/newsamples/imapsync/internal/mailparse"
+)
+
+// Attachment is a file part extracted from a stored email. Content is only
+// populated by GetAttachment.
+type Attachment struct {
+	Mailbox     string `json:"mailbox"`
+	UID         uint32 `json:"uid"`
+	PartIndex   int    `json:"part_index"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Content     []byte `json:"-"`
+}
+
+// migrateAddAttachments creates the attachments table and extracts the
+// attachments of already-stored emails. SQLite doesn't enforce foreign keys
+// by default, so rows are removed with their email by a trigger.
+func (s *Storage) migrateAddAttachments(tx *sql.Tx) error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS attachments (
+		mailbox TEXT NOT NULL,
+		uid INTEGER NOT NULL,
+		part_index INTEGER NOT NULL,
+		filename TEXT,
+		content_type TEXT,
+		size INTEGER,
+		content BLOB,
+		PRIMARY KEY (mailbox, uid, part_index),
+		FOREIGN KEY (mailbox, uid) REFERENCES emails(mailbox, uid) ON DELETE CASCADE
+	);
+
+	CREATE TRIGGER IF NOT EXISTS emails_attachments_delete AFTER DELETE ON emails BEGIN
+		DELETE FROM attachments WHERE mailbox = old.mailbox AND uid = old.uid;
+	END;
+	`
+
+	if _, err := tx.Exec(schema); err != nil {
+		return fmt.Errorf("failed to create attachments table: %w", err)
+	}
+
+	return s.backfillAttachments(tx)
+}
+
+// backfillAttachments extracts attachments for every stored email.
+func (s *Storage) backfillAttachments(tx *sql.Tx) error {
+	return s.forEachStoredContent(tx, `SELECT mailbox, uid FROM email_content WHERE raw_message IS NOT NULL`, "attachments", func(email *Email) error {
+		// Undecodable content has no attachments to extract.
+		if email.RawMessage == nil {
+			return nil
+		}
+		return s.saveAttachments(tx, email)
+	})
+}
+
+// saveAttachments replaces the stored attachments of an email with those
+// found in its raw message, inside the caller's transaction.
+func (s *Storage) saveAttachments(tx *sql.Tx, email *Email) error {
+	if _, err := tx.Exec(
+		`DELETE FROM attachments WHERE mailbox = ? AND uid = ?`,
+		email.Mailbox, email.UID,
+	); err != nil {
+		return fmt.Errorf("failed to clear attachments: %w", err)
+	}
+
+	for _, a := range mailparse.Attachments(email.RawMessage) {
+		compressed, err := s.encodeContent(a.Content)
+		if err != nil {
+			return fmt.Errorf("failed to compress attachment: %w", err)
+		}
+
+		if _, err := tx.Exec(`
+			INSERT INTO attachments (mailbox, uid, part_index, filename, content_type, size, content)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			email.Mailbox, email.UID, a.Index, a.Filename, a.ContentType, len(a.Content), compressed,
+		); err != nil {
+			return fmt.Errorf("failed to insert attachment: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ListAttachments returns the attachments of an email ordered by part index,
+// without their content.
+func (s *Storage) ListAttachments(mailbox string, uid uint32) ([]*Attachment, error) {
+	rows, err := s.db.Query(`
+		SELECT part_index, filename, content_type, size
+		FROM attachments
+		WHERE mailbox = ? AND uid = ?
+		ORDER BY part_index ASC
+	`, mailbox, uid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query attachments: %w", err)
+	}
+	defer rows.Close()
+
+	var attachments []*Attachment
+	for rows.Next() {
+		a := &Attachment{Mailbox: mailbox, UID: uid}
+		var filename, contentType sql.NullString
+		if err := rows.Scan(&a.PartIndex, &filename, &contentType, &a.Size); err != nil {
+			return nil, fmt.Errorf("failed to scan attachment: %w", err)
+		}
+		a.Filename = filename.String
+		a.ContentType = contentType.String
+		attachments = append(attachments, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating attachments: %w", err)
+	}
+
+	return attachments, nil
+}
+
+// GetAttachment returns a single attachment with its decompressed content, or
+// nil if the email has no attachment at that part index.
+func (s *Storage) GetAttachment(mailbox string, uid uint32, partIndex int) (*Attachment, error) {
+	a := &Attachment{Mailbox: mailbox, UID: uid, PartIndex: partIndex}
+	var filename, contentType sql.NullString
+	var compressed []byte
+
+	err := s.db.QueryRow(`
+		SELECT filename, content_type, size, content
+		FROM attachments
+		WHERE mailbox = ? AND uid = ? AND part_index = ?
+	`, mailbox, uid, partIndex).Scan(&filename, &contentType, &a.Size, &compressed)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attachment: %w", err)
+	}
+
+	a.Filename = filename.String
+	a.ContentType = contentType.String
+
+	a.Content, err = s.decodeContent(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress attachment: %w", err)
+	}
+
+	return a, nil
+}
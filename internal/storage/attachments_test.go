@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const attachmentTestMsg = "Subject: Invoice\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"b\"\r\n\r\n" +
+	"--b\r\nContent-Type: text/plain\r\n\r\nSee attached.\r\n" +
+	"--b\r\nContent-Type: application/pdf\r\n" +
+	"Content-Disposition: attachment; filename=\"invoice.pdf\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n\r\n" +
+	"JVBERi0xLjQKJSBmYWtl\r\n" +
+	"--b--\r\n"
+
+func TestAttachments_SaveListGet(t *testing.T) {
+	s := newSearchTestStorage(t)
+
+	require.NoError(t, s.SaveEmail(&Email{UID: 1, Mailbox: "INBOX", RawMessage: []byte(attachmentTestMsg)}))
+	require.NoError(t, s.SaveEmailBatch([]*Email{
+		{UID: 2, Mailbox: "INBOX", RawMessage: []byte(attachmentTestMsg)},
+		{UID: 3, Mailbox: "INBOX", RawMessage: []byte("Subject: plain\r\n\r\nNo files.")},
+	}))
+
+	for _, uid := range []uint32{1, 2} {
+		list, err := s.ListAttachments("INBOX", uid)
+		require.NoError(t, err)
+		require.Len(t, list, 1)
+		assert.Equal(t, 1, list[0].PartIndex)
+		assert.Equal(t, "invoice.pdf", list[0].Filename)
+		assert.Equal(t, "application/pdf", list[0].ContentType)
+		assert.Equal(t, int64(15), list[0].Size)
+		assert.Nil(t, list[0].Content, "listing omits content")
+	}
+
+	list, err := s.ListAttachments("INBOX", 3)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+
+	a, err := s.GetAttachment("INBOX", 1, 1)
+	require.NoError(t, err)
+	require.NotNil(t, a)
+	assert.Equal(t, "invoice.pdf", a.Filename)
+	assert.Equal(t, "%PDF-1.4\n% fake", string(a.Content))
+
+	a, err = s.GetAttachment("INBOX", 1, 0)
+	require.NoError(t, err)
+	assert.Nil(t, a, "text body is not an attachment")
+}
+
+func TestAttachments_FollowEmailLifecycle(t *testing.T) {
+	s := newSearchTestStorage(t)
+
+	require.NoError(t, s.SaveEmail(&Email{UID: 1, Mailbox: "INBOX", RawMessage: []byte(attachmentTestMsg)}))
+
+	// Re-saving without the attachment replaces the previous rows.
+	require.NoError(t, s.SaveEmail(&Email{UID: 1, Mailbox: "INBOX", RawMessage: []byte("Subject: x\r\n\r\nbody")}))
+	list, err := s.ListAttachments("INBOX", 1)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+
+	require.NoError(t, s.SaveEmail(&Email{UID: 1, Mailbox: "INBOX", RawMessage: []byte(attachmentTestMsg)}))
+	_, err = s.MarkDeleted("INBOX", []uint32{1}, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	_, err = s.PurgeDeletedBefore(time.Now())
+	require.NoError(t, err)
+
+	var count int
+	require.NoError(t, s.db.QueryRow(`SELECT COUNT(*) FROM attachments`).Scan(&count))
+	assert.Equal(t, 0, count, "purged emails lose their attachments")
+}
+
+func TestAttachments_BackfillsExistingDB(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	s, err := New(dbPath, log)
+	require.NoError(t, err)
+	require.NoError(t, s.SaveEmail(&Email{UID: 9, Mailbox: "Archive", RawMessage: []byte(attachmentTestMsg)}))
+
+	// Simulate a database created before attachments were extracted.
+	_, err = s.db.Exec(`DROP TRIGGER emails_attachments_delete; DROP TABLE attachments`)
+	require.NoError(t, err)
+	_, err = s.db.Exec(`DELETE FROM schema_migrations WHERE version >= 5`)
+	require.NoError(t, err)
+	s.Close()
+
+	s2, err := New(dbPath, log)
+	require.NoError(t, err)
+	defer s2.Close()
+
+	list, err := s2.ListAttachments("Archive", 9)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, "invoice.pdf", list[0].Filename)
+}
+
+func TestAttachments_ClosedDB(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	s.Close()
+
+	_, err = s.ListAttachments("INBOX", 1)
+	assert.Error(t, err)
+	_, err = s.GetAttachment("INBOX", 1, 1)
+	assert.Error(t, err)
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is the codec used for email content and attachments written
+// from now on. Rows already stored keep the codec they were written with.
+type Compression string
+
+const (
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+	CompressionNone Compression = "none"
+)
+
+// Stored content is either a bare gzip stream, as written by every version
+// before codecs were configurable, or a codec marker byte followed by the
+// payload. Markers must never be 0x1f, the first byte of the gzip magic.
+// markerEncrypted wraps any of the others; see encryption.go.
+const (
+	markerNone      byte = 0x00
+	markerZstd      byte = 0x01
+	markerEncrypted byte = 0x02
+)
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// WithCompression sets the codec used for new content. The default is gzip.
+func WithCompression(compression Compression) Option {
+	return func(s *Storage) {
+		if compression != "" {
+			s.compression = compression
+		}
+	}
+}
+
+func compressData(data []byte, compression Compression) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+
+	switch compression {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+
+		if _, err := writer.Write(data); err != nil {
+			return nil, fmt.Errorf("failed to write compressed data: %w", err)
+		}
+
+		if err := writer.Close(); err != nil {
+			return nil, fmt.Errorf("failed to close gzip writer: %w", err)
+		}
+
+		return buf.Bytes(), nil
+	case CompressionZstd:
+		return zstdEncoder.EncodeAll(data, []byte{markerZstd}), nil
+	case CompressionNone:
+		return append([]byte{markerNone}, data...), nil
+	default:
+		return nil, fmt.Errorf("unknown compression %q", compression)
+	}
+}
+
+func decompressData(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+
+	switch data[0] {
+	case markerNone:
+		return data[1:], nil
+	case markerZstd:
+		result, err := zstdDecoder.DecodeAll(data[1:], nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read decompressed data: %w", err)
+		}
+		return result, nil
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+	}
+	defer reader.Close()
+
+	result, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read decompressed data: %w", err)
+	}
+
+	return result, nil
+}
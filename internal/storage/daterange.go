@@ -0,0 +1,31 @@
+package storage
+
+import "time"
+
+// DateRange restricts a listing to emails dated within it, both ends
+// included. A zero Since or Until leaves that end open; emails with an
+// unknown date never match a range with either end set.
+type DateRange struct {
+	Since time.Time
+	Until time.Time
+}
+
+// IsZero reports whether the range is open at both ends.
+func (d DateRange) IsZero() bool {
+	return d.Since.IsZero() && d.Until.IsZero()
+}
+
+// where returns the SQL conditions for the range, prefixed with AND, and
+// their arguments. Dates are stored as Unix seconds.
+func (d DateRange) where() (string, []any) {
+	switch {
+	case d.IsZero():
+		return "", nil
+	case d.Until.IsZero():
+		return ` AND date > 0 AND date >= ?`, []any{d.Since.Unix()}
+	case d.Since.IsZero():
+		return ` AND date > 0 AND date <= ?`, []any{d.Until.Unix()}
+	default:
+		return ` AND date > 0 AND date BETWEEN ? AND ?`, []any{d.Since.Unix(), d.Until.Unix()}
+	}
+}
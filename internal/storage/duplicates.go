@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/newsamples/imapsync/internal/mailparse"
+)
+
+// EmailRef identifies a stored email.
+type EmailRef struct {
+	Mailbox string `json:"mailbox"`
+	UID     uint32 `json:"uid"`
+	Size    uint32 `json:"size"`
+}
+
+// migrateAddMessageID adds the indexed message_id column and fills it in for
+// already-stored emails.
+func (s *Storage) migrateAddMessageID(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "emails", "message_id", "TEXT"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_emails_message_id ON emails(message_id)`); err != nil {
+		return fmt.Errorf("failed to create message_id index: %w", err)
+	}
+
+	return s.backfillMessageIDs(tx)
+}
+
+// backfillMessageIDs extracts the Message-ID of every stored email.
+func (s *Storage) backfillMessageIDs(tx *sql.Tx) error {
+	return s.forEachStoredContent(tx, `SELECT mailbox, uid FROM email_content`, "message IDs", func(email *Email) error {
+		id := messageID(email)
+		if !id.Valid {
+			return nil
+		}
+		if _, err := tx.Exec(
+			`UPDATE emails SET message_id = ? WHERE mailbox = ? AND uid = ?`,
+			id, email.Mailbox, email.UID,
+		); err != nil {
+			return fmt.Errorf("failed to save message ID: %w", err)
+		}
+		return nil
+	})
+}
+
+// messageID returns the Message-ID to store for an email, taken from its
+// headers or, failing that, its raw message. Emails without one store NULL.
+func messageID(email *Email) sql.NullString {
+	id := mailparse.MessageID(email.Headers)
+	if id == "" {
+		id = mailparse.MessageID(email.RawMessage)
+	}
+	return sql.NullString{String: id, Valid: id != ""}
+}
+
+// FindDuplicates returns the live emails that share a Message-ID with at
+// least one other live email, keyed by Message-ID. Each group is ordered by
+// mailbox and UID. Gmail stores a message once per label, so its mailboxes
+// typically produce many groups.
+func (s *Storage) FindDuplicates() (map[string][]EmailRef, error) {
+	rows, err := s.db.Query(`
+		SELECT message_id, mailbox, uid, COALESCE(size, 0)
+		FROM emails
+		WHERE deleted_at IS NULL AND message_id IN (
+			SELECT message_id FROM emails
+			WHERE deleted_at IS NULL AND message_id IS NOT NULL
+			GROUP BY message_id
+			HAVING COUNT(*) > 1
+		)
+		ORDER BY message_id, mailbox, uid
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query duplicates: %w", err)
+	}
+	defer rows.Close()
+
+	duplicates := make(map[string][]EmailRef)
+	for rows.Next() {
+		var id string
+		var r EmailRef
+		if err := rows.Scan(&id, &r.Mailbox, &r.UID, &r.Size); err != nil {
+			return nil, fmt.Errorf("failed to scan duplicate: %w", err)
+		}
+		duplicates[id] = append(duplicates[id], r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating duplicates: %w", err)
+	}
+
+	return duplicates, nil
+}
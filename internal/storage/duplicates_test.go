@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer s.Close()
+
+	shared := []byte("Message-ID: <shared@example.com>\r\nSubject: Hi\r\n\r\nHello")
+	require.NoError(t, s.SaveEmailBatch([]*Email{
+		{UID: 1, Mailbox: "INBOX", Size: 100, RawMessage: shared},
+		{UID: 7, Mailbox: "[Gmail]/All Mail", Size: 100, RawMessage: shared},
+		{UID: 2, Mailbox: "INBOX", Size: 50, RawMessage: []byte("Message-ID: <unique@example.com>\r\n\r\nx")},
+		{UID: 3, Mailbox: "INBOX", Size: 10, RawMessage: []byte("Subject: no id\r\n\r\nx")},
+		{UID: 4, Mailbox: "INBOX", Size: 10, RawMessage: []byte("Subject: no id\r\n\r\nx")},
+		// Skipped bodies still carry their headers.
+		{UID: 5, Mailbox: "Work", Size: 100, Headers: []byte("Message-ID: <shared@example.com>\r\n\r\n")},
+	}))
+
+	dups, err := s.FindDuplicates()
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]EmailRef{
+		"<shared@example.com>": {
+			{Mailbox: "INBOX", UID: 1, Size: 100},
+			{Mailbox: "Work", UID: 5, Size: 100},
+			{Mailbox: "[Gmail]/All Mail", UID: 7, Size: 100},
+		},
+	}, dups)
+
+	_, err = s.MarkDeleted("Work", []uint32{5}, time.Now())
+	require.NoError(t, err)
+	_, err = s.MarkDeleted("[Gmail]/All Mail", []uint32{7}, time.Now())
+	require.NoError(t, err)
+
+	dups, err = s.FindDuplicates()
+	require.NoError(t, err)
+	assert.Empty(t, dups, "deleted emails are not duplicates")
+}
+
+func TestMessageID_BackfillsExistingDB(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	s, err := New(dbPath, log)
+	require.NoError(t, err)
+	raw := []byte("Message-ID: <old@example.com>\r\n\r\nbody")
+	require.NoError(t, s.SaveEmail(&Email{UID: 1, Mailbox: "INBOX", RawMessage: raw}))
+	require.NoError(t, s.SaveEmail(&Email{UID: 1, Mailbox: "Archive", RawMessage: raw}))
+
+	// Simulate a database created before Message-IDs were stored.
+	_, err = s.db.Exec(`DROP INDEX idx_emails_message_id; ALTER TABLE emails DROP COLUMN message_id`)
+	require.NoError(t, err)
+	_, err = s.db.Exec(`DELETE FROM schema_migrations WHERE version >= 6`)
+	require.NoError(t, err)
+	s.Close()
+
+	s2, err := New(dbPath, log)
+	require.NoError(t, err)
+	defer s2.Close()
+
+	dups, err := s2.FindDuplicates()
+	require.NoError(t, err)
+	assert.Len(t, dups["<old@example.com>"], 2)
+}
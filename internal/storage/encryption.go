@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrWrongEncryptionKey is returned by New when the configured key isn't
+	// the one the database was encrypted with.
+	ErrWrongEncryptionKey = errors.New("wrong encryption key for this database")
+	// ErrEncryptionKeyRequired is returned by New for an encrypted database
+	// opened without a key.
+	ErrEncryptionKeyRequired = errors.New("database is encrypted; an encryption key is required")
+)
+
+// Encrypted content is markerEncrypted, a nonce and the AES-256-GCM sealed
+// output of compressData. The key is derived from a passphrase with PBKDF2,
+// using a salt stored in the settings table.
+const (
+	keyLength       = 32
+	saltLength      = 16
+	pbkdf2Rounds    = 600_000
+	settingSalt     = "encryption_salt"
+	settingKeyCheck = "encryption_check"
+)
+
+// keyCheckPlaintext is sealed with the key when encryption is first enabled,
+// so a wrong key is detected on open rather than on the first read.
+var keyCheckPlaintext = []byte("imapsync encryption key check")
+
+// WithEncryptionKey encrypts email content and attachments written from now
+// on with a key derived from passphrase. Metadata stays in clear text for
+// querying, and content stored before encryption was enabled stays readable.
+// Once a database holds a key check, it can only be opened with that key.
+func WithEncryptionKey(passphrase string) Option {
+	return func(s *Storage) {
+		s.passphrase = passphrase
+	}
+}
+
+// migrateAddSettings adds a table of database-wide settings, such as the salt
+// of the encryption key.
+func (s *Storage) migrateAddSettings(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS settings (
+			name TEXT PRIMARY KEY,
+			value BLOB NOT NULL
+		)
+	`); err != nil {
+		return fmt.Errorf("failed to create settings table: %w", err)
+	}
+	return nil
+}
+
+// setupEncryption checks the configured key against the database and keeps
+// the cipher for it. A writable database without a key check gets one, which
+// turns encryption on for good.
+func (s *Storage) setupEncryption() error {
+	salt, check, err := s.encryptionSettings()
+	if err != nil {
+		return err
+	}
+
+	if s.passphrase == "" {
+		if check != nil {
+			return ErrEncryptionKeyRequired
+		}
+		return nil
+	}
+
+	if check == nil {
+		if s.readOnly {
+			// Nothing can be encrypted yet, and nothing can be written.
+			return nil
+		}
+		return s.enableEncryption()
+	}
+
+	s.aead, err = newAEAD(s.passphrase, salt)
+	if err != nil {
+		return err
+	}
+	plain, err := s.open(check)
+	if err != nil || !bytes.Equal(plain, keyCheckPlaintext) {
+		return ErrWrongEncryptionKey
+	}
+	return nil
+}
+
+// encryptionSettings returns the stored salt and key check, both nil for a
+// database that was never encrypted.
+func (s *Storage) encryptionSettings() (salt, check []byte, err error) {
+	var exists int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'settings'`).Scan(&exists); err != nil {
+		return nil, nil, fmt.Errorf("failed to check for settings: %w", err)
+	}
+	if exists == 0 {
+		return nil, nil, nil
+	}
+
+	rows, err := s.db.Query(`SELECT name, value FROM settings WHERE name IN (?, ?)`, settingSalt, settingKeyCheck)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read encryption settings: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var value []byte
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan encryption setting: %w", err)
+		}
+		switch name {
+		case settingSalt:
+			salt = value
+		case settingKeyCheck:
+			check = value
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error iterating encryption settings: %w", err)
+	}
+	return salt, check, nil
+}
+
+// enableEncryption stores a new salt and a key check for the configured key.
+func (s *Storage) enableEncryption() error {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	aead, err := newAEAD(s.passphrase, salt)
+	if err != nil {
+		return err
+	}
+	s.aead = aead
+
+	check, err := s.seal(keyCheckPlaintext)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	for name, value := range map[string][]byte{settingSalt: salt, settingKeyCheck: check} {
+		if _, err := tx.Exec(`INSERT INTO settings (name, value) VALUES (?, ?)`, name, value); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to save encryption settings: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit encryption settings: %w", err)
+	}
+
+	s.log.Info("Encryption enabled: content stored from now on is encrypted")
+	return nil
+}
+
+func newAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, pbkdf2Rounds, keyLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive encryption key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts data, which is already compressed, with a random nonce.
+func (s *Storage) seal(data []byte) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize(), 1+s.aead.NonceSize()+len(data)+s.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	out := append([]byte{markerEncrypted}, nonce...)
+	return s.aead.Seal(out, nonce, data, nil), nil
+}
+
+// open reverses seal.
+func (s *Storage) open(data []byte) ([]byte, error) {
+	if s.aead == nil {
+		return nil, ErrEncryptionKeyRequired
+	}
+	nonceSize := s.aead.NonceSize()
+	if len(data) < 1+nonceSize {
+		return nil, fmt.Errorf("encrypted content is truncated")
+	}
+	plain, err := s.aead.Open(nil, data[1:1+nonceSize], data[1+nonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt content: %w", err)
+	}
+	return plain, nil
+}
+
+// encodeContent compresses data with the configured codec, then encrypts it
+// when a key is set.
+func (s *Storage) encodeContent(data []byte) ([]byte, error) {
+	encoded, err := compressData(data, s.compression)
+	if err != nil || len(encoded) == 0 || s.aead == nil {
+		return encoded, err
+	}
+	return s.seal(encoded)
+}
+
+// decodeContent reverses encodeContent, for content written with or without
+// encryption.
+func (s *Storage) decodeContent(data []byte) ([]byte, error) {
+	if len(data) > 0 && data[0] == markerEncrypted {
+		var err error
+		if data, err = s.open(data); err != nil {
+			return nil, err
+		}
+	}
+	return decompressData(data)
+}
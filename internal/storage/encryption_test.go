@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithEncryptionKey(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+
+	// Content stored before encryption is enabled stays readable.
+	s, err := New(dbPath, log)
+	require.NoError(t, err)
+	require.NoError(t, s.SaveEmail(&Email{UID: 1, Mailbox: "INBOX", Subject: "Before", RawMessage: []byte(attachmentTestMsg)}))
+	s.Close()
+
+	s, err = New(dbPath, log, WithEncryptionKey("correct horse"))
+	require.NoError(t, err)
+	require.NoError(t, s.SaveEmail(&Email{UID: 2, Mailbox: "INBOX", Subject: "Invoice", RawMessage: []byte(attachmentTestMsg)}))
+
+	var raw, attachment []byte
+	require.NoError(t, s.db.QueryRow(`SELECT raw_message FROM email_content WHERE uid = 2`).Scan(&raw))
+	require.NoError(t, s.db.QueryRow(`SELECT content FROM attachments WHERE uid = 2`).Scan(&attachment))
+	assert.Equal(t, markerEncrypted, raw[0])
+	assert.Equal(t, markerEncrypted, attachment[0])
+
+	for _, uid := range []uint32{1, 2} {
+		email, err := s.GetEmail("INBOX", uid)
+		require.NoError(t, err)
+		assert.Equal(t, attachmentTestMsg, string(email.RawMessage))
+
+		a, err := s.GetAttachment("INBOX", uid, 1)
+		require.NoError(t, err)
+		require.NotNil(t, a)
+		assert.Equal(t, "%PDF-1.4\n% fake", string(a.Content))
+	}
+
+	// Metadata is still searchable, the encrypted body isn't.
+	results, err := s.SearchEmails("Invoice", "", 10, 0)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, uint32(2), results[0].UID)
+	results, err = s.SearchEmails("attached", "", 10, 0)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, uint32(1), results[0].UID)
+	s.Close()
+
+	t.Run("wrong key", func(t *testing.T) {
+		_, err := New(dbPath, log, WithEncryptionKey("battery staple"))
+		assert.ErrorIs(t, err, ErrWrongEncryptionKey)
+	})
+
+	t.Run("no key", func(t *testing.T) {
+		_, err := New(dbPath, log)
+		assert.ErrorIs(t, err, ErrEncryptionKeyRequired)
+		_, err = Open(dbPath)
+		assert.ErrorIs(t, err, ErrEncryptionKeyRequired)
+	})
+
+	t.Run("read-only", func(t *testing.T) {
+		s, err := New(dbPath, log, WithReadOnly(true), WithEncryptionKey("correct horse"))
+		require.NoError(t, err)
+		defer s.Close()
+
+		email, err := s.GetEmail("INBOX", 2)
+		require.NoError(t, err)
+		assert.Equal(t, attachmentTestMsg, string(email.RawMessage))
+	})
+}
@@ -0,0 +1,56 @@
+package storage_test
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/newsamples/imapsync/internal/storage"
+)
+
+func ExampleOpen() {
+	dir, err := os.MkdirTemp("", "imapsync-example")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "backup.sqlite3")
+
+	// A backup as a sync leaves it.
+	backup, err := storage.New(path, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, mailbox := range []string{"INBOX", "Archive"} {
+		if err := backup.SaveMailboxState(&storage.MailboxState{Name: mailbox, UIDValidity: 1, LastSync: time.Now()}); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := backup.SaveEmail(&storage.Email{UID: 1, Mailbox: "INBOX", Subject: "Hello"}); err != nil {
+		log.Fatal(err)
+	}
+	backup.Close()
+
+	s, err := storage.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer s.Close()
+
+	mailboxes, err := s.ListMailboxes()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, mailbox := range mailboxes {
+		n, err := s.CountMessages(mailbox)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s: %d\n", mailbox, n)
+	}
+	// Output:
+	// Archive: 0
+	// INBOX: 1
+}
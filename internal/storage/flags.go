@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// FlagFilter restricts a listing by IMAP flags. An email matches if it has
+// every flag in Require and none in Exclude. Flags compare case-insensitively,
+// as in IMAP.
+type FlagFilter struct {
+	Require []string
+	Exclude []string
+
+	// From, when set, also keeps only emails whose sender address contains
+	// it, ignoring ASCII case.
+	From string
+}
+
+// flagFilterNames are the filter names accepted by ParseFlagFilter.
+var flagFilterNames = map[string]struct {
+	flag    string
+	exclude bool
+}{
+	"seen":       {`\Seen`, false},
+	"unseen":     {`\Seen`, true},
+	"flagged":    {`\Flagged`, false},
+	"unflagged":  {`\Flagged`, true},
+	"answered":   {`\Answered`, false},
+	"unanswered": {`\Answered`, true},
+	"draft":      {`\Draft`, false},
+}
+
+// ParseFlagFilter builds a FlagFilter from names such as "unseen" or
+// "flagged". No names match every email.
+func ParseFlagFilter(names []string) (FlagFilter, error) {
+	var filter FlagFilter
+	for _, name := range names {
+		f, ok := flagFilterNames[strings.ToLower(name)]
+		if !ok {
+			return FlagFilter{}, fmt.Errorf("invalid flag filter %q: expected seen, unseen, flagged, unflagged, answered, unanswered or draft", name)
+		}
+		if f.exclude {
+			filter.Exclude = append(filter.Exclude, f.flag)
+		} else {
+			filter.Require = append(filter.Require, f.flag)
+		}
+	}
+	return filter, nil
+}
+
+// where returns the SQL conditions for the filter, each prefixed with AND,
+// and their arguments.
+//
+// Flags are stored as a JSON array, so each flag is matched as its complete
+// quoted JSON string: "\\Seen" never matches inside a longer keyword.
+func (f FlagFilter) where() (string, []any) {
+	var b strings.Builder
+	var args []any
+	for _, flag := range f.Require {
+		b.WriteString(` AND flags LIKE ? ESCAPE '!'`)
+		args = append(args, flagPattern(flag))
+	}
+	for _, flag := range f.Exclude {
+		b.WriteString(` AND flags NOT LIKE ? ESCAPE '!'`)
+		args = append(args, flagPattern(flag))
+	}
+	if f.From != "" {
+		b.WriteString(` AND from_addr LIKE ? ESCAPE '!'`)
+		args = append(args, containsPattern(f.From))
+	}
+	return b.String(), args
+}
+
+// flagPattern returns a LIKE pattern matching flag as a whole JSON array
+// element.
+func flagPattern(flag string) string {
+	token, _ := json.Marshal(flag)
+	return containsPattern(string(token))
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern using ESCAPE '!'.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// containsPattern returns a LIKE pattern, used with ESCAPE '!', matching
+// values that contain s.
+func containsPattern(s string) string {
+	return "%" + likeEscaper.Replace(s) + "%"
+}
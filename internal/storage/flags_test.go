@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListEmailsFiltered(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, s.SaveEmailBatch([]*Email{
+		{UID: 1, Mailbox: "INBOX", Date: time.Now(), Flags: []string{`\Seen`}},
+		{UID: 2, Mailbox: "INBOX", Date: time.Now()},
+		{UID: 3, Mailbox: "INBOX", Date: time.Now(), Flags: []string{`\seen`, `\Flagged`}},
+		// Keywords containing a flag name must not match it.
+		{UID: 4, Mailbox: "INBOX", Date: time.Now(), Flags: []string{`$\Seen_later`, `Flagged`}},
+		{UID: 5, Mailbox: "Sent", Date: time.Now(), Flags: []string{`\Flagged`}},
+	}))
+
+	uids := func(filter FlagFilter) []uint32 {
+		emails, err := s.ListEmailsFiltered("INBOX", filter, SortByUID, SortAsc, 10, 0)
+		require.NoError(t, err)
+		var result []uint32
+		for _, e := range emails {
+			result = append(result, e.UID)
+		}
+
+		count, err := s.CountMessagesFiltered("INBOX", filter)
+		require.NoError(t, err)
+		assert.Equal(t, len(result), count)
+		return result
+	}
+
+	assert.Equal(t, []uint32{1, 2, 3, 4}, uids(FlagFilter{}))
+	assert.Equal(t, []uint32{2, 4}, uids(FlagFilter{Exclude: []string{`\Seen`}}))
+	assert.Equal(t, []uint32{3}, uids(FlagFilter{Require: []string{`\Flagged`}}))
+	assert.Equal(t, []uint32{1}, uids(FlagFilter{Require: []string{`\Seen`}, Exclude: []string{`\Flagged`}}))
+	assert.Equal(t, []uint32{4}, uids(FlagFilter{Require: []string{`$\Seen_later`}}))
+	assert.Empty(t, uids(FlagFilter{Require: []string{`$\Seen%`}}))
+}
+
+func TestParseFlagFilter(t *testing.T) {
+	filter, err := ParseFlagFilter(nil)
+	require.NoError(t, err)
+	assert.Equal(t, FlagFilter{}, filter)
+
+	filter, err = ParseFlagFilter([]string{"Unseen", "flagged"})
+	require.NoError(t, err)
+	assert.Equal(t, FlagFilter{Require: []string{`\Flagged`}, Exclude: []string{`\Seen`}}, filter)
+
+	_, err = ParseFlagFilter([]string{"important"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SyncHistory records the server-side size of a mailbox as of one sync, for
+// charting how a backup grows over time.
+type SyncHistory struct {
+	Mailbox            string    `json:"mailbox"`
+	SyncedAt           time.Time `json:"synced_at"`
+	ServerMessageCount int       `json:"server_message_count"`
+	NewMessages        int       `json:"new_messages"`
+	UIDNext            uint32    `json:"uidnext"`
+}
+
+// migrateAddSyncHistory creates the table of per-sync mailbox sizes. Rows
+// are only ever appended; id keeps them in the order they were written.
+func (s *Storage) migrateAddSyncHistory(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS sync_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			mailbox TEXT NOT NULL,
+			synced_at INTEGER NOT NULL,
+			server_message_count INTEGER NOT NULL,
+			new_messages INTEGER NOT NULL,
+			uidnext INTEGER NOT NULL
+		)
+	`); err != nil {
+		return fmt.Errorf("failed to create sync_history table: %w", err)
+	}
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_sync_history_mailbox ON sync_history(mailbox, id)`); err != nil {
+		return fmt.Errorf("failed to create sync_history index: %w", err)
+	}
+	return nil
+}
+
+// AddSyncHistory appends an entry to the sync history of its mailbox.
+func (s *Storage) AddSyncHistory(entry *SyncHistory) error {
+	return s.AddSyncHistoryContext(context.Background(), entry)
+}
+
+// AddSyncHistoryContext is like AddSyncHistory but stops when ctx is done.
+func (s *Storage) AddSyncHistoryContext(ctx context.Context, entry *SyncHistory) error {
+	if _, err := s.db.ExecContext(ctx, `
+		INSERT INTO sync_history (mailbox, synced_at, server_message_count, new_messages, uidnext)
+		VALUES (?, ?, ?, ?, ?)
+	`, entry.Mailbox, entry.SyncedAt.Unix(), entry.ServerMessageCount, entry.NewMessages, entry.UIDNext); err != nil {
+		return fmt.Errorf("failed to save sync history: %w", err)
+	}
+	return nil
+}
+
+// GetSyncHistory returns the sync history of a mailbox, oldest first.
+func (s *Storage) GetSyncHistory(mailbox string) ([]*SyncHistory, error) {
+	return s.GetSyncHistoryContext(context.Background(), mailbox)
+}
+
+// GetSyncHistoryContext is like GetSyncHistory but stops when ctx is done.
+func (s *Storage) GetSyncHistoryContext(ctx context.Context, mailbox string) ([]*SyncHistory, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT mailbox, synced_at, server_message_count, new_messages, uidnext
+		FROM sync_history
+		WHERE mailbox = ?
+		ORDER BY id ASC
+	`, mailbox)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sync history: %w", err)
+	}
+	defer rows.Close()
+
+	history := []*SyncHistory{}
+	for rows.Next() {
+		var entry SyncHistory
+		var syncedAt int64
+		if err := rows.Scan(&entry.Mailbox, &syncedAt, &entry.ServerMessageCount, &entry.NewMessages, &entry.UIDNext); err != nil {
+			return nil, fmt.Errorf("failed to scan sync history: %w", err)
+		}
+		entry.SyncedAt = time.Unix(syncedAt, 0)
+		history = append(history, &entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating sync history: %w", err)
+	}
+
+	return history, nil
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncHistory(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer s.Close()
+
+	history, err := s.GetSyncHistory("INBOX")
+	require.NoError(t, err)
+	assert.Empty(t, history)
+
+	first := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	entries := []*SyncHistory{
+		{Mailbox: "INBOX", SyncedAt: first, ServerMessageCount: 10, NewMessages: 10, UIDNext: 11},
+		{Mailbox: "Sent", SyncedAt: first, ServerMessageCount: 3, NewMessages: 3, UIDNext: 4},
+		// Same second: insertion order still decides.
+		{Mailbox: "INBOX", SyncedAt: first, ServerMessageCount: 12, NewMessages: 2, UIDNext: 13},
+	}
+	for _, entry := range entries {
+		require.NoError(t, s.AddSyncHistory(entry))
+	}
+
+	history, err = s.GetSyncHistory("INBOX")
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, 10, history[0].ServerMessageCount)
+	assert.Equal(t, uint32(13), history[1].UIDNext)
+	assert.Equal(t, 2, history[1].NewMessages)
+	assert.True(t, first.Equal(history[1].SyncedAt))
+
+	_, err = s.DeleteMailbox("INBOX")
+	require.NoError(t, err)
+	history, err = s.GetSyncHistory("INBOX")
+	require.NoError(t, err)
+	assert.Empty(t, history, "deleting a mailbox drops its history")
+	history, err = s.GetSyncHistory("Sent")
+	require.NoError(t, err)
+	assert.Len(t, history, 1)
+}
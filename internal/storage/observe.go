@@ -0,0 +1,24 @@
+package storage
+
+// SaveObserver is told about emails once a save of them is committed.
+type SaveObserver func(emails []*Email)
+
+// OnSave registers fn to be called after each SaveEmail and SaveEmailBatch
+// commits, with the saved emails. It runs on the saving goroutine, so it
+// must return quickly and must not modify the emails. Saves made by other
+// processes using the same database aren't seen.
+func (s *Storage) OnSave(fn SaveObserver) {
+	s.observersMu.Lock()
+	defer s.observersMu.Unlock()
+	s.observers = append(s.observers, fn)
+}
+
+func (s *Storage) notifySaved(emails []*Email) {
+	s.observersMu.Lock()
+	observers := s.observers
+	s.observersMu.Unlock()
+
+	for _, fn := range observers {
+		fn(emails)
+	}
+}
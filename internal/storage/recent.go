@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migrateAddRecentIndex indexes emails by date across mailboxes for
+// ListRecent.
+func (s *Storage) migrateAddRecentIndex(tx *sql.Tx) error {
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_emails_recent ON emails(date)`); err != nil {
+		return fmt.Errorf("failed to create recent index: %w", err)
+	}
+	return nil
+}
+
+// ListRecent returns a page of the live emails of every mailbox, newest
+// date first. A message stored in several mailboxes, such as a Gmail
+// message in INBOX and All Mail, is listed once per mailbox.
+func (s *Storage) ListRecent(limit, offset int) ([]*Email, error) {
+	return s.ListRecentContext(context.Background(), limit, offset)
+}
+
+// ListRecentContext is like ListRecent but stops when ctx is done.
+func (s *Storage) ListRecentContext(ctx context.Context, limit, offset int) ([]*Email, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT mailbox, uid, subject, from_addr, to_addrs, date, size, flags, gmail_labels, synced, thread_id, from_name
+		FROM emails
+		WHERE deleted_at IS NULL
+		ORDER BY date DESC, mailbox, uid DESC
+		LIMIT ? OFFSET ?
+	`, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query recent emails: %w", err)
+	}
+	defer rows.Close()
+
+	return scanEmailList(rows)
+}
+
+// CountAllMessages counts the live emails of every mailbox, the emails
+// ListRecent pages through.
+func (s *Storage) CountAllMessages() (int, error) {
+	return s.CountAllMessagesContext(context.Background())
+}
+
+// CountAllMessagesContext is like CountAllMessages but stops when ctx is done.
+func (s *Storage) CountAllMessagesContext(ctx context.Context) (int, error) {
+	var count int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM emails WHERE deleted_at IS NULL`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count messages: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// DefaultWriteRetries is how many times a save is retried when the database
+// is busy, unless WithWriteRetries says otherwise.
+const DefaultWriteRetries = 3
+
+// writeRetryBackoff is the wait before the first retry of a busy save. It
+// doubles with each retry.
+const writeRetryBackoff = 50 * time.Millisecond
+
+// WithWriteRetries sets how many times SaveEmail and SaveEmailBatch run
+// their transaction again when SQLite reports the database busy or locked,
+// which the busy timeout doesn't cover when another connection wrote since
+// the transaction started reading. 0 disables retries; negative values are
+// ignored.
+func WithWriteRetries(n int) Option {
+	return func(s *Storage) {
+		if n >= 0 {
+			s.writeRetries = n
+		}
+	}
+}
+
+// commitTx is replaced in tests to simulate a busy database.
+var commitTx = (*sql.Tx).Commit
+
+// retryBusy runs write, which must run a whole transaction, until it
+// doesn't fail with a busy database or the retries run out.
+func (s *Storage) retryBusy(ctx context.Context, write func() error) error {
+	backoff := writeRetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := write()
+		if err == nil || attempt >= s.writeRetries || !isBusy(err) {
+			return err
+		}
+
+		// A busy COMMIT leaves SQLite's transaction open on the writer
+		// connection, which database/sql has released all the same.
+		s.db.ExecContext(ctx, "ROLLBACK")
+
+		s.log.WithError(err).Debugf("Database busy, retrying write in %v", backoff)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// isBusy reports whether err is SQLite's SQLITE_BUSY or SQLITE_LOCKED.
+func isBusy(err error) bool {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() & 0xff {
+		case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+			return true
+		}
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "database is locked") || strings.Contains(msg, "SQLITE_BUSY")
+}
@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/newsamples/imapsync/internal/mailparse"
+)
+
+// initSearchIndex creates the FTS5 index over subject, sender, recipients and
+// body text. Rows in emails_fts are keyed by email_fts_docs.docid, which is an
+// INTEGER PRIMARY KEY and therefore stable across VACUUM (unlike the implicit
+// rowid of the emails table). When the index is created for an existing
+// database, it is backfilled from the stored emails.
+func (s *Storage) initSearchIndex(tx *sql.Tx) error {
+	var exists int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'emails_fts'`).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check search index: %w", err)
+	}
+
+	schema := `
+	CREATE TABLE IF NOT EXISTS email_fts_docs (
+		docid INTEGER PRIMARY KEY AUTOINCREMENT,
+		mailbox TEXT NOT NULL,
+		uid INTEGER NOT NULL,
+		UNIQUE (mailbox, uid)
+	);
+
+	CREATE VIRTUAL TABLE IF NOT EXISTS emails_fts USING fts5(
+		subject,
+		from_addr,
+		to_addrs,
+		body,
+		tokenize = 'unicode61 remove_diacritics 2'
+	);
+
+	CREATE TRIGGER IF NOT EXISTS emails_fts_delete AFTER DELETE ON emails BEGIN
+		DELETE FROM emails_fts WHERE rowid = (
+			SELECT docid FROM email_fts_docs WHERE mailbox = old.mailbox AND uid = old.uid
+		);
+		DELETE FROM email_fts_docs WHERE mailbox = old.mailbox AND uid = old.uid;
+	END;
+	`
+
+	if _, err := tx.Exec(schema); err != nil {
+		return fmt.Errorf("failed to create search index: %w", err)
+	}
+
+	if exists == 0 {
+		return s.rebuildSearchIndex(tx)
+	}
+	return nil
+}
+
+// rebuildSearchIndex indexes every stored email. It is used to backfill the
+// index for databases created before full-text search existed.
+func (s *Storage) rebuildSearchIndex(tx *sql.Tx) error {
+	rows, err := tx.Query(`
+		SELECT e.mailbox, e.uid, e.subject, e.from_addr, e.to_addrs, c.body, c.raw_message
+		FROM emails e
+		LEFT JOIN email_content c ON e.mailbox = c.mailbox AND e.uid = c.uid
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to query emails for indexing: %w", err)
+	}
+
+	var emails []*Email
+	for rows.Next() {
+		var email Email
+		var subject, from, toJSON sql.NullString
+		var compressedBody, compressedRawMessage []byte
+
+		if err := rows.Scan(&email.Mailbox, &email.UID, &subject, &from, &toJSON, &compressedBody, &compressedRawMessage); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan email for indexing: %w", err)
+		}
+
+		email.Subject = subject.String
+		email.From = from.String
+		if toJSON.Valid && toJSON.String != "" {
+			_ = json.Unmarshal([]byte(toJSON.String), &email.To)
+		}
+
+		// Undecodable content is indexed by metadata only.
+		email.Body, _ = s.decodeContent(compressedBody)
+		email.RawMessage, _ = s.decodeContent(compressedRawMessage)
+
+		emails = append(emails, &email)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return fmt.Errorf("error iterating emails for indexing: %w", err)
+	}
+	rows.Close()
+
+	if len(emails) == 0 {
+		return nil
+	}
+
+	s.log.Infof("Building search index for %d emails", len(emails))
+
+	for _, email := range emails {
+		if err := s.indexEmail(tx, email); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// indexEmail writes the search index entry for an email inside the caller's
+// transaction, replacing any previous entry for the same mailbox and UID.
+func (s *Storage) indexEmail(tx *sql.Tx, email *Email) error {
+	if _, err := tx.Exec(
+		`INSERT OR IGNORE INTO email_fts_docs (mailbox, uid) VALUES (?, ?)`,
+		email.Mailbox, email.UID,
+	); err != nil {
+		return fmt.Errorf("failed to register search document: %w", err)
+	}
+
+	var docID int64
+	if err := tx.QueryRow(
+		`SELECT docid FROM email_fts_docs WHERE mailbox = ? AND uid = ?`,
+		email.Mailbox, email.UID,
+	).Scan(&docID); err != nil {
+		return fmt.Errorf("failed to look up search document: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM emails_fts WHERE rowid = ?`, docID); err != nil {
+		return fmt.Errorf("failed to clear search index entry: %w", err)
+	}
+
+	raw := email.RawMessage
+	if len(raw) == 0 {
+		raw = email.Body
+	}
+	// The index stores what it indexes, so it would give encrypted bodies
+	// away.
+	var body string
+	if s.aead == nil {
+		body = mailparse.Text(raw)
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO emails_fts (rowid, subject, from_addr, to_addrs, body) VALUES (?, ?, ?, ?, ?)`,
+		docID,
+		email.Subject,
+		strings.TrimSpace(email.FromName+" "+email.From),
+		strings.Join(slices.Concat(email.To, email.Cc, email.Bcc), " "),
+		body,
+	); err != nil {
+		return fmt.Errorf("failed to index email: %w", err)
+	}
+
+	return nil
+}
+
+// SearchEmails runs a full-text query over subject, sender, recipients and body
+// text, returning matches ordered by relevance. Each whitespace-separated word
+// in query must match. An empty mailbox searches across all mailboxes.
+func (s *Storage) SearchEmails(query string, mailbox string, limit, offset int) ([]*Email, error) {
+	match := buildMatchQuery(query)
+	if match == "" {
+		return nil, nil
+	}
+
+	rows, err := s.db.Query(`
+		SELECT e.mailbox, e.uid, e.subject, e.from_addr, e.to_addrs, e.date, e.size, e.flags, e.gmail_labels, e.synced, e.thread_id, e.from_name
+		FROM emails_fts f
+		JOIN email_fts_docs d ON d.docid = f.rowid
+		JOIN emails e ON e.mailbox = d.mailbox AND e.uid = d.uid
+		WHERE emails_fts MATCH ? AND (? = '' OR e.mailbox = ?) AND e.deleted_at IS NULL
+		ORDER BY f.rank
+		LIMIT ? OFFSET ?
+	`, match, mailbox, mailbox, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search emails: %w", err)
+	}
+	defer rows.Close()
+
+	return scanEmailList(rows)
+}
+
+// CountSearchResults returns the total number of emails SearchEmails would
+// match for the same query and mailbox, ignoring pagination.
+func (s *Storage) CountSearchResults(query string, mailbox string) (int, error) {
+	match := buildMatchQuery(query)
+	if match == "" {
+		return 0, nil
+	}
+
+	var count int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*)
+		FROM emails_fts f
+		JOIN email_fts_docs d ON d.docid = f.rowid
+		JOIN emails e ON e.mailbox = d.mailbox AND e.uid = d.uid
+		WHERE emails_fts MATCH ? AND (? = '' OR e.mailbox = ?) AND e.deleted_at IS NULL
+	`, match, mailbox, mailbox).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count search results: %w", err)
+	}
+
+	return count, nil
+}
+
+// buildMatchQuery turns free text into an FTS5 query where every word is a
+// quoted term, so user input can't produce FTS syntax errors.
+func buildMatchQuery(query string) string {
+	terms := strings.Fields(query)
+	for i, term := range terms {
+		terms[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	}
+	return strings.Join(terms, " ")
+}
@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSearchTestStorage(t *testing.T) *Storage {
+	t.Helper()
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func searchUIDs(t *testing.T, s *Storage, query, mailbox string) []uint32 {
+	t.Helper()
+	emails, err := s.SearchEmails(query, mailbox, 50, 0)
+	require.NoError(t, err)
+	uids := make([]uint32, 0, len(emails))
+	for _, e := range emails {
+		uids = append(uids, e.UID)
+	}
+	return uids
+}
+
+func TestSearchEmails(t *testing.T) {
+	s := newSearchTestStorage(t)
+
+	require.NoError(t, s.SaveEmail(&Email{
+		UID:        1,
+		Mailbox:    "INBOX",
+		Subject:    "Quarterly report",
+		From:       "boss@example.com",
+		To:         []string{"me@example.com"},
+		Date:       time.Now(),
+		RawMessage: []byte("From: boss@example.com\r\nSubject: Quarterly report\r\nContent-Type: text/plain\r\n\r\nPlease review the budget spreadsheet."),
+	}))
+	require.NoError(t, s.SaveEmailBatch([]*Email{
+		{
+			UID:        2,
+			Mailbox:    "INBOX",
+			Subject:    "Dinner",
+			From:       "friend@example.com",
+			To:         []string{"me@example.com"},
+			Date:       time.Now(),
+			RawMessage: []byte("From: friend@example.com\r\nSubject: Dinner\r\nContent-Type: text/html\r\n\r\n<html><body><p>Pizza at <b>eight</b>?</p><script>budget()</script></body></html>"),
+		},
+		{
+			UID:        3,
+			Mailbox:    "Sent",
+			Subject:    "Re: Quarterly report",
+			From:       "me@example.com",
+			To:         []string{"boss@example.com"},
+			Date:       time.Now(),
+			RawMessage: []byte("From: me@example.com\r\nSubject: Re: Quarterly report\r\nContent-Transfer-Encoding: base64\r\n\r\nVGhlIGJ1ZGdldCBsb29rcyBmaW5lLg==\r\n"),
+		},
+	}))
+
+	t.Run("finds by body word", func(t *testing.T) {
+		assert.ElementsMatch(t, []uint32{1, 3}, searchUIDs(t, s, "budget", ""))
+	})
+
+	t.Run("case insensitive", func(t *testing.T) {
+		assert.ElementsMatch(t, []uint32{1, 3}, searchUIDs(t, s, "BUDGET", ""))
+	})
+
+	t.Run("restricted to mailbox", func(t *testing.T) {
+		assert.Equal(t, []uint32{1}, searchUIDs(t, s, "budget", "INBOX"))
+	})
+
+	t.Run("html is stripped to text", func(t *testing.T) {
+		assert.Equal(t, []uint32{2}, searchUIDs(t, s, "pizza eight", ""))
+		assert.Empty(t, searchUIDs(t, s, "script", ""))
+	})
+
+	t.Run("matches subject and sender", func(t *testing.T) {
+		assert.ElementsMatch(t, []uint32{1, 3}, searchUIDs(t, s, "quarterly", ""))
+		assert.Equal(t, []uint32{2}, searchUIDs(t, s, "friend", ""))
+	})
+
+	t.Run("all words must match", func(t *testing.T) {
+		assert.Equal(t, []uint32{3}, searchUIDs(t, s, "budget fine", ""))
+	})
+
+	t.Run("fts syntax is treated as text", func(t *testing.T) {
+		assert.Empty(t, searchUIDs(t, s, `"unbalanced AND (`, ""))
+	})
+
+	t.Run("empty query", func(t *testing.T) {
+		assert.Empty(t, searchUIDs(t, s, "   ", ""))
+	})
+
+	t.Run("count results", func(t *testing.T) {
+		count, err := s.CountSearchResults("budget", "")
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+
+		count, err = s.CountSearchResults("budget", "Sent")
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+
+		count, err = s.CountSearchResults("", "")
+		require.NoError(t, err)
+		assert.Equal(t, 0, count)
+	})
+
+	t.Run("limit and offset", func(t *testing.T) {
+		first, err := s.SearchEmails("budget", "", 1, 0)
+		require.NoError(t, err)
+		second, err := s.SearchEmails("budget", "", 1, 1)
+		require.NoError(t, err)
+		require.Len(t, first, 1)
+		require.Len(t, second, 1)
+		assert.NotEqual(t, first[0].UID, second[0].UID)
+	})
+}
+
+func TestSearchEmails_IndexFollowsChanges(t *testing.T) {
+	s := newSearchTestStorage(t)
+
+	require.NoError(t, s.SaveEmail(&Email{UID: 1, Mailbox: "INBOX", Subject: "original wording"}))
+	require.NoError(t, s.SaveEmail(&Email{UID: 1, Mailbox: "INBOX", Subject: "replacement wording"}))
+
+	assert.Empty(t, searchUIDs(t, s, "original", ""))
+	assert.Equal(t, []uint32{1}, searchUIDs(t, s, "replacement", ""))
+
+	_, err := s.MarkDeleted("INBOX", []uint32{1}, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, searchUIDs(t, s, "replacement", ""), "soft-deleted emails are not returned")
+
+	_, err = s.PurgeDeletedBefore(time.Now())
+	require.NoError(t, err)
+
+	var count int
+	require.NoError(t, s.db.QueryRow(`SELECT COUNT(*) FROM emails_fts`).Scan(&count))
+	assert.Equal(t, 0, count, "purged emails are removed from the index")
+}
+
+func TestSearchEmails_BackfillsExistingDB(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	s, err := New(dbPath, log)
+	require.NoError(t, err)
+	require.NoError(t, s.SaveEmail(&Email{
+		UID:        7,
+		Mailbox:    "INBOX",
+		Subject:    "Old mail",
+		RawMessage: []byte("Subject: Old mail\r\n\r\nArchived invoice attached."),
+	}))
+
+	// Simulate a database created before the search index existed.
+	_, err = s.db.Exec(`DROP TRIGGER emails_fts_delete; DROP TABLE emails_fts; DROP TABLE email_fts_docs`)
+	require.NoError(t, err)
+	_, err = s.db.Exec(`DELETE FROM schema_migrations WHERE version >= 4`)
+	require.NoError(t, err)
+	s.Close()
+
+	s2, err := New(dbPath, log)
+	require.NoError(t, err)
+	defer s2.Close()
+
+	assert.Equal(t, []uint32{7}, searchUIDs(t, s2, "invoice", ""))
+}
+
+func TestSearchEmails_ClosedDB(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	s.Close()
+
+	_, err = s.SearchEmails("anything", "", 10, 0)
+	assert.Error(t, err)
+}
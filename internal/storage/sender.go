@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migrateAddFromIndex indexes emails by sender within a mailbox.
+func (s *Storage) migrateAddFromIndex(tx *sql.Tx) error {
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_emails_from ON emails(mailbox, from_addr)`); err != nil {
+		return fmt.Errorf("failed to create sender index: %w", err)
+	}
+	return nil
+}
+
+// ListEmailsFromSender returns a page of the live emails in mailbox whose
+// sender address contains fromSubstr, ignoring ASCII case, highest UID
+// first. An empty fromSubstr matches every email.
+func (s *Storage) ListEmailsFromSender(mailbox, fromSubstr string, limit, offset int) ([]*Email, error) {
+	return s.ListEmailsFromSenderContext(context.Background(), mailbox, fromSubstr, limit, offset)
+}
+
+// ListEmailsFromSenderContext is like ListEmailsFromSender but stops when ctx is done.
+func (s *Storage) ListEmailsFromSenderContext(ctx context.Context, mailbox, fromSubstr string, limit, offset int) ([]*Email, error) {
+	return s.ListEmailsFilteredContext(ctx, mailbox, FlagFilter{From: fromSubstr}, SortByUID, SortDesc, limit, offset)
+}
+
+// CountEmailsFromSender counts the emails ListEmailsFromSender pages through.
+func (s *Storage) CountEmailsFromSender(mailbox, fromSubstr string) (int, error) {
+	return s.CountEmailsFromSenderContext(context.Background(), mailbox, fromSubstr)
+}
+
+// CountEmailsFromSenderContext is like CountEmailsFromSender but stops when ctx is done.
+func (s *Storage) CountEmailsFromSenderContext(ctx context.Context, mailbox, fromSubstr string) (int, error) {
+	return s.CountMessagesFilteredContext(ctx, mailbox, FlagFilter{From: fromSubstr})
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListEmailsFromSender(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, s.SaveEmailBatch([]*Email{
+		{UID: 1, Mailbox: "INBOX", Date: time.Now(), From: "alice@example.com"},
+		{UID: 2, Mailbox: "INBOX", Date: time.Now(), From: "Bob@Example.com"},
+		{UID: 3, Mailbox: "INBOX", Date: time.Now(), From: "alice@example.org"},
+		{UID: 4, Mailbox: "INBOX", Date: time.Now(), From: "100%_off@shop.example"},
+		{UID: 5, Mailbox: "Sent", Date: time.Now(), From: "alice@example.com"},
+	}))
+
+	uids := func(from string) []uint32 {
+		emails, err := s.ListEmailsFromSender("INBOX", from, 10, 0)
+		require.NoError(t, err)
+		var result []uint32
+		for _, e := range emails {
+			result = append(result, e.UID)
+		}
+
+		count, err := s.CountEmailsFromSender("INBOX", from)
+		require.NoError(t, err)
+		assert.Equal(t, len(result), count)
+		return result
+	}
+
+	assert.Equal(t, []uint32{1}, uids("alice@example.com"), "exact address")
+	assert.Equal(t, []uint32{3, 1}, uids("alice"), "substring, highest UID first")
+	assert.Equal(t, []uint32{2, 1}, uids("EXAMPLE.COM"), "case-insensitive")
+	assert.Equal(t, []uint32{4}, uids("%_"), "wildcards match literally")
+	assert.Empty(t, uids("carol"))
+	assert.Equal(t, []uint32{4, 3, 2, 1}, uids(""))
+
+	page, err := s.ListEmailsFromSender("INBOX", "example", 1, 1)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, uint32(3), page[0].UID)
+	t.Run("with a flag filter", func(t *testing.T) {
+		require.NoError(t, s.UpdateFlags("INBOX", 3, []string{`\Seen`}))
+		filter := FlagFilter{Require: []string{`\Seen`}, From: "alice"}
+		emails, err := s.ListEmailsFiltered("INBOX", filter, SortByUID, SortDesc, 10, 0)
+		require.NoError(t, err)
+		require.Len(t, emails, 1)
+		assert.Equal(t, uint32(3), emails[0].UID)
+
+		count, err := s.CountMessagesFiltered("INBOX", filter)
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
+}
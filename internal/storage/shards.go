@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Shard is one database of a Shards set: the storage of an account.
+type Shard struct {
+	Name string
+	Path string
+	// Options are passed to New for this shard only, after the options
+	// common to every shard.
+	Options []Option
+}
+
+// ShardMailbox is a mailbox of a Shards set, named by the shard it is
+// stored in since two shards may both have an INBOX.
+type ShardMailbox struct {
+	Shard   string `json:"shard"`
+	Mailbox string `json:"mailbox"`
+}
+
+// Shards reads a backup that keeps each account in its own database file,
+// aggregating across every shard. Each account is still synced into its own
+// Storage; Shards only opens them read-only.
+type Shards struct {
+	names   []string
+	missing []string
+	stores  map[string]*Storage
+}
+
+// OpenShards opens the database of each shard read-only with New. Shard
+// names must be unique. A shard whose file doesn't exist, an account never
+// synced, is skipped and reported by Missing. When one fails to open, those
+// already opened are closed.
+func OpenShards(shards []Shard, log *logrus.Logger, options ...Option) (*Shards, error) {
+	s := &Shards{stores: make(map[string]*Storage, len(shards))}
+	seen := make(map[string]bool, len(shards))
+	for _, shard := range shards {
+		if seen[shard.Name] {
+			s.Close()
+			return nil, fmt.Errorf("duplicate shard %q", shard.Name)
+		}
+		seen[shard.Name] = true
+		if _, err := os.Stat(shard.Path); errors.Is(err, fs.ErrNotExist) {
+			s.missing = append(s.missing, shard.Name)
+			continue
+		}
+		opts := append(append([]Option{}, options...), shard.Options...)
+		store, err := New(shard.Path, log, append(opts, WithReadOnly(true))...)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("failed to open shard %s: %w", shard.Name, err)
+		}
+		s.names = append(s.names, shard.Name)
+		s.stores[shard.Name] = store
+	}
+	return s, nil
+}
+
+// Names returns the shard names in the order they were opened.
+func (s *Shards) Names() []string {
+	return append([]string(nil), s.names...)
+}
+
+// Missing returns the names of the shards skipped because their file
+// doesn't exist, in the order they were given.
+func (s *Shards) Missing() []string {
+	return append([]string(nil), s.missing...)
+}
+
+// Shard returns the storage of the named shard.
+func (s *Shards) Shard(name string) (*Storage, error) {
+	store, ok := s.stores[name]
+	if !ok {
+		return nil, fmt.Errorf("shard %q not found", name)
+	}
+	return store, nil
+}
+
+// ListMailboxes returns the mailboxes of every shard, by shard in the order
+// they were opened, then by name.
+func (s *Shards) ListMailboxes() ([]ShardMailbox, error) {
+	return s.ListMailboxesContext(context.Background())
+}
+
+// ListMailboxesContext is like ListMailboxes but stops when ctx is done.
+func (s *Shards) ListMailboxesContext(ctx context.Context) ([]ShardMailbox, error) {
+	var result []ShardMailbox
+	for _, name := range s.names {
+		mailboxes, err := s.stores[name].ListMailboxesContext(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("shard %s: %w", name, err)
+		}
+		for _, mailbox := range mailboxes {
+			result = append(result, ShardMailbox{Shard: name, Mailbox: mailbox})
+		}
+	}
+	return result, nil
+}
+
+// CountAllMessages counts the live emails of every shard.
+func (s *Shards) CountAllMessages() (int, error) {
+	return s.CountAllMessagesContext(context.Background())
+}
+
+// CountAllMessagesContext is like CountAllMessages but stops when ctx is done.
+func (s *Shards) CountAllMessagesContext(ctx context.Context) (int, error) {
+	var total int
+	for _, name := range s.names {
+		count, err := s.stores[name].CountAllMessagesContext(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("shard %s: %w", name, err)
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// Close closes every shard.
+func (s *Shards) Close() error {
+	var errs []error
+	for _, name := range s.names {
+		if err := s.stores[name].Close(); err != nil {
+			errs = append(errs, fmt.Errorf("shard %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
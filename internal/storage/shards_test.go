@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShards(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	dir := t.TempDir()
+	personal := filepath.Join(dir, "personal.db")
+	work := filepath.Join(dir, "work.db")
+
+	save := func(path string, emails ...*Email) {
+		store, err := New(path, log)
+		require.NoError(t, err)
+		defer store.Close()
+		require.NoError(t, store.SaveEmailBatch(emails))
+		for _, e := range emails {
+			require.NoError(t, store.SaveMailboxState(&MailboxState{Name: e.Mailbox, UIDValidity: 1, LastUID: e.UID}))
+		}
+	}
+	save(personal, &Email{UID: 1, Mailbox: "INBOX", Date: time.Now()}, &Email{UID: 1, Mailbox: "Travel", Date: time.Now()})
+	save(work, &Email{UID: 7, Mailbox: "INBOX", Date: time.Now()})
+
+	shards, err := OpenShards([]Shard{{Name: "personal", Path: personal}, {Name: "work", Path: work}}, log)
+	require.NoError(t, err)
+	defer shards.Close()
+	assert.Equal(t, []string{"personal", "work"}, shards.Names())
+	assert.Empty(t, shards.Missing())
+
+	t.Run("aggregates every shard", func(t *testing.T) {
+		mailboxes, err := shards.ListMailboxes()
+		require.NoError(t, err)
+		assert.Equal(t, []ShardMailbox{
+			{Shard: "personal", Mailbox: "INBOX"},
+			{Shard: "personal", Mailbox: "Travel"},
+			{Shard: "work", Mailbox: "INBOX"},
+		}, mailboxes)
+
+		count, err := shards.CountAllMessages()
+		require.NoError(t, err)
+		assert.Equal(t, 3, count)
+	})
+
+	t.Run("shards are read-only", func(t *testing.T) {
+		store, err := shards.Shard("work")
+		require.NoError(t, err)
+		uids, err := store.ListUIDs("INBOX")
+		require.NoError(t, err)
+		assert.Equal(t, []uint32{7}, uids)
+		assert.Error(t, store.SaveEmail(&Email{UID: 8, Mailbox: "INBOX"}))
+
+		_, err = shards.Shard("missing")
+		assert.ErrorContains(t, err, `shard "missing" not found`)
+	})
+
+	t.Run("missing files are skipped", func(t *testing.T) {
+		missing := filepath.Join(dir, "missing.db")
+		shards, err := OpenShards([]Shard{{Name: "missing", Path: missing}, {Name: "work", Path: work}}, log)
+		require.NoError(t, err)
+		defer shards.Close()
+		assert.Equal(t, []string{"work"}, shards.Names())
+		assert.Equal(t, []string{"missing"}, shards.Missing())
+		assert.NoFileExists(t, missing)
+
+		count, err := shards.CountAllMessages()
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("open failure", func(t *testing.T) {
+		_, err := OpenShards([]Shard{{Name: "a", Path: personal}, {Name: "a", Path: work}}, log)
+		assert.ErrorContains(t, err, `duplicate shard "a"`)
+
+		_, err = OpenShards([]Shard{{Name: "personal", Path: personal}, {Name: "dir", Path: dir}}, log)
+		assert.ErrorContains(t, err, "failed to open shard dir")
+	})
+}
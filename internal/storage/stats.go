@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// StorageStats summarizes the contents of the backup. Soft-deleted emails are
+// not counted.
+type StorageStats struct {
+	Messages  int            `json:"messages"`
+	Mailboxes []MailboxStats `json:"mailboxes"`
+
+	// DatabaseSize is the size of the main database file in bytes, excluding
+	// any WAL not yet checkpointed.
+	DatabaseSize int64 `json:"database_size"`
+	// MessageSize is the total RFC822 size reported by the server, which is
+	// roughly what the messages take uncompressed.
+	MessageSize int64 `json:"message_size"`
+	// CompressedSize is the total size of the stored compressed content.
+	CompressedSize int64 `json:"compressed_size"`
+
+	// Oldest and Newest are the bounds of the message dates, nil when no
+	// message has a date.
+	Oldest *time.Time `json:"oldest,omitempty"`
+	Newest *time.Time `json:"newest,omitempty"`
+}
+
+type MailboxStats struct {
+	Name     string `json:"name"`
+	Messages int    `json:"messages"`
+	Size     int64  `json:"size"`
+}
+
+// Stats aggregates message counts, sizes and date bounds across all mailboxes.
+func (s *Storage) Stats() (*StorageStats, error) {
+	return s.StatsContext(context.Background())
+}
+
+// StatsContext is like Stats but stops when ctx is done.
+func (s *Storage) StatsContext(ctx context.Context) (*StorageStats, error) {
+	stats := &StorageStats{Mailboxes: []MailboxStats{}}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT mailbox, COUNT(*), COALESCE(SUM(size), 0)
+		FROM emails
+		WHERE deleted_at IS NULL
+		GROUP BY mailbox
+		ORDER BY mailbox ASC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query mailbox stats: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m MailboxStats
+		if err := rows.Scan(&m.Name, &m.Messages, &m.Size); err != nil {
+			return nil, fmt.Errorf("failed to scan mailbox stats: %w", err)
+		}
+		stats.Mailboxes = append(stats.Mailboxes, m)
+		stats.Messages += m.Messages
+		stats.MessageSize += m.Size
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating mailbox stats: %w", err)
+	}
+
+	err = s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(COALESCE(LENGTH(c.body), 0) + COALESCE(LENGTH(c.headers), 0) + COALESCE(LENGTH(c.raw_message), 0)), 0)
+		FROM email_content c
+		JOIN emails e ON e.mailbox = c.mailbox AND e.uid = c.uid
+		WHERE e.deleted_at IS NULL
+	`).Scan(&stats.CompressedSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query content size: %w", err)
+	}
+
+	// Undated messages are stored with date 0 and would skew the bounds.
+	var oldest, newest sql.NullInt64
+	err = s.db.QueryRowContext(ctx, `
+		SELECT MIN(date), MAX(date)
+		FROM emails
+		WHERE deleted_at IS NULL AND date > 0
+	`).Scan(&oldest, &newest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query date bounds: %w", err)
+	}
+	if oldest.Valid {
+		t := time.Unix(oldest.Int64, 0)
+		stats.Oldest = &t
+	}
+	if newest.Valid {
+		t := time.Unix(newest.Int64, 0)
+		stats.Newest = &t
+	}
+
+	if stats.DatabaseSize, err = s.databaseSize(); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// databaseSize returns the size of the main database file in bytes.
+func (s *Storage) databaseSize() (int64, error) {
+	var size int64
+	if err := s.db.QueryRow(
+		`SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()`,
+	).Scan(&size); err != nil {
+		return 0, fmt.Errorf("failed to query database size: %w", err)
+	}
+	return size, nil
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer s.Close()
+
+	t.Run("empty", func(t *testing.T) {
+		stats, err := s.Stats()
+		require.NoError(t, err)
+		assert.Equal(t, 0, stats.Messages)
+		assert.Empty(t, stats.Mailboxes)
+		assert.Nil(t, stats.Oldest)
+		assert.Nil(t, stats.Newest)
+		assert.Positive(t, stats.DatabaseSize)
+	})
+
+	oldest := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	newest := time.Date(2024, 1, 15, 8, 30, 0, 0, time.UTC)
+	raw := []byte("Subject: stats\r\n\r\nSome body text that gets compressed.")
+
+	require.NoError(t, s.SaveEmailBatch([]*Email{
+		{UID: 1, Mailbox: "INBOX", Date: oldest, Size: 100, Flags: []string{}, RawMessage: raw},
+		{UID: 2, Mailbox: "INBOX", Date: newest, Size: 200, Flags: []string{}, RawMessage: raw},
+		{UID: 3, Mailbox: "INBOX", Size: 50, Flags: []string{}},
+		{UID: 1, Mailbox: "Sent", Date: oldest.Add(time.Hour), Size: 300, Flags: []string{}, RawMessage: raw},
+		{UID: 2, Mailbox: "Sent", Date: oldest.Add(-time.Hour), Size: 1000, Flags: []string{}, RawMessage: raw},
+	}))
+	_, err = s.MarkDeleted("Sent", []uint32{2}, time.Now())
+	require.NoError(t, err)
+
+	stats, err := s.Stats()
+	require.NoError(t, err)
+
+	assert.Equal(t, 4, stats.Messages)
+	assert.Equal(t, []MailboxStats{
+		{Name: "INBOX", Messages: 3, Size: 350},
+		{Name: "Sent", Messages: 1, Size: 300},
+	}, stats.Mailboxes)
+	assert.Equal(t, int64(650), stats.MessageSize)
+	assert.Positive(t, stats.CompressedSize)
+
+	require.NotNil(t, stats.Oldest)
+	require.NotNil(t, stats.Newest)
+	assert.True(t, oldest.Equal(*stats.Oldest), "undated and soft-deleted emails don't count: %v", stats.Oldest)
+	assert.True(t, newest.Equal(*stats.Newest))
+
+	t.Run("closed db", func(t *testing.T) {
+		s.Close()
+		_, err := s.Stats()
+		assert.Error(t, err)
+	})
+}
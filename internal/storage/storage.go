@@ -1,31 +1,61 @@
+// Package storage keeps synced emails in a SQLite database. Syncs write
+// through New; other tools can read a backup with Open, which is safe to use
+// while a sync is writing to the same file.
 package storage
 
 import (
 	"bytes"
-	"compress/gzip"
+	"context"
+	"crypto/cipher"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	_ "modernc.org/sqlite" // sqlite driver
 )
 
+const (
+	// busyTimeoutMillis is how long a connection waits for a lock held by
+	// another connection before failing with SQLITE_BUSY.
+	busyTimeoutMillis = 5000
+	// readOnlyMaxConns is the connection pool size for read-only handles.
+	readOnlyMaxConns = 8
+)
+
+// Storage is a handle on a backup database. It is safe for concurrent use.
 type Storage struct {
-	db       *sql.DB
-	log      *logrus.Logger
-	readOnly bool
+	db          *sql.DB
+	log         *logrus.Logger
+	readOnly    bool
+	compression Compression
+	passphrase  string
+	aead        cipher.AEAD // nil unless content is encrypted
+
+	writeRetries int
+
+	observersMu sync.Mutex
+	observers   []SaveObserver
 }
 
+// Email is a stored message. Listing methods leave Body, Headers and
+// RawMessage empty; GetEmail fills them in. The body is part of RawMessage,
+// so syncs leave Body empty; it is only set on messages saved without one.
 type Email struct {
 	UID         uint32     `json:"uid"`
 	Mailbox     string     `json:"mailbox"`
 	Subject     string     `json:"subject"`
 	From        string     `json:"from"`
+	FromName    string     `json:"from_name,omitempty"`
 	To          []string   `json:"to"`
+	Cc          []string   `json:"cc,omitempty"`
+	Bcc         []string   `json:"bcc,omitempty"`
 	Date        time.Time  `json:"date"`
 	Size        uint32     `json:"size"`
 	Flags       []string   `json:"flags"`
@@ -35,8 +65,20 @@ type Email struct {
 	RawMessage  []byte     `json:"raw_message"`
 	Synced      time.Time  `json:"synced"`
 	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	// InternalDate is when the server received the message (IMAP
+	// INTERNALDATE), kept alongside the envelope Date.
+	InternalDate *time.Time `json:"internal_date,omitempty"`
+	// ThreadID is the Message-ID of the first message of the conversation,
+	// derived from References and In-Reply-To when the email is saved. On
+	// Gmail it is the decimal X-GM-THRID instead.
+	ThreadID string `json:"thread_id,omitempty"`
+	// GmailMsgID and GmailThreadID are Gmail's X-GM-MSGID and X-GM-THRID,
+	// or 0 for other servers.
+	GmailMsgID    uint64 `json:"gmail_msgid,omitempty,string"`
+	GmailThreadID uint64 `json:"gmail_thrid,omitempty,string"`
 }
 
+// MailboxState records how far a mailbox has been synced.
 type MailboxState struct {
 	Name        string    `json:"name"`
 	UIDValidity uint32    `json:"uid_validity"`
@@ -44,8 +86,11 @@ type MailboxState struct {
 	LastSync    time.Time `json:"last_sync"`
 }
 
+// Option configures New.
 type Option func(*Storage)
 
+// WithReadOnly opens the database without write access or migrations. The
+// schema must already be at the version this package writes.
 func WithReadOnly(readOnly bool) Option {
 	return func(s *Storage) {
 		if readOnly {
@@ -54,16 +99,25 @@ func WithReadOnly(readOnly bool) Option {
 	}
 }
 
+// New opens the database at path, creating and migrating it unless it is
+// opened read-only. A nil log discards the package's log output.
 func New(path string, log *logrus.Logger, options ...Option) (*Storage, error) {
-	s := &Storage{log: log, readOnly: false}
+	if log == nil {
+		log = logrus.New()
+		log.SetOutput(io.Discard)
+	}
+	s := &Storage{log: log, readOnly: false, compression: CompressionGzip, writeRetries: DefaultWriteRetries}
 
 	for _, option := range options {
 		option(s)
 	}
 
-	dsn := path
+	// Pragmas go in the DSN so they apply to every pooled connection;
+	// busy_timeout in particular is per connection. journal_mode=WAL is
+	// persisted in the file, so only the writer needs to set it.
+	dsn := fmt.Sprintf("%s?_pragma=busy_timeout(%d)&_pragma=journal_mode(WAL)", path, busyTimeoutMillis)
 	if s.readOnly {
-		dsn = fmt.Sprintf("file:%s?mode=ro", path)
+		dsn = fmt.Sprintf("file:%s?mode=ro&_pragma=busy_timeout(%d)", path, busyTimeoutMillis)
 	}
 
 	db, err := sql.Open("sqlite", dsn)
@@ -71,7 +125,14 @@ func New(path string, log *logrus.Logger, options ...Option) (*Storage, error) {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	db.SetMaxOpenConns(1)
+	// WAL lets readers run alongside the single writer, so read-only handles
+	// (the web server) can serve requests concurrently. Writers stay on one
+	// connection to avoid "database is locked" between their own statements.
+	if s.readOnly {
+		db.SetMaxOpenConns(readOnlyMaxConns)
+	} else {
+		db.SetMaxOpenConns(1)
+	}
 
 	if err := db.Ping(); err != nil {
 		db.Close()
@@ -80,17 +141,147 @@ func New(path string, log *logrus.Logger, options ...Option) (*Storage, error) {
 
 	s.db = db
 
-	if !s.readOnly {
-		if err := s.initSchema(); err != nil {
+	if s.readOnly {
+		if err := s.checkSchemaVersion(); err != nil {
 			db.Close()
-			return nil, fmt.Errorf("failed to initialize schema: %w", err)
+			return nil, err
 		}
+	} else if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize schema: %w", err)
+	}
+
+	if err := s.setupEncryption(); err != nil {
+		db.Close()
+		return nil, err
 	}
 
 	return s, nil
 }
 
-func (s *Storage) initSchema() error {
+// Open opens an existing backup read-only, for reading it from other tools.
+// Nothing is logged.
+func Open(path string) (*Storage, error) {
+	return New(path, nil, WithReadOnly(true))
+}
+
+// migrations are the ordered schema migration steps; step i upgrades the
+// database to version i+1. Steps must tolerate databases created before
+// versioning existed (version 0), which may already contain some of their
+// changes. Only append to this list: never reorder or edit released steps.
+var migrations = []func(s *Storage, tx *sql.Tx) error{
+	(*Storage).migrateInitialSchema,
+	(*Storage).migrateAddDeletedAt,
+	(*Storage).migrateAddGmailLabels,
+	(*Storage).migrateAddSearchIndex,
+	(*Storage).migrateAddAttachments,
+	(*Storage).migrateAddMessageID,
+	(*Storage).migrateAddInternalDate,
+	(*Storage).migrateAddDateIndex,
+	(*Storage).migrateAddThreadID,
+	(*Storage).migrateAddFromName,
+	(*Storage).migrateAddCcBcc,
+	(*Storage).migrateAddGmailIDs,
+	(*Storage).migrateAddPruned,
+	(*Storage).migrateAddSyncHistory,
+	(*Storage).migrateAddSettings,
+	(*Storage).migrateDropDuplicateBodies,
+	(*Storage).migrateAddArrivalIndex,
+	(*Storage).migrateAddRecentIndex,
+	(*Storage).migrateAddFromIndex,
+}
+
+// latestSchemaVersion is the schema version this binary writes.
+var latestSchemaVersion = len(migrations)
+
+// migrate applies every migration step above the database's current version,
+// each in its own transaction together with its schema_migrations record.
+func (s *Storage) migrate() error {
+	if _, err := s.db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at INTEGER NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	if current > latestSchemaVersion {
+		return fmt.Errorf("database schema version %d is newer than supported version %d; upgrade imapsync", current, latestSchemaVersion)
+	}
+
+	for version := current + 1; version <= latestSchemaVersion; version++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+
+		if err := migrations[version-1](s, tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d failed: %w", version, err)
+		}
+
+		if _, err := tx.Exec(
+			`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`,
+			version, time.Now().Unix(),
+		); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %d: %w", version, err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %w", version, err)
+		}
+
+		s.log.Debugf("Applied schema migration %d", version)
+	}
+
+	return nil
+}
+
+// checkSchemaVersion is used for read-only opens, which can't migrate. It
+// refuses databases written by a newer binary.
+func (s *Storage) checkSchemaVersion() error {
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	if current > latestSchemaVersion {
+		return fmt.Errorf("database schema version %d is newer than supported version %d; upgrade imapsync", current, latestSchemaVersion)
+	}
+	if current < latestSchemaVersion {
+		s.log.Warnf("Database schema version %d is older than %d; run sync once to upgrade it", current, latestSchemaVersion)
+	}
+
+	return nil
+}
+
+// SchemaVersion returns the highest applied migration version, or 0 for
+// databases created before schema versioning.
+func (s *Storage) SchemaVersion() (int, error) {
+	var exists int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`).Scan(&exists)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check schema version: %w", err)
+	}
+	if exists == 0 {
+		return 0, nil
+	}
+
+	var version int
+	if err := s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// migrateInitialSchema creates the original tables.
+func (s *Storage) migrateInitialSchema(tx *sql.Tx) error {
 	schema := `
 	CREATE TABLE IF NOT EXISTS emails (
 		mailbox TEXT NOT NULL,
@@ -101,9 +292,7 @@ func (s *Storage) initSchema() error {
 		date INTEGER,
 		size INTEGER,
 		flags TEXT,
-		gmail_labels TEXT,
 		synced INTEGER,
-		deleted_at INTEGER,
 		PRIMARY KEY (mailbox, uid)
 	);
 
@@ -128,75 +317,249 @@ func (s *Storage) initSchema() error {
 	);
 	`
 
-	if _, err := s.db.Exec(schema); err != nil {
-		return err
+	if _, err := tx.Exec(schema); err != nil {
+		return fmt.Errorf("failed to create tables: %w", err)
 	}
-
-	return s.migrateAddDeletedAt()
+	return nil
 }
 
 // migrateAddDeletedAt adds the deleted_at column to older DBs that predate it,
 // then ensures the supporting index exists.
-func (s *Storage) migrateAddDeletedAt() error {
-	var hasCol int
-	err := s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('emails') WHERE name = 'deleted_at'`).Scan(&hasCol)
-	if err != nil {
-		return fmt.Errorf("failed to check deleted_at column: %w", err)
-	}
-	if hasCol == 0 {
-		if _, err := s.db.Exec(`ALTER TABLE emails ADD COLUMN deleted_at INTEGER`); err != nil {
-			return fmt.Errorf("failed to add deleted_at column: %w", err)
-		}
+func (s *Storage) migrateAddDeletedAt(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "emails", "deleted_at", "INTEGER"); err != nil {
+		return err
 	}
-	if _, err := s.db.Exec(`CREATE INDEX IF NOT EXISTS idx_emails_deleted_at ON emails(deleted_at)`); err != nil {
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_emails_deleted_at ON emails(deleted_at)`); err != nil {
 		return fmt.Errorf("failed to create deleted_at index: %w", err)
 	}
 	return nil
 }
 
-func (s *Storage) Close() error {
-	return s.db.Close()
+// migrateAddGmailLabels adds the gmail_labels column to older DBs that predate it.
+func (s *Storage) migrateAddGmailLabels(tx *sql.Tx) error {
+	return addColumnIfMissing(tx, "emails", "gmail_labels", "TEXT")
+}
+
+// migrateAddSearchIndex creates the full-text search index.
+func (s *Storage) migrateAddSearchIndex(tx *sql.Tx) error {
+	return s.initSearchIndex(tx)
+}
+
+// migrateAddInternalDate adds the internal_date column. Older rows keep NULL,
+// as their INTERNALDATE was never fetched.
+func (s *Storage) migrateAddInternalDate(tx *sql.Tx) error {
+	return addColumnIfMissing(tx, "emails", "internal_date", "INTEGER")
+}
+
+// migrateAddDateIndex indexes emails by date within a mailbox for sorted
+// listings.
+func (s *Storage) migrateAddDateIndex(tx *sql.Tx) error {
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_emails_mailbox_date ON emails(mailbox, date)`); err != nil {
+		return fmt.Errorf("failed to create date index: %w", err)
+	}
+	return nil
 }
 
-func compressData(data []byte) ([]byte, error) {
-	if len(data) == 0 {
-		return data, nil
+// migrateAddFromName adds the sender display name column. Older rows keep
+// NULL until they are synced again.
+func (s *Storage) migrateAddFromName(tx *sql.Tx) error {
+	return addColumnIfMissing(tx, "emails", "from_name", "TEXT")
+}
+
+// migrateAddCcBcc adds the JSON-encoded CC and BCC recipient columns.
+func (s *Storage) migrateAddCcBcc(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "emails", "cc_addrs", "TEXT"); err != nil {
+		return err
 	}
+	return addColumnIfMissing(tx, "emails", "bcc_addrs", "TEXT")
+}
 
-	var buf bytes.Buffer
-	writer := gzip.NewWriter(&buf)
+// migrateAddGmailIDs adds the Gmail message and thread ID columns.
+func (s *Storage) migrateAddGmailIDs(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "emails", "gmail_msgid", "INTEGER"); err != nil {
+		return err
+	}
+	return addColumnIfMissing(tx, "emails", "gmail_thrid", "INTEGER")
+}
 
-	if _, err := writer.Write(data); err != nil {
-		return nil, fmt.Errorf("failed to write compressed data: %w", err)
+// migrateAddPruned adds the table recording which UIDs prune removed, so
+// syncs don't download them again. Rows carry the mailbox's UIDVALIDITY at
+// the time and stop counting once it changes.
+func (s *Storage) migrateAddPruned(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS pruned (
+			mailbox TEXT NOT NULL,
+			uid INTEGER NOT NULL,
+			uid_validity INTEGER NOT NULL,
+			PRIMARY KEY (mailbox, uid)
+		)
+	`); err != nil {
+		return fmt.Errorf("failed to create pruned table: %w", err)
 	}
+	return nil
+}
 
-	if err := writer.Close(); err != nil {
-		return nil, fmt.Errorf("failed to close gzip writer: %w", err)
+// migrateDropDuplicateBodies clears the bodies older versions stored as a
+// copy of the raw message. Encoding the same content gives the same bytes
+// unless it is encrypted, so encrypted copies are kept.
+func (s *Storage) migrateDropDuplicateBodies(tx *sql.Tx) error {
+	if _, err := tx.Exec(`UPDATE email_content SET body = NULL WHERE body = raw_message`); err != nil {
+		return fmt.Errorf("failed to drop duplicate bodies: %w", err)
 	}
+	return nil
+}
 
-	return buf.Bytes(), nil
+// migrateAddArrivalIndex indexes emails by arrival within a mailbox for
+// listings sorted by SortByArrival. The expression must match its entry in
+// sortColumns for SQLite to use the index.
+func (s *Storage) migrateAddArrivalIndex(tx *sql.Tx) error {
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_emails_date ON emails(mailbox, COALESCE(internal_date, date))`); err != nil {
+		return fmt.Errorf("failed to create arrival index: %w", err)
+	}
+	return nil
 }
 
-func decompressData(data []byte) ([]byte, error) {
-	if len(data) == 0 {
-		return data, nil
+// distinctBody returns the body of email to store, which is nothing when
+// it is a copy of the raw message.
+func distinctBody(email *Email) []byte {
+	if bytes.Equal(email.Body, email.RawMessage) {
+		return nil
 	}
+	return email.Body
+}
 
-	reader, err := gzip.NewReader(bytes.NewReader(data))
+// addColumnIfMissing runs ALTER TABLE ADD COLUMN unless the column already exists.
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	var hasCol int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&hasCol)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+		return fmt.Errorf("failed to check %s column: %w", column, err)
+	}
+	if hasCol > 0 {
+		return nil
 	}
-	defer reader.Close()
+	if _, err := tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add %s column: %w", column, err)
+	}
+	return nil
+}
 
-	result, err := io.ReadAll(reader)
+// forEachStoredContent calls fn with the decoded headers and raw message of
+// each email that query, selecting mailbox and uid, returns, in its order.
+// Content is loaded one email at a time to keep memory bounded, and what
+// names the pass in errors and the log. Undecodable content is passed as
+// nil.
+func (s *Storage) forEachStoredContent(tx *sql.Tx, query, what string, fn func(email *Email) error) error {
+	rows, err := tx.Query(query)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read decompressed data: %w", err)
+		return fmt.Errorf("failed to query emails for %s: %w", what, err)
 	}
 
-	return result, nil
+	var refs []EmailRef
+	for rows.Next() {
+		var r EmailRef
+		if err := rows.Scan(&r.Mailbox, &r.UID); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan email for %s: %w", what, err)
+		}
+		refs = append(refs, r)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return fmt.Errorf("error iterating emails for %s: %w", what, err)
+	}
+	rows.Close()
+
+	if len(refs) == 0 {
+		return nil
+	}
+
+	s.log.Infof("Backfilling %s for %d emails", what, len(refs))
+
+	for _, r := range refs {
+		var compressedHeaders, compressedRaw []byte
+		if err := tx.QueryRow(
+			`SELECT headers, raw_message FROM email_content WHERE mailbox = ? AND uid = ?`,
+			r.Mailbox, r.UID,
+		).Scan(&compressedHeaders, &compressedRaw); err != nil {
+			return fmt.Errorf("failed to load email content: %w", err)
+		}
+
+		email := &Email{Mailbox: r.Mailbox, UID: r.UID}
+		email.Headers, _ = s.decodeContent(compressedHeaders)
+		email.RawMessage, _ = s.decodeContent(compressedRaw)
+		if err := fn(email); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
+// Close closes the database.
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// Ping checks that the database answers a trivial query.
+func (s *Storage) Ping() error {
+	var one int
+	if err := s.db.QueryRow(`SELECT 1`).Scan(&one); err != nil {
+		return fmt.Errorf("database unavailable: %w", err)
+	}
+	return nil
+}
+
+// marshalCcBcc encodes the CC and BCC recipients for storage. Empty lists
+// store NULL.
+func marshalCcBcc(email *Email) (cc, bcc sql.NullString, err error) {
+	encode := func(addrs []string, what string) (sql.NullString, error) {
+		if len(addrs) == 0 {
+			return sql.NullString{}, nil
+		}
+		data, err := json.Marshal(addrs)
+		if err != nil {
+			return sql.NullString{}, fmt.Errorf("failed to marshal %s addresses: %w", what, err)
+		}
+		return sql.NullString{String: string(data), Valid: true}, nil
+	}
+
+	if cc, err = encode(email.Cc, "cc"); err != nil {
+		return cc, bcc, err
+	}
+	bcc, err = encode(email.Bcc, "bcc")
+	return cc, bcc, err
+}
+
+// gmailIDOrNull stores a Gmail ID, or NULL when unset. IDs are unsigned 64-bit
+// and are kept bit-for-bit in SQLite's signed integers.
+func gmailIDOrNull(id uint64) sql.NullInt64 {
+	if id == 0 {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(id), Valid: true}
+}
+
+// unixOrNull stores an optional time as Unix seconds, or NULL when unset.
+func unixOrNull(t *time.Time) sql.NullInt64 {
+	if t == nil || t.IsZero() {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: t.Unix(), Valid: true}
+}
+
+// SaveEmail stores an email, replacing any stored under the same mailbox
+// and UID.
 func (s *Storage) SaveEmail(email *Email) error {
+	return s.SaveEmailContext(context.Background(), email)
+}
+
+// SaveEmailContext is like SaveEmail but stops when ctx is done.
+func (s *Storage) SaveEmailContext(ctx context.Context, email *Email) error {
+	return s.retryBusy(ctx, func() error { return s.saveEmail(ctx, email) })
+}
+
+func (s *Storage) saveEmail(ctx context.Context, email *Email) error {
 	toJSON, err := json.Marshal(email.To)
 	if err != nil {
 		return fmt.Errorf("failed to marshal to addresses: %w", err)
@@ -212,18 +575,29 @@ func (s *Storage) SaveEmail(email *Email) error {
 		return fmt.Errorf("failed to marshal gmail labels: %w", err)
 	}
 
-	tx, err := s.db.Begin()
+	ccJSON, bccJSON, err := marshalCcBcc(email)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 
+	threadID, err := resolveThreadID(tx, email)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	// Insert metadata
 	metadataQuery := `
 	INSERT OR REPLACE INTO emails (
-		mailbox, uid, subject, from_addr, to_addrs, date, size, flags, gmail_labels, synced
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		mailbox, uid, subject, from_addr, to_addrs, date, size, flags, gmail_labels, synced, message_id, internal_date, thread_id, from_name, cc_addrs, bcc_addrs, gmail_msgid, gmail_thrid
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
-	_, err = tx.Exec(metadataQuery,
+	_, err = tx.ExecContext(ctx, metadataQuery,
 		email.Mailbox,
 		email.UID,
 		email.Subject,
@@ -234,6 +608,14 @@ func (s *Storage) SaveEmail(email *Email) error {
 		string(flagsJSON),
 		string(gmailLabelsJSON),
 		email.Synced.Unix(),
+		messageID(email),
+		unixOrNull(email.InternalDate),
+		threadID,
+		email.FromName,
+		ccJSON,
+		bccJSON,
+		gmailIDOrNull(email.GmailMsgID),
+		gmailIDOrNull(email.GmailThreadID),
 	)
 	if err != nil {
 		tx.Rollback()
@@ -241,19 +623,19 @@ func (s *Storage) SaveEmail(email *Email) error {
 	}
 
 	// Compress binary content
-	compressedBody, err := compressData(email.Body)
+	compressedBody, err := s.encodeContent(distinctBody(email))
 	if err != nil {
 		tx.Rollback()
 		return fmt.Errorf("failed to compress body: %w", err)
 	}
 
-	compressedHeaders, err := compressData(email.Headers)
+	compressedHeaders, err := s.encodeContent(email.Headers)
 	if err != nil {
 		tx.Rollback()
 		return fmt.Errorf("failed to compress headers: %w", err)
 	}
 
-	compressedRawMessage, err := compressData(email.RawMessage)
+	compressedRawMessage, err := s.encodeContent(email.RawMessage)
 	if err != nil {
 		tx.Rollback()
 		return fmt.Errorf("failed to compress raw message: %w", err)
@@ -265,7 +647,7 @@ func (s *Storage) SaveEmail(email *Email) error {
 		mailbox, uid, body, headers, raw_message
 	) VALUES (?, ?, ?, ?, ?)`
 
-	_, err = tx.Exec(contentQuery,
+	_, err = tx.ExecContext(ctx, contentQuery,
 		email.Mailbox,
 		email.UID,
 		compressedBody,
@@ -277,23 +659,54 @@ func (s *Storage) SaveEmail(email *Email) error {
 		return fmt.Errorf("failed to insert email content: %w", err)
 	}
 
-	return tx.Commit()
+	if err := s.indexEmail(tx, email); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := s.saveAttachments(tx, email); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := commitTx(tx); err != nil {
+		return err
+	}
+	s.notifySaved([]*Email{email})
+	return nil
 }
 
+// SaveEmailBatch stores emails like SaveEmail, in a single transaction.
 func (s *Storage) SaveEmailBatch(emails []*Email) error {
+	return s.SaveEmailBatchContext(context.Background(), emails)
+}
+
+// SaveEmailBatchContext is like SaveEmailBatch but stops when ctx is done.
+func (s *Storage) SaveEmailBatchContext(ctx context.Context, emails []*Email) error {
 	if len(emails) == 0 {
 		return nil
 	}
+	return s.retryBusy(ctx, func() error { return s.saveEmailBatch(ctx, emails) })
+}
+
+func (s *Storage) saveEmailBatch(ctx context.Context, emails []*Email) (err error) {
+	// A cancel rolls the transaction back from under the statements, which
+	// then fail with errors of their own.
+	defer func() {
+		if err != nil && ctx.Err() != nil && !errors.Is(err, ctx.Err()) {
+			err = fmt.Errorf("failed to save email batch: %w", ctx.Err())
+		}
+	}()
 
-	tx, err := s.db.Begin()
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 
-	metadataStmt, err := tx.Prepare(`
+	metadataStmt, err := tx.PrepareContext(ctx, `
 		INSERT OR REPLACE INTO emails (
-			mailbox, uid, subject, from_addr, to_addrs, date, size, flags, gmail_labels, synced
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			mailbox, uid, subject, from_addr, to_addrs, date, size, flags, gmail_labels, synced, message_id, internal_date, thread_id, from_name, cc_addrs, bcc_addrs, gmail_msgid, gmail_thrid
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`)
 	if err != nil {
 		tx.Rollback()
@@ -301,7 +714,7 @@ func (s *Storage) SaveEmailBatch(emails []*Email) error {
 	}
 	defer metadataStmt.Close()
 
-	contentStmt, err := tx.Prepare(`
+	contentStmt, err := tx.PrepareContext(ctx, `
 		INSERT OR REPLACE INTO email_content (
 			mailbox, uid, body, headers, raw_message
 		) VALUES (?, ?, ?, ?, ?)
@@ -312,6 +725,10 @@ func (s *Storage) SaveEmailBatch(emails []*Email) error {
 	}
 	defer contentStmt.Close()
 
+	// Content sizes and time spent compressing, to judge the codec by.
+	var contentBytes, storedBytes int64
+	var compressTime time.Duration
+
 	for _, email := range emails {
 		toJSON, err := json.Marshal(email.To)
 		if err != nil {
@@ -331,8 +748,21 @@ func (s *Storage) SaveEmailBatch(emails []*Email) error {
 			return fmt.Errorf("failed to marshal gmail labels: %w", err)
 		}
 
+		ccJSON, bccJSON, err := marshalCcBcc(email)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		// Earlier emails of the batch are visible to the lookup.
+		threadID, err := resolveThreadID(tx, email)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
 		// Insert metadata
-		_, err = metadataStmt.Exec(
+		_, err = metadataStmt.ExecContext(ctx,
 			email.Mailbox,
 			email.UID,
 			email.Subject,
@@ -343,6 +773,14 @@ func (s *Storage) SaveEmailBatch(emails []*Email) error {
 			string(flagsJSON),
 			string(gmailLabelsJSON),
 			email.Synced.Unix(),
+			messageID(email),
+			unixOrNull(email.InternalDate),
+			threadID,
+			email.FromName,
+			ccJSON,
+			bccJSON,
+			gmailIDOrNull(email.GmailMsgID),
+			gmailIDOrNull(email.GmailThreadID),
 		)
 		if err != nil {
 			tx.Rollback()
@@ -350,26 +788,30 @@ func (s *Storage) SaveEmailBatch(emails []*Email) error {
 		}
 
 		// Compress binary content
-		compressedBody, err := compressData(email.Body)
+		compressStart := time.Now()
+		compressedBody, err := s.encodeContent(distinctBody(email))
 		if err != nil {
 			tx.Rollback()
 			return fmt.Errorf("failed to compress body: %w", err)
 		}
 
-		compressedHeaders, err := compressData(email.Headers)
+		compressedHeaders, err := s.encodeContent(email.Headers)
 		if err != nil {
 			tx.Rollback()
 			return fmt.Errorf("failed to compress headers: %w", err)
 		}
 
-		compressedRawMessage, err := compressData(email.RawMessage)
+		compressedRawMessage, err := s.encodeContent(email.RawMessage)
 		if err != nil {
 			tx.Rollback()
 			return fmt.Errorf("failed to compress raw message: %w", err)
 		}
+		compressTime += time.Since(compressStart)
+		contentBytes += int64(len(distinctBody(email)) + len(email.Headers) + len(email.RawMessage))
+		storedBytes += int64(len(compressedBody) + len(compressedHeaders) + len(compressedRawMessage))
 
 		// Insert content
-		_, err = contentStmt.Exec(
+		_, err = contentStmt.ExecContext(ctx,
 			email.Mailbox,
 			email.UID,
 			compressedBody,
@@ -380,14 +822,41 @@ func (s *Storage) SaveEmailBatch(emails []*Email) error {
 			tx.Rollback()
 			return fmt.Errorf("failed to insert email content: %w", err)
 		}
+
+		if err := s.indexEmail(tx, email); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := s.saveAttachments(tx, email); err != nil {
+			tx.Rollback()
+			return err
+		}
 	}
 
-	return tx.Commit()
+	if err := commitTx(tx); err != nil {
+		return err
+	}
+	s.notifySaved(emails)
+
+	if contentBytes > 0 {
+		s.log.Debugf("Stored %d emails with %s compression: %d bytes as %d (%.0f%%), %s compressing",
+			len(emails), s.compression, contentBytes, storedBytes,
+			float64(storedBytes)/float64(contentBytes)*100, compressTime)
+	}
+	return nil
 }
 
+// GetEmail returns an email with its content, or nil if there is no live
+// email with that UID in mailbox.
 func (s *Storage) GetEmail(mailbox string, uid uint32) (*Email, error) {
+	return s.GetEmailContext(context.Background(), mailbox, uid)
+}
+
+// GetEmailContext is like GetEmail but stops when ctx is done.
+func (s *Storage) GetEmailContext(ctx context.Context, mailbox string, uid uint32) (*Email, error) {
 	query := `
-		SELECT e.mailbox, e.uid, e.subject, e.from_addr, e.to_addrs, e.date, e.size, e.flags, e.gmail_labels, e.synced, e.deleted_at,
+		SELECT e.mailbox, e.uid, e.subject, e.from_addr, e.to_addrs, e.date, e.size, e.flags, e.gmail_labels, e.synced, e.deleted_at, e.internal_date, e.thread_id, e.from_name, e.cc_addrs, e.bcc_addrs, e.gmail_msgid, e.gmail_thrid,
 			   c.body, c.headers, c.raw_message
 		FROM emails e
 		LEFT JOIN email_content c ON e.mailbox = c.mailbox AND e.uid = c.uid
@@ -395,12 +864,14 @@ func (s *Storage) GetEmail(mailbox string, uid uint32) (*Email, error) {
 	`
 
 	var email Email
-	var toJSON, flagsJSON, gmailLabelsJSON string
+	var toJSON, flagsJSON string
+	var gmailLabelsJSON sql.NullString
 	var dateUnix, syncedUnix int64
-	var deletedAtUnix sql.NullInt64
+	var deletedAtUnix, internalDateUnix, gmailMsgID, gmailThreadID sql.NullInt64
+	var threadID, fromName, ccJSON, bccJSON sql.NullString
 	var compressedBody, compressedHeaders, compressedRawMessage []byte
 
-	err := s.db.QueryRow(query, mailbox, uid).Scan(
+	err := s.db.QueryRowContext(ctx, query, mailbox, uid).Scan(
 		&email.Mailbox,
 		&email.UID,
 		&email.Subject,
@@ -412,6 +883,13 @@ func (s *Storage) GetEmail(mailbox string, uid uint32) (*Email, error) {
 		&gmailLabelsJSON,
 		&syncedUnix,
 		&deletedAtUnix,
+		&internalDateUnix,
+		&threadID,
+		&fromName,
+		&ccJSON,
+		&bccJSON,
+		&gmailMsgID,
+		&gmailThreadID,
 		&compressedBody,
 		&compressedHeaders,
 		&compressedRawMessage,
@@ -428,28 +906,40 @@ func (s *Storage) GetEmail(mailbox string, uid uint32) (*Email, error) {
 		return nil, fmt.Errorf("failed to unmarshal to addresses: %w", err)
 	}
 
+	if ccJSON.Valid {
+		if err := json.Unmarshal([]byte(ccJSON.String), &email.Cc); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal cc addresses: %w", err)
+		}
+	}
+
+	if bccJSON.Valid {
+		if err := json.Unmarshal([]byte(bccJSON.String), &email.Bcc); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal bcc addresses: %w", err)
+		}
+	}
+
 	if err := json.Unmarshal([]byte(flagsJSON), &email.Flags); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal flags: %w", err)
 	}
 
-	if gmailLabelsJSON != "" && gmailLabelsJSON != "null" {
-		if err := json.Unmarshal([]byte(gmailLabelsJSON), &email.GmailLabels); err != nil {
+	if gmailLabelsJSON.String != "" && gmailLabelsJSON.String != "null" {
+		if err := json.Unmarshal([]byte(gmailLabelsJSON.String), &email.GmailLabels); err != nil {
 			return nil, fmt.Errorf("failed to unmarshal gmail labels: %w", err)
 		}
 	}
 
 	// Decompress binary content
-	email.Body, err = decompressData(compressedBody)
+	email.Body, err = s.decodeContent(compressedBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decompress body: %w", err)
 	}
 
-	email.Headers, err = decompressData(compressedHeaders)
+	email.Headers, err = s.decodeContent(compressedHeaders)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decompress headers: %w", err)
 	}
 
-	email.RawMessage, err = decompressData(compressedRawMessage)
+	email.RawMessage, err = s.decodeContent(compressedRawMessage)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decompress raw message: %w", err)
 	}
@@ -460,17 +950,31 @@ func (s *Storage) GetEmail(mailbox string, uid uint32) (*Email, error) {
 		t := time.Unix(deletedAtUnix.Int64, 0)
 		email.DeletedAt = &t
 	}
+	if internalDateUnix.Valid {
+		t := time.Unix(internalDateUnix.Int64, 0)
+		email.InternalDate = &t
+	}
+	email.ThreadID = threadID.String
+	email.FromName = fromName.String
+	email.GmailMsgID = uint64(gmailMsgID.Int64)
+	email.GmailThreadID = uint64(gmailThreadID.Int64)
 
 	return &email, nil
 }
 
+// SaveMailboxState records the sync state of a mailbox.
 func (s *Storage) SaveMailboxState(state *MailboxState) error {
+	return s.SaveMailboxStateContext(context.Background(), state)
+}
+
+// SaveMailboxStateContext is like SaveMailboxState but stops when ctx is done.
+func (s *Storage) SaveMailboxStateContext(ctx context.Context, state *MailboxState) error {
 	query := `
 		INSERT OR REPLACE INTO mailbox_state (name, uid_validity, last_uid, last_sync)
 		VALUES (?, ?, ?, ?)
 	`
 
-	_, err := s.db.Exec(query,
+	_, err := s.db.ExecContext(ctx, query,
 		state.Name,
 		state.UIDValidity,
 		state.LastUID,
@@ -480,7 +984,14 @@ func (s *Storage) SaveMailboxState(state *MailboxState) error {
 	return err
 }
 
+// GetMailboxState returns the sync state of a mailbox, or nil if it was
+// never synced.
 func (s *Storage) GetMailboxState(mailbox string) (*MailboxState, error) {
+	return s.GetMailboxStateContext(context.Background(), mailbox)
+}
+
+// GetMailboxStateContext is like GetMailboxState but stops when ctx is done.
+func (s *Storage) GetMailboxStateContext(ctx context.Context, mailbox string) (*MailboxState, error) {
 	query := `
 		SELECT name, uid_validity, last_uid, last_sync
 		FROM mailbox_state
@@ -490,7 +1001,7 @@ func (s *Storage) GetMailboxState(mailbox string) (*MailboxState, error) {
 	var state MailboxState
 	var lastSyncUnix int64
 
-	err := s.db.QueryRow(query, mailbox).Scan(
+	err := s.db.QueryRowContext(ctx, query, mailbox).Scan(
 		&state.Name,
 		&state.UIDValidity,
 		&state.LastUID,
@@ -509,10 +1020,16 @@ func (s *Storage) GetMailboxState(mailbox string) (*MailboxState, error) {
 	return &state, nil
 }
 
+// ListMailboxes returns the names of the synced mailboxes, sorted.
 func (s *Storage) ListMailboxes() ([]string, error) {
+	return s.ListMailboxesContext(context.Background())
+}
+
+// ListMailboxesContext is like ListMailboxes but stops when ctx is done.
+func (s *Storage) ListMailboxesContext(ctx context.Context) ([]string, error) {
 	query := `SELECT name FROM mailbox_state ORDER BY name ASC`
 
-	rows, err := s.db.Query(query)
+	rows, err := s.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query mailboxes: %w", err)
 	}
@@ -536,11 +1053,42 @@ func (s *Storage) ListMailboxes() ([]string, error) {
 	return mailboxes, nil
 }
 
+// CountMessages counts the live emails in mailbox.
 func (s *Storage) CountMessages(mailbox string) (int, error) {
-	query := `SELECT COUNT(*) FROM emails WHERE mailbox = ? AND deleted_at IS NULL`
+	return s.CountMessagesContext(context.Background(), mailbox)
+}
+
+// CountMessagesContext is like CountMessages but stops when ctx is done.
+func (s *Storage) CountMessagesContext(ctx context.Context, mailbox string) (int, error) {
+	return s.CountMessagesFilteredContext(ctx, mailbox, FlagFilter{})
+}
+
+// CountMessagesFiltered counts the live emails in mailbox matching filter.
+func (s *Storage) CountMessagesFiltered(mailbox string, filter FlagFilter) (int, error) {
+	return s.CountMessagesFilteredContext(context.Background(), mailbox, filter)
+}
+
+// CountMessagesFilteredContext is like CountMessagesFiltered but stops when ctx is done.
+func (s *Storage) CountMessagesFilteredContext(ctx context.Context, mailbox string, filter FlagFilter) (int, error) {
+	return s.CountMessagesInRangeContext(ctx, mailbox, filter, DateRange{})
+}
+
+// CountMessagesInRange counts the live emails in mailbox matching filter and
+// dated within dates.
+func (s *Storage) CountMessagesInRange(mailbox string, filter FlagFilter, dates DateRange) (int, error) {
+	return s.CountMessagesInRangeContext(context.Background(), mailbox, filter, dates)
+}
+
+// CountMessagesInRangeContext is like CountMessagesInRange but stops when ctx is done.
+func (s *Storage) CountMessagesInRangeContext(ctx context.Context, mailbox string, filter FlagFilter, dates DateRange) (int, error) {
+	where, args := filter.where()
+	dateWhere, dateArgs := dates.where()
+	where += dateWhere
+	args = append(args, dateArgs...)
+	query := `SELECT COUNT(*) FROM emails WHERE mailbox = ? AND deleted_at IS NULL` + where
 
 	var count int
-	err := s.db.QueryRow(query, mailbox).Scan(&count)
+	err := s.db.QueryRowContext(ctx, query, append([]any{mailbox}, args...)...).Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("failed to count messages: %w", err)
 	}
@@ -548,25 +1096,196 @@ func (s *Storage) CountMessages(mailbox string) (int, error) {
 	return count, nil
 }
 
-func (s *Storage) ListEmails(mailbox string, limit, offset int) ([]*Email, error) {
+// SortField is a column ListEmails can sort by.
+type SortField string
+
+const (
+	SortByUID     SortField = "uid"
+	SortByDate    SortField = "date"
+	SortBySize    SortField = "size"
+	SortBySubject SortField = "subject"
+	// SortByArrival sorts by when the server received a message, its
+	// INTERNALDATE, falling back to its date for messages stored before
+	// INTERNALDATE was fetched. UIDs usually follow arrival, but not after
+	// messages are moved or copied in.
+	SortByArrival SortField = "arrival"
+)
+
+// sortColumns maps each SortField to its ORDER BY expression. Only these
+// expressions are ever interpolated into a query.
+var sortColumns = map[SortField]string{
+	SortByUID:     "uid",
+	SortByDate:    "date",
+	SortBySize:    "size",
+	SortBySubject: "subject COLLATE NOCASE",
+	SortByArrival: "COALESCE(internal_date, date)",
+}
+
+// SortOrder is the direction of a ListEmails sort.
+type SortOrder string
+
+const (
+	SortDesc SortOrder = "desc"
+	SortAsc  SortOrder = "asc"
+)
+
+// ParseSortField validates a sort field name. An empty name selects SortByUID.
+func ParseSortField(name string) (SortField, error) {
+	if name == "" {
+		return SortByUID, nil
+	}
+	field := SortField(strings.ToLower(name))
+	if _, ok := sortColumns[field]; !ok {
+		return "", fmt.Errorf("invalid sort field %q: expected uid, date, arrival, size or subject", name)
+	}
+	return field, nil
+}
+
+// ParseSortOrder validates a sort direction. An empty name selects SortDesc.
+func ParseSortOrder(name string) (SortOrder, error) {
+	switch order := SortOrder(strings.ToLower(name)); order {
+	case "":
+		return SortDesc, nil
+	case SortAsc, SortDesc:
+		return order, nil
+	default:
+		return "", fmt.Errorf("invalid sort order %q: expected asc or desc", name)
+	}
+}
+
+// ListEmails returns a page of live emails in mailbox sorted by field in the
+// given order. Ties are broken by UID in the same direction.
+func (s *Storage) ListEmails(mailbox string, field SortField, order SortOrder, limit, offset int) ([]*Email, error) {
+	return s.ListEmailsContext(context.Background(), mailbox, field, order, limit, offset)
+}
+
+// ListEmailsContext is like ListEmails but stops when ctx is done.
+func (s *Storage) ListEmailsContext(ctx context.Context, mailbox string, field SortField, order SortOrder, limit, offset int) ([]*Email, error) {
+	return s.ListEmailsFilteredContext(ctx, mailbox, FlagFilter{}, field, order, limit, offset)
+}
+
+// ListEmailsFiltered is ListEmails restricted to emails matching filter.
+func (s *Storage) ListEmailsFiltered(mailbox string, filter FlagFilter, field SortField, order SortOrder, limit, offset int) ([]*Email, error) {
+	return s.ListEmailsFilteredContext(context.Background(), mailbox, filter, field, order, limit, offset)
+}
+
+// ListEmailsFilteredContext is like ListEmailsFiltered but stops when ctx is done.
+func (s *Storage) ListEmailsFilteredContext(ctx context.Context, mailbox string, filter FlagFilter, field SortField, order SortOrder, limit, offset int) ([]*Email, error) {
+	return s.ListEmailsInRangeContext(ctx, mailbox, filter, DateRange{}, field, order, limit, offset)
+}
+
+// ListEmailsInRange is ListEmailsFiltered restricted to emails dated within
+// dates.
+func (s *Storage) ListEmailsInRange(mailbox string, filter FlagFilter, dates DateRange, field SortField, order SortOrder, limit, offset int) ([]*Email, error) {
+	return s.ListEmailsInRangeContext(context.Background(), mailbox, filter, dates, field, order, limit, offset)
+}
+
+// ListEmailsInRangeContext is like ListEmailsInRange but stops when ctx is done.
+func (s *Storage) ListEmailsInRangeContext(ctx context.Context, mailbox string, filter FlagFilter, dates DateRange, field SortField, order SortOrder, limit, offset int) ([]*Email, error) {
+	column, ok := sortColumns[field]
+	if !ok {
+		return nil, fmt.Errorf("invalid sort field %q", field)
+	}
+	if order != SortAsc && order != SortDesc {
+		return nil, fmt.Errorf("invalid sort order %q", order)
+	}
+
+	dir := strings.ToUpper(string(order))
+	orderBy := column + " " + dir
+	if field != SortByUID {
+		orderBy += ", uid " + dir
+	}
+
+	where, args := filter.where()
+	dateWhere, dateArgs := dates.where()
+	where += dateWhere
+	args = append(args, dateArgs...)
+
+	query := `
+		SELECT mailbox, uid, subject, from_addr, to_addrs, date, size, flags, gmail_labels, synced, thread_id, from_name
+		FROM emails
+		WHERE mailbox = ? AND deleted_at IS NULL` + where + `
+		ORDER BY ` + orderBy + `
+		LIMIT ? OFFSET ?
+	`
+
+	args = append([]any{mailbox}, args...)
+	rows, err := s.db.QueryContext(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query emails: %w", err)
+	}
+	defer rows.Close()
+
+	return scanEmailList(rows)
+}
+
+// ListEmailsAfter returns up to limit live emails in mailbox with a UID below
+// afterUID, highest UID first. It pages with the primary key instead of an
+// OFFSET, so deep pages cost as much as the first one. An afterUID of 0 starts
+// at the newest email; pass the UID of the last email returned to get the
+// next page.
+func (s *Storage) ListEmailsAfter(mailbox string, afterUID uint32, limit int) ([]*Email, error) {
+	return s.ListEmailsAfterContext(context.Background(), mailbox, afterUID, limit)
+}
+
+// ListEmailsAfterContext is like ListEmailsAfter but stops when ctx is done.
+func (s *Storage) ListEmailsAfterContext(ctx context.Context, mailbox string, afterUID uint32, limit int) ([]*Email, error) {
+	return s.ListEmailsAfterFilteredContext(ctx, mailbox, FlagFilter{}, afterUID, limit)
+}
+
+// ListEmailsAfterFiltered is ListEmailsAfter restricted to emails matching
+// filter.
+func (s *Storage) ListEmailsAfterFiltered(mailbox string, filter FlagFilter, afterUID uint32, limit int) ([]*Email, error) {
+	return s.ListEmailsAfterFilteredContext(context.Background(), mailbox, filter, afterUID, limit)
+}
+
+// ListEmailsAfterFilteredContext is like ListEmailsAfterFiltered but stops when ctx is done.
+func (s *Storage) ListEmailsAfterFilteredContext(ctx context.Context, mailbox string, filter FlagFilter, afterUID uint32, limit int) ([]*Email, error) {
+	return s.ListEmailsAfterInRangeContext(ctx, mailbox, filter, DateRange{}, afterUID, limit)
+}
+
+// ListEmailsAfterInRange is ListEmailsAfterFiltered restricted to emails dated
+// within dates.
+func (s *Storage) ListEmailsAfterInRange(mailbox string, filter FlagFilter, dates DateRange, afterUID uint32, limit int) ([]*Email, error) {
+	return s.ListEmailsAfterInRangeContext(context.Background(), mailbox, filter, dates, afterUID, limit)
+}
+
+// ListEmailsAfterInRangeContext is like ListEmailsAfterInRange but stops when ctx is done.
+func (s *Storage) ListEmailsAfterInRangeContext(ctx context.Context, mailbox string, filter FlagFilter, dates DateRange, afterUID uint32, limit int) ([]*Email, error) {
+	where, args := filter.where()
+	dateWhere, dateArgs := dates.where()
+	where += dateWhere
+	args = append([]any{mailbox}, append(args, dateArgs...)...)
+	if afterUID > 0 {
+		where += ` AND uid < ?`
+		args = append(args, afterUID)
+	}
+
 	query := `
-		SELECT mailbox, uid, subject, from_addr, to_addrs, date, size, flags, gmail_labels, synced
+		SELECT mailbox, uid, subject, from_addr, to_addrs, date, size, flags, gmail_labels, synced, thread_id, from_name
 		FROM emails
-		WHERE mailbox = ? AND deleted_at IS NULL
+		WHERE mailbox = ? AND deleted_at IS NULL` + where + `
 		ORDER BY uid DESC
-		LIMIT ? OFFSET ?
+		LIMIT ?
 	`
 
-	rows, err := s.db.Query(query, mailbox, limit, offset)
+	rows, err := s.db.QueryContext(ctx, query, append(args, limit)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query emails: %w", err)
 	}
 	defer rows.Close()
 
+	return scanEmailList(rows)
+}
+
+// scanEmailList scans metadata-only email rows selected as
+// mailbox, uid, subject, from_addr, to_addrs, date, size, flags, gmail_labels, synced, thread_id, from_name.
+func scanEmailList(rows *sql.Rows) ([]*Email, error) {
 	var emails []*Email
 	for rows.Next() {
 		var email Email
-		var toJSON, flagsJSON, gmailLabelsJSON string
+		var toJSON, flagsJSON string
+		var gmailLabelsJSON, threadID, fromName sql.NullString
 		var dateUnix, syncedUnix int64
 
 		err := rows.Scan(
@@ -580,6 +1299,8 @@ func (s *Storage) ListEmails(mailbox string, limit, offset int) ([]*Email, error
 			&flagsJSON,
 			&gmailLabelsJSON,
 			&syncedUnix,
+			&threadID,
+			&fromName,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan email: %w", err)
@@ -593,14 +1314,16 @@ func (s *Storage) ListEmails(mailbox string, limit, offset int) ([]*Email, error
 			return nil, fmt.Errorf("failed to unmarshal flags: %w", err)
 		}
 
-		if gmailLabelsJSON != "" && gmailLabelsJSON != "null" {
-			if err := json.Unmarshal([]byte(gmailLabelsJSON), &email.GmailLabels); err != nil {
+		if gmailLabelsJSON.String != "" && gmailLabelsJSON.String != "null" {
+			if err := json.Unmarshal([]byte(gmailLabelsJSON.String), &email.GmailLabels); err != nil {
 				return nil, fmt.Errorf("failed to unmarshal gmail labels: %w", err)
 			}
 		}
 
 		email.Date = time.Unix(dateUnix, 0)
 		email.Synced = time.Unix(syncedUnix, 0)
+		email.ThreadID = threadID.String
+		email.FromName = fromName.String
 
 		emails = append(emails, &email)
 	}
@@ -612,9 +1335,99 @@ func (s *Storage) ListEmails(mailbox string, limit, offset int) ([]*Email, error
 	return emails, nil
 }
 
+// StreamRawMessages calls fn for every non-deleted email in a mailbox in UID
+// order, with its metadata and decompressed raw message. Rows are read one at
+// a time so the mailbox doesn't have to fit in memory. RawMessage is empty for
+// emails stored without one. Iteration stops at the first error returned by
+// fn. fn must not call back into the Storage, which may hold its only
+// connection for the duration of the iteration.
+func (s *Storage) StreamRawMessages(mailbox string, fn func(*Email) error) error {
+	return s.StreamRawMessagesContext(context.Background(), mailbox, fn)
+}
+
+// StreamRawMessagesContext is like StreamRawMessages but stops when ctx is done.
+func (s *Storage) StreamRawMessagesContext(ctx context.Context, mailbox string, fn func(*Email) error) error {
+	return s.StreamRawMessagesAfterContext(ctx, mailbox, 0, fn)
+}
+
+// StreamRawMessagesAfter is like StreamRawMessages but starts after
+// afterUID, so an interrupted export can resume where it stopped.
+func (s *Storage) StreamRawMessagesAfter(mailbox string, afterUID uint32, fn func(*Email) error) error {
+	return s.StreamRawMessagesAfterContext(context.Background(), mailbox, afterUID, fn)
+}
+
+// StreamRawMessagesAfterContext is like StreamRawMessagesAfter but stops when ctx is done.
+func (s *Storage) StreamRawMessagesAfterContext(ctx context.Context, mailbox string, afterUID uint32, fn func(*Email) error) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT e.mailbox, e.uid, e.subject, e.from_addr, e.to_addrs, e.date, e.size, e.flags, e.synced,
+			   c.raw_message
+		FROM emails e
+		LEFT JOIN email_content c ON e.mailbox = c.mailbox AND e.uid = c.uid
+		WHERE e.mailbox = ? AND e.deleted_at IS NULL AND e.uid > ?
+		ORDER BY e.uid ASC
+	`, mailbox, afterUID)
+	if err != nil {
+		return fmt.Errorf("failed to query emails: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var email Email
+		var toJSON, flagsJSON string
+		var dateUnix, syncedUnix int64
+		var compressedRawMessage []byte
+
+		if err := rows.Scan(
+			&email.Mailbox,
+			&email.UID,
+			&email.Subject,
+			&email.From,
+			&toJSON,
+			&dateUnix,
+			&email.Size,
+			&flagsJSON,
+			&syncedUnix,
+			&compressedRawMessage,
+		); err != nil {
+			return fmt.Errorf("failed to scan email: %w", err)
+		}
+
+		if err := json.Unmarshal([]byte(toJSON), &email.To); err != nil {
+			return fmt.Errorf("failed to unmarshal to addresses: %w", err)
+		}
+
+		if err := json.Unmarshal([]byte(flagsJSON), &email.Flags); err != nil {
+			return fmt.Errorf("failed to unmarshal flags: %w", err)
+		}
+
+		email.RawMessage, err = s.decodeContent(compressedRawMessage)
+		if err != nil {
+			return fmt.Errorf("failed to decompress raw message of UID %d: %w", email.UID, err)
+		}
+
+		email.Date = time.Unix(dateUnix, 0)
+		email.Synced = time.Unix(syncedUnix, 0)
+
+		if err := fn(&email); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating emails: %w", err)
+	}
+
+	return nil
+}
+
 // ListLiveUIDs returns UIDs for a mailbox that are not soft-deleted.
 func (s *Storage) ListLiveUIDs(mailbox string) ([]uint32, error) {
-	rows, err := s.db.Query(
+	return s.ListLiveUIDsContext(context.Background(), mailbox)
+}
+
+// ListLiveUIDsContext is like ListLiveUIDs but stops when ctx is done.
+func (s *Storage) ListLiveUIDsContext(ctx context.Context, mailbox string) ([]uint32, error) {
+	rows, err := s.db.QueryContext(ctx,
 		`SELECT uid FROM emails WHERE mailbox = ? AND deleted_at IS NULL`,
 		mailbox,
 	)
@@ -637,17 +1450,154 @@ func (s *Storage) ListLiveUIDs(mailbox string) ([]uint32, error) {
 	return uids, nil
 }
 
+// ListUIDs returns every UID a sync has stored for a mailbox, including
+// soft-deleted emails and emails removed by DeleteOlderThan under the
+// mailbox's current UIDVALIDITY.
+func (s *Storage) ListUIDs(mailbox string) ([]uint32, error) {
+	return s.ListUIDsContext(context.Background(), mailbox)
+}
+
+// ListUIDsContext is like ListUIDs but stops when ctx is done.
+func (s *Storage) ListUIDsContext(ctx context.Context, mailbox string) ([]uint32, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT uid FROM emails WHERE mailbox = ?
+		 UNION
+		 SELECT p.uid FROM pruned p
+		 JOIN mailbox_state m ON m.name = p.mailbox AND m.uid_validity = p.uid_validity
+		 WHERE p.mailbox = ?`,
+		mailbox, mailbox,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query uids: %w", err)
+	}
+	defer rows.Close()
+
+	var uids []uint32
+	for rows.Next() {
+		var uid uint32
+		if err := rows.Scan(&uid); err != nil {
+			return nil, fmt.Errorf("failed to scan uid: %w", err)
+		}
+		uids = append(uids, uid)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating uids: %w", err)
+	}
+	return uids, nil
+}
+
+// EmailsMissingBody returns the UIDs of non-deleted emails in a mailbox that
+// were stored without a raw message, in ascending order.
+func (s *Storage) EmailsMissingBody(mailbox string) ([]uint32, error) {
+	return s.EmailsMissingBodyContext(context.Background(), mailbox)
+}
+
+// EmailsMissingBodyContext is like EmailsMissingBody but stops when ctx is done.
+func (s *Storage) EmailsMissingBodyContext(ctx context.Context, mailbox string) ([]uint32, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT e.uid
+		FROM emails e
+		LEFT JOIN email_content c ON e.mailbox = c.mailbox AND e.uid = c.uid
+		WHERE e.mailbox = ? AND e.deleted_at IS NULL
+		  AND (c.raw_message IS NULL OR length(c.raw_message) = 0)
+		ORDER BY e.uid ASC
+	`, mailbox)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query emails missing body: %w", err)
+	}
+	defer rows.Close()
+
+	var uids []uint32
+	for rows.Next() {
+		var uid uint32
+		if err := rows.Scan(&uid); err != nil {
+			return nil, fmt.Errorf("failed to scan uid: %w", err)
+		}
+		uids = append(uids, uid)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating uids: %w", err)
+	}
+	return uids, nil
+}
+
+// ListFlags returns the stored flags of every non-deleted email in a mailbox,
+// keyed by UID.
+func (s *Storage) ListFlags(mailbox string) (map[uint32][]string, error) {
+	return s.ListFlagsContext(context.Background(), mailbox)
+}
+
+// ListFlagsContext is like ListFlags but stops when ctx is done.
+func (s *Storage) ListFlagsContext(ctx context.Context, mailbox string) (map[uint32][]string, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT uid, flags FROM emails WHERE mailbox = ? AND deleted_at IS NULL`,
+		mailbox,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query flags: %w", err)
+	}
+	defer rows.Close()
+
+	result := make(map[uint32][]string)
+	for rows.Next() {
+		var uid uint32
+		var flagsJSON sql.NullString
+		if err := rows.Scan(&uid, &flagsJSON); err != nil {
+			return nil, fmt.Errorf("failed to scan flags: %w", err)
+		}
+
+		var flags []string
+		if flagsJSON.Valid && flagsJSON.String != "" {
+			if err := json.Unmarshal([]byte(flagsJSON.String), &flags); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal flags: %w", err)
+			}
+		}
+		result[uid] = flags
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating flags: %w", err)
+	}
+	return result, nil
+}
+
+// UpdateFlags replaces the stored flags of a single email. Unknown emails are
+// ignored.
+func (s *Storage) UpdateFlags(mailbox string, uid uint32, flags []string) error {
+	return s.UpdateFlagsContext(context.Background(), mailbox, uid, flags)
+}
+
+// UpdateFlagsContext is like UpdateFlags but stops when ctx is done.
+func (s *Storage) UpdateFlagsContext(ctx context.Context, mailbox string, uid uint32, flags []string) error {
+	flagsJSON, err := json.Marshal(flags)
+	if err != nil {
+		return fmt.Errorf("failed to marshal flags: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx,
+		`UPDATE emails SET flags = ? WHERE mailbox = ? AND uid = ?`,
+		string(flagsJSON), mailbox, uid,
+	); err != nil {
+		return fmt.Errorf("failed to update flags: %w", err)
+	}
+	return nil
+}
+
 // PurgeDeletedBefore permanently removes soft-deleted emails whose deleted_at
 // is older than the cutoff, from both the emails and email_content tables.
 func (s *Storage) PurgeDeletedBefore(cutoff time.Time) (int, error) {
-	tx, err := s.db.Begin()
+	return s.PurgeDeletedBeforeContext(context.Background(), cutoff)
+}
+
+// PurgeDeletedBeforeContext is like PurgeDeletedBefore but stops when ctx is done.
+func (s *Storage) PurgeDeletedBeforeContext(ctx context.Context, cutoff time.Time) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return 0, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 
 	cutoffUnix := cutoff.Unix()
 
-	if _, err := tx.Exec(
+	if _, err := tx.ExecContext(ctx,
 		`DELETE FROM email_content
 		 WHERE (mailbox, uid) IN (
 			SELECT mailbox, uid FROM emails
@@ -659,7 +1609,7 @@ func (s *Storage) PurgeDeletedBefore(cutoff time.Time) (int, error) {
 		return 0, fmt.Errorf("failed to purge email_content: %w", err)
 	}
 
-	res, err := tx.Exec(
+	res, err := tx.ExecContext(ctx,
 		`DELETE FROM emails WHERE deleted_at IS NOT NULL AND deleted_at < ?`,
 		cutoffUnix,
 	)
@@ -680,19 +1630,165 @@ func (s *Storage) PurgeDeletedBefore(cutoff time.Time) (int, error) {
 	return int(n), nil
 }
 
+// DeleteOlderThan permanently removes emails dated before the cutoff, from
+// one mailbox or from all of them when mailbox is empty. Emails with an
+// unknown date are kept. Attachments and the search index are cleaned up by
+// triggers. The removed UIDs are remembered so ListUIDs still reports them.
+func (s *Storage) DeleteOlderThan(mailbox string, cutoff time.Time) (int, error) {
+	return s.DeleteOlderThanContext(context.Background(), mailbox, cutoff)
+}
+
+// DeleteOlderThanContext is like DeleteOlderThan but stops when ctx is done.
+func (s *Storage) DeleteOlderThanContext(ctx context.Context, mailbox string, cutoff time.Time) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	where := `date > 0 AND date < ? AND (? = '' OR mailbox = ?)`
+	args := []any{cutoff.Unix(), mailbox, mailbox}
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT OR REPLACE INTO pruned (mailbox, uid, uid_validity)
+		 SELECT mailbox, uid, COALESCE(m.uid_validity, 0)
+		 FROM emails LEFT JOIN mailbox_state m ON m.name = mailbox
+		 WHERE `+where,
+		args...,
+	); err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to record pruned uids: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM email_content
+		 WHERE (mailbox, uid) IN (SELECT mailbox, uid FROM emails WHERE `+where+`)`,
+		args...,
+	); err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to delete email_content: %w", err)
+	}
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM emails WHERE `+where, args...)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to delete emails: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to read rows affected: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit: %w", err)
+	}
+	return int(n), nil
+}
+
+// DeleteMailbox permanently removes a mailbox from the backup: its emails,
+// their content, its state and its sync history, in one transaction.
+// Attachments and the search index are cleaned up by triggers. It returns
+// the number of emails removed, soft-deleted ones included.
+func (s *Storage) DeleteMailbox(name string) (int, error) {
+	return s.DeleteMailboxContext(context.Background(), name)
+}
+
+// DeleteMailboxContext is like DeleteMailbox but stops when ctx is done.
+func (s *Storage) DeleteMailboxContext(ctx context.Context, name string) (int, error) {
+	if s.readOnly {
+		return 0, fmt.Errorf("cannot delete a mailbox from storage opened read-only")
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM email_content WHERE mailbox = ?`, name); err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to delete email_content: %w", err)
+	}
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM emails WHERE mailbox = ?`, name)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to delete emails: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to read rows affected: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM pruned WHERE mailbox = ?`, name); err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to delete pruned uids: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM sync_history WHERE mailbox = ?`, name); err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to delete sync history: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mailbox_state WHERE name = ?`, name); err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to delete mailbox state: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit: %w", err)
+	}
+	return int(n), nil
+}
+
+// Compact rebuilds the database file to return the space freed by deletions
+// to the filesystem, then truncates the write-ahead log.
+func (s *Storage) Compact() error {
+	if s.readOnly {
+		return fmt.Errorf("cannot compact storage opened read-only")
+	}
+
+	before, err := s.databaseSize()
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.db.Exec(`VACUUM`); err != nil {
+		return fmt.Errorf("failed to vacuum database: %w", err)
+	}
+	if _, err := s.db.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+		return fmt.Errorf("failed to checkpoint WAL: %w", err)
+	}
+
+	after, err := s.databaseSize()
+	if err != nil {
+		return err
+	}
+
+	s.log.Infof("Compacted database from %d to %d bytes (%d reclaimed)", before, after, before-after)
+	return nil
+}
+
 // MarkDeleted soft-deletes the given UIDs in a mailbox, preserving any
 // existing deleted_at timestamp so the original deletion time isn't overwritten.
 func (s *Storage) MarkDeleted(mailbox string, uids []uint32, deletedAt time.Time) (int, error) {
+	return s.MarkDeletedContext(context.Background(), mailbox, uids, deletedAt)
+}
+
+// MarkDeletedContext is like MarkDeleted but stops when ctx is done.
+func (s *Storage) MarkDeletedContext(ctx context.Context, mailbox string, uids []uint32, deletedAt time.Time) (int, error) {
 	if len(uids) == 0 {
 		return 0, nil
 	}
 
-	tx, err := s.db.Begin()
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return 0, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 
-	stmt, err := tx.Prepare(
+	stmt, err := tx.PrepareContext(ctx,
 		`UPDATE emails SET deleted_at = ? WHERE mailbox = ? AND uid = ? AND deleted_at IS NULL`,
 	)
 	if err != nil {
@@ -704,7 +1800,7 @@ func (s *Storage) MarkDeleted(mailbox string, uids []uint32, deletedAt time.Time
 	ts := deletedAt.Unix()
 	var total int64
 	for _, uid := range uids {
-		res, err := stmt.Exec(ts, mailbox, uid)
+		res, err := stmt.ExecContext(ctx, ts, mailbox, uid)
 		if err != nil {
 			tx.Rollback()
 			return 0, fmt.Errorf("failed to mark deleted: %w", err)
@@ -1,9 +1,13 @@
 package storage
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -244,7 +248,7 @@ func TestCompression(t *testing.T) {
 	t.Run("compress and decompress data", func(t *testing.T) {
 		original := []byte(fmt.Sprintf("This is a test message with some content that should be compressed. %s", strings.Repeat("Repetitive data. ", 50)))
 
-		compressed, err := compressData(original)
+		compressed, err := compressData(original, CompressionGzip)
 		require.NoError(t, err)
 		assert.NotEmpty(t, compressed)
 		assert.Less(t, len(compressed), len(original))
@@ -257,7 +261,7 @@ func TestCompression(t *testing.T) {
 	t.Run("compress empty data", func(t *testing.T) {
 		original := []byte{}
 
-		compressed, err := compressData(original)
+		compressed, err := compressData(original, CompressionGzip)
 		require.NoError(t, err)
 		assert.Empty(t, compressed)
 
@@ -272,7 +276,7 @@ func TestCompression(t *testing.T) {
 			original[i] = byte(i % 256)
 		}
 
-		compressed, err := compressData(original)
+		compressed, err := compressData(original, CompressionGzip)
 		require.NoError(t, err)
 		assert.NotEmpty(t, compressed)
 
@@ -287,6 +291,91 @@ func TestCompression(t *testing.T) {
 		_, err := decompressData(invalid)
 		assert.Error(t, err)
 	})
+
+	t.Run("codecs round trip", func(t *testing.T) {
+		original := []byte(strings.Repeat("Repetitive data. ", 50))
+
+		for _, c := range []Compression{CompressionGzip, CompressionZstd, CompressionNone} {
+			compressed, err := compressData(original, c)
+			require.NoError(t, err, c)
+
+			decompressed, err := decompressData(compressed)
+			require.NoError(t, err, c)
+			assert.Equal(t, original, decompressed, c)
+		}
+	})
+
+	t.Run("unknown codec", func(t *testing.T) {
+		_, err := compressData([]byte("data"), "lz4")
+		assert.ErrorContains(t, err, `unknown compression "lz4"`)
+	})
+}
+
+func TestWithCompression_ReadsLegacyGzipRows(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	s, err := New(dbPath, log)
+	require.NoError(t, err)
+	require.NoError(t, s.SaveEmail(&Email{UID: 1, Mailbox: "INBOX", RawMessage: []byte(attachmentTestMsg)}))
+	s.Close()
+
+	s, err = New(dbPath, log, WithCompression(CompressionZstd))
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, s.SaveEmail(&Email{UID: 2, Mailbox: "INBOX", RawMessage: []byte(attachmentTestMsg)}))
+
+	var legacy, current []byte
+	require.NoError(t, s.db.QueryRow(`SELECT raw_message FROM email_content WHERE uid = 1`).Scan(&legacy))
+	require.NoError(t, s.db.QueryRow(`SELECT raw_message FROM email_content WHERE uid = 2`).Scan(&current))
+	assert.Equal(t, []byte{0x1f, 0x8b}, legacy[:2], "gzip rows are stored without a marker")
+	assert.Equal(t, markerZstd, current[0])
+
+	for _, uid := range []uint32{1, 2} {
+		email, err := s.GetEmail("INBOX", uid)
+		require.NoError(t, err)
+		assert.Equal(t, attachmentTestMsg, string(email.RawMessage))
+
+		attachment, err := s.GetAttachment("INBOX", uid, 1)
+		require.NoError(t, err)
+		require.NotNil(t, attachment)
+		assert.Equal(t, "%PDF-1.4\n% fake", string(attachment.Content))
+	}
+}
+
+func TestSaveEmailBatch_Uncompressed(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	s, err := New(dbPath, log)
+	require.NoError(t, err)
+	require.NoError(t, s.SaveEmailBatch([]*Email{{UID: 1, Mailbox: "INBOX", RawMessage: []byte(attachmentTestMsg)}}))
+	s.Close()
+
+	s, err = New(dbPath, log, WithCompression(CompressionNone))
+	require.NoError(t, err)
+	defer s.Close()
+
+	raw := []byte("Subject: Plain\r\n\r\nStored as is.")
+	require.NoError(t, s.SaveEmailBatch([]*Email{{UID: 2, Mailbox: "INBOX", Body: []byte("Stored as is."), RawMessage: raw}}))
+
+	var stored []byte
+	require.NoError(t, s.db.QueryRow(`SELECT raw_message FROM email_content WHERE uid = 2`).Scan(&stored))
+	require.NotEmpty(t, stored)
+	assert.Equal(t, markerNone, stored[0], "the row records its codec")
+	assert.Equal(t, raw, stored[1:], "the payload is the input, byte for byte")
+
+	email, err := s.GetEmail("INBOX", 2)
+	require.NoError(t, err)
+	assert.Equal(t, raw, email.RawMessage)
+	assert.Equal(t, "Stored as is.", string(email.Body))
+
+	// Rows written with gzip before the switch still read.
+	email, err = s.GetEmail("INBOX", 1)
+	require.NoError(t, err)
+	assert.Equal(t, attachmentTestMsg, string(email.RawMessage))
 }
 
 func TestListEmails(t *testing.T) {
@@ -346,37 +435,149 @@ func TestListEmails(t *testing.T) {
 	}
 
 	t.Run("list all inbox", func(t *testing.T) {
-		result, err := s.ListEmails("INBOX", 10, 0)
+		result, err := s.ListEmails("INBOX", SortByUID, SortDesc, 10, 0)
 		require.NoError(t, err)
 		assert.Len(t, result, 3)
 	})
 
 	t.Run("list with limit", func(t *testing.T) {
-		result, err := s.ListEmails("INBOX", 2, 0)
+		result, err := s.ListEmails("INBOX", SortByUID, SortDesc, 2, 0)
 		require.NoError(t, err)
 		assert.Len(t, result, 2)
 	})
 
 	t.Run("list with offset", func(t *testing.T) {
-		result, err := s.ListEmails("INBOX", 10, 2)
+		result, err := s.ListEmails("INBOX", SortByUID, SortDesc, 10, 2)
 		require.NoError(t, err)
 		assert.Len(t, result, 1)
 	})
 
 	t.Run("list different mailbox", func(t *testing.T) {
-		result, err := s.ListEmails("Sent", 10, 0)
+		result, err := s.ListEmails("Sent", SortByUID, SortDesc, 10, 0)
 		require.NoError(t, err)
 		assert.Len(t, result, 1)
 		assert.Equal(t, "Sent1", result[0].Subject)
 	})
 
 	t.Run("list empty mailbox", func(t *testing.T) {
-		result, err := s.ListEmails("Drafts", 10, 0)
+		result, err := s.ListEmails("Drafts", SortByUID, SortDesc, 10, 0)
 		require.NoError(t, err)
 		assert.Empty(t, result)
 	})
 }
 
+func TestListEmailsAfter(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer s.Close()
+
+	// UIDs with gaps, a deleted email and another mailbox in between.
+	var batch []*Email
+	var want []uint32
+	for uid := uint32(1); uid <= 40; uid++ {
+		if uid%7 == 0 {
+			continue
+		}
+		batch = append(batch, &Email{UID: uid, Mailbox: "INBOX", Date: time.Now()})
+		batch = append(batch, &Email{UID: uid, Mailbox: "Other", Date: time.Now()})
+		if uid != 20 {
+			want = append([]uint32{uid}, want...)
+		}
+	}
+	require.NoError(t, s.SaveEmailBatch(batch))
+	_, err = s.MarkDeleted("INBOX", []uint32{20}, time.Now())
+	require.NoError(t, err)
+
+	var got []uint32
+	var cursor uint32
+	for {
+		page, err := s.ListEmailsAfter("INBOX", cursor, 6)
+		require.NoError(t, err)
+		if len(page) == 0 {
+			break
+		}
+		require.LessOrEqual(t, len(page), 6)
+		for _, email := range page {
+			assert.Equal(t, "INBOX", email.Mailbox)
+			got = append(got, email.UID)
+		}
+		cursor = page[len(page)-1].UID
+	}
+
+	assert.Equal(t, want, got, "every email once, highest UID first")
+
+	filtered, err := s.ListEmailsAfterFiltered("INBOX", FlagFilter{Require: []string{"\\Seen"}}, 0, 10)
+	require.NoError(t, err)
+	assert.Empty(t, filtered)
+}
+
+func TestListEmails_Sort(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer s.Close()
+
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, s.SaveEmailBatch([]*Email{
+		{UID: 1, Mailbox: "INBOX", Subject: "banana", Size: 300, Date: base.AddDate(0, 0, 2)},
+		{UID: 2, Mailbox: "INBOX", Subject: "Cherry", Size: 100, Date: base},
+		{UID: 3, Mailbox: "INBOX", Subject: "apple", Size: 200, Date: base.AddDate(0, 0, 1)},
+		{UID: 4, Mailbox: "INBOX", Subject: "Apple", Size: 100, Date: base},
+	}))
+
+	uids := func(field SortField, order SortOrder, limit, offset int) []uint32 {
+		emails, err := s.ListEmails("INBOX", field, order, limit, offset)
+		require.NoError(t, err)
+		var result []uint32
+		for _, e := range emails {
+			result = append(result, e.UID)
+		}
+		return result
+	}
+
+	assert.Equal(t, []uint32{4, 3, 2, 1}, uids(SortByUID, SortDesc, 10, 0))
+	assert.Equal(t, []uint32{1, 2, 3, 4}, uids(SortByUID, SortAsc, 10, 0))
+	assert.Equal(t, []uint32{1, 3, 4, 2}, uids(SortByDate, SortDesc, 10, 0))
+	assert.Equal(t, []uint32{2, 4, 3, 1}, uids(SortByDate, SortAsc, 10, 0))
+	assert.Equal(t, []uint32{4, 2}, uids(SortByDate, SortDesc, 2, 2))
+	assert.Equal(t, []uint32{1, 3, 4, 2}, uids(SortBySize, SortDesc, 10, 0))
+	assert.Equal(t, []uint32{3, 4, 1, 2}, uids(SortBySubject, SortAsc, 10, 0))
+
+	_, err = s.ListEmails("INBOX", SortField("uid; DROP TABLE emails"), SortDesc, 10, 0)
+	assert.Error(t, err)
+	_, err = s.ListEmails("INBOX", SortByUID, SortOrder("sideways"), 10, 0)
+	assert.Error(t, err)
+}
+
+func TestParseSort(t *testing.T) {
+	field, err := ParseSortField("")
+	require.NoError(t, err)
+	assert.Equal(t, SortByUID, field)
+
+	field, err = ParseSortField("Subject")
+	require.NoError(t, err)
+	assert.Equal(t, SortBySubject, field)
+
+	_, err = ParseSortField("from_addr")
+	assert.Error(t, err)
+
+	order, err := ParseSortOrder("")
+	require.NoError(t, err)
+	assert.Equal(t, SortDesc, order)
+
+	order, err = ParseSortOrder("ASC")
+	require.NoError(t, err)
+	assert.Equal(t, SortAsc, order)
+
+	_, err = ParseSortOrder("up")
+	assert.Error(t, err)
+}
+
 func TestEmailCompressionRoundTrip(t *testing.T) {
 	log := logrus.New()
 	log.SetLevel(logrus.PanicLevel)
@@ -419,6 +620,110 @@ func TestEmailCompressionRoundTrip(t *testing.T) {
 	assert.Equal(t, originalEmail.RawMessage, retrievedEmail.RawMessage)
 }
 
+func TestInternalDateRoundTrip(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer s.Close()
+
+	internalDate := time.Date(2025, 1, 2, 8, 30, 0, 0, time.UTC)
+	require.NoError(t, s.SaveEmail(&Email{UID: 1, Mailbox: "INBOX", Date: time.Now(), InternalDate: &internalDate}))
+	require.NoError(t, s.SaveEmailBatch([]*Email{
+		{UID: 2, Mailbox: "INBOX", Date: time.Now(), InternalDate: &internalDate},
+		{UID: 3, Mailbox: "INBOX", Date: time.Now()},
+	}))
+
+	for _, uid := range []uint32{1, 2} {
+		email, err := s.GetEmail("INBOX", uid)
+		require.NoError(t, err)
+		require.NotNil(t, email.InternalDate)
+		assert.True(t, internalDate.Equal(*email.InternalDate))
+	}
+
+	email, err := s.GetEmail("INBOX", 3)
+	require.NoError(t, err)
+	assert.Nil(t, email.InternalDate)
+}
+
+func TestFromNameRoundTrip(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, s.SaveEmail(&Email{UID: 1, Mailbox: "INBOX", From: "alice@example.com", FromName: "Alice Smith", Date: time.Now()}))
+	require.NoError(t, s.SaveEmailBatch([]*Email{
+		{UID: 2, Mailbox: "INBOX", From: "bob@example.com", FromName: "Bőb Ünicode", Date: time.Now()},
+		{UID: 3, Mailbox: "INBOX", From: "noname@example.com", Date: time.Now()},
+	}))
+
+	email, err := s.GetEmail("INBOX", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", email.From)
+	assert.Equal(t, "Alice Smith", email.FromName)
+
+	emails, err := s.ListEmails("INBOX", SortByUID, SortAsc, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, emails, 3)
+	assert.Equal(t, "Alice Smith", emails[0].FromName)
+	assert.Equal(t, "Bőb Ünicode", emails[1].FromName)
+	assert.Empty(t, emails[2].FromName)
+
+	// The name is searchable alongside the address.
+	found, err := s.SearchEmails("smith", "", 10, 0)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, uint32(1), found[0].UID)
+	assert.Equal(t, "Alice Smith", found[0].FromName)
+}
+
+func TestCcBccRoundTrip(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, s.SaveEmail(&Email{
+		UID:     1,
+		Mailbox: "INBOX",
+		To:      []string{"to@example.com"},
+		Cc:      []string{"cc1@example.com", "cc2@example.com"},
+		Bcc:     []string{"hidden@example.com"},
+		Date:    time.Now(),
+	}))
+	require.NoError(t, s.SaveEmailBatch([]*Email{
+		{UID: 2, Mailbox: "INBOX", To: []string{"to@example.com"}, Cc: []string{"batch@example.com"}, Date: time.Now()},
+		{UID: 3, Mailbox: "INBOX", To: []string{"to@example.com"}, Date: time.Now()},
+	}))
+
+	email, err := s.GetEmail("INBOX", 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cc1@example.com", "cc2@example.com"}, email.Cc)
+	assert.Equal(t, []string{"hidden@example.com"}, email.Bcc)
+
+	email, err = s.GetEmail("INBOX", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"batch@example.com"}, email.Cc)
+	assert.Nil(t, email.Bcc)
+
+	email, err = s.GetEmail("INBOX", 3)
+	require.NoError(t, err)
+	assert.Nil(t, email.Cc)
+	assert.Nil(t, email.Bcc)
+
+	// CC recipients are searchable like To.
+	found, err := s.SearchEmails("batch@example.com", "", 10, 0)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, uint32(2), found[0].UID)
+}
+
 func TestWithReadOnly(t *testing.T) {
 	log := logrus.New()
 	log.SetLevel(logrus.PanicLevel)
@@ -476,7 +781,7 @@ func TestNew_ReadOnlyNonExistent(t *testing.T) {
 
 func TestDecompressData_TruncatedGzip(t *testing.T) {
 	original := []byte("data to compress for truncation test")
-	compressed, err := compressData(original)
+	compressed, err := compressData(original, CompressionGzip)
 	require.NoError(t, err)
 	require.Greater(t, len(compressed), 10)
 
@@ -525,6 +830,54 @@ func TestSaveEmailBatch_ClosedDB(t *testing.T) {
 	assert.Error(t, err)
 }
 
+// cancelAfter is cancelled on its nth check of Done, to interrupt work
+// part way through.
+type cancelAfter struct {
+	context.Context
+	cancel context.CancelFunc
+	n      atomic.Int32
+	limit  int32
+}
+
+func newCancelAfter(limit int32) *cancelAfter {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &cancelAfter{Context: ctx, cancel: cancel, limit: limit}
+}
+
+func (c *cancelAfter) Done() <-chan struct{} {
+	if c.n.Add(1) == c.limit {
+		c.cancel()
+	}
+	return c.Context.Done()
+}
+
+func TestSaveEmailBatchContext_Cancelled(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer s.Close()
+
+	emails := make([]*Email, 100)
+	for i := range emails {
+		emails[i] = &Email{UID: uint32(i + 1), Mailbox: "INBOX", Subject: "test", Body: []byte("body")}
+	}
+
+	ctx := newCancelAfter(20)
+	defer ctx.cancel()
+	err = s.SaveEmailBatchContext(ctx, emails)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	n, err := s.CountMessages("INBOX")
+	require.NoError(t, err)
+	assert.Zero(t, n, "the batch is rolled back")
+
+	require.NoError(t, s.SaveEmailBatchContext(context.Background(), emails))
+	n, err = s.CountMessages("INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, 100, n)
+}
+
 func TestSaveEmailBatch_NoTable(t *testing.T) {
 	log := logrus.New()
 	log.SetLevel(logrus.PanicLevel)
@@ -621,6 +974,18 @@ func TestGetEmail_CorruptedGmailLabels(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestPing(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+
+	assert.NoError(t, s.Ping())
+
+	s.Close()
+	assert.Error(t, s.Ping())
+}
+
 func TestGetMailboxState_ClosedDB(t *testing.T) {
 	log := logrus.New()
 	log.SetLevel(logrus.PanicLevel)
@@ -660,7 +1025,7 @@ func TestMarkDeleted_SoftDeletesAndHides(t *testing.T) {
 	require.NoError(t, err)
 	assert.ElementsMatch(t, []uint32{1, 3}, live)
 
-	emails, err := s.ListEmails("INBOX", 10, 0)
+	emails, err := s.ListEmails("INBOX", SortByUID, SortDesc, 10, 0)
 	require.NoError(t, err)
 	assert.Len(t, emails, 2)
 }
@@ -731,6 +1096,105 @@ func TestListLiveUIDs_ClosedDB(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestEmailsMissingBody(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer s.Close()
+
+	raw := []byte("Subject: full\r\n\r\nbody")
+	require.NoError(t, s.SaveEmailBatch([]*Email{
+		{UID: 3, Mailbox: "INBOX", Subject: "metadata", Size: 100},
+		{UID: 1, Mailbox: "INBOX", Subject: "metadata", Size: 100, Headers: []byte("Subject: metadata\r\n")},
+		{UID: 2, Mailbox: "INBOX", Subject: "full", Body: raw, RawMessage: raw},
+		{UID: 4, Mailbox: "INBOX", Subject: "deleted", Size: 100},
+		{UID: 1, Mailbox: "Sent", Subject: "other mailbox", Size: 100},
+	}))
+	_, err = s.MarkDeleted("INBOX", []uint32{4}, time.Now())
+	require.NoError(t, err)
+
+	missing, err := s.EmailsMissingBody("INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{1, 3}, missing)
+
+	// Filling a body removes the email from the missing set.
+	require.NoError(t, s.SaveEmail(&Email{UID: 1, Mailbox: "INBOX", Subject: "full", Body: raw, RawMessage: raw}))
+	missing, err = s.EmailsMissingBody("INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{3}, missing)
+
+	missing, err = s.EmailsMissingBody("Archive")
+	require.NoError(t, err)
+	assert.Empty(t, missing)
+}
+
+func TestSaveEmail_DuplicateBody(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer s.Close()
+
+	raw := []byte("Subject: twice\r\n\r\n" + strings.Repeat("A body that used to be stored twice. ", 50))
+	require.NoError(t, s.SaveEmail(&Email{UID: 1, Mailbox: "INBOX", RawMessage: raw}))
+	before, err := s.Stats()
+	require.NoError(t, err)
+
+	require.NoError(t, s.SaveEmail(&Email{UID: 1, Mailbox: "INBOX", Body: raw, RawMessage: raw}))
+	require.NoError(t, s.SaveEmailBatch([]*Email{{UID: 2, Mailbox: "INBOX", Body: raw, RawMessage: raw}}))
+	after, err := s.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 2*before.CompressedSize, after.CompressedSize, "the raw message is stored once per email")
+
+	var bodies int
+	require.NoError(t, s.db.QueryRow(`SELECT COUNT(*) FROM email_content WHERE body IS NOT NULL AND length(body) > 0`).Scan(&bodies))
+	assert.Zero(t, bodies)
+
+	email, err := s.GetEmail("INBOX", 2)
+	require.NoError(t, err)
+	assert.Equal(t, raw, email.RawMessage)
+	assert.Empty(t, email.Body)
+}
+
+func TestMigrateDropDuplicateBodies(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	raw := []byte("Subject: old\r\n\r\nStored twice by older versions.")
+
+	// Store the body twice, as older versions did.
+	s, err := New(dbPath, log)
+	require.NoError(t, err)
+	require.NoError(t, s.SaveEmail(&Email{UID: 1, Mailbox: "INBOX", Body: []byte("Kept."), RawMessage: raw}))
+	require.NoError(t, s.SaveEmail(&Email{UID: 2, Mailbox: "INBOX", RawMessage: raw}))
+	_, err = s.db.Exec(`UPDATE email_content SET body = raw_message WHERE uid = 2`)
+	require.NoError(t, err)
+	_, err = s.db.Exec(`DELETE FROM schema_migrations WHERE version >= 16`)
+	require.NoError(t, err)
+	s.Close()
+
+	s, err = New(dbPath, log)
+	require.NoError(t, err)
+	defer s.Close()
+
+	var duplicates int
+	require.NoError(t, s.db.QueryRow(`SELECT COUNT(*) FROM email_content WHERE body = raw_message`).Scan(&duplicates))
+	assert.Zero(t, duplicates)
+
+	email, err := s.GetEmail("INBOX", 2)
+	require.NoError(t, err)
+	assert.Equal(t, raw, email.RawMessage)
+	assert.Empty(t, email.Body)
+
+	email, err = s.GetEmail("INBOX", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "Kept.", string(email.Body), "bodies differing from the raw message stay")
+}
+
 func TestMigrateAddDeletedAt_AddsMissingColumn(t *testing.T) {
 	log := logrus.New()
 	log.SetLevel(logrus.PanicLevel)
@@ -744,6 +1208,8 @@ func TestMigrateAddDeletedAt_AddsMissingColumn(t *testing.T) {
 	require.NoError(t, err)
 	_, err = s.db.Exec(`ALTER TABLE emails DROP COLUMN deleted_at`)
 	require.NoError(t, err)
+	_, err = s.db.Exec(`DELETE FROM schema_migrations WHERE version >= 2`)
+	require.NoError(t, err)
 	s.Close()
 
 	// Reopen — migration should re-add the column.
@@ -816,7 +1282,7 @@ func TestPurgeDeletedBefore_RemovesOldSoftDeleted(t *testing.T) {
 	assert.ElementsMatch(t, []uint32{3}, live)
 }
 
-func TestPurgeDeletedBefore_NothingToDelete(t *testing.T) {
+func TestDeleteOlderThan(t *testing.T) {
 	log := logrus.New()
 	log.SetLevel(logrus.PanicLevel)
 
@@ -824,34 +1290,204 @@ func TestPurgeDeletedBefore_NothingToDelete(t *testing.T) {
 	require.NoError(t, err)
 	defer s.Close()
 
-	n, err := s.PurgeDeletedBefore(time.Now())
+	old := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+	recent := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, s.SaveEmailBatch([]*Email{
+		{UID: 1, Mailbox: "INBOX", Subject: "old", Date: old, RawMessage: []byte(attachmentTestMsg)},
+		{UID: 2, Mailbox: "INBOX", Subject: "recent", Date: recent},
+		{UID: 3, Mailbox: "INBOX", Subject: "undated"},
+		{UID: 4, Mailbox: "INBOX", Subject: "old deleted", Date: old},
+		{UID: 1, Mailbox: "Archive", Subject: "old archived", Date: old},
+		{UID: 2, Mailbox: "Archive", Subject: "recent archived", Date: recent},
+	}))
+	_, err = s.MarkDeleted("INBOX", []uint32{4}, time.Now())
 	require.NoError(t, err)
-	assert.Equal(t, 0, n)
-}
 
-func TestPurgeDeletedBefore_ClosedDB(t *testing.T) {
-	log := logrus.New()
-	log.SetLevel(logrus.PanicLevel)
-	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	cutoff := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	n, err := s.DeleteOlderThan("INBOX", cutoff)
 	require.NoError(t, err)
-	s.Close()
+	assert.Equal(t, 2, n, "old and old deleted")
 
-	_, err = s.PurgeDeletedBefore(time.Now())
-	assert.Error(t, err)
-}
+	live, err := s.ListLiveUIDs("INBOX")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []uint32{2, 3}, live, "undated emails are kept")
 
-func TestPurgeDeletedBefore_MissingContentTable(t *testing.T) {
-	log := logrus.New()
-	log.SetLevel(logrus.PanicLevel)
-	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	var count int
+	require.NoError(t, s.db.QueryRow(`SELECT COUNT(*) FROM email_content WHERE mailbox = 'INBOX' AND uid = 1`).Scan(&count))
+	assert.Equal(t, 0, count)
+	require.NoError(t, s.db.QueryRow(`SELECT COUNT(*) FROM attachments WHERE mailbox = 'INBOX' AND uid = 1`).Scan(&count))
+	assert.Equal(t, 0, count)
+
+	archived, err := s.ListLiveUIDs("Archive")
 	require.NoError(t, err)
-	defer s.Close()
+	assert.ElementsMatch(t, []uint32{1, 2}, archived, "other mailboxes are untouched")
 
-	_, err = s.db.Exec(`DROP TABLE email_content`)
+	n, err = s.DeleteOlderThan("", cutoff)
 	require.NoError(t, err)
+	assert.Equal(t, 1, n)
 
-	_, err = s.PurgeDeletedBefore(time.Now())
-	assert.Error(t, err)
+	archived, err = s.ListLiveUIDs("Archive")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []uint32{2}, archived)
+}
+
+func TestDeleteMailbox(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	s, err := New(dbPath, log)
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, s.SaveEmailBatch([]*Email{
+		{UID: 1, Mailbox: "INBOX", Subject: "inbox", RawMessage: []byte(attachmentTestMsg)},
+		{UID: 1, Mailbox: "Archive", Subject: "archived", RawMessage: []byte(attachmentTestMsg)},
+		{UID: 2, Mailbox: "Archive", Subject: "archived deleted"},
+	}))
+	_, err = s.MarkDeleted("Archive", []uint32{2}, time.Now())
+	require.NoError(t, err)
+	for _, mailbox := range []string{"INBOX", "Archive"} {
+		require.NoError(t, s.SaveMailboxState(&MailboxState{Name: mailbox, UIDValidity: 1, LastUID: 2}))
+	}
+
+	n, err := s.DeleteMailbox("Archive")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n, "soft-deleted emails are removed too")
+
+	mailboxes, err := s.ListMailboxes()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"INBOX"}, mailboxes)
+
+	var count int
+	require.NoError(t, s.db.QueryRow(`SELECT COUNT(*) FROM email_content WHERE mailbox = 'Archive'`).Scan(&count))
+	assert.Equal(t, 0, count)
+	require.NoError(t, s.db.QueryRow(`SELECT COUNT(*) FROM attachments WHERE mailbox = 'Archive'`).Scan(&count))
+	assert.Equal(t, 0, count)
+
+	email, err := s.GetEmail("INBOX", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "inbox", email.Subject)
+	require.NoError(t, s.db.QueryRow(`SELECT COUNT(*) FROM attachments WHERE mailbox = 'INBOX'`).Scan(&count))
+	assert.NotZero(t, count, "other mailboxes are untouched")
+	state, err := s.GetMailboxState("INBOX")
+	require.NoError(t, err)
+	assert.NotNil(t, state)
+
+	ro, err := New(dbPath, log, WithReadOnly(true))
+	require.NoError(t, err)
+	defer ro.Close()
+	_, err = ro.DeleteMailbox("INBOX")
+	assert.ErrorContains(t, err, "read-only")
+}
+
+func TestListUIDs(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer s.Close()
+
+	old := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, s.SaveMailboxState(&MailboxState{Name: "INBOX", UIDValidity: 7, LastUID: 4, LastSync: time.Now()}))
+	require.NoError(t, s.SaveEmailBatch([]*Email{
+		{UID: 1, Mailbox: "INBOX", Date: old},
+		{UID: 2, Mailbox: "INBOX"},
+		{UID: 4, Mailbox: "INBOX"},
+		{UID: 1, Mailbox: "Archive"},
+	}))
+	_, err = s.MarkDeleted("INBOX", []uint32{4}, time.Now())
+	require.NoError(t, err)
+	_, err = s.DeleteOlderThan("INBOX", time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+
+	uids, err := s.ListUIDs("INBOX")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []uint32{1, 2, 4}, uids, "pruned and soft-deleted UIDs are listed")
+
+	// A new UIDVALIDITY makes the pruned UIDs meaningless.
+	require.NoError(t, s.SaveMailboxState(&MailboxState{Name: "INBOX", UIDValidity: 8, LastSync: time.Now()}))
+	uids, err = s.ListUIDs("INBOX")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []uint32{2, 4}, uids)
+}
+
+func TestCompact(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	s, err := New(dbPath, log)
+	require.NoError(t, err)
+
+	emails := make([]*Email, 50)
+	for i := range emails {
+		emails[i] = &Email{
+			UID:        uint32(i + 1),
+			Mailbox:    "INBOX",
+			Date:       time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			Flags:      []string{},
+			RawMessage: []byte(fmt.Sprintf("Subject: %d\r\n\r\n%s", i, strings.Repeat(fmt.Sprintf("line %d\r\n", i), 500))),
+		}
+	}
+	require.NoError(t, s.SaveEmailBatch(emails))
+
+	n, err := s.DeleteOlderThan("", time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, 50, n)
+
+	before, err := s.databaseSize()
+	require.NoError(t, err)
+	require.NoError(t, s.Compact())
+	after, err := s.databaseSize()
+	require.NoError(t, err)
+	assert.Less(t, after, before)
+	s.Close()
+
+	sRO, err := New(dbPath, log, WithReadOnly(true))
+	require.NoError(t, err)
+	defer sRO.Close()
+	assert.ErrorContains(t, sRO.Compact(), "read-only")
+}
+
+func TestPurgeDeletedBefore_NothingToDelete(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer s.Close()
+
+	n, err := s.PurgeDeletedBefore(time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestPurgeDeletedBefore_ClosedDB(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	s.Close()
+
+	_, err = s.PurgeDeletedBefore(time.Now())
+	assert.Error(t, err)
+}
+
+func TestPurgeDeletedBefore_MissingContentTable(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, err = s.db.Exec(`DROP TABLE email_content`)
+	require.NoError(t, err)
+
+	_, err = s.PurgeDeletedBefore(time.Now())
+	assert.Error(t, err)
 }
 
 func TestPurgeDeletedBefore_MissingEmailsTable(t *testing.T) {
@@ -944,6 +1580,432 @@ func TestListEmails_ClosedDB(t *testing.T) {
 	require.NoError(t, err)
 	s.Close()
 
-	_, err = s.ListEmails("INBOX", 10, 0)
+	_, err = s.ListEmails("INBOX", SortByUID, SortDesc, 10, 0)
 	assert.Error(t, err)
 }
+
+func TestGmailLabels_RoundTrip(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, s.SaveEmail(&Email{
+		UID:         1,
+		Mailbox:     "INBOX",
+		GmailLabels: []string{"Important", "Work/Projects"},
+	}))
+	require.NoError(t, s.SaveEmailBatch([]*Email{
+		{UID: 2, Mailbox: "INBOX", GmailLabels: []string{"Starred"}},
+		{UID: 3, Mailbox: "INBOX"},
+	}))
+
+	e, err := s.GetEmail("INBOX", 1)
+	require.NoError(t, err)
+	require.NotNil(t, e)
+	assert.Equal(t, []string{"Important", "Work/Projects"}, e.GmailLabels)
+
+	emails, err := s.ListEmails("INBOX", SortByUID, SortDesc, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, emails, 3)
+	byUID := map[uint32][]string{}
+	for _, e := range emails {
+		byUID[e.UID] = e.GmailLabels
+	}
+	assert.Equal(t, []string{"Starred"}, byUID[2])
+	assert.Nil(t, byUID[3])
+}
+
+func TestMigrateAddGmailLabels_AddsMissingColumn(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+
+	// Open fresh, drop the column to simulate a pre-migration database.
+	s, err := New(dbPath, log)
+	require.NoError(t, err)
+	_, err = s.db.Exec(`ALTER TABLE emails DROP COLUMN gmail_labels`)
+	require.NoError(t, err)
+	_, err = s.db.Exec(`DELETE FROM schema_migrations WHERE version >= 3`)
+	require.NoError(t, err)
+	s.Close()
+
+	// Reopen — migration should re-add the column.
+	s2, err := New(dbPath, log)
+	require.NoError(t, err)
+	defer s2.Close()
+
+	require.NoError(t, s2.SaveEmail(&Email{UID: 1, Mailbox: "INBOX", GmailLabels: []string{"Travel"}}))
+	e, err := s2.GetEmail("INBOX", 1)
+	require.NoError(t, err)
+	require.NotNil(t, e)
+	assert.Equal(t, []string{"Travel"}, e.GmailLabels)
+}
+
+func TestUpdateFlags(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, s.SaveEmailBatch([]*Email{
+		{UID: 1, Mailbox: "INBOX", Flags: []string{}},
+		{UID: 2, Mailbox: "INBOX", Flags: []string{`\Seen`}},
+	}))
+
+	require.NoError(t, s.UpdateFlags("INBOX", 1, []string{`\Seen`, `\Flagged`}))
+	require.NoError(t, s.UpdateFlags("INBOX", 99, []string{`\Seen`}), "unknown emails are ignored")
+
+	e, err := s.GetEmail("INBOX", 1)
+	require.NoError(t, err)
+	require.NotNil(t, e)
+	assert.Equal(t, []string{`\Seen`, `\Flagged`}, e.Flags)
+
+	flags, err := s.ListFlags("INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, map[uint32][]string{
+		1: {`\Seen`, `\Flagged`},
+		2: {`\Seen`},
+	}, flags)
+
+	_, err = s.MarkDeleted("INBOX", []uint32{2}, time.Now())
+	require.NoError(t, err)
+	flags, err = s.ListFlags("INBOX")
+	require.NoError(t, err)
+	assert.NotContains(t, flags, uint32(2), "soft-deleted emails are skipped")
+}
+
+func TestStreamRawMessages(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer s.Close()
+
+	date := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, s.SaveEmailBatch([]*Email{
+		{UID: 3, Mailbox: "INBOX", Subject: "third", Date: date, Flags: []string{`\Seen`}, RawMessage: []byte("Subject: third\r\n\r\nc")},
+		{UID: 1, Mailbox: "INBOX", Subject: "first", Date: date, Flags: []string{}, RawMessage: []byte("Subject: first\r\n\r\na")},
+		{UID: 2, Mailbox: "INBOX", Subject: "no raw", Date: date, Flags: []string{}},
+		{UID: 4, Mailbox: "INBOX", Subject: "deleted", Date: date, Flags: []string{}, RawMessage: []byte("x")},
+		{UID: 1, Mailbox: "Other", Subject: "other", Date: date, Flags: []string{}, RawMessage: []byte("y")},
+	}))
+	_, err = s.MarkDeleted("INBOX", []uint32{4}, time.Now())
+	require.NoError(t, err)
+
+	var got []*Email
+	require.NoError(t, s.StreamRawMessages("INBOX", func(e *Email) error {
+		got = append(got, e)
+		return nil
+	}))
+
+	require.Len(t, got, 3)
+	assert.Equal(t, uint32(1), got[0].UID)
+	assert.Equal(t, "Subject: first\r\n\r\na", string(got[0].RawMessage))
+	assert.Equal(t, uint32(2), got[1].UID)
+	assert.Empty(t, got[1].RawMessage)
+	assert.Equal(t, uint32(3), got[2].UID)
+	assert.Equal(t, []string{`\Seen`}, got[2].Flags)
+	assert.True(t, date.Equal(got[2].Date))
+
+	t.Run("stops on callback error", func(t *testing.T) {
+		calls := 0
+		err := s.StreamRawMessages("INBOX", func(*Email) error {
+			calls++
+			return fmt.Errorf("stop")
+		})
+		assert.EqualError(t, err, "stop")
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("after a UID", func(t *testing.T) {
+		var uids []uint32
+		require.NoError(t, s.StreamRawMessagesAfter("INBOX", 1, func(e *Email) error {
+			uids = append(uids, e.UID)
+			return nil
+		}))
+		assert.Equal(t, []uint32{2, 3}, uids)
+	})
+
+	t.Run("closed db", func(t *testing.T) {
+		s.Close()
+		assert.Error(t, s.StreamRawMessages("INBOX", func(*Email) error { return nil }))
+	})
+}
+
+// createVersion0DB writes a database the way releases before schema
+// versioning did: original tables only, no schema_migrations table.
+func createVersion0DB(t *testing.T, path string) {
+	t.Helper()
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec(`
+	CREATE TABLE emails (
+		mailbox TEXT NOT NULL,
+		uid INTEGER NOT NULL,
+		subject TEXT,
+		from_addr TEXT,
+		to_addrs TEXT,
+		date INTEGER,
+		size INTEGER,
+		flags TEXT,
+		synced INTEGER,
+		PRIMARY KEY (mailbox, uid)
+	);
+	CREATE TABLE email_content (
+		mailbox TEXT NOT NULL,
+		uid INTEGER NOT NULL,
+		body BLOB,
+		headers BLOB,
+		raw_message BLOB,
+		PRIMARY KEY (mailbox, uid)
+	);
+	CREATE TABLE mailbox_state (
+		name TEXT PRIMARY KEY,
+		uid_validity INTEGER NOT NULL,
+		last_uid INTEGER NOT NULL,
+		last_sync INTEGER NOT NULL
+	);
+	INSERT INTO emails (mailbox, uid, subject, from_addr, to_addrs, date, size, flags, synced)
+	VALUES ('INBOX', 1, 'Legacy invoice', 'old@example.com', '[]', 0, 0, '["\\Seen"]', 0);
+	`)
+	require.NoError(t, err)
+}
+
+func TestMigrate_UpgradesVersion0DB(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	dbPath := filepath.Join(t.TempDir(), "legacy.db")
+	createVersion0DB(t, dbPath)
+
+	s, err := New(dbPath, log)
+	require.NoError(t, err)
+	defer s.Close()
+
+	version, err := s.SchemaVersion()
+	require.NoError(t, err)
+	assert.Equal(t, latestSchemaVersion, version)
+
+	e, err := s.GetEmail("INBOX", 1)
+	require.NoError(t, err)
+	require.NotNil(t, e)
+	assert.Equal(t, "Legacy invoice", e.Subject)
+	assert.Equal(t, []string{`\Seen`}, e.Flags)
+
+	listed, err := s.ListEmails("INBOX", SortByUID, SortDesc, 10, 0)
+	require.NoError(t, err)
+	assert.Len(t, listed, 1, "rows without gmail_labels still list")
+
+	n, err := s.MarkDeleted("INBOX", []uint32{1}, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n, "deleted_at column was added")
+
+	results, err := s.SearchEmails("invoice", "", 10, 0)
+	require.NoError(t, err)
+	assert.Empty(t, results, "backfilled index excludes the now soft-deleted row")
+
+	var indexed int
+	require.NoError(t, s.db.QueryRow(`SELECT COUNT(*) FROM emails_fts`).Scan(&indexed))
+	assert.Equal(t, 1, indexed, "search index was backfilled")
+}
+
+func TestMigrate_FreshDBIsLatest(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	s, err := New(dbPath, log)
+	require.NoError(t, err)
+	s.Close()
+
+	// Reopening applies nothing and records nothing new.
+	s2, err := New(dbPath, log)
+	require.NoError(t, err)
+	defer s2.Close()
+
+	var rows int
+	require.NoError(t, s2.db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&rows))
+	assert.Equal(t, latestSchemaVersion, rows)
+}
+
+func TestMigrate_RejectsNewerDB(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	s, err := New(dbPath, log)
+	require.NoError(t, err)
+	_, err = s.db.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, 0)`, latestSchemaVersion+1)
+	require.NoError(t, err)
+	s.Close()
+
+	_, err = New(dbPath, log)
+	assert.ErrorContains(t, err, "newer than supported")
+
+	_, err = New(dbPath, log, WithReadOnly(true))
+	assert.ErrorContains(t, err, "newer than supported")
+}
+
+func TestSchemaVersion_ReadOnlyVersion0DB(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	dbPath := filepath.Join(t.TempDir(), "legacy.db")
+	createVersion0DB(t, dbPath)
+
+	s, err := New(dbPath, log, WithReadOnly(true))
+	require.NoError(t, err)
+	defer s.Close()
+
+	version, err := s.SchemaVersion()
+	require.NoError(t, err)
+	assert.Equal(t, 0, version)
+}
+
+func TestWAL_ConcurrentWriterAndReader(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	writer, err := New(dbPath, log)
+	require.NoError(t, err)
+	defer writer.Close()
+
+	var mode string
+	require.NoError(t, writer.db.QueryRow(`PRAGMA journal_mode`).Scan(&mode))
+	assert.Equal(t, "wal", mode)
+
+	require.NoError(t, writer.SaveEmail(&Email{UID: 1, Mailbox: "INBOX", Subject: "seed"}))
+
+	reader, err := New(dbPath, log, WithReadOnly(true))
+	require.NoError(t, err)
+	defer reader.Close()
+
+	// Hold a write transaction open while the reader queries.
+	tx, err := writer.db.Begin()
+	require.NoError(t, err)
+	_, err = tx.Exec(`UPDATE emails SET subject = 'pending' WHERE uid = 1`)
+	require.NoError(t, err)
+
+	done := make(chan error, 1)
+	go func() {
+		for i := range 50 {
+			if err := writer.SaveEmailBatch([]*Email{{UID: uint32(100 + i), Mailbox: "INBOX"}}); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	for range 20 {
+		emails, err := reader.ListEmails("INBOX", SortByUID, SortDesc, 10, 0)
+		require.NoError(t, err)
+		require.NotEmpty(t, emails)
+	}
+
+	e, err := reader.GetEmail("INBOX", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "seed", e.Subject, "reader sees the last committed state")
+
+	require.NoError(t, tx.Commit())
+	require.NoError(t, <-done)
+
+	count, err := reader.CountMessages("INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, 51, count)
+}
+
+func TestReadOnly_ConcurrentQueries(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	writer, err := New(dbPath, log)
+	require.NoError(t, err)
+	require.NoError(t, writer.SaveEmail(&Email{UID: 1, Mailbox: "INBOX"}))
+	writer.Close()
+
+	reader, err := New(dbPath, log, WithReadOnly(true))
+	require.NoError(t, err)
+	defer reader.Close()
+
+	assert.Equal(t, readOnlyMaxConns, reader.db.Stats().MaxOpenConnections)
+
+	// Open rows on one connection must not block queries on another.
+	rows, err := reader.db.Query(`SELECT uid FROM emails`)
+	require.NoError(t, err)
+	defer rows.Close()
+
+	count, err := reader.CountMessages("INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestSaveEmail_RetriesBusyDatabase(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	// busyCommits makes the next n commits fail as if another process held
+	// the database locked, and counts the attempts.
+	busyCommits := func(t *testing.T, n int, busyErr error) *int {
+		attempts := new(int)
+		commitTx = func(tx *sql.Tx) error {
+			*attempts++
+			if *attempts <= n {
+				tx.Rollback()
+				return busyErr
+			}
+			return tx.Commit()
+		}
+		t.Cleanup(func() { commitTx = (*sql.Tx).Commit })
+		return attempts
+	}
+	busy := errors.New("database is locked (5) (SQLITE_BUSY)")
+
+	t.Run("succeeds once the lock is released", func(t *testing.T) {
+		s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+		require.NoError(t, err)
+		defer s.Close()
+
+		attempts := busyCommits(t, 2, busy)
+		require.NoError(t, s.SaveEmailBatch([]*Email{{UID: 1, Mailbox: "INBOX"}, {UID: 2, Mailbox: "INBOX"}}))
+		assert.Equal(t, 3, *attempts)
+
+		count, err := s.CountMessages("INBOX")
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("gives up after the retries", func(t *testing.T) {
+		s, err := New(filepath.Join(t.TempDir(), "test.db"), log, WithWriteRetries(1))
+		require.NoError(t, err)
+		defer s.Close()
+
+		attempts := busyCommits(t, 2, busy)
+		err = s.SaveEmail(&Email{UID: 1, Mailbox: "INBOX"})
+		assert.ErrorIs(t, err, busy)
+		assert.Equal(t, 2, *attempts)
+
+		email, err := s.GetEmail("INBOX", 1)
+		require.NoError(t, err)
+		assert.Nil(t, email)
+	})
+
+	t.Run("other errors fail at once", func(t *testing.T) {
+		s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+		require.NoError(t, err)
+		defer s.Close()
+
+		diskFull := errors.New("database or disk is full (13) (SQLITE_FULL)")
+		attempts := busyCommits(t, 1, diskFull)
+		assert.ErrorIs(t, s.SaveEmail(&Email{UID: 1, Mailbox: "INBOX"}), diskFull)
+		assert.Equal(t, 1, *attempts)
+	})
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/newsamples/imapsync/internal/mailparse"
+)
+
+// migrateAddThreadID adds the thread_id column and threads already-stored
+// emails.
+func (s *Storage) migrateAddThreadID(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "emails", "thread_id", "TEXT"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_emails_mailbox_thread ON emails(mailbox, thread_id)`); err != nil {
+		return fmt.Errorf("failed to create thread_id index: %w", err)
+	}
+
+	return s.backfillThreadIDs(tx)
+}
+
+// backfillThreadIDs threads every stored email, oldest first so that replies
+// can find their parent's thread.
+func (s *Storage) backfillThreadIDs(tx *sql.Tx) error {
+	return s.forEachStoredContent(tx, `
+		SELECT c.mailbox, c.uid
+		FROM email_content c
+		JOIN emails e ON e.mailbox = c.mailbox AND e.uid = c.uid
+		ORDER BY e.date, e.uid
+	`, "threads", func(email *Email) error {
+		threadID, err := resolveThreadID(tx, email)
+		if err != nil || !threadID.Valid {
+			return err
+		}
+		if _, err := tx.Exec(
+			`UPDATE emails SET thread_id = ? WHERE mailbox = ? AND uid = ?`,
+			threadID, email.Mailbox, email.UID,
+		); err != nil {
+			return fmt.Errorf("failed to save thread ID: %w", err)
+		}
+		return nil
+	})
+}
+
+// resolveThreadID returns the thread an email belongs to: the first message
+// it references, or the thread of that message if it is already stored, so
+// replies carrying only In-Reply-To join their parent's thread. An email that
+// references nothing is its own root. Emails without any Message-ID store
+// NULL. Gmail already threads messages itself, so its X-GM-THRID wins when
+// known.
+func resolveThreadID(tx *sql.Tx, email *Email) (sql.NullString, error) {
+	if email.GmailThreadID != 0 {
+		return sql.NullString{String: strconv.FormatUint(email.GmailThreadID, 10), Valid: true}, nil
+	}
+
+	refs := mailparse.References(email.Headers)
+	if len(refs) == 0 {
+		refs = mailparse.References(email.RawMessage)
+	}
+	if len(refs) == 0 {
+		return messageID(email), nil
+	}
+
+	var threadID sql.NullString
+	err := tx.QueryRow(
+		`SELECT thread_id FROM emails WHERE message_id = ? AND thread_id IS NOT NULL LIMIT 1`,
+		refs[0],
+	).Scan(&threadID)
+	switch {
+	case err == sql.ErrNoRows:
+		return sql.NullString{String: refs[0], Valid: true}, nil
+	case err != nil:
+		return sql.NullString{}, fmt.Errorf("failed to look up thread: %w", err)
+	}
+	return threadID, nil
+}
+
+// GetThread returns the live emails of a thread in mailbox, oldest first,
+// without their content.
+func (s *Storage) GetThread(mailbox, threadID string) ([]*Email, error) {
+	rows, err := s.db.Query(`
+		SELECT mailbox, uid, subject, from_addr, to_addrs, date, size, flags, gmail_labels, synced, thread_id, from_name
+		FROM emails
+		WHERE mailbox = ? AND thread_id = ? AND deleted_at IS NULL
+		ORDER BY date ASC, uid ASC
+	`, mailbox, threadID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query thread: %w", err)
+	}
+	defer rows.Close()
+
+	return scanEmailList(rows)
+}
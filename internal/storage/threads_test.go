@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func threadEmail(uid uint32, day int, headers string) *Email {
+	return &Email{
+		UID:     uid,
+		Mailbox: "INBOX",
+		Date:    time.Date(2025, 1, day, 12, 0, 0, 0, time.UTC),
+		Headers: []byte(headers + "\r\n"),
+	}
+}
+
+func TestGmailThreadID(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Gmail threads these together although the reply lost its headers.
+	root := threadEmail(1, 1, "Message-ID: <root@example.com>\r\n")
+	root.GmailMsgID, root.GmailThreadID = 1278455344230334865, 1278455344230334865
+	reply := threadEmail(2, 2, "Message-ID: <reply@example.com>\r\n")
+	reply.GmailMsgID, reply.GmailThreadID = 18446744073709551615, 1278455344230334865
+	require.NoError(t, s.SaveEmailBatch([]*Email{root, reply}))
+
+	thread, err := s.GetThread("INBOX", "1278455344230334865")
+	require.NoError(t, err)
+	require.Len(t, thread, 2)
+
+	got, err := s.GetEmail("INBOX", 2)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(18446744073709551615), got.GmailMsgID, "the full unsigned range round-trips")
+	assert.Equal(t, uint64(1278455344230334865), got.GmailThreadID)
+	assert.Equal(t, "1278455344230334865", got.ThreadID)
+
+	// Other servers keep header threading and no Gmail IDs.
+	require.NoError(t, s.SaveEmail(threadEmail(3, 3, "Message-ID: <plain@example.com>\r\n")))
+	plain, err := s.GetEmail("INBOX", 3)
+	require.NoError(t, err)
+	assert.Zero(t, plain.GmailMsgID)
+	assert.Equal(t, "<plain@example.com>", plain.ThreadID)
+}
+
+func TestGetThread(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, s.SaveEmail(threadEmail(1, 1, "Message-ID: <root@example.com>\r\nSubject: Plans\r\n")))
+	require.NoError(t, s.SaveEmailBatch([]*Email{
+		// A reply that only carries In-Reply-To.
+		threadEmail(2, 2, "Message-ID: <reply1@example.com>\r\nIn-Reply-To: <root@example.com>\r\n"),
+		// A reply to the reply, again without References: it finds its
+		// parent's thread, saved earlier in the same batch.
+		threadEmail(3, 3, "Message-ID: <reply2@example.com>\r\nIn-Reply-To: <reply1@example.com>\r\n"),
+		threadEmail(4, 4, "Message-ID: <reply3@example.com>\r\nReferences: <root@example.com> <reply2@example.com>\r\nIn-Reply-To: <reply2@example.com>\r\n"),
+		threadEmail(5, 5, "Message-ID: <other@example.com>\r\nSubject: Unrelated\r\n"),
+		threadEmail(6, 6, "Subject: No Message-ID\r\n"),
+	}))
+
+	thread, err := s.GetThread("INBOX", "<root@example.com>")
+	require.NoError(t, err)
+	var uids []uint32
+	for _, e := range thread {
+		uids = append(uids, e.UID)
+		assert.Equal(t, "<root@example.com>", e.ThreadID)
+	}
+	assert.Equal(t, []uint32{1, 2, 3, 4}, uids)
+
+	// A message without references is the root of its own thread.
+	other, err := s.GetEmail("INBOX", 5)
+	require.NoError(t, err)
+	assert.Equal(t, "<other@example.com>", other.ThreadID)
+
+	orphan, err := s.GetEmail("INBOX", 6)
+	require.NoError(t, err)
+	assert.Empty(t, orphan.ThreadID)
+
+	thread, err = s.GetThread("INBOX", "<missing@example.com>")
+	require.NoError(t, err)
+	assert.Empty(t, thread)
+}
+
+func TestBackfillThreadIDs(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, s.SaveEmailBatch([]*Email{
+		threadEmail(1, 1, "Message-ID: <root@example.com>\r\n"),
+		threadEmail(2, 2, "Message-ID: <reply@example.com>\r\nIn-Reply-To: <root@example.com>\r\n"),
+	}))
+
+	// Simulate a database written before threading existed.
+	_, err = s.db.Exec(`UPDATE emails SET thread_id = NULL`)
+	require.NoError(t, err)
+
+	tx, err := s.db.Begin()
+	require.NoError(t, err)
+	require.NoError(t, s.backfillThreadIDs(tx))
+	require.NoError(t, tx.Commit())
+
+	thread, err := s.GetThread("INBOX", "<root@example.com>")
+	require.NoError(t, err)
+	assert.Len(t, thread, 2)
+}
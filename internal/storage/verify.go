@@ -0,0 +1,141 @@
+package storage
+
+import "fmt"
+
+// VerifyIssue is a problem found by Verify. Mailbox and UID are empty for
+// issues reported by SQLite's integrity check, which aren't tied to an email.
+type VerifyIssue struct {
+	Mailbox string `json:"mailbox,omitempty"`
+	UID     uint32 `json:"uid,omitempty"`
+	Problem string `json:"problem"`
+}
+
+func (i VerifyIssue) String() string {
+	if i.Mailbox == "" {
+		return i.Problem
+	}
+	return fmt.Sprintf("%s UID %d: %s", i.Mailbox, i.UID, i.Problem)
+}
+
+// Verify checks the database for corruption: SQLite's own integrity check,
+// emails and content rows without their counterpart, and content or
+// attachments that fail to decompress. Blobs are checked one row at a time to
+// keep memory bounded.
+func (s *Storage) Verify() ([]VerifyIssue, error) {
+	var issues []VerifyIssue
+
+	rows, err := s.db.Query(`PRAGMA integrity_check`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run integrity check: %w", err)
+	}
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan integrity check: %w", err)
+		}
+		if result != "ok" {
+			issues = append(issues, VerifyIssue{Problem: "integrity check: " + result})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, fmt.Errorf("error iterating integrity check: %w", err)
+	}
+	rows.Close()
+
+	orphans := []struct {
+		query   string
+		problem string
+	}{
+		{`SELECT e.mailbox, e.uid FROM emails e
+			LEFT JOIN email_content c ON c.mailbox = e.mailbox AND c.uid = e.uid
+			WHERE c.uid IS NULL`, "email has no content row"},
+		{`SELECT c.mailbox, c.uid FROM email_content c
+			LEFT JOIN emails e ON e.mailbox = c.mailbox AND e.uid = c.uid
+			WHERE e.uid IS NULL`, "content row has no email"},
+	}
+	for _, o := range orphans {
+		refs, err := s.queryRefs(o.query)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range refs {
+			issues = append(issues, VerifyIssue{Mailbox: r.Mailbox, UID: r.UID, Problem: o.problem})
+		}
+	}
+
+	refs, err := s.queryRefs(`SELECT mailbox, uid FROM email_content ORDER BY mailbox, uid`)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range refs {
+		var body, headers, raw []byte
+		if err := s.db.QueryRow(
+			`SELECT body, headers, raw_message FROM email_content WHERE mailbox = ? AND uid = ?`,
+			r.Mailbox, r.UID,
+		).Scan(&body, &headers, &raw); err != nil {
+			return nil, fmt.Errorf("failed to load email content: %w", err)
+		}
+
+		for _, blob := range []struct {
+			name string
+			data []byte
+		}{{"body", body}, {"headers", headers}, {"raw message", raw}} {
+			if _, err := s.decodeContent(blob.data); err != nil {
+				issues = append(issues, VerifyIssue{Mailbox: r.Mailbox, UID: r.UID, Problem: fmt.Sprintf("%s: %v", blob.name, err)})
+			}
+		}
+	}
+
+	rows, err = s.db.Query(`SELECT mailbox, uid, part_index FROM attachments ORDER BY mailbox, uid, part_index`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query attachments: %w", err)
+	}
+	var attachments []Attachment
+	for rows.Next() {
+		var a Attachment
+		if err := rows.Scan(&a.Mailbox, &a.UID, &a.PartIndex); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan attachment: %w", err)
+		}
+		attachments = append(attachments, a)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, fmt.Errorf("error iterating attachments: %w", err)
+	}
+	rows.Close()
+
+	for _, a := range attachments {
+		if _, err := s.GetAttachment(a.Mailbox, a.UID, a.PartIndex); err != nil {
+			issues = append(issues, VerifyIssue{Mailbox: a.Mailbox, UID: a.UID, Problem: fmt.Sprintf("attachment %d: %v", a.PartIndex, err)})
+		}
+	}
+
+	return issues, nil
+}
+
+// queryRefs runs a query selecting mailbox and uid columns.
+func (s *Storage) queryRefs(query string) ([]EmailRef, error) {
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query emails: %w", err)
+	}
+	defer rows.Close()
+
+	var refs []EmailRef
+	for rows.Next() {
+		var r EmailRef
+		if err := rows.Scan(&r.Mailbox, &r.UID); err != nil {
+			return nil, fmt.Errorf("failed to scan email: %w", err)
+		}
+		refs = append(refs, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating emails: %w", err)
+	}
+
+	return refs, nil
+}
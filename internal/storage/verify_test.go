@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+	s, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, s.SaveEmailBatch([]*Email{
+		{UID: 1, Mailbox: "INBOX", RawMessage: []byte(attachmentTestMsg)},
+		{UID: 2, Mailbox: "INBOX", RawMessage: []byte("Subject: ok\r\n\r\nfine")},
+		{UID: 3, Mailbox: "INBOX", RawMessage: []byte("Subject: lost\r\n\r\ncontent")},
+	}))
+
+	issues, err := s.Verify()
+	require.NoError(t, err)
+	assert.Empty(t, issues)
+
+	_, err = s.db.Exec(`UPDATE email_content SET raw_message = x'1f8b0800deadbeef' WHERE uid = 2`)
+	require.NoError(t, err)
+	_, err = s.db.Exec(`UPDATE attachments SET content = x'01ffff' WHERE uid = 1`)
+	require.NoError(t, err)
+	_, err = s.db.Exec(`DELETE FROM email_content WHERE uid = 3`)
+	require.NoError(t, err)
+	_, err = s.db.Exec(`INSERT INTO email_content (mailbox, uid) VALUES ('Archive', 9)`)
+	require.NoError(t, err)
+
+	issues, err = s.Verify()
+	require.NoError(t, err)
+	require.Len(t, issues, 4)
+
+	assert.Equal(t, "INBOX UID 3: email has no content row", issues[0].String())
+	assert.Equal(t, "Archive UID 9: content row has no email", issues[1].String())
+	assert.Contains(t, issues[2].String(), "INBOX UID 2: raw message: ")
+	assert.Contains(t, issues[3].String(), "INBOX UID 1: attachment 1: ")
+}
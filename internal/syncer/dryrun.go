@@ -0,0 +1,86 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+
+	imap2 "github.com/emersion/go-imap/v2"
+	"github.com/newsamples/imapsync/internal/storage"
+)
+
+// WithDryRun makes SyncAll and SyncMailbox only report what they would
+// download: message sizes are fetched, but no bodies, and nothing is
+// written to storage.
+func WithDryRun(enabled bool) Option {
+	return func(s *Syncer) {
+		s.dryRun = enabled
+	}
+}
+
+// planMailbox is SyncMailbox in dry-run mode. It finds the messages a sync
+// would download from the selected mailbox and logs how many and how large
+// they are. NewMessages counts them; deletions and flag changes aren't
+// looked for.
+func (s *Syncer) planMailbox(ctx context.Context, mailbox string, selectData *imap2.SelectData, state *storage.MailboxState) (*Stats, error) {
+	if selectData.NumMessages == 0 {
+		s.log.Infof("Dry run: mailbox %s is empty, nothing to download", mailbox)
+		return &Stats{}, nil
+	}
+
+	uids, err := s.client.SearchAllWithContext(ctx, s.dateRange)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+
+	uidsToSync, err := s.missingUIDs(ctx, mailbox, uids, state)
+	if err != nil {
+		return nil, err
+	}
+
+	if policy := s.policyFor(mailbox); policy != FetchFull {
+		s.log.Infof("Dry run: mailbox %s: %d messages total, %d would be stored without bodies (%s policy)",
+			mailbox, len(uids), len(uidsToSync), policy)
+		return &Stats{TotalMessages: len(uids), NewMessages: len(uidsToSync)}, nil
+	}
+
+	var size, skipped int64
+	for i := 0; i < len(uidsToSync); i += metadataBatchSize {
+		end := min(i+metadataBatchSize, len(uidsToSync))
+		envelopes, err := s.client.FetchEnvelopes(ctx, uidSet(uidsToSync[i:end]))
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch envelopes: %w", err)
+		}
+		for _, msg := range envelopes {
+			if s.maxMessageSize > 0 && int64(msg.Size) > s.maxMessageSize {
+				skipped++
+				continue
+			}
+			size += int64(msg.Size)
+		}
+	}
+
+	if skipped > 0 {
+		s.log.Infof("Dry run: mailbox %s: %d messages total, %d would be downloaded (about %s), %d over the max message size without bodies",
+			mailbox, len(uids), len(uidsToSync), formatSize(size), skipped)
+	} else {
+		s.log.Infof("Dry run: mailbox %s: %d messages total, %d would be downloaded (about %s)",
+			mailbox, len(uids), len(uidsToSync), formatSize(size))
+	}
+
+	return &Stats{TotalMessages: len(uids), NewMessages: len(uidsToSync)}, nil
+}
+
+// formatSize renders a byte count with a binary unit, e.g. "1.5 MiB".
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,39 @@
+package syncer
+
+// MailboxFilter selects mailboxes by name, as requested on the command line.
+// Patterns are exact names or use * as a wildcard, matched the same way as
+// the Gmail include/exclude folders.
+type MailboxFilter struct {
+	// Include lists the only mailboxes to sync. Empty means all.
+	Include []string
+	// Exclude lists mailboxes to skip when Include is empty.
+	Exclude []string
+}
+
+// Match reports whether the mailbox should be synced.
+func (f *MailboxFilter) Match(mailbox string) bool {
+	if len(f.Include) > 0 {
+		return matchesAnyWildcard(mailbox, f.Include)
+	}
+	return !matchesAnyWildcard(mailbox, f.Exclude)
+}
+
+// FilterMailboxes returns the mailboxes that match, in their original order.
+func (f *MailboxFilter) FilterMailboxes(mailboxes []string) []string {
+	filtered := make([]string, 0, len(mailboxes))
+	for _, mailbox := range mailboxes {
+		if f.Match(mailbox) {
+			filtered = append(filtered, mailbox)
+		}
+	}
+	return filtered
+}
+
+func matchesAnyWildcard(mailbox string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if simpleWildcardMatch(pattern, mailbox) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package syncer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMailboxFilter_FilterMailboxes(t *testing.T) {
+	mailboxes := []string{"INBOX", "Sent", "Archive/2023", "Archive/2024", "[Gmail]/Spam", "Drafts"}
+
+	tests := []struct {
+		name     string
+		filter   MailboxFilter
+		expected []string
+	}{
+		{
+			name:     "include only",
+			filter:   MailboxFilter{Include: []string{"INBOX", "Drafts"}},
+			expected: []string{"INBOX", "Drafts"},
+		},
+		{
+			name:     "include glob",
+			filter:   MailboxFilter{Include: []string{"Archive/*"}},
+			expected: []string{"Archive/2023", "Archive/2024"},
+		},
+		{
+			name:     "include unknown",
+			filter:   MailboxFilter{Include: []string{"Nope"}},
+			expected: []string{},
+		},
+		{
+			name:     "exclude glob",
+			filter:   MailboxFilter{Exclude: []string{"Archive/*", "[Gmail]/*"}},
+			expected: []string{"INBOX", "Sent", "Drafts"},
+		},
+		{
+			name:     "exclude suffix glob",
+			filter:   MailboxFilter{Exclude: []string{"*/2023"}},
+			expected: []string{"INBOX", "Sent", "Archive/2024", "[Gmail]/Spam", "Drafts"},
+		},
+		{
+			name:     "include wins over exclude",
+			filter:   MailboxFilter{Include: []string{"Archive/*"}, Exclude: []string{"Archive/2023"}},
+			expected: []string{"Archive/2023", "Archive/2024"},
+		},
+		{
+			name:     "empty filter",
+			filter:   MailboxFilter{},
+			expected: mailboxes,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.filter.FilterMailboxes(mailboxes))
+		})
+	}
+}
+
+func TestWithMailboxFilter(t *testing.T) {
+	s := &Syncer{}
+	WithMailboxFilter(nil, nil)(s)
+	assert.Nil(t, s.mailboxFilter)
+
+	WithMailboxFilter([]string{"INBOX"}, nil)(s)
+	assert.Equal(t, &MailboxFilter{Include: []string{"INBOX"}}, s.mailboxFilter)
+}
@@ -0,0 +1,147 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+
+	imap2 "github.com/emersion/go-imap/v2"
+	"github.com/newsamples/imapsync/internal/imap"
+)
+
+// mailboxScan is what the pre-pass of SyncAll found in a mailbox: how many
+// messages it has to download, and the results of its searches, which
+// SyncMailbox reuses while the mailbox is unchanged.
+type mailboxScan struct {
+	uidValidity uint32
+	uidNext     imap2.UID
+	numMessages uint32
+
+	uids       []uint32 // matching the date range
+	serverUIDs []uint32 // all of them
+	toSync     int
+}
+
+// scanMailboxes selects each mailbox and counts the messages it has to
+// download, so the size of the whole sync is known before it starts. A
+// mailbox that can't be scanned is left out; SyncMailbox reports its error.
+func (s *Syncer) scanMailboxes(ctx context.Context, mailboxes []string) map[string]*mailboxScan {
+	scans := make(map[string]*mailboxScan, len(mailboxes))
+	for _, mailbox := range mailboxes {
+		if ctx.Err() != nil {
+			break
+		}
+		scan, err := s.scanMailbox(ctx, mailbox)
+		if err != nil {
+			s.log.WithError(err).Debugf("Failed to scan mailbox %s before syncing", mailbox)
+			continue
+		}
+		scans[mailbox] = scan
+	}
+	return scans
+}
+
+func (s *Syncer) scanMailbox(ctx context.Context, mailbox string) (*mailboxScan, error) {
+	selectData, err := s.client.SelectMailboxWithContext(ctx, mailbox)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select mailbox: %w", err)
+	}
+
+	scan := &mailboxScan{
+		uidValidity: selectData.UIDValidity,
+		uidNext:     selectData.UIDNext,
+		numMessages: selectData.NumMessages,
+	}
+	if selectData.NumMessages == 0 {
+		return scan, nil
+	}
+
+	if scan.uids, err = s.client.SearchAllWithContext(ctx, s.dateRange); err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+	scan.serverUIDs = scan.uids
+	if !s.dateRange.IsZero() {
+		if scan.serverUIDs, err = s.client.SearchAllWithContext(ctx, imap.DateRange{}); err != nil {
+			return nil, fmt.Errorf("failed to search messages: %w", err)
+		}
+	}
+
+	state, err := s.storage.GetMailboxStateContext(ctx, mailbox)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get mailbox state: %w", err)
+	}
+	// As in resumeState, stored emails only count under the same
+	// UIDVALIDITY.
+	if state != nil && state.UIDValidity != selectData.UIDValidity {
+		state = nil
+	}
+	missing, err := s.missingUIDs(ctx, mailbox, scan.uids, state)
+	if err != nil {
+		return nil, err
+	}
+	scan.toSync = len(missing)
+	return scan, nil
+}
+
+// takeScan returns the scan of mailbox if it still describes the mailbox
+// as just selected, and forgets it. Without new or expunged messages, the
+// UIDNEXT and message count are those of the scan.
+func (s *Syncer) takeScan(mailbox string, selectData *imap2.SelectData) *mailboxScan {
+	scan := s.scans[mailbox]
+	delete(s.scans, mailbox)
+
+	if scan == nil || scan.uidNext == 0 ||
+		scan.uidValidity != selectData.UIDValidity ||
+		scan.uidNext != selectData.UIDNext ||
+		scan.numMessages != selectData.NumMessages {
+		return nil
+	}
+	return scan
+}
+
+// overallProgress follows SyncAll across its mailboxes.
+type overallProgress struct {
+	mailboxes int // in the sync
+	index     int // of the mailbox being synced, from 1
+	total     int // messages to download in all mailboxes
+	done      int // messages downloaded by finished passes
+}
+
+// newOverallProgress sums the messages the scans found to download. In
+// mailboxes where twoPass reports WithMetadataFirst's two passes, each
+// message counts twice.
+func newOverallProgress(mailboxes []string, scans map[string]*mailboxScan, twoPass func(mailbox string) bool) *overallProgress {
+	o := &overallProgress{mailboxes: len(mailboxes)}
+	for _, mailbox := range mailboxes {
+		scan := scans[mailbox]
+		if scan == nil {
+			continue
+		}
+		o.total += scan.toSync
+		if twoPass(mailbox) {
+			o.total += scan.toSync
+		}
+	}
+	return o
+}
+
+// annotate adds the overall progress to ev, and counts the messages of a
+// finished pass as done.
+func (o *overallProgress) annotate(ev ProgressEvent) ProgressEvent {
+	ev.MailboxIndex = o.index
+	ev.Mailboxes = o.mailboxes
+	ev.OverallDone = o.done + ev.Done
+	ev.OverallTotal = o.total
+	if ev.Kind == ProgressFinish {
+		o.done += ev.Done
+	}
+	return ev
+}
+
+// percent returns done as a percentage of total. Messages found after the
+// scan can take done past total, so it is capped at 100.
+func percent(done, total int) int {
+	if total <= 0 {
+		return 0
+	}
+	return min(100, done*100/total)
+}
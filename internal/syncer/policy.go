@@ -0,0 +1,73 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/newsamples/imapsync/internal/storage"
+)
+
+// FetchPolicy is how much of the messages of a mailbox is downloaded.
+type FetchPolicy string
+
+const (
+	// FetchFull downloads whole messages.
+	FetchFull FetchPolicy = "full"
+	// FetchHeaders stores the envelope, flags, size and headers, which
+	// keeps a mailbox searchable without its bodies.
+	FetchHeaders FetchPolicy = "headers"
+	// FetchMetadata stores the envelope, flags and size only.
+	FetchMetadata FetchPolicy = "metadata"
+)
+
+// WithMailboxPolicies sets the fetch policy of the mailboxes matching each
+// pattern, an exact name or one using * as a wildcard like WithMailboxFilter.
+// An exact name wins over patterns, and a longer pattern over a shorter one.
+// Other mailboxes use FetchFull.
+func WithMailboxPolicies(policies map[string]FetchPolicy) Option {
+	return func(s *Syncer) {
+		s.mailboxPolicies = policies
+	}
+}
+
+// policyFor returns the fetch policy of mailbox.
+func (s *Syncer) policyFor(mailbox string) FetchPolicy {
+	if policy, ok := s.mailboxPolicies[mailbox]; ok {
+		return policy
+	}
+
+	policy, best := FetchFull, ""
+	for pattern, p := range s.mailboxPolicies {
+		if !strings.Contains(pattern, "*") || !simpleWildcardMatch(pattern, mailbox) {
+			continue
+		}
+		// Ties are broken by name, so the choice doesn't depend on map
+		// order.
+		if len(pattern) > len(best) || len(pattern) == len(best) && pattern < best {
+			policy, best = p, pattern
+		}
+	}
+	return policy
+}
+
+// twoPass reports whether new messages of mailbox are downloaded in the two
+// passes of WithMetadataFirst. Mailboxes without bodies take one.
+func (s *Syncer) twoPass(mailbox string) bool {
+	return s.metadataFirst && s.policyFor(mailbox) == FetchFull
+}
+
+// fetchEnvelopeBatch downloads the flags, envelope and size of uids, for
+// FetchMetadata mailboxes. Nothing of the bodies is transferred.
+func (s *Syncer) fetchEnvelopeBatch(ctx context.Context, mailbox string, uids []uint32) ([]*storage.Email, int64, error) {
+	messages, err := s.client.FetchMetadata(ctx, uidSet(uids))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch envelopes: %w", err)
+	}
+
+	emails := make([]*storage.Email, 0, len(messages))
+	for _, msg := range messages {
+		emails = append(emails, s.convertToEmail(mailbox, msg))
+	}
+	return emails, 0, nil
+}
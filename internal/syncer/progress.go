@@ -0,0 +1,187 @@
+package syncer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/schollz/progressbar/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// ProgressFormat selects how sync progress is shown.
+type ProgressFormat string
+
+const (
+	// ProgressFormatBar draws a terminal progress bar per mailbox. With
+	// SyncAll, it is labelled with the overall progress.
+	ProgressFormatBar ProgressFormat = "bar"
+	// ProgressFormatPlain logs "mailbox: done/total" lines, for CI logs and
+	// other output that isn't a terminal.
+	ProgressFormatPlain ProgressFormat = "plain"
+	// ProgressFormatNone shows no progress beyond a log line per batch.
+	ProgressFormatNone ProgressFormat = "none"
+)
+
+// ParseProgressFormat validates a progress format name.
+func ParseProgressFormat(name string) (ProgressFormat, error) {
+	switch format := ProgressFormat(strings.ToLower(name)); format {
+	case ProgressFormatBar, ProgressFormatPlain, ProgressFormatNone:
+		return format, nil
+	default:
+		return "", fmt.Errorf("invalid progress format %q: expected bar, plain or none", name)
+	}
+}
+
+// WithProgressFormat selects how progress is shown. The default is
+// ProgressFormatNone.
+func WithProgressFormat(format ProgressFormat) Option {
+	return func(s *Syncer) {
+		s.progressFormat = format
+	}
+}
+
+// ProgressKind identifies what a ProgressEvent reports.
+type ProgressKind int
+
+const (
+	// ProgressStart is sent before the first batch of a mailbox is downloaded.
+	ProgressStart ProgressKind = iota
+	// ProgressBatch is sent after each batch is saved.
+	ProgressBatch
+	// ProgressFinish is sent once the mailbox is done, or the download
+	// stopped early because of an error or cancellation.
+	ProgressFinish
+)
+
+// ProgressEvent reports the download progress of a mailbox. With
+// WithMetadataFirst, the metadata and body passes are reported as two
+// separate start/finish sequences.
+type ProgressEvent struct {
+	Kind    ProgressKind
+	Mailbox string
+	// Done is the number of messages downloaded so far, out of Total.
+	Done  int
+	Total int
+	// Bytes is the size of the message bodies downloaded so far.
+	Bytes int64
+
+	// With SyncAll, Mailbox is the MailboxIndex-th of Mailboxes, and
+	// OverallDone messages are downloaded out of the OverallTotal found in
+	// all of them before the sync started. They are zero otherwise.
+	MailboxIndex int
+	Mailboxes    int
+	OverallDone  int
+	OverallTotal int
+}
+
+// OverallPercent returns how much of the whole sync is done, from 0 to 100.
+func (ev ProgressEvent) OverallPercent() int {
+	return percent(ev.OverallDone, ev.OverallTotal)
+}
+
+// WithProgressCallback registers fn to receive progress events. It is called
+// synchronously from the sync goroutine, so it should return quickly.
+func WithProgressCallback(fn func(ProgressEvent)) Option {
+	return func(s *Syncer) {
+		s.progressCallback = fn
+	}
+}
+
+// showsProgress reports whether progress events are shown or passed on.
+func (s *Syncer) showsProgress() bool {
+	return s.progressFormat != ProgressFormatNone || s.progressCallback != nil
+}
+
+func (s *Syncer) reportProgress(ev ProgressEvent) {
+	if s.overall != nil {
+		ev = s.overall.annotate(ev)
+	}
+
+	switch s.progressFormat {
+	case ProgressFormatBar:
+		s.progressBar.handle(ev)
+	case ProgressFormatPlain:
+		s.plainProgress.handle(s.log, ev)
+	}
+	if s.progressCallback != nil {
+		s.progressCallback(ev)
+	}
+}
+
+// progressBar renders progress events as a terminal progress bar.
+type progressBar struct {
+	bar *progressbar.ProgressBar
+}
+
+func (p *progressBar) handle(ev ProgressEvent) {
+	switch ev.Kind {
+	case ProgressStart:
+		p.bar = progressbar.NewOptions(ev.Total,
+			progressbar.OptionSetDescription(barDescription(ev)),
+			progressbar.OptionShowCount(),
+			progressbar.OptionSetWidth(40),
+			progressbar.OptionShowIts(),
+			progressbar.OptionSetItsString("msgs"),
+		)
+	case ProgressBatch:
+		if p.bar != nil {
+			if ev.Mailboxes > 0 {
+				p.bar.Describe(barDescription(ev))
+			}
+			p.bar.Set(ev.Done)
+		}
+	case ProgressFinish:
+		if p.bar != nil {
+			p.bar.Finish()
+			fmt.Println()
+			p.bar = nil
+		}
+	}
+}
+
+// barDescription labels the bar of a mailbox, with its position and the
+// overall progress under SyncAll, e.g. "[3/12, 45%] INBOX".
+func barDescription(ev ProgressEvent) string {
+	description := ev.Mailbox
+	if ev.Mailboxes > 0 {
+		description = fmt.Sprintf("[%d/%d, %d%%] %s", ev.MailboxIndex, ev.Mailboxes, ev.OverallPercent(), ev.Mailbox)
+	}
+	return fmt.Sprintf("%-30s", description)
+}
+
+// plainProgressEvery is how many messages a plain progress line stands for
+// at most, so large mailboxes don't flood the log.
+const plainProgressEvery = 100
+
+// plainProgress renders progress events as log lines: one each time another
+// plainProgressEvery messages are done, and one when the mailbox finishes.
+// With SyncAll, lines also tell the position of the mailbox and the overall
+// progress.
+type plainProgress struct {
+	logged int // Done as of the last line
+}
+
+func (p *plainProgress) handle(log logrus.FieldLogger, ev ProgressEvent) {
+	switch ev.Kind {
+	case ProgressStart:
+		p.logged = 0
+	case ProgressBatch:
+		if ev.Done/plainProgressEvery > p.logged/plainProgressEvery {
+			log.Info(plainProgressLine(ev))
+			p.logged = ev.Done
+		}
+	case ProgressFinish:
+		if ev.Done != p.logged {
+			log.Info(plainProgressLine(ev))
+			p.logged = ev.Done
+		}
+	}
+}
+
+func plainProgressLine(ev ProgressEvent) string {
+	line := fmt.Sprintf("%s: %d/%d", ev.Mailbox, ev.Done, ev.Total)
+	if ev.Mailboxes > 0 {
+		line += fmt.Sprintf(" (mailbox %d/%d, overall %d%%)", ev.MailboxIndex, ev.Mailboxes, ev.OverallPercent())
+	}
+	return line
+}
@@ -0,0 +1,40 @@
+package syncer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	t.Run("burst passes without delay", func(t *testing.T) {
+		l := newRateLimiter(10000)
+
+		start := time.Now()
+		require.NoError(t, l.wait(context.Background(), 10000))
+		assert.Less(t, time.Since(start), 50*time.Millisecond)
+	})
+
+	t.Run("delays once the bucket is empty", func(t *testing.T) {
+		l := newRateLimiter(10000)
+		require.NoError(t, l.wait(context.Background(), 10000))
+
+		start := time.Now()
+		require.NoError(t, l.wait(context.Background(), 2000))
+		assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+	})
+
+	t.Run("context cancels the wait", func(t *testing.T) {
+		l := newRateLimiter(1)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		assert.ErrorIs(t, l.wait(ctx, 1000), context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+}
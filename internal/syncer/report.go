@@ -0,0 +1,29 @@
+package syncer
+
+import (
+	"time"
+
+	"github.com/newsamples/imapsync/internal/imap"
+)
+
+// SyncReport is the outcome of a SyncAll run, meant to be written as JSON
+// for automation.
+type SyncReport struct {
+	StartedAt       time.Time       `json:"started_at"`
+	DurationSeconds float64         `json:"duration_seconds"`
+	Mailboxes       []MailboxReport `json:"mailboxes"`
+	Totals          Stats           `json:"totals"`
+	// Failed is the number of mailboxes whose sync returned an error.
+	Failed int `json:"failed"`
+	// Retries counts the reconnections the run needed.
+	Retries imap.RetryStats `json:"retries"`
+}
+
+// MailboxReport is the outcome of syncing one mailbox. Stats are zero when
+// Error is set.
+type MailboxReport struct {
+	Mailbox string `json:"mailbox"`
+	Stats
+	DurationSeconds float64 `json:"duration_seconds"`
+	Error           string  `json:"error,omitempty"`
+}
@@ -0,0 +1,164 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	imap2 "github.com/emersion/go-imap/v2"
+	"github.com/newsamples/imapsync/internal/imap"
+)
+
+// RestoreOptions controls which stored emails Restore uploads.
+type RestoreOptions struct {
+	// Mailbox restricts the restore to a single mailbox. Empty restores all.
+	Mailbox string
+	// DryRun logs what would be uploaded without changing the server.
+	DryRun bool
+}
+
+type RestoreStats struct {
+	Mailboxes int
+	Uploaded  int
+	Skipped   int
+}
+
+// Restore uploads stored emails back to the IMAP server, creating missing
+// mailboxes. Messages whose Message-ID already exists in the target mailbox
+// are skipped, so running it repeatedly is idempotent.
+func (s *Syncer) Restore(ctx context.Context, opts RestoreOptions) (*RestoreStats, error) {
+	mailboxes, err := s.storage.ListMailboxesContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stored mailboxes: %w", err)
+	}
+
+	if opts.Mailbox != "" {
+		if !slices.Contains(mailboxes, opts.Mailbox) {
+			return nil, fmt.Errorf("mailbox %q not found in storage", opts.Mailbox)
+		}
+		mailboxes = []string{opts.Mailbox}
+	}
+
+	remote, err := s.client.ListMailboxesWithContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mailboxes: %w", err)
+	}
+
+	var total RestoreStats
+
+	for _, mailbox := range mailboxes {
+		if err := ctx.Err(); err != nil {
+			return &total, err
+		}
+
+		// Stored names use the delimiter of the server they came from,
+		// usually /; a server using another one gets them converted.
+		target := s.client.MailboxPath(mailbox)
+
+		stats, err := s.restoreMailbox(ctx, mailbox, target, slices.Contains(remote, target), opts.DryRun)
+		if err != nil {
+			return &total, fmt.Errorf("failed to restore mailbox %s: %w", mailbox, err)
+		}
+
+		total.Mailboxes++
+		total.Uploaded += stats.Uploaded
+		total.Skipped += stats.Skipped
+	}
+
+	if opts.DryRun {
+		s.log.Infof("Dry run completed: %d mailboxes, %d messages would be uploaded, %d already present",
+			total.Mailboxes, total.Uploaded, total.Skipped)
+	} else {
+		s.log.Infof("Restore completed: %d mailboxes, %d messages uploaded, %d already present",
+			total.Mailboxes, total.Uploaded, total.Skipped)
+	}
+
+	return &total, nil
+}
+
+// restoreMailbox uploads the stored mailbox to target on the server.
+func (s *Syncer) restoreMailbox(ctx context.Context, mailbox, target string, exists, dryRun bool) (*RestoreStats, error) {
+	stats := &RestoreStats{}
+
+	existing := make(map[string]struct{})
+	switch {
+	case exists:
+		ids, err := s.client.ListMessageIDs(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+		existing = ids
+	case dryRun:
+		s.log.Infof("Would create mailbox: %s", target)
+	default:
+		s.log.Infof("Creating mailbox: %s", target)
+		if err := s.client.CreateMailbox(ctx, target); err != nil {
+			return nil, err
+		}
+	}
+
+	uids, err := s.storage.ListLiveUIDsContext(ctx, mailbox)
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(uids)
+
+	for _, uid := range uids {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+
+		email, err := s.storage.GetEmailContext(ctx, mailbox, uid)
+		if err != nil {
+			return stats, err
+		}
+
+		if len(email.RawMessage) == 0 {
+			s.log.Warnf("Skipping UID %d in %s: no raw message stored", uid, mailbox)
+			stats.Skipped++
+			continue
+		}
+
+		messageID := imap.MessageID(email.RawMessage)
+		if messageID != "" {
+			if _, ok := existing[messageID]; ok {
+				stats.Skipped++
+				continue
+			}
+			existing[messageID] = struct{}{}
+		}
+
+		if dryRun {
+			s.log.Infof("Would upload to %s: UID %d %q (%d bytes)", target, uid, email.Subject, len(email.RawMessage))
+			stats.Uploaded++
+			continue
+		}
+
+		date := email.Date
+		if email.InternalDate != nil {
+			date = *email.InternalDate
+		}
+		if err := s.client.AppendMessage(ctx, target, restoreFlags(email.Flags), date, email.RawMessage); err != nil {
+			return stats, fmt.Errorf("failed to upload UID %d: %w", uid, err)
+		}
+		stats.Uploaded++
+	}
+
+	s.log.Infof("Mailbox %s: %d uploaded, %d skipped", mailbox, stats.Uploaded, stats.Skipped)
+
+	return stats, nil
+}
+
+// restoreFlags converts stored flags for APPEND. \Recent is session state and
+// cannot be set by clients.
+func restoreFlags(flags []string) []imap2.Flag {
+	result := make([]imap2.Flag, 0, len(flags))
+	for _, flag := range flags {
+		if strings.EqualFold(flag, `\Recent`) {
+			continue
+		}
+		result = append(result, imap2.Flag(flag))
+	}
+	return result
+}
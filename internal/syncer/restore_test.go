@@ -0,0 +1,128 @@
+package syncer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	imap2 "github.com/emersion/go-imap/v2"
+	"github.com/newsamples/imapsync/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func seedRestoreStorage(t *testing.T, store *storage.Storage) {
+	t.Helper()
+
+	emails := []*storage.Email{
+		{
+			UID:        1,
+			Mailbox:    "INBOX",
+			Subject:    "First",
+			Flags:      []string{`\Seen`, `\Recent`},
+			Date:       time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC),
+			RawMessage: []byte("Message-ID: <first@example.com>\r\nSubject: First\r\n\r\nFirst body."),
+		},
+		{
+			UID:        2,
+			Mailbox:    "INBOX",
+			Subject:    "Second",
+			Date:       time.Date(2024, 3, 2, 9, 0, 0, 0, time.UTC),
+			RawMessage: []byte("Message-ID: <second@example.com>\r\nSubject: Second\r\n\r\nSecond body."),
+		},
+		{
+			UID:        1,
+			Mailbox:    "Archive",
+			Subject:    "Archived",
+			Flags:      []string{`\Flagged`},
+			Date:       time.Date(2023, 1, 1, 9, 0, 0, 0, time.UTC),
+			RawMessage: []byte("Message-ID: <archived@example.com>\r\nSubject: Archived\r\n\r\nOld body."),
+		},
+	}
+	require.NoError(t, store.SaveEmailBatch(emails))
+	require.NoError(t, store.SaveMailboxState(&storage.MailboxState{Name: "INBOX", UIDValidity: 1, LastUID: 2}))
+	require.NoError(t, store.SaveMailboxState(&storage.MailboxState{Name: "Archive", UIDValidity: 1, LastUID: 1}))
+}
+
+func TestRestore_UploadsAndIsIdempotent(t *testing.T) {
+	opts, cleanup := newSyncTestServer(t)
+	defer cleanup()
+
+	s, store := newTestSyncer(t, opts)
+	seedRestoreStorage(t, store)
+
+	// One message is already on the server and must not be duplicated.
+	require.NoError(t, s.client.AppendMessage(context.Background(), "INBOX", nil, time.Now(),
+		[]byte("Message-ID: <second@example.com>\r\nSubject: Second\r\n\r\nSecond body.")))
+
+	stats, err := s.Restore(context.Background(), RestoreOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Mailboxes)
+	assert.Equal(t, 2, stats.Uploaded)
+	assert.Equal(t, 1, stats.Skipped)
+
+	mailboxes, err := s.client.ListMailboxesWithContext(context.Background())
+	require.NoError(t, err)
+	assert.Contains(t, mailboxes, "Archive", "missing mailbox is created")
+
+	ids, err := s.client.ListMessageIDs(context.Background(), "INBOX")
+	require.NoError(t, err)
+	assert.Len(t, ids, 2)
+
+	_, err = s.client.SelectMailboxWithContext(context.Background(), "Archive")
+	require.NoError(t, err)
+	msgs, err := s.client.FetchMessagesWithContext(context.Background(), imap2.SeqSetNum(1))
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	assert.Contains(t, msgs[0].Flags, imap2.FlagFlagged)
+
+	stats, err = s.Restore(context.Background(), RestoreOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.Uploaded)
+	assert.Equal(t, 3, stats.Skipped)
+}
+
+func TestRestore_SingleMailbox(t *testing.T) {
+	opts, cleanup := newSyncTestServer(t)
+	defer cleanup()
+
+	s, store := newTestSyncer(t, opts)
+	seedRestoreStorage(t, store)
+
+	stats, err := s.Restore(context.Background(), RestoreOptions{Mailbox: "Archive"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Mailboxes)
+	assert.Equal(t, 1, stats.Uploaded)
+
+	ids, err := s.client.ListMessageIDs(context.Background(), "INBOX")
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+
+	_, err = s.Restore(context.Background(), RestoreOptions{Mailbox: "Nope"})
+	assert.ErrorContains(t, err, "not found in storage")
+}
+
+func TestRestore_DryRun(t *testing.T) {
+	opts, cleanup := newSyncTestServer(t)
+	defer cleanup()
+
+	s, store := newTestSyncer(t, opts)
+	seedRestoreStorage(t, store)
+
+	stats, err := s.Restore(context.Background(), RestoreOptions{DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.Uploaded)
+
+	mailboxes, err := s.client.ListMailboxesWithContext(context.Background())
+	require.NoError(t, err)
+	assert.NotContains(t, mailboxes, "Archive")
+
+	ids, err := s.client.ListMessageIDs(context.Background(), "INBOX")
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+}
+
+func TestRestoreFlags(t *testing.T) {
+	flags := restoreFlags([]string{`\Seen`, `\Recent`, "$Label"})
+	assert.Equal(t, []imap2.Flag{imap2.FlagSeen, "$Label"}, flags)
+}
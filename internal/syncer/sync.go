@@ -2,14 +2,18 @@ package syncer
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"slices"
 	"time"
 
 	imap2 "github.com/emersion/go-imap/v2"
 	"github.com/newsamples/imapsync/internal/config"
 	"github.com/newsamples/imapsync/internal/imap"
+	"github.com/newsamples/imapsync/internal/mailparse"
+	"github.com/newsamples/imapsync/internal/metrics"
 	"github.com/newsamples/imapsync/internal/storage"
-	"github.com/schollz/progressbar/v3"
 	"github.com/sirupsen/logrus"
 )
 
@@ -17,25 +21,73 @@ type Syncer struct {
 	client         *imap.Client
 	storage        *storage.Storage
 	log            *logrus.Logger
-	showProgress   bool
+	progressFormat ProgressFormat
 	gmailFilter    *GmailFilter
+	gmailConfig    *config.GmailConfig
+	gmailServer    *bool
+	mailboxFilter  *MailboxFilter
 	purgeAfterDays int
+	batchSize      int
+	syncFlags      bool
+	dateRange      imap.DateRange
+	maxMessageSize int64
+	metadataFirst  bool
+	rateLimiter    *rateLimiter
+	strict         bool
+	dryRun         bool
+	failFast       bool
+
+	mailboxPolicies map[string]FetchPolicy
+
+	progressCallback func(ProgressEvent)
+	progressBar      progressBar
+	plainProgress    plainProgress
+
+	// Set while SyncAll runs, when progress is shown.
+	scans   map[string]*mailboxScan
+	overall *overallProgress
 }
 
+// metadataBatchSize is the number of envelopes fetched per round-trip in the
+// metadata pass of WithMetadataFirst. Envelopes are small, so it is much
+// larger than the default body batch size.
+const metadataBatchSize = 500
+
 type Option func(*Syncer)
 
+// WithProgress shows progress bars, or no progress at all when disabled. It
+// is WithProgressFormat with ProgressFormatBar or ProgressFormatNone.
 func WithProgress(enabled bool) Option {
+	if enabled {
+		return WithProgressFormat(ProgressFormatBar)
+	}
+	return WithProgressFormat(ProgressFormatNone)
+}
+
+// WithGmailConfig applies the Gmail folder filter and label fetching when the
+// server turns out to be Gmail, as detected by Client.IsGmail.
+func WithGmailConfig(cfg *config.GmailConfig) Option {
 	return func(s *Syncer) {
-		s.showProgress = enabled
+		s.gmailConfig = cfg
 	}
 }
 
-func WithGmailConfig(cfg *config.GmailConfig, isGmail bool) Option {
+// WithGmailServer overrides Gmail detection, for servers that behave like
+// Gmail without saying so.
+func WithGmailServer(isGmail bool) Option {
 	return func(s *Syncer) {
-		s.gmailFilter = NewGmailFilter(cfg, isGmail)
-		// Enable Gmail label fetching if configured
-		if cfg.IsEnabled() && cfg.ShouldFetchLabels() && isGmail {
-			s.client.SetFetchGmailLabels(true)
+		s.gmailServer = &isGmail
+	}
+}
+
+// WithMailboxFilter restricts SyncAll to the included mailboxes, or to all
+// but the excluded ones when include is empty. Mailboxes included explicitly
+// bypass the Gmail folder filter, so e.g. [Gmail]/All Mail can be synced on
+// request.
+func WithMailboxFilter(include, exclude []string) Option {
+	return func(s *Syncer) {
+		if len(include) > 0 || len(exclude) > 0 {
+			s.mailboxFilter = &MailboxFilter{Include: include, Exclude: exclude}
 		}
 	}
 }
@@ -48,41 +100,176 @@ func WithPurgeAfterDays(days int) Option {
 	}
 }
 
+// WithBatchSize sets how many messages are fetched per round-trip. Memory use
+// grows with batch size times message size. Values below 1 are ignored.
+func WithBatchSize(n int) Option {
+	return func(s *Syncer) {
+		if n >= 1 {
+			s.batchSize = n
+		}
+	}
+}
+
+// WithSyncFlags enables refreshing the flags of already-downloaded messages
+// (read, flagged, answered...) on every mailbox sync.
+func WithSyncFlags(enabled bool) Option {
+	return func(s *Syncer) {
+		s.syncFlags = enabled
+	}
+}
+
+// WithSince limits syncing to messages received on or after the given date.
+func WithSince(t time.Time) Option {
+	return func(s *Syncer) {
+		s.dateRange.Since = t
+	}
+}
+
+// WithBefore limits syncing to messages received before the given date.
+func WithBefore(t time.Time) Option {
+	return func(s *Syncer) {
+		s.dateRange.Before = t
+	}
+}
+
+// WithMaxMessageSize skips downloading the body of messages larger than n
+// bytes; they are stored with their envelope and size only. 0 disables the
+// limit.
+func WithMaxMessageSize(n int64) Option {
+	return func(s *Syncer) {
+		s.maxMessageSize = n
+	}
+}
+
+// WithMetadataFirst makes SyncMailbox store the flags, envelope and size of
+// all new messages in one fast pass before downloading their bodies.
+func WithMetadataFirst(enabled bool) Option {
+	return func(s *Syncer) {
+		s.metadataFirst = enabled
+	}
+}
+
+// WithRateLimit caps the download rate of message bodies to bytesPerSec,
+// averaged over the size of fetched messages. 0 disables the limit.
+func WithRateLimit(bytesPerSec int) Option {
+	return func(s *Syncer) {
+		s.rateLimiter = nil
+		if bytesPerSec > 0 {
+			s.rateLimiter = newRateLimiter(bytesPerSec)
+		}
+	}
+}
+
+// WithStrictUIDValidity makes SyncMailbox fail instead of resyncing when the
+// stored state of a mailbox doesn't match the server, which usually means
+// the storage belongs to another account.
+func WithStrictUIDValidity(enabled bool) Option {
+	return func(s *Syncer) {
+		s.strict = enabled
+	}
+}
+
+// WithFailFast makes SyncAll stop at the first mailbox that fails, returning
+// its error, instead of logging it and syncing the others.
+func WithFailFast(enabled bool) Option {
+	return func(s *Syncer) {
+		s.failFast = enabled
+	}
+}
+
 func New(client *imap.Client, store *storage.Storage, log *logrus.Logger, opts ...Option) *Syncer {
 	s := &Syncer{
 		client:         client,
 		storage:        store,
 		log:            log,
-		showProgress:   false,
+		progressFormat: ProgressFormatNone,
 		gmailFilter:    nil, // Will be set when Gmail config is provided
 		purgeAfterDays: 90,
+		batchSize:      5,
 	}
 
 	for _, opt := range opts {
 		opt(s)
 	}
 
+	if s.gmailConfig != nil {
+		s.applyGmailConfig()
+	}
+
 	return s
 }
 
+func (s *Syncer) applyGmailConfig() {
+	cfg := s.gmailConfig
+
+	isGmail := false
+	switch {
+	case s.gmailServer != nil:
+		isGmail = *s.gmailServer
+	case cfg.IsEnabled() && s.client != nil:
+		isGmail = s.client.IsGmail()
+		if isGmail {
+			s.log.Info("Gmail server detected, applying Gmail-specific configuration")
+		}
+	}
+
+	s.gmailFilter = NewGmailFilter(cfg, isGmail)
+	// Enable Gmail label fetching if configured
+	if cfg.IsEnabled() && cfg.ShouldFetchLabels() && isGmail {
+		s.client.SetFetchGmailLabels(true)
+	}
+}
+
 type Stats struct {
-	TotalMessages   int
-	NewMessages     int
-	DeletedMessages int
+	TotalMessages   int `json:"total_messages"`
+	NewMessages     int `json:"new_messages"`
+	DeletedMessages int `json:"deleted_messages"`
+	UpdatedFlags    int `json:"updated_flags"`
 }
 
-func (s *Syncer) SyncAll(ctx context.Context) error {
-	s.purgeOldDeleted()
+func (s *Stats) add(other *Stats) {
+	s.TotalMessages += other.TotalMessages
+	s.NewMessages += other.NewMessages
+	s.DeletedMessages += other.DeletedMessages
+	s.UpdatedFlags += other.UpdatedFlags
+}
+
+// SyncAll syncs every mailbox that passes the filters. A mailbox that fails
+// is logged and recorded in the report without stopping the others, unless
+// WithFailFast is set. The report is returned even when the sync is
+// cancelled or stopped part-way.
+func (s *Syncer) SyncAll(ctx context.Context) (*SyncReport, error) {
+	report := &SyncReport{StartedAt: time.Now(), Mailboxes: []MailboxReport{}}
+	retries := s.client.RetryStats()
+	defer func() {
+		report.DurationSeconds = time.Since(report.StartedAt).Seconds()
+		report.Retries = s.client.RetryStats().Sub(retries)
+	}()
+
+	if !s.dryRun {
+		s.purgeOldDeleted()
+	}
 
 	mailboxes, err := s.client.ListMailboxesWithContext(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to list mailboxes: %w", err)
+		return report, fmt.Errorf("failed to list mailboxes: %w", err)
+	}
+
+	s.logQuota(ctx)
+
+	if s.mailboxFilter != nil {
+		listed := len(mailboxes)
+		mailboxes = s.mailboxFilter.FilterMailboxes(mailboxes)
+		if skipped := listed - len(mailboxes); skipped > 0 {
+			s.log.Infof("Mailbox filter: skipped %d mailboxes", skipped)
+		}
 	}
 
 	originalCount := len(mailboxes)
 
-	// Apply Gmail filtering if configured
-	if s.gmailFilter != nil {
+	// Apply Gmail filtering if configured, unless the user named the
+	// mailboxes to sync.
+	if s.gmailFilter != nil && (s.mailboxFilter == nil || len(s.mailboxFilter.Include) == 0) {
 		mailboxes = s.gmailFilter.FilterMailboxes(mailboxes)
 		if filteredCount := originalCount - len(mailboxes); filteredCount > 0 {
 			s.log.Infof("Gmail filter: skipped %d mailboxes (%.0f%%)", filteredCount, float64(filteredCount)/float64(originalCount)*100)
@@ -93,68 +280,109 @@ func (s *Syncer) SyncAll(ctx context.Context) error {
 
 	s.log.Infof("Found %d mailboxes to sync", len(mailboxes))
 
-	var totalStats Stats
-	processedMailboxes := 0
+	if s.showsProgress() && !s.dryRun {
+		s.scans = s.scanMailboxes(ctx, mailboxes)
+		s.overall = newOverallProgress(mailboxes, s.scans, s.twoPass)
+		s.log.Infof("Found %d messages to download", s.overall.total)
+		defer func() { s.scans, s.overall = nil, nil }()
+	}
 
-	for _, mailbox := range mailboxes {
+	for i, mailbox := range mailboxes {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return report, ctx.Err()
 		default:
 		}
 
-		if !s.showProgress {
-			s.log.Infof("Syncing mailbox: %s", mailbox)
+		if s.overall != nil {
+			s.overall.index = i + 1
+		}
+		if s.progressFormat != ProgressFormatBar {
+			if s.overall != nil && s.overall.total > 0 {
+				s.log.Infof("Syncing mailbox %d/%d: %s (overall %d%%)", i+1, len(mailboxes), mailbox, percent(s.overall.done, s.overall.total))
+			} else {
+				s.log.Infof("Syncing mailbox: %s", mailbox)
+			}
 		}
 
+		started := time.Now()
 		stats, err := s.SyncMailbox(ctx, mailbox)
+		entry := MailboxReport{Mailbox: mailbox}
+		entry.DurationSeconds = time.Since(started).Seconds()
+
 		if err != nil {
 			if ctx.Err() != nil {
-				return ctx.Err()
+				return report, ctx.Err()
+			}
+			entry.Error = err.Error()
+			report.Failed++
+			report.Mailboxes = append(report.Mailboxes, entry)
+			if s.failFast {
+				return report, fmt.Errorf("mailbox %s: %w", mailbox, err)
 			}
 			s.log.WithError(err).Errorf("Failed to sync mailbox: %s", mailbox)
 			continue
 		}
 
-		processedMailboxes++
-		totalStats.TotalMessages += stats.TotalMessages
-		totalStats.NewMessages += stats.NewMessages
-		totalStats.DeletedMessages += stats.DeletedMessages
+		entry.Stats = *stats
+		report.Totals.add(stats)
+		report.Mailboxes = append(report.Mailboxes, entry)
 
-		if !s.showProgress {
+		if s.progressFormat != ProgressFormatBar {
 			s.log.Infof("Completed sync for mailbox: %s", mailbox)
 		}
 	}
 
+	if s.dryRun {
+		s.log.Infof("Dry run completed: %d mailboxes checked, %d messages total, %d would be downloaded",
+			len(report.Mailboxes)-report.Failed, report.Totals.TotalMessages, report.Totals.NewMessages)
+		return report, nil
+	}
+
 	s.log.Infof("Sync completed: %d mailboxes processed, %d messages total, %d new synced, %d deleted",
-		processedMailboxes, totalStats.TotalMessages, totalStats.NewMessages, totalStats.DeletedMessages)
+		len(report.Mailboxes)-report.Failed, report.Totals.TotalMessages, report.Totals.NewMessages, report.Totals.DeletedMessages)
 
-	return nil
+	return report, nil
 }
 
-func (s *Syncer) SyncMailbox(ctx context.Context, mailbox string) (*Stats, error) {
+func (s *Syncer) SyncMailbox(ctx context.Context, mailbox string) (stats *Stats, err error) {
+	start := time.Now()
+	defer func() {
+		metrics.SyncDuration.WithLabelValues(mailbox).Observe(time.Since(start).Seconds())
+	}()
+
 	selectData, err := s.client.SelectMailboxWithContext(ctx, mailbox)
 	if err != nil {
 		return nil, fmt.Errorf("failed to select mailbox: %w", err)
 	}
+	defer func() {
+		if err == nil && !s.dryRun {
+			s.recordHistory(mailbox, selectData, stats)
+		}
+	}()
 
-	state, err := s.storage.GetMailboxState(mailbox)
+	state, err := s.storage.GetMailboxStateContext(ctx, mailbox)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get mailbox state: %w", err)
 	}
 
-	if state != nil && state.UIDValidity != selectData.UIDValidity {
-		s.log.Warnf("UIDValidity changed for mailbox %s, performing full resync", mailbox)
-		state = nil
+	state, err = s.resumeState(mailbox, state, selectData.UIDValidity)
+	if err != nil {
+		return nil, err
 	}
-
-	var startUID uint32 = 1
-	if state != nil {
-		startUID = state.LastUID + 1
+	if s.dryRun {
+		return s.planMailbox(ctx, mailbox, selectData, state)
+	}
+	if state == nil {
+		// Recorded before downloading, so the emails of an interrupted
+		// full sync are recognised as this mailbox's on the next run.
+		if err := s.updateMailboxState(mailbox, selectData.UIDValidity, 0); err != nil {
+			return nil, err
+		}
 	}
 
 	if selectData.NumMessages == 0 {
-		deleted, rerr := s.reconcileDeleted(mailbox, nil)
+		deleted, rerr := s.reconcileDeleted(ctx, mailbox, nil)
 		if rerr != nil {
 			s.log.WithError(rerr).Warnf("Reconcile deleted failed for %s", mailbox)
 		}
@@ -163,99 +391,377 @@ func (s *Syncer) SyncMailbox(ctx context.Context, mailbox string) (*Stats, error
 			s.updateMailboxState(mailbox, selectData.UIDValidity, 0)
 	}
 
-	uids, err := s.client.SearchAllWithContext(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to search messages: %w", err)
+	if ok, err := s.upToDate(ctx, mailbox, selectData, state); err != nil {
+		return nil, err
+	} else if ok {
+		updated := s.refreshFlags(ctx, mailbox)
+		if s.twoPass(mailbox) {
+			// Nothing changed, so the stored emails are the server's.
+			live, err := s.storage.ListLiveUIDsContext(ctx, mailbox)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list stored UIDs: %w", err)
+			}
+			if err := s.fillBodies(ctx, mailbox, live); err != nil {
+				return nil, err
+			}
+		}
+		s.log.Infof("Mailbox %s: %d messages total, up to date", mailbox, selectData.NumMessages)
+		return &Stats{TotalMessages: int(selectData.NumMessages), UpdatedFlags: updated}, nil
+	}
+
+	var uids, serverUIDs []uint32
+	if scan := s.takeScan(mailbox, selectData); scan != nil {
+		uids, serverUIDs = scan.uids, scan.serverUIDs
+	} else {
+		uids, err = s.client.SearchAllWithContext(ctx, s.dateRange)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search messages: %w", err)
+		}
+
+		// A date-limited search only sees part of the mailbox, so
+		// deletions are reconciled against a full UID listing instead.
+		serverUIDs = uids
+		if !s.dateRange.IsZero() {
+			serverUIDs, err = s.client.SearchAllWithContext(ctx, imap.DateRange{})
+			if err != nil {
+				return nil, fmt.Errorf("failed to search messages: %w", err)
+			}
+		}
 	}
 
 	if len(uids) == 0 {
-		deleted, rerr := s.reconcileDeleted(mailbox, nil)
+		deleted, rerr := s.reconcileDeleted(ctx, mailbox, serverUIDs)
 		if rerr != nil {
 			s.log.WithError(rerr).Warnf("Reconcile deleted failed for %s", mailbox)
 		}
 		s.log.Infof("Mailbox %s: 0 messages total, 0 new, %d deleted (empty)", mailbox, deleted)
 		return &Stats{TotalMessages: 0, NewMessages: 0, DeletedMessages: deleted},
-			s.updateMailboxState(mailbox, selectData.UIDValidity, 0)
+			s.updateMailboxState(mailbox, selectData.UIDValidity, s.nextLastUID(state, 0))
 	}
 
-	uidsToSync := s.filterUIDs(uids, startUID)
+	uidsToSync, err := s.missingUIDs(ctx, mailbox, uids, state)
+	if err != nil {
+		return nil, err
+	}
 
 	if len(uidsToSync) == 0 {
-		deleted, rerr := s.reconcileDeleted(mailbox, uids)
+		deleted, rerr := s.reconcileDeleted(ctx, mailbox, serverUIDs)
 		if rerr != nil {
 			s.log.WithError(rerr).Warnf("Reconcile deleted failed for %s", mailbox)
 		}
+		updated := s.refreshFlags(ctx, mailbox)
 		s.log.Infof("Mailbox %s: %d messages total, 0 new, %d deleted", mailbox, len(uids), deleted)
-		return &Stats{TotalMessages: len(uids), NewMessages: 0, DeletedMessages: deleted}, nil
-	}
-
-	var bar *progressbar.ProgressBar
-	if s.showProgress {
-		bar = progressbar.NewOptions(len(uidsToSync),
-			progressbar.OptionSetDescription(fmt.Sprintf("%-30s", mailbox)),
-			progressbar.OptionShowCount(),
-			progressbar.OptionSetWidth(40),
-			progressbar.OptionShowIts(),
-			progressbar.OptionSetItsString("msgs"),
-		)
-	} else {
-		s.log.Infof("Syncing %d messages from mailbox %s", len(uidsToSync), mailbox)
+		if err := s.fillBodies(ctx, mailbox, serverUIDs); err != nil {
+			return nil, err
+		}
+		return &Stats{TotalMessages: len(uids), NewMessages: 0, DeletedMessages: deleted, UpdatedFlags: updated}, nil
 	}
 
-	batchSize := 5
-	for i := 0; i < len(uidsToSync); i += batchSize {
-		select {
-		case <-ctx.Done():
-			if bar != nil {
-				bar.Finish()
-				fmt.Println()
-			}
-			return nil, ctx.Err()
-		default:
+	fetch, store, batchSize := s.fetchBatch, s.storeBatch, s.batchSize
+	switch policy := s.policyFor(mailbox); {
+	case policy == FetchHeaders:
+		fetch, batchSize = s.fetchMetadataBatch, metadataBatchSize
+	case policy == FetchMetadata:
+		fetch, batchSize = s.fetchEnvelopeBatch, metadataBatchSize
+	case s.metadataFirst:
+		fetch, store, batchSize = s.fetchMetadataBatch, s.storeMetadataBatch, metadataBatchSize
+	}
+	if s.dateRange.IsZero() {
+		store = s.recordProgress(store, state, selectData.UIDValidity)
+	}
+	if err := s.syncInBatches(ctx, mailbox, uidsToSync, batchSize, fetch, store); err != nil {
+		return nil, err
+	}
+
+	deleted, rerr := s.reconcileDeleted(ctx, mailbox, serverUIDs)
+	if rerr != nil {
+		s.log.WithError(rerr).Warnf("Reconcile deleted failed for %s", mailbox)
+	}
+
+	updated := s.refreshFlags(ctx, mailbox)
+
+	s.log.Infof("Mailbox %s: %d messages total, %d new messages synced, %d deleted",
+		mailbox, len(uids), len(uidsToSync), deleted)
+
+	stats = &Stats{TotalMessages: len(uids), NewMessages: len(uidsToSync), DeletedMessages: deleted, UpdatedFlags: updated}
+
+	// The state is saved before bodies are filled so an interrupted fill
+	// doesn't re-fetch metadata over bodies that were already downloaded.
+	maxUID := uidsToSync[len(uidsToSync)-1]
+	if err := s.updateMailboxState(mailbox, selectData.UIDValidity, s.nextLastUID(state, maxUID)); err != nil {
+		return stats, err
+	}
+
+	if err := s.fillBodies(ctx, mailbox, serverUIDs); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// upToDate reports whether mailbox can't have changed since the last sync
+// except for flags, so searching it can be skipped. The server assigns new
+// messages UIDs from UIDNEXT on, so a UIDNEXT right above the stored LastUID
+// means none arrived, and as many messages as are stored means none were
+// expunged. Mailboxes of servers that don't send UIDNEXT, and date-limited
+// syncs, which don't advance LastUID, are always searched.
+func (s *Syncer) upToDate(ctx context.Context, mailbox string, selectData *imap2.SelectData, state *storage.MailboxState) (bool, error) {
+	if state == nil || !s.dateRange.IsZero() || selectData.UIDNext == 0 {
+		return false, nil
+	}
+	if uint32(selectData.UIDNext) != state.LastUID+1 {
+		return false, nil
+	}
+
+	stored, err := s.storage.CountMessagesContext(ctx, mailbox)
+	if err != nil {
+		return false, fmt.Errorf("failed to count stored emails: %w", err)
+	}
+	return stored == int(selectData.NumMessages), nil
+}
+
+// pipelineDepth is how many downloaded batches may wait to be stored. The
+// connection serializes fetches, so one is enough to keep it busy while the
+// previous batch is written.
+const pipelineDepth = 1
+
+// fetchFunc downloads a batch of uids, returning the emails to store and the
+// bytes of message bodies downloaded.
+type fetchFunc func(ctx context.Context, mailbox string, uids []uint32) ([]*storage.Email, int64, error)
+
+// storeFunc saves a downloaded batch.
+type storeFunc func(ctx context.Context, mailbox string, emails []*storage.Email) error
+
+// fetchedBatch is a downloaded batch on its way to be stored. Batches are
+// uids[start:end] of a syncInBatches call.
+type fetchedBatch struct {
+	start, end int
+	emails     []*storage.Email
+	bytes      int64
+	err        error
+}
+
+// ErrStateMismatch is returned with WithStrictUIDValidity when the stored
+// state of a mailbox can't be trusted for an incremental sync.
+var ErrStateMismatch = errors.New("stored mailbox state doesn't match the server")
+
+// resumeState decides whether the stored state of mailbox can be trusted to
+// resume after its LastUID, given the server's UIDVALIDITY. It returns nil
+// for a full resync, or ErrStateMismatch in strict mode when the storage
+// looks like it came from another mailbox.
+func (s *Syncer) resumeState(mailbox string, state *storage.MailboxState, uidValidity uint32) (*storage.MailboxState, error) {
+	if state != nil && state.UIDValidity != 0 {
+		if state.UIDValidity == uidValidity {
+			s.log.Debugf("Mailbox %s: UIDVALIDITY %d matches, resuming after UID %d", mailbox, uidValidity, state.LastUID)
+			return state, nil
+		}
+		if s.strict {
+			return nil, fmt.Errorf("%w: UIDVALIDITY of %s is %d on the server, %d in storage",
+				ErrStateMismatch, mailbox, uidValidity, state.UIDValidity)
 		}
+		s.log.Warnf("UIDValidity changed for mailbox %s (%d in storage, %d on the server), performing full resync",
+			mailbox, state.UIDValidity, uidValidity)
+		return nil, nil
+	}
 
-		end := i + batchSize
-		if end > len(uidsToSync) {
-			end = len(uidsToSync)
+	// Without a recorded UIDVALIDITY, stored emails can't be matched to
+	// the server's UIDs.
+	stored, err := s.storage.CountMessages(mailbox)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count stored emails: %w", err)
+	}
+	if stored == 0 {
+		s.log.Debugf("Mailbox %s: no stored state, performing full sync", mailbox)
+		return nil, nil
+	}
+	if s.strict {
+		return nil, fmt.Errorf("%w: %s has %d stored emails but no recorded UIDVALIDITY",
+			ErrStateMismatch, mailbox, stored)
+	}
+	s.log.Warnf("Mailbox %s has %d stored emails but no recorded UIDVALIDITY, performing full resync", mailbox, stored)
+	return nil, nil
+}
+
+// syncInBatches downloads uids batchSize at a time with fetch and saves them
+// with store, reporting progress as it goes. The next batch is fetched while
+// the current one is stored.
+func (s *Syncer) syncInBatches(ctx context.Context, mailbox string, uids []uint32, batchSize int,
+	fetch fetchFunc, store storeFunc,
+) error {
+	if s.progressFormat != ProgressFormatBar {
+		s.log.Infof("Syncing %d messages from mailbox %s", len(uids), mailbox)
+	}
+
+	progress := ProgressEvent{Kind: ProgressStart, Mailbox: mailbox, Total: len(uids)}
+	s.reportProgress(progress)
+	defer func() {
+		progress.Kind = ProgressFinish
+		s.reportProgress(progress)
+	}()
+
+	batches := make(chan fetchedBatch, pipelineDepth)
+	fetchCtx, cancel := context.WithCancel(ctx)
+	go s.fetchBatches(fetchCtx, mailbox, uids, batchSize, fetch, batches)
+
+	// On an early return, the fetcher is stopped and waited for so it's
+	// done with the connection.
+	defer func() {
+		cancel()
+		for range batches {
 		}
+	}()
 
-		batch := uidsToSync[i:end]
-		if err := s.syncBatch(ctx, mailbox, batch, bar); err != nil {
+	for batch := range batches {
+		if batch.err != nil {
 			if ctx.Err() != nil {
-				if bar != nil {
-					bar.Finish()
-					fmt.Println()
-				}
-				return nil, ctx.Err()
+				return ctx.Err()
 			}
-			if bar != nil {
-				bar.Finish()
-				fmt.Println()
+			return fmt.Errorf("failed to sync batch: %w", batch.err)
+		}
+
+		// Cancelling also aborts a batch being stored; it is rolled back.
+		if err := store(ctx, mailbox, batch.emails); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
 			}
-			return nil, fmt.Errorf("failed to sync batch: %w", err)
+			return fmt.Errorf("failed to sync batch: %w", err)
 		}
 
-		if bar == nil {
-			s.log.Infof("Synced batch %d-%d of %d messages", i+1, end, len(uidsToSync))
+		progress.Kind = ProgressBatch
+		progress.Done += len(batch.emails)
+		progress.Bytes += batch.bytes
+		s.reportProgress(progress)
+
+		if s.progressFormat == ProgressFormatNone {
+			s.log.Infof("Synced batch %d-%d of %d messages", batch.start+1, batch.end, len(uids))
 		}
 	}
 
-	if bar != nil {
-		bar.Finish()
-		fmt.Println()
+	return ctx.Err()
+}
+
+// fetchBatches downloads uids batchSize at a time into batches, closing it
+// when done, cancelled, or after sending a failed batch.
+func (s *Syncer) fetchBatches(ctx context.Context, mailbox string, uids []uint32, batchSize int,
+	fetch fetchFunc, batches chan<- fetchedBatch,
+) {
+	defer close(batches)
+
+	for i := 0; i < len(uids); i += batchSize {
+		if ctx.Err() != nil {
+			return
+		}
+
+		end := min(i+batchSize, len(uids))
+		emails, bytes, err := fetch(ctx, mailbox, uids[i:end])
+
+		select {
+		case batches <- fetchedBatch{start: i, end: end, emails: emails, bytes: bytes, err: err}:
+		case <-ctx.Done():
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		// Throttling after the batch is handed over keeps what was already
+		// downloaded if the sync is cancelled while waiting.
+		if s.rateLimiter != nil {
+			if err := s.rateLimiter.wait(ctx, bytes); err != nil {
+				return
+			}
+		}
 	}
+}
 
-	deleted, rerr := s.reconcileDeleted(mailbox, uids)
-	if rerr != nil {
-		s.log.WithError(rerr).Warnf("Reconcile deleted failed for %s", mailbox)
+// fillBodies downloads the bodies of stored emails that only have metadata,
+// as the second pass of WithMetadataFirst. The missing set is read back from
+// storage, so a run that was interrupted resumes with what is still missing.
+func (s *Syncer) fillBodies(ctx context.Context, mailbox string, serverUIDs []uint32) error {
+	if !s.twoPass(mailbox) {
+		return nil
 	}
 
-	s.log.Infof("Mailbox %s: %d messages total, %d new messages synced, %d deleted",
-		mailbox, len(uids), len(uidsToSync), deleted)
+	missing, err := s.storage.EmailsMissingBodyContext(ctx, mailbox)
+	if err != nil {
+		return fmt.Errorf("failed to list emails missing body: %w", err)
+	}
 
-	maxUID := uidsToSync[len(uidsToSync)-1]
-	err = s.updateMailboxState(mailbox, selectData.UIDValidity, maxUID)
-	return &Stats{TotalMessages: len(uids), NewMessages: len(uidsToSync), DeletedMessages: deleted}, err
+	onServer := make(map[uint32]struct{}, len(serverUIDs))
+	for _, uid := range serverUIDs {
+		onServer[uid] = struct{}{}
+	}
+	missing = slices.DeleteFunc(missing, func(uid uint32) bool {
+		_, ok := onServer[uid]
+		return !ok
+	})
+	if len(missing) == 0 {
+		return nil
+	}
+
+	return s.syncInBatches(ctx, mailbox, missing, s.batchSize, s.fetchBatch, s.storeBatch)
+}
+
+// refreshFlags fetches FLAGS for the whole selected mailbox and rewrites the
+// stored flags of emails whose flags changed on the server. It is a no-op
+// unless WithSyncFlags is enabled. Errors are logged, not returned, like
+// reconcileDeleted: stale flags should not fail an otherwise-good sync.
+func (s *Syncer) refreshFlags(ctx context.Context, mailbox string) int {
+	if !s.syncFlags {
+		return 0
+	}
+
+	stored, err := s.storage.ListFlagsContext(ctx, mailbox)
+	if err != nil {
+		s.log.WithError(err).Warnf("Flag sync failed for %s", mailbox)
+		return 0
+	}
+	if len(stored) == 0 {
+		return 0
+	}
+
+	remote, err := s.client.FetchFlags(ctx, imap2.UIDSet{imap2.UIDRange{Start: 1, Stop: 0}})
+	if err != nil {
+		s.log.WithError(err).Warnf("Flag sync failed for %s", mailbox)
+		return 0
+	}
+
+	updated := 0
+	for uid, flags := range remote {
+		current, ok := stored[uid]
+		if !ok {
+			continue
+		}
+
+		newFlags := imap.FlagsToStrings(flags)
+		if sameFlags(current, newFlags) {
+			continue
+		}
+
+		if err := s.storage.UpdateFlagsContext(ctx, mailbox, uid, newFlags); err != nil {
+			s.log.WithError(err).Warnf("Failed to update flags for UID %d in %s", uid, mailbox)
+			continue
+		}
+		updated++
+	}
+
+	if updated > 0 {
+		s.log.Infof("Mailbox %s: updated flags on %d messages", mailbox, updated)
+	}
+
+	return updated
+}
+
+// sameFlags reports whether two flag lists contain the same flags, ignoring order.
+func sameFlags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = slices.Clone(a)
+	b = slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
 }
 
 // purgeOldDeleted removes soft-deleted emails whose deleted_at is older than
@@ -276,11 +782,44 @@ func (s *Syncer) purgeOldDeleted() {
 	}
 }
 
+// recordHistory appends the mailbox's size on the server to its sync history.
+// Like reconcileDeleted, a failure is only logged: the history is for charts
+// and shouldn't fail a sync that stored everything.
+func (s *Syncer) recordHistory(mailbox string, selectData *imap2.SelectData, stats *Stats) {
+	err := s.storage.AddSyncHistory(&storage.SyncHistory{
+		Mailbox:            mailbox,
+		SyncedAt:           time.Now(),
+		ServerMessageCount: int(selectData.NumMessages),
+		NewMessages:        stats.NewMessages,
+		UIDNext:            uint32(selectData.UIDNext),
+	})
+	if err != nil {
+		s.log.WithError(err).Warnf("Failed to record sync history for %s", mailbox)
+	}
+}
+
+// logQuota logs how full the account is, so a sync that will run out of
+// space on the server side is no surprise. Quotas are informational only.
+func (s *Syncer) logQuota(ctx context.Context) {
+	quota, err := s.client.GetQuota(ctx)
+	switch {
+	case errors.Is(err, imap.ErrQuotaUnsupported):
+		s.log.Debug("Server doesn't report quotas")
+	case err != nil:
+		s.log.WithError(err).Warn("Failed to get quota")
+	case quota.HasStorage && quota.TotalBytes > 0:
+		s.log.Infof("Server quota: %.1f of %.1f MiB used (%.0f%%)",
+			float64(quota.UsedBytes)/(1<<20), float64(quota.TotalBytes)/(1<<20), quota.UsedPercent())
+	case quota.HasStorage:
+		s.log.Infof("Server quota: %.1f MiB used", float64(quota.UsedBytes)/(1<<20))
+	}
+}
+
 // reconcileDeleted soft-deletes local emails whose UIDs are no longer present
 // on the server. The serverUIDs slice is the authoritative list for the mailbox;
 // pass nil or empty to mark everything local as deleted.
-func (s *Syncer) reconcileDeleted(mailbox string, serverUIDs []uint32) (int, error) {
-	liveUIDs, err := s.storage.ListLiveUIDs(mailbox)
+func (s *Syncer) reconcileDeleted(ctx context.Context, mailbox string, serverUIDs []uint32) (int, error) {
+	liveUIDs, err := s.storage.ListLiveUIDsContext(ctx, mailbox)
 	if err != nil {
 		return 0, fmt.Errorf("failed to list live uids: %w", err)
 	}
@@ -303,92 +842,222 @@ func (s *Syncer) reconcileDeleted(mailbox string, serverUIDs []uint32) (int, err
 		return 0, nil
 	}
 
-	return s.storage.MarkDeleted(mailbox, toDelete, time.Now())
+	return s.storage.MarkDeletedContext(ctx, mailbox, toDelete, time.Now())
 }
 
-func (s *Syncer) syncBatch(ctx context.Context, mailbox string, uids []uint32, bar *progressbar.ProgressBar) error {
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	default:
+// fetchMetadataBatch downloads the flags, envelope and size of uids without
+// their bodies. Body bytes aren't transferred, so it reports 0.
+func (s *Syncer) fetchMetadataBatch(ctx context.Context, mailbox string, uids []uint32) ([]*storage.Email, int64, error) {
+	messages, err := s.client.FetchEnvelopes(ctx, uidSet(uids))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch envelopes: %w", err)
 	}
 
-	imapUIDs := make([]imap2.UID, len(uids))
-	for i, uid := range uids {
-		imapUIDs[i] = imap2.UID(uid)
+	emails := make([]*storage.Email, 0, len(messages))
+	for _, msg := range messages {
+		emails = append(emails, s.convertToEmail(mailbox, msg))
 	}
-	seqSet := imap2.UIDSetNum(imapUIDs...)
 
-	messages, err := s.client.FetchMessagesWithContext(ctx, seqSet)
-	if err != nil {
-		return fmt.Errorf("failed to fetch messages: %w", err)
+	return emails, 0, nil
+}
+
+// storeMetadataBatch saves emails fetched by fetchMetadataBatch. They are
+// counted as synced once their bodies are filled in.
+func (s *Syncer) storeMetadataBatch(ctx context.Context, _ string, emails []*storage.Email) error {
+	if err := s.storage.SaveEmailBatchContext(ctx, emails); err != nil {
+		return fmt.Errorf("failed to save emails: %w", err)
 	}
+	return nil
+}
 
+// fetchBatch downloads uids, returning the emails to store and the bytes of
+// message bodies downloaded.
+func (s *Syncer) fetchBatch(ctx context.Context, mailbox string, uids []uint32) ([]*storage.Email, int64, error) {
 	select {
 	case <-ctx.Done():
-		return ctx.Err()
+		return nil, 0, ctx.Err()
 	default:
 	}
 
-	emails := make([]*storage.Email, 0, len(messages))
-	for _, msg := range messages {
-		email := s.convertToEmail(mailbox, msg)
-		emails = append(emails, email)
+	emails := make([]*storage.Email, 0, len(uids))
+	var fetched int64
+
+	if s.maxMessageSize > 0 {
+		envelopes, err := s.client.FetchEnvelopes(ctx, uidSet(uids))
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to fetch envelopes: %w", err)
+		}
+
+		uids = uids[:0:0]
+		for _, msg := range envelopes {
+			if int64(msg.Size) <= s.maxMessageSize {
+				uids = append(uids, msg.UID)
+				continue
+			}
+			s.log.Infof("Skipping body of UID %d in %s: %d bytes exceeds max message size", msg.UID, mailbox, msg.Size)
+			emails = append(emails, s.convertToEmail(mailbox, msg))
+		}
 	}
 
-	if err := s.storage.SaveEmailBatch(emails); err != nil {
+	if len(uids) > 0 {
+		messages, err := s.client.FetchMessagesWithContext(ctx, uidSet(uids))
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to fetch messages: %w", err)
+		}
+
+		for _, msg := range messages {
+			emails = append(emails, s.convertToEmail(mailbox, msg))
+			fetched += int64(msg.Size)
+		}
+	}
+	metrics.BytesFetched.Add(float64(fetched))
+
+	return emails, fetched, nil
+}
+
+// storeBatch saves emails fetched by fetchBatch.
+func (s *Syncer) storeBatch(ctx context.Context, mailbox string, emails []*storage.Email) error {
+	if err := s.storage.SaveEmailBatchContext(ctx, emails); err != nil {
 		return fmt.Errorf("failed to save emails: %w", err)
 	}
+	metrics.MessagesSynced.WithLabelValues(mailbox).Add(float64(len(emails)))
+	return nil
+}
 
-	if bar != nil {
-		bar.Add(len(emails))
+func uidSet(uids []uint32) imap2.UIDSet {
+	imapUIDs := make([]imap2.UID, len(uids))
+	for i, uid := range uids {
+		imapUIDs[i] = imap2.UID(uid)
 	}
+	return imap2.UIDSetNum(imapUIDs...)
+}
 
-	return nil
+// formatAddresses returns the bare mailbox@host form of envelope addresses.
+func formatAddresses(addrs []imap2.Address) []string {
+	var result []string
+	for _, addr := range addrs {
+		result = append(result, fmt.Sprintf("%s@%s", addr.Mailbox, addr.Host))
+	}
+	return result
 }
 
 func (s *Syncer) convertToEmail(mailbox string, msg *imap.Message) *storage.Email {
-	var subject, from string
-	var to []string
+	var subject, from, fromName string
+	var to, cc, bcc []string
 
 	if msg.Envelope != nil {
-		subject = msg.Envelope.Subject
+		// go-imap leaves encoded words in charsets it can't decode as-is.
+		subject = mailparse.DecodeHeader(msg.Envelope.Subject)
 
 		if len(msg.Envelope.From) > 0 {
 			addr := msg.Envelope.From[0]
 			from = fmt.Sprintf("%s@%s", addr.Mailbox, addr.Host)
+			fromName = mailparse.DecodeHeader(addr.Name)
 		}
 
-		for _, addr := range msg.Envelope.To {
-			to = append(to, fmt.Sprintf("%s@%s", addr.Mailbox, addr.Host))
-		}
+		to = formatAddresses(msg.Envelope.To)
+		cc = formatAddresses(msg.Envelope.Cc)
+		bcc = formatAddresses(msg.Envelope.Bcc)
 	}
 
-	return &storage.Email{
-		UID:         msg.UID,
-		Mailbox:     mailbox,
-		Subject:     subject,
-		From:        from,
-		To:          to,
-		Date:        imap.ParseEnvelopeDate(msg.Envelope),
-		Size:        msg.Size,
-		Flags:       imap.FlagsToStrings(msg.Flags),
-		GmailLabels: msg.GmailLabels, // Include Gmail labels if fetched
-		Body:        msg.Body,
-		Headers:     msg.Headers,
-		RawMessage:  msg.RawMessage,
-		Synced:      time.Now(),
+	email := &storage.Email{
+		UID:           msg.UID,
+		Mailbox:       mailbox,
+		Subject:       subject,
+		From:          from,
+		FromName:      fromName,
+		To:            to,
+		Cc:            cc,
+		Bcc:           bcc,
+		Date:          imap.MessageDate(msg),
+		Size:          msg.Size,
+		Flags:         imap.FlagsToStrings(msg.Flags),
+		GmailLabels:   msg.GmailLabels, // Include Gmail labels if fetched
+		GmailMsgID:    msg.GmailMsgID,
+		GmailThreadID: msg.GmailThreadID,
+		Headers:       msg.Headers,
+		RawMessage:    msg.RawMessage,
+		Synced:        time.Now(),
+	}
+	if !msg.InternalDate.IsZero() {
+		email.InternalDate = &msg.InternalDate
 	}
+
+	return email
 }
 
-func (s *Syncer) filterUIDs(uids []uint32, startUID uint32) []uint32 {
-	var result []uint32
+// missingUIDs returns the UIDs that aren't stored yet. UIDs above LastUID
+// are new without looking, but older ones are checked against storage so
+// gaps left by an interrupted or failed sync are retried; a date-limited sync
+// checks them all, since it doesn't advance LastUID. A nil state (first sync
+// or UIDVALIDITY change) means nothing stored is valid.
+func (s *Syncer) missingUIDs(ctx context.Context, mailbox string, uids []uint32, state *storage.MailboxState) ([]uint32, error) {
+	if state == nil {
+		return uids, nil
+	}
+
+	var known uint32
+	if s.dateRange.IsZero() {
+		known = state.LastUID
+	} else {
+		known = math.MaxUint32
+	}
+	if !slices.ContainsFunc(uids, func(uid uint32) bool { return uid <= known }) {
+		return uids, nil
+	}
+
+	stored, err := s.storage.ListUIDsContext(ctx, mailbox)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stored uids: %w", err)
+	}
+
+	have := make(map[uint32]struct{}, len(stored))
+	for _, uid := range stored {
+		have[uid] = struct{}{}
+	}
+
+	var missing []uint32
 	for _, uid := range uids {
-		if uid >= startUID {
-			result = append(result, uid)
+		if _, ok := have[uid]; uid > known || !ok {
+			missing = append(missing, uid)
 		}
 	}
-	return result
+	return missing, nil
+}
+
+// nextLastUID returns the LastUID to record after syncing up to maxUID. It
+// doesn't go back when only gaps below LastUID were filled. A date-limited
+// sync skips older UIDs outside the range, so it keeps the previous value
+// for a later full sync to pick them up.
+func (s *Syncer) nextLastUID(state *storage.MailboxState, maxUID uint32) uint32 {
+	var last uint32
+	if state != nil {
+		last = state.LastUID
+	}
+	if s.dateRange.IsZero() {
+		return max(last, maxUID)
+	}
+	return last
+}
+
+// recordProgress wraps store to save LastUID after every batch, so an
+// interrupted sync resumes after the last batch that was stored. Batches
+// are stored in ascending UID order. The state is saved even if ctx was
+// cancelled once the batch was committed.
+func (s *Syncer) recordProgress(store storeFunc, state *storage.MailboxState, uidValidity uint32) storeFunc {
+	return func(ctx context.Context, mailbox string, emails []*storage.Email) error {
+		if err := store(ctx, mailbox, emails); err != nil {
+			return err
+		}
+
+		var maxUID uint32
+		for _, email := range emails {
+			maxUID = max(maxUID, email.UID)
+		}
+		lastUID := s.nextLastUID(state, maxUID)
+		state = &storage.MailboxState{Name: mailbox, UIDValidity: uidValidity, LastUID: lastUID}
+		return s.updateMailboxState(mailbox, uidValidity, lastUID)
+	}
 }
 
 func (s *Syncer) updateMailboxState(mailbox string, uidValidity, lastUID uint32) error {
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"net"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -14,8 +16,11 @@ import (
 	"github.com/emersion/go-imap/v2/imapserver/imapmemserver"
 	"github.com/newsamples/imapsync/internal/config"
 	imapClient "github.com/newsamples/imapsync/internal/imap"
+	"github.com/newsamples/imapsync/internal/metrics"
 	"github.com/newsamples/imapsync/internal/storage"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -92,12 +97,19 @@ func newTestSyncer(t *testing.T, opts imapClient.ConnectOptions) (*Syncer, *stor
 	return New(client, store, log), store
 }
 
+func syncAll(t *testing.T, s *Syncer) *SyncReport {
+	t.Helper()
+	report, err := s.SyncAll(context.Background())
+	require.NoError(t, err)
+	return report
+}
+
 func TestSyncAll_EmptyMailboxes(t *testing.T) {
 	opts, cleanup := newSyncTestServer(t)
 	defer cleanup()
 
 	s, _ := newTestSyncer(t, opts)
-	require.NoError(t, s.SyncAll(context.Background()))
+	syncAll(t, s)
 }
 
 func TestSyncAll_WithMessages(t *testing.T) {
@@ -108,7 +120,7 @@ func TestSyncAll_WithMessages(t *testing.T) {
 	appendSyncMsgs(t, opts, "Sent", 2)
 
 	s, store := newTestSyncer(t, opts)
-	require.NoError(t, s.SyncAll(context.Background()))
+	syncAll(t, s)
 
 	inboxCount, err := store.CountMessages("INBOX")
 	require.NoError(t, err)
@@ -119,6 +131,36 @@ func TestSyncAll_WithMessages(t *testing.T) {
 	assert.Equal(t, 2, sentCount)
 }
 
+func TestSyncAll_Report(t *testing.T) {
+	opts, cleanup := newSyncTestServer(t)
+	defer cleanup()
+
+	appendSyncMsgs(t, opts, "INBOX", 3)
+	appendSyncMsgs(t, opts, "Sent", 2)
+
+	s, _ := newTestSyncer(t, opts)
+	report := syncAll(t, s)
+
+	byMailbox := make(map[string]MailboxReport)
+	for _, m := range report.Mailboxes {
+		byMailbox[m.Mailbox] = m
+	}
+	require.Len(t, byMailbox, len(report.Mailboxes), "one entry per mailbox")
+	assert.Equal(t, "INBOX", report.Mailboxes[0].Mailbox)
+	assert.Equal(t, Stats{TotalMessages: 3, NewMessages: 3}, byMailbox["INBOX"].Stats)
+	assert.Equal(t, Stats{TotalMessages: 2, NewMessages: 2}, byMailbox["Sent"].Stats)
+	assert.Equal(t, 5, report.Totals.NewMessages)
+	assert.Equal(t, 5, report.Totals.TotalMessages)
+	assert.Zero(t, report.Failed)
+	assert.Zero(t, report.Retries, "no reconnects on a healthy connection")
+	assert.False(t, report.StartedAt.IsZero())
+
+	appendSyncMsgs(t, opts, "INBOX", 1)
+	report = syncAll(t, s)
+	assert.Equal(t, Stats{TotalMessages: 4, NewMessages: 1}, report.Mailboxes[0].Stats)
+	assert.Equal(t, 1, report.Totals.NewMessages)
+}
+
 func TestSyncAll_IncrementalSync(t *testing.T) {
 	opts, cleanup := newSyncTestServer(t)
 	defer cleanup()
@@ -126,7 +168,7 @@ func TestSyncAll_IncrementalSync(t *testing.T) {
 	appendSyncMsgs(t, opts, "INBOX", 2)
 
 	s, store := newTestSyncer(t, opts)
-	require.NoError(t, s.SyncAll(context.Background()))
+	syncAll(t, s)
 
 	count, err := store.CountMessages("INBOX")
 	require.NoError(t, err)
@@ -134,13 +176,54 @@ func TestSyncAll_IncrementalSync(t *testing.T) {
 
 	// Append more messages, sync again — only new ones should be synced.
 	appendSyncMsgs(t, opts, "INBOX", 3)
-	require.NoError(t, s.SyncAll(context.Background()))
+	syncAll(t, s)
 
 	count, err = store.CountMessages("INBOX")
 	require.NoError(t, err)
 	assert.Equal(t, 5, count)
 }
 
+func TestSyncMailbox_RefetchesMissingUID(t *testing.T) {
+	opts, cleanup := newSyncTestServer(t)
+	defer cleanup()
+
+	appendSyncMsgs(t, opts, "INBOX", 3)
+
+	s, store := newTestSyncer(t, opts)
+	_, err := s.SyncMailbox(context.Background(), "INBOX")
+	require.NoError(t, err)
+
+	// A store that saw UID 3 but lost UID 2, as after a batch that failed
+	// while a later one was saved.
+	state, err := store.GetMailboxState("INBOX")
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), state.LastUID)
+
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+	gappy, err := storage.New(filepath.Join(t.TempDir(), "gappy.db"), log)
+	require.NoError(t, err)
+	defer gappy.Close()
+	for _, uid := range []uint32{1, 3} {
+		email, err := store.GetEmail("INBOX", uid)
+		require.NoError(t, err)
+		require.NoError(t, gappy.SaveEmail(email))
+	}
+	require.NoError(t, gappy.SaveMailboxState(state))
+
+	stats, err := New(s.client, gappy, log).SyncMailbox(context.Background(), "INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.NewMessages, "only the missing UID is fetched")
+
+	uids, err := gappy.ListUIDs("INBOX")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []uint32{1, 2, 3}, uids)
+
+	after, err := gappy.GetMailboxState("INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(3), after.LastUID)
+}
+
 func TestSyncMailbox_EmptyMailbox(t *testing.T) {
 	opts, cleanup := newSyncTestServer(t)
 	defer cleanup()
@@ -264,6 +347,89 @@ func TestSyncMailbox_NothingNew(t *testing.T) {
 	assert.Equal(t, 0, stats.NewMessages)
 }
 
+func TestSyncMailbox_UpToDate(t *testing.T) {
+	opts, cleanup := newSyncTestServer(t)
+	defer cleanup()
+
+	appendSyncMsgs(t, opts, "INBOX", 3)
+
+	s, store := newTestSyncer(t, opts)
+	log, hook := logtest.NewNullLogger()
+	s.log = log
+	syncInbox := func() *Stats {
+		t.Helper()
+		hook.Reset()
+		stats, err := s.SyncMailbox(context.Background(), "INBOX")
+		require.NoError(t, err)
+		return stats
+	}
+	upToDate := func() bool {
+		return strings.HasSuffix(hook.LastEntry().Message, "up to date")
+	}
+
+	syncInbox()
+	assert.False(t, upToDate(), "the first sync searches")
+
+	// UIDNEXT is right above the last stored UID: nothing arrived.
+	stats := syncInbox()
+	assert.True(t, upToDate(), hook.LastEntry().Message)
+	assert.Equal(t, &Stats{TotalMessages: 3}, stats)
+
+	// UIDNEXT doesn't move when messages are expunged, but the count does.
+	deleteSyncMsg(t, opts, "INBOX", 2)
+	stats = syncInbox()
+	assert.False(t, upToDate())
+	assert.Equal(t, 1, stats.DeletedMessages)
+
+	appendSyncMsgs(t, opts, "INBOX", 1)
+	stats = syncInbox()
+	assert.False(t, upToDate())
+	assert.Equal(t, 1, stats.NewMessages)
+	syncInbox()
+	assert.True(t, upToDate())
+
+	t.Run("without UIDNEXT", func(t *testing.T) {
+		state, err := store.GetMailboxState("INBOX")
+		require.NoError(t, err)
+		ok, err := s.upToDate(context.Background(), "INBOX", &imap2.SelectData{NumMessages: 3}, state)
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+}
+
+func TestSyncMailbox_RecordsHistory(t *testing.T) {
+	opts, cleanup := newSyncTestServer(t)
+	defer cleanup()
+
+	appendSyncMsgs(t, opts, "INBOX", 2)
+
+	s, store := newTestSyncer(t, opts)
+	_, err := s.SyncMailbox(context.Background(), "INBOX")
+	require.NoError(t, err)
+
+	appendSyncMsgs(t, opts, "INBOX", 1)
+	_, err = s.SyncMailbox(context.Background(), "INBOX")
+	require.NoError(t, err)
+
+	history, err := store.GetSyncHistory("INBOX")
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, 2, history[0].ServerMessageCount)
+	assert.Equal(t, 2, history[0].NewMessages)
+	assert.Equal(t, uint32(3), history[0].UIDNext)
+	assert.Equal(t, 3, history[1].ServerMessageCount)
+	assert.Equal(t, 1, history[1].NewMessages)
+	assert.Equal(t, uint32(4), history[1].UIDNext)
+
+	// A dry run leaves no trace.
+	dry := New(s.client, store, s.log, WithDryRun(true))
+	_, err = dry.SyncMailbox(context.Background(), "INBOX")
+	require.NoError(t, err)
+	history, err = store.GetSyncHistory("INBOX")
+	require.NoError(t, err)
+	assert.Len(t, history, 2)
+}
+
 func TestSyncMailbox_UIDValidityChanged(t *testing.T) {
 	opts, cleanup := newSyncTestServer(t)
 	defer cleanup()
@@ -286,6 +452,98 @@ func TestSyncMailbox_UIDValidityChanged(t *testing.T) {
 	assert.Equal(t, 1, stats.NewMessages)
 }
 
+func TestSyncMailbox_StrictUIDValidity(t *testing.T) {
+	opts, cleanup := newSyncTestServer(t)
+	defer cleanup()
+
+	appendSyncMsgs(t, opts, "INBOX", 1)
+
+	base, store := newTestSyncer(t, opts)
+	s := New(base.client, store, base.log, WithStrictUIDValidity(true))
+
+	stale := &storage.MailboxState{Name: "INBOX", UIDValidity: 99999, LastUID: 100, LastSync: time.Now()}
+	require.NoError(t, store.SaveMailboxState(stale))
+
+	_, err := s.SyncMailbox(context.Background(), "INBOX")
+	assert.ErrorIs(t, err, ErrStateMismatch)
+
+	state, err := store.GetMailboxState("INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(99999), state.UIDValidity, "the stored state is left alone")
+	count, err := store.CountMessages("INBOX")
+	require.NoError(t, err)
+	assert.Zero(t, count)
+}
+
+func TestSyncAll_FailFast(t *testing.T) {
+	opts, cleanup := newSyncTestServer(t)
+	defer cleanup()
+
+	appendSyncMsgs(t, opts, "INBOX", 1)
+	appendSyncMsgs(t, opts, "Sent", 2)
+
+	// INBOX is synced first and fails on its mismatched state.
+	sync := func(t *testing.T, failFast bool) (*SyncReport, *storage.Storage, error) {
+		base, store := newTestSyncer(t, opts)
+		stale := &storage.MailboxState{Name: "INBOX", UIDValidity: 99999, LastUID: 100, LastSync: time.Now()}
+		require.NoError(t, store.SaveMailboxState(stale))
+
+		s := New(base.client, store, base.log, WithStrictUIDValidity(true), WithFailFast(failFast))
+		report, err := s.SyncAll(context.Background())
+		return report, store, err
+	}
+
+	t.Run("continue", func(t *testing.T) {
+		report, store, err := sync(t, false)
+		require.NoError(t, err)
+		assert.Equal(t, 1, report.Failed)
+		assert.Len(t, report.Mailboxes, 2)
+
+		count, err := store.CountMessages("Sent")
+		require.NoError(t, err)
+		assert.Equal(t, 2, count, "later mailboxes are still synced")
+	})
+
+	t.Run("fail fast", func(t *testing.T) {
+		report, store, err := sync(t, true)
+		assert.ErrorIs(t, err, ErrStateMismatch)
+		assert.ErrorContains(t, err, "mailbox INBOX: ")
+		require.Len(t, report.Mailboxes, 1)
+		assert.NotEmpty(t, report.Mailboxes[0].Error)
+		assert.Equal(t, 1, report.Failed)
+
+		count, err := store.CountMessages("Sent")
+		require.NoError(t, err)
+		assert.Zero(t, count, "the sync stopped")
+	})
+}
+
+func TestSyncAll_DryRun(t *testing.T) {
+	opts, cleanup := newSyncTestServer(t)
+	defer cleanup()
+
+	appendSyncMsgs(t, opts, "INBOX", 3)
+
+	base, store := newTestSyncer(t, opts)
+	s := New(base.client, store, base.log, WithDryRun(true))
+
+	report, err := s.SyncAll(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Totals.NewMessages, "messages that would be downloaded")
+
+	stats, err := store.Stats()
+	require.NoError(t, err)
+	assert.Zero(t, stats.Messages, "no emails are written")
+	state, err := store.GetMailboxState("INBOX")
+	require.NoError(t, err)
+	assert.Nil(t, state, "no mailbox state is written")
+
+	// A real sync afterwards still downloads everything.
+	stats2, err := base.SyncMailbox(context.Background(), "INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats2.NewMessages)
+}
+
 func TestSyncAll_ContextCancelled(t *testing.T) {
 	opts, cleanup := newSyncTestServer(t)
 	defer cleanup()
@@ -297,7 +555,7 @@ func TestSyncAll_ContextCancelled(t *testing.T) {
 	cancel()
 
 	// Should return nil (not error) when context is cancelled.
-	err := s.SyncAll(ctx)
+	_, err := s.SyncAll(ctx)
 	// Context cancelled before or during sync — either nil or ctx error.
 	if err != nil {
 		assert.ErrorIs(t, err, context.Canceled)
@@ -321,6 +579,22 @@ func TestWatch_IntervalMode(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestWithGmailConfig_DetectsServer(t *testing.T) {
+	opts, cleanup := newSyncTestServer(t)
+	defer cleanup()
+
+	base, store := newTestSyncer(t, opts)
+	cfg := &config.GmailConfig{IncludeFolders: []string{"INBOX"}}
+
+	// The test server doesn't advertise X-GM-EXT-1.
+	s := New(base.client, store, base.log, WithGmailConfig(cfg))
+	assert.False(t, s.gmailFilter.isGmail)
+	assert.False(t, s.gmailFilter.enabled)
+
+	s = New(base.client, store, base.log, WithGmailConfig(cfg), WithGmailServer(true))
+	assert.True(t, s.gmailFilter.enabled)
+}
+
 func TestSyncAll_WithGmailFilter(t *testing.T) {
 	opts, cleanup := newSyncTestServer(t)
 	defer cleanup()
@@ -341,9 +615,9 @@ func TestSyncAll_WithGmailFilter(t *testing.T) {
 
 	// IncludeFolders: only INBOX — filter skips Sent, so filteredCount > 0
 	cfg := &config.GmailConfig{IncludeFolders: []string{"INBOX"}}
-	s := New(client, store, log, WithGmailConfig(cfg, true))
+	s := New(client, store, log, WithGmailConfig(cfg), WithGmailServer(true))
 
-	require.NoError(t, s.SyncAll(context.Background()))
+	syncAll(t, s)
 
 	inboxCount, err := store.CountMessages("INBOX")
 	require.NoError(t, err)
@@ -355,6 +629,338 @@ func TestSyncAll_WithGmailFilter(t *testing.T) {
 	assert.Equal(t, 0, sentCount)
 }
 
+func TestSyncAll_WithMailboxFilter(t *testing.T) {
+	newSyncer := func(t *testing.T, opts imapClient.ConnectOptions, extra ...Option) (*Syncer, *storage.Storage) {
+		t.Helper()
+		log := logrus.New()
+		log.SetLevel(logrus.PanicLevel)
+
+		client, err := imapClient.Connect(opts)
+		require.NoError(t, err)
+		t.Cleanup(func() { client.Close() }) //nolint:errcheck
+
+		store, err := storage.New(filepath.Join(t.TempDir(), "test.db"), log)
+		require.NoError(t, err)
+		t.Cleanup(func() { store.Close() }) //nolint:errcheck
+
+		return New(client, store, log, extra...), store
+	}
+
+	counts := func(t *testing.T, store *storage.Storage) map[string]int {
+		t.Helper()
+		result := map[string]int{}
+		for _, mailbox := range []string{"INBOX", "Sent"} {
+			n, err := store.CountMessages(mailbox)
+			require.NoError(t, err)
+			result[mailbox] = n
+		}
+		return result
+	}
+
+	opts, cleanup := newSyncTestServer(t)
+	defer cleanup()
+
+	appendSyncMsgs(t, opts, "INBOX", 2)
+	appendSyncMsgs(t, opts, "Sent", 1)
+
+	t.Run("include only", func(t *testing.T) {
+		s, store := newSyncer(t, opts, WithMailboxFilter([]string{"Sent"}, nil))
+		syncAll(t, s)
+		assert.Equal(t, map[string]int{"INBOX": 0, "Sent": 1}, counts(t, store))
+	})
+
+	t.Run("exclude glob", func(t *testing.T) {
+		s, store := newSyncer(t, opts, WithMailboxFilter(nil, []string{"Se*"}))
+		syncAll(t, s)
+		assert.Equal(t, map[string]int{"INBOX": 2, "Sent": 0}, counts(t, store))
+	})
+
+	t.Run("include bypasses gmail filter", func(t *testing.T) {
+		cfg := &config.GmailConfig{IncludeFolders: []string{"INBOX"}}
+		s, store := newSyncer(t, opts, WithGmailConfig(cfg), WithGmailServer(true), WithMailboxFilter([]string{"Sent"}, nil))
+		syncAll(t, s)
+		assert.Equal(t, map[string]int{"INBOX": 0, "Sent": 1}, counts(t, store))
+	})
+
+	t.Run("exclude combines with gmail filter", func(t *testing.T) {
+		cfg := &config.GmailConfig{ExcludeFolders: []string{"Sent"}}
+		s, store := newSyncer(t, opts, WithGmailConfig(cfg), WithGmailServer(true), WithMailboxFilter(nil, []string{"INBOX"}))
+		syncAll(t, s)
+		assert.Equal(t, map[string]int{"INBOX": 0, "Sent": 0}, counts(t, store))
+	})
+}
+
+func TestSyncMailbox_DateRange(t *testing.T) {
+	opts, cleanup := newSyncTestServer(t)
+	defer cleanup()
+
+	base, store := newTestSyncer(t, opts)
+	ctx := context.Background()
+
+	for _, d := range []time.Time{
+		time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC),
+		time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		time.Date(2025, 8, 1, 12, 0, 0, 0, time.UTC),
+	} {
+		require.NoError(t, base.client.AppendMessage(ctx, "INBOX", nil, d, []byte(syncTestMsg)))
+	}
+
+	since := func(year int) Option {
+		return WithSince(time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC))
+	}
+	storedUIDs := func() []uint32 {
+		uids, err := store.ListLiveUIDs("INBOX")
+		require.NoError(t, err)
+		slices.Sort(uids)
+		return uids
+	}
+
+	stats, err := New(base.client, store, base.log, since(2024)).SyncMailbox(ctx, "INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.NewMessages)
+	assert.Equal(t, []uint32{2, 3}, storedUIDs())
+
+	state, err := store.GetMailboxState("INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0), state.LastUID, "date-limited sync doesn't advance LastUID")
+
+	// Messages outside a narrower range are not treated as deleted.
+	stats, err = New(base.client, store, base.log, since(2025)).SyncMailbox(ctx, "INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.NewMessages)
+	assert.Equal(t, 0, stats.DeletedMessages)
+	assert.Equal(t, []uint32{2, 3}, storedUIDs())
+
+	// A wider range fetches only what isn't stored, even below LastUID.
+	stats, err = New(base.client, store, base.log, since(2022)).SyncMailbox(ctx, "INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.NewMessages)
+	assert.Equal(t, []uint32{1, 2, 3}, storedUIDs())
+
+	// Before excludes the newest message and still reconciles deletions.
+	deleteSyncMsg(t, opts, "INBOX", 1)
+	stats, err = New(base.client, store, base.log, WithBefore(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))).SyncMailbox(ctx, "INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.TotalMessages)
+	assert.Equal(t, 0, stats.NewMessages)
+	assert.Equal(t, 1, stats.DeletedMessages)
+	assert.Equal(t, []uint32{2, 3}, storedUIDs())
+}
+
+func TestSyncMailbox_InternalDate(t *testing.T) {
+	opts, cleanup := newSyncTestServer(t)
+	defer cleanup()
+
+	base, store := newTestSyncer(t, opts)
+	ctx := context.Background()
+
+	received := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	undated := strings.Replace(syncTestMsg, "Date: Wed, 01 Jan 2025 12:00:00 +0000\r\n", "", 1)
+	require.NoError(t, base.client.AppendMessage(ctx, "INBOX", nil, received, []byte(syncTestMsg)))
+	require.NoError(t, base.client.AppendMessage(ctx, "INBOX", nil, received, []byte(undated)))
+
+	_, err := base.SyncMailbox(ctx, "INBOX")
+	require.NoError(t, err)
+
+	dated, err := store.GetEmail("INBOX", 1)
+	require.NoError(t, err)
+	assert.True(t, dated.Date.Equal(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)), "envelope date is kept")
+	require.NotNil(t, dated.InternalDate)
+	assert.True(t, dated.InternalDate.Equal(received))
+
+	// Without an envelope date the message is dated by INTERNALDATE.
+	email, err := store.GetEmail("INBOX", 2)
+	require.NoError(t, err)
+	assert.True(t, email.Date.Equal(received))
+	require.NotNil(t, email.InternalDate)
+	assert.True(t, email.InternalDate.Equal(received))
+}
+
+func TestSyncMailbox_Metrics(t *testing.T) {
+	opts, cleanup := newSyncTestServer(t)
+	defer cleanup()
+
+	base, _ := newTestSyncer(t, opts)
+	ctx := context.Background()
+
+	for range 2 {
+		require.NoError(t, base.client.AppendMessage(ctx, "INBOX", nil, time.Now(), []byte(syncTestMsg)))
+	}
+
+	synced := testutil.ToFloat64(metrics.MessagesSynced.WithLabelValues("INBOX"))
+	fetched := testutil.ToFloat64(metrics.BytesFetched)
+
+	_, err := base.SyncMailbox(ctx, "INBOX")
+	require.NoError(t, err)
+
+	assert.Equal(t, synced+2, testutil.ToFloat64(metrics.MessagesSynced.WithLabelValues("INBOX")))
+	assert.Equal(t, fetched+float64(2*len(syncTestMsg)), testutil.ToFloat64(metrics.BytesFetched))
+	assert.Positive(t, testutil.CollectAndCount(metrics.SyncDuration))
+}
+
+func TestSyncMailbox_DecodesEncodedSubject(t *testing.T) {
+	opts, cleanup := newSyncTestServer(t)
+	defer cleanup()
+
+	base, store := newTestSyncer(t, opts)
+	ctx := context.Background()
+
+	// go-imap decodes UTF-8 encoded words itself, but not KOI8-R ones.
+	for _, subject := range []string{
+		"=?UTF-8?B?R3LDvMOfZSBhdXMgTcO8bmNoZW4=?=",
+		"=?KOI8-R?B?8NLJ18XULCDNydI=?=",
+	} {
+		raw := strings.Replace(syncTestMsg, "Subject: Sync Test", "Subject: "+subject, 1)
+		require.NoError(t, base.client.AppendMessage(ctx, "INBOX", nil, time.Now(), []byte(raw)))
+	}
+
+	_, err := base.SyncMailbox(ctx, "INBOX")
+	require.NoError(t, err)
+
+	email, err := store.GetEmail("INBOX", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "Grüße aus München", email.Subject)
+
+	email, err = store.GetEmail("INBOX", 2)
+	require.NoError(t, err)
+	assert.Equal(t, "Привет, мир", email.Subject)
+}
+
+func TestSyncMailbox_MaxMessageSize(t *testing.T) {
+	opts, cleanup := newSyncTestServer(t)
+	defer cleanup()
+
+	base, store := newTestSyncer(t, opts)
+	ctx := context.Background()
+
+	large := syncTestMsg + strings.Repeat("x", 4096)
+	require.NoError(t, base.client.AppendMessage(ctx, "INBOX", nil, time.Now(), []byte(syncTestMsg)))
+	require.NoError(t, base.client.AppendMessage(ctx, "INBOX", nil, time.Now(), []byte(large)))
+
+	s := New(base.client, store, base.log, WithMaxMessageSize(1024))
+	stats, err := s.SyncMailbox(ctx, "INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.NewMessages)
+
+	small, err := store.GetEmail("INBOX", 1)
+	require.NoError(t, err)
+	assert.Equal(t, syncTestMsg, string(small.RawMessage))
+	assert.Empty(t, small.Body, "the message is stored once, as its raw form")
+
+	skipped, err := store.GetEmail("INBOX", 2)
+	require.NoError(t, err)
+	require.NotNil(t, skipped)
+	assert.Equal(t, "Sync Test", skipped.Subject)
+	assert.Equal(t, uint32(len(large)), skipped.Size)
+	assert.NotEmpty(t, skipped.Headers)
+	assert.Empty(t, skipped.Body)
+	assert.Empty(t, skipped.RawMessage)
+}
+
+func TestSyncMailbox_MailboxPolicies(t *testing.T) {
+	opts, cleanup := newSyncTestServer(t)
+	defer cleanup()
+
+	appendSyncMsgs(t, opts, "INBOX", 2)
+	appendSyncMsgs(t, opts, "Sent", 2)
+
+	base, store := newTestSyncer(t, opts)
+	ctx := context.Background()
+
+	t.Run("headers", func(t *testing.T) {
+		s := New(base.client, store, base.log, WithMailboxPolicies(map[string]FetchPolicy{"S*": FetchHeaders}))
+		report := syncAll(t, s)
+		assert.Equal(t, 4, report.Totals.NewMessages)
+
+		full, err := store.GetEmail("INBOX", 1)
+		require.NoError(t, err)
+		assert.Equal(t, syncTestMsg, string(full.RawMessage))
+
+		headersOnly, err := store.GetEmail("Sent", 1)
+		require.NoError(t, err)
+		assert.Equal(t, "Sync Test", headersOnly.Subject)
+		assert.NotZero(t, headersOnly.Size)
+		assert.NotEmpty(t, headersOnly.Headers)
+		assert.Empty(t, headersOnly.Body)
+		assert.Empty(t, headersOnly.RawMessage)
+
+		// Emails stored without bodies aren't downloaded again.
+		stats, err := s.SyncMailbox(ctx, "Sent")
+		require.NoError(t, err)
+		assert.Equal(t, 0, stats.NewMessages)
+	})
+
+	t.Run("metadata", func(t *testing.T) {
+		appendSyncMsgs(t, opts, "Sent", 1)
+
+		s := New(base.client, store, base.log, WithMetadataFirst(true),
+			WithMailboxPolicies(map[string]FetchPolicy{"Sent": FetchMetadata}))
+		stats, err := s.SyncMailbox(ctx, "Sent")
+		require.NoError(t, err)
+		assert.Equal(t, 1, stats.NewMessages)
+
+		email, err := store.GetEmail("Sent", 3)
+		require.NoError(t, err)
+		assert.Equal(t, "Sync Test", email.Subject)
+		assert.Empty(t, email.Headers)
+		assert.Empty(t, email.RawMessage, "the body pass of WithMetadataFirst is skipped")
+	})
+}
+
+func TestSyncMailbox_MetadataFirst(t *testing.T) {
+	opts, cleanup := newSyncTestServer(t)
+	defer cleanup()
+
+	appendSyncMsgs(t, opts, "INBOX", 3)
+
+	base, store := newTestSyncer(t, opts)
+	ctx := context.Background()
+	s := New(base.client, store, base.log, WithMetadataFirst(true), WithBatchSize(2))
+
+	stats, err := s.SyncMailbox(ctx, "INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.NewMessages)
+
+	missing, err := store.EmailsMissingBody("INBOX")
+	require.NoError(t, err)
+	assert.Empty(t, missing)
+
+	email, err := store.GetEmail("INBOX", 2)
+	require.NoError(t, err)
+	assert.Equal(t, syncTestMsg, string(email.RawMessage))
+}
+
+func TestSyncMailbox_MetadataFirstResumesBodies(t *testing.T) {
+	opts, cleanup := newSyncTestServer(t)
+	defer cleanup()
+
+	appendSyncMsgs(t, opts, "INBOX", 3)
+
+	base, store := newTestSyncer(t, opts)
+	ctx := context.Background()
+	s := New(base.client, store, base.log, WithMetadataFirst(true))
+
+	// Simulate a run interrupted after the metadata pass.
+	selectData, err := base.client.SelectMailboxWithContext(ctx, "INBOX")
+	require.NoError(t, err)
+	emails, _, err := s.fetchMetadataBatch(ctx, "INBOX", []uint32{1, 2, 3})
+	require.NoError(t, err)
+	require.NoError(t, s.storeMetadataBatch(context.Background(), "INBOX", emails))
+	require.NoError(t, s.updateMailboxState("INBOX", selectData.UIDValidity, 3))
+
+	missing, err := store.EmailsMissingBody("INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{1, 2, 3}, missing)
+
+	stats, err := s.SyncMailbox(ctx, "INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.NewMessages)
+
+	missing, err = store.EmailsMissingBody("INBOX")
+	require.NoError(t, err)
+	assert.Empty(t, missing)
+}
+
 func TestSyncMailbox_WithProgress(t *testing.T) {
 	opts, cleanup := newSyncTestServer(t)
 	defer cleanup()
@@ -383,6 +989,72 @@ func TestSyncMailbox_WithProgress(t *testing.T) {
 	assert.Equal(t, 3, count)
 }
 
+func TestSyncMailbox_ProgressCallback(t *testing.T) {
+	opts, cleanup := newSyncTestServer(t)
+	defer cleanup()
+
+	appendSyncMsgs(t, opts, "INBOX", 3)
+
+	base, store := newTestSyncer(t, opts)
+
+	var events []ProgressEvent
+	s := New(base.client, store, base.log, WithBatchSize(2), WithProgressCallback(func(ev ProgressEvent) {
+		events = append(events, ev)
+	}))
+
+	_, err := s.SyncMailbox(context.Background(), "INBOX")
+	require.NoError(t, err)
+
+	size := int64(len(syncTestMsg))
+	assert.Equal(t, []ProgressEvent{
+		{Kind: ProgressStart, Mailbox: "INBOX", Total: 3},
+		{Kind: ProgressBatch, Mailbox: "INBOX", Done: 2, Total: 3, Bytes: 2 * size},
+		{Kind: ProgressBatch, Mailbox: "INBOX", Done: 3, Total: 3, Bytes: 3 * size},
+		{Kind: ProgressFinish, Mailbox: "INBOX", Done: 3, Total: 3, Bytes: 3 * size},
+	}, events)
+
+	// Nothing new to download: no events.
+	events = nil
+	_, err = s.SyncMailbox(context.Background(), "INBOX")
+	require.NoError(t, err)
+	assert.Empty(t, events)
+}
+
+func TestSyncAll_OverallProgress(t *testing.T) {
+	opts, cleanup := newSyncTestServer(t)
+	defer cleanup()
+
+	appendSyncMsgs(t, opts, "INBOX", 3)
+	appendSyncMsgs(t, opts, "Sent", 1)
+
+	base, store := newTestSyncer(t, opts)
+
+	var events []ProgressEvent
+	s := New(base.client, store, base.log, WithBatchSize(2), WithProgressCallback(func(ev ProgressEvent) {
+		events = append(events, ev)
+	}))
+
+	_, err := s.SyncAll(context.Background())
+	require.NoError(t, err)
+
+	type overall struct{ index, done, percent int }
+	var got []overall
+	for _, ev := range events {
+		assert.Equal(t, 2, ev.Mailboxes)
+		assert.Equal(t, 4, ev.OverallTotal)
+		got = append(got, overall{ev.MailboxIndex, ev.OverallDone, ev.OverallPercent()})
+	}
+	assert.Equal(t, []overall{
+		{1, 0, 0}, {1, 2, 50}, {1, 3, 75}, {1, 3, 75}, // INBOX
+		{2, 3, 75}, {2, 4, 100}, {2, 4, 100}, // Sent
+	}, got)
+	assert.Nil(t, s.overall, "only kept while SyncAll runs")
+
+	count, err := store.CountMessages("Sent")
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
 func TestWatch_IdleMode(t *testing.T) {
 	opts, cleanup := newSyncTestServer(t)
 	defer cleanup()
@@ -399,3 +1071,73 @@ func TestWatch_IdleMode(t *testing.T) {
 	err := s.Watch(ctx, 0)
 	assert.NoError(t, err)
 }
+
+func TestSyncMailbox_CustomBatchSize(t *testing.T) {
+	opts, cleanup := newSyncTestServer(t)
+	defer cleanup()
+	appendSyncMsgs(t, opts, "INBOX", 7)
+
+	s, store := newTestSyncer(t, opts)
+	WithBatchSize(3)(s)
+
+	stats, err := s.SyncMailbox(context.Background(), "INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, 7, stats.NewMessages)
+
+	count, err := store.CountMessages("INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, 7, count)
+}
+
+func setSyncMsgFlags(t *testing.T, opts imapClient.ConnectOptions, mailbox string, uid uint32, flags ...imap2.Flag) {
+	t.Helper()
+	addr := fmt.Sprintf("%s:%d", opts.Host, opts.Port)
+	c, err := imapclient.DialInsecure(addr, nil)
+	require.NoError(t, err)
+	defer func() { c.Logout().Wait() }() //nolint:errcheck
+	require.NoError(t, c.Login(opts.Username, opts.Password).Wait())
+	_, err = c.Select(mailbox, nil).Wait()
+	require.NoError(t, err)
+
+	storeCmd := c.Store(imap2.UIDSetNum(imap2.UID(uid)), &imap2.StoreFlags{
+		Op:     imap2.StoreFlagsSet,
+		Flags:  flags,
+		Silent: true,
+	}, nil)
+	require.NoError(t, storeCmd.Close())
+}
+
+func TestSyncMailbox_SyncFlags(t *testing.T) {
+	opts, cleanup := newSyncTestServer(t)
+	defer cleanup()
+	appendSyncMsgs(t, opts, "INBOX", 2)
+
+	s, store := newTestSyncer(t, opts)
+
+	_, err := s.SyncMailbox(context.Background(), "INBOX")
+	require.NoError(t, err)
+
+	setSyncMsgFlags(t, opts, "INBOX", 1, imap2.FlagSeen, imap2.FlagFlagged)
+
+	// Flags of already-downloaded messages are left alone by default.
+	stats, err := s.SyncMailbox(context.Background(), "INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.UpdatedFlags)
+	email, err := store.GetEmail("INBOX", 1)
+	require.NoError(t, err)
+	assert.NotContains(t, email.Flags, string(imap2.FlagSeen))
+
+	WithSyncFlags(true)(s)
+
+	stats, err = s.SyncMailbox(context.Background(), "INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.UpdatedFlags, "only changed rows are rewritten")
+
+	email, err = store.GetEmail("INBOX", 1)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{string(imap2.FlagSeen), string(imap2.FlagFlagged)}, email.Flags)
+
+	stats, err = s.SyncMailbox(context.Background(), "INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.UpdatedFlags)
+}
@@ -2,7 +2,10 @@ package syncer
 
 import (
 	"context"
+	"errors"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -11,46 +14,92 @@ import (
 	imapClient "github.com/newsamples/imapsync/internal/imap"
 	"github.com/newsamples/imapsync/internal/storage"
 	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-func TestFilterUIDs(t *testing.T) {
+func TestMissingUIDs(t *testing.T) {
 	log := logrus.New()
 	log.SetLevel(logrus.PanicLevel)
 
-	s := &Syncer{log: log, showProgress: false}
+	store, err := storage.New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer store.Close()
 
-	t.Run("filter with start UID", func(t *testing.T) {
-		uids := []uint32{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
-		result := s.filterUIDs(uids, 5)
-		assert.Equal(t, []uint32{5, 6, 7, 8, 9, 10}, result)
+	require.NoError(t, store.SaveEmailBatch([]*storage.Email{
+		{UID: 1, Mailbox: "INBOX"},
+		{UID: 3, Mailbox: "INBOX"},
+		{UID: 5, Mailbox: "INBOX"},
+	}))
+	state := &storage.MailboxState{Name: "INBOX", UIDValidity: 1, LastUID: 5}
+	require.NoError(t, store.SaveMailboxState(state))
+
+	s := &Syncer{storage: store, log: log}
+	uids := []uint32{1, 2, 3, 4, 5, 6, 7}
+
+	t.Run("gaps below LastUID and new UIDs", func(t *testing.T) {
+		missing, err := s.missingUIDs(context.Background(), "INBOX", uids, state)
+		require.NoError(t, err)
+		assert.Equal(t, []uint32{2, 4, 6, 7}, missing)
 	})
 
-	t.Run("filter with start UID 1", func(t *testing.T) {
-		uids := []uint32{1, 2, 3, 4, 5}
-		result := s.filterUIDs(uids, 1)
-		assert.Equal(t, uids, result)
+	t.Run("only new UIDs", func(t *testing.T) {
+		missing, err := s.missingUIDs(context.Background(), "INBOX", []uint32{6, 7}, state)
+		require.NoError(t, err)
+		assert.Equal(t, []uint32{6, 7}, missing)
 	})
 
-	t.Run("filter with high start UID", func(t *testing.T) {
-		uids := []uint32{1, 2, 3}
-		result := s.filterUIDs(uids, 100)
-		assert.Empty(t, result)
+	t.Run("no state", func(t *testing.T) {
+		missing, err := s.missingUIDs(context.Background(), "INBOX", uids, nil)
+		require.NoError(t, err)
+		assert.Equal(t, uids, missing)
 	})
 
-	t.Run("empty UIDs", func(t *testing.T) {
-		uids := []uint32{}
-		result := s.filterUIDs(uids, 1)
-		assert.Empty(t, result)
+	t.Run("date range ignores LastUID", func(t *testing.T) {
+		ranged := &Syncer{storage: store, log: log, dateRange: imapClient.DateRange{Since: time.Now()}}
+		missing, err := ranged.missingUIDs(context.Background(), "INBOX", uids, &storage.MailboxState{Name: "INBOX", LastUID: 0})
+		require.NoError(t, err)
+		assert.Equal(t, []uint32{2, 4, 6, 7}, missing)
 	})
 }
 
+func TestRecordProgress(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	store, err := storage.New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer store.Close()
+
+	s := &Syncer{storage: store, log: log}
+	failing := errors.New("disk full")
+	var fail bool
+	save := s.recordProgress(func(_ context.Context, _ string, emails []*storage.Email) error {
+		if fail {
+			return failing
+		}
+		return store.SaveEmailBatch(emails)
+	}, &storage.MailboxState{Name: "INBOX", UIDValidity: 9, LastUID: 2}, 9)
+
+	require.NoError(t, save(context.Background(), "INBOX", []*storage.Email{{UID: 4, Mailbox: "INBOX"}, {UID: 6, Mailbox: "INBOX"}}))
+	state, err := store.GetMailboxState("INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(6), state.LastUID)
+	assert.Equal(t, uint32(9), state.UIDValidity)
+
+	fail = true
+	require.ErrorIs(t, save(context.Background(), "INBOX", []*storage.Email{{UID: 8, Mailbox: "INBOX"}}), failing)
+	state, err = store.GetMailboxState("INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(6), state.LastUID, "a failed batch isn't recorded")
+}
+
 func TestConvertToEmail(t *testing.T) {
 	log := logrus.New()
 	log.SetLevel(logrus.PanicLevel)
 
-	s := &Syncer{log: log, showProgress: false}
+	s := &Syncer{log: log}
 
 	t.Run("convert message with envelope", func(t *testing.T) {
 		msg := &imapClient.Message{
@@ -61,14 +110,16 @@ func TestConvertToEmail(t *testing.T) {
 				Subject: "Test Subject",
 				Date:    time.Now(),
 				From: []imap.Address{
-					{Mailbox: "sender", Host: "example.com"},
+					{Name: "=?KOI8-R?B?8NLJ18XULCDNydI=?=", Mailbox: "sender", Host: "example.com"},
 				},
 				To: []imap.Address{
 					{Mailbox: "recipient", Host: "example.com"},
 				},
 			},
-			Body:    []byte("Test body"),
-			Headers: []byte("Header: value\r\n"),
+			RawMessage:    []byte("Header: value\r\n\r\nTest body"),
+			Headers:       []byte("Header: value\r\n"),
+			GmailMsgID:    1278455344230334866,
+			GmailThreadID: 1278455344230334865,
 		}
 
 		email := s.convertToEmail("INBOX", msg)
@@ -78,20 +129,24 @@ func TestConvertToEmail(t *testing.T) {
 		assert.Equal(t, "INBOX", email.Mailbox)
 		assert.Equal(t, "Test Subject", email.Subject)
 		assert.Equal(t, "sender@example.com", email.From)
+		assert.Equal(t, "Привет, мир", email.FromName)
 		assert.Equal(t, []string{"recipient@example.com"}, email.To)
 		assert.Equal(t, uint32(1024), email.Size)
 		assert.Equal(t, []string{"\\Seen"}, email.Flags)
-		assert.Equal(t, []byte("Test body"), email.Body)
+		assert.Equal(t, []byte("Header: value\r\n\r\nTest body"), email.RawMessage)
+		assert.Empty(t, email.Body, "the body is only kept as part of the raw message")
 		assert.Equal(t, []byte("Header: value\r\n"), email.Headers)
+		assert.Equal(t, uint64(1278455344230334866), email.GmailMsgID)
+		assert.Equal(t, uint64(1278455344230334865), email.GmailThreadID)
 	})
 
 	t.Run("convert message without envelope", func(t *testing.T) {
 		msg := &imapClient.Message{
-			UID:     456,
-			Flags:   []imap.Flag{},
-			Size:    512,
-			Body:    []byte("Body"),
-			Headers: []byte("Headers"),
+			UID:        456,
+			Flags:      []imap.Flag{},
+			Size:       512,
+			RawMessage: []byte("Headers\r\n\r\nBody"),
+			Headers:    []byte("Headers"),
 		}
 
 		email := s.convertToEmail("Sent", msg)
@@ -103,6 +158,36 @@ func TestConvertToEmail(t *testing.T) {
 		assert.Empty(t, email.From)
 		assert.Empty(t, email.To)
 	})
+
+	t.Run("captures cc and bcc", func(t *testing.T) {
+		msg := &imapClient.Message{
+			UID: 7,
+			Envelope: &imap.Envelope{
+				To:  []imap.Address{{Mailbox: "to", Host: "example.com"}},
+				Cc:  []imap.Address{{Mailbox: "cc1", Host: "example.com"}, {Name: "Second", Mailbox: "cc2", Host: "example.org"}},
+				Bcc: []imap.Address{{Mailbox: "hidden", Host: "example.com"}},
+			},
+		}
+
+		email := s.convertToEmail("INBOX", msg)
+
+		assert.Equal(t, []string{"to@example.com"}, email.To)
+		assert.Equal(t, []string{"cc1@example.com", "cc2@example.org"}, email.Cc)
+		assert.Equal(t, []string{"hidden@example.com"}, email.Bcc)
+	})
+
+	t.Run("decodes encoded-word subjects", func(t *testing.T) {
+		for raw, want := range map[string]string{
+			"=?UTF-8?B?R3LDvMOfZSBhdXMgTcO8bmNoZW4=?=":   "Grüße aus München",
+			"Re: =?windows-1252?Q?Caf=E9?= meeting":      "Re: Café meeting",
+			"=?KOI8-R?B?8NLJ18XULCDNydI=?=":              "Привет, мир",
+			"=?UTF-8?B?not base64!?=":                    "=?UTF-8?B?not base64!?=",
+			"=?x-unknown-charset?Q?abc?= stays as it is": "=?x-unknown-charset?Q?abc?= stays as it is",
+		} {
+			msg := &imapClient.Message{UID: 1, Envelope: &imap.Envelope{Subject: raw}}
+			assert.Equal(t, want, s.convertToEmail("INBOX", msg).Subject, raw)
+		}
+	})
 }
 
 func TestUpdateMailboxState(t *testing.T) {
@@ -116,9 +201,8 @@ func TestUpdateMailboxState(t *testing.T) {
 	defer store.Close()
 
 	s := &Syncer{
-		storage:      store,
-		log:          log,
-		showProgress: false,
+		storage: store,
+		log:     log,
 	}
 
 	err = s.updateMailboxState("INBOX", 12345, 100)
@@ -177,9 +261,128 @@ func TestWithProgress(t *testing.T) {
 	log.SetLevel(logrus.PanicLevel)
 	s := &Syncer{log: log}
 	WithProgress(true)(s)
-	assert.True(t, s.showProgress)
+	assert.Equal(t, ProgressFormatBar, s.progressFormat)
 	WithProgress(false)(s)
-	assert.False(t, s.showProgress)
+	assert.Equal(t, ProgressFormatNone, s.progressFormat)
+}
+
+func TestParseProgressFormat(t *testing.T) {
+	for _, name := range []string{"bar", "plain", "none", "Plain"} {
+		format, err := ParseProgressFormat(name)
+		require.NoError(t, err)
+		assert.Equal(t, ProgressFormat(strings.ToLower(name)), format)
+	}
+	_, err := ParseProgressFormat("fancy")
+	assert.ErrorContains(t, err, "expected bar, plain or none")
+}
+
+func TestReportProgress_Plain(t *testing.T) {
+	log, hook := logtest.NewNullLogger()
+	s := &Syncer{log: log, progressFormat: ProgressFormatPlain}
+
+	report := func(kind ProgressKind, done int) {
+		s.reportProgress(ProgressEvent{Kind: kind, Mailbox: "INBOX", Done: done, Total: 250})
+	}
+	report(ProgressStart, 0)
+	for done := 30; done < 250; done += 30 {
+		report(ProgressBatch, done)
+	}
+	report(ProgressBatch, 250)
+	report(ProgressFinish, 250)
+
+	var lines []string
+	for _, entry := range hook.AllEntries() {
+		lines = append(lines, entry.Message)
+	}
+	assert.Equal(t, []string{"INBOX: 120/250", "INBOX: 210/250", "INBOX: 250/250"}, lines)
+	assert.Nil(t, s.progressBar.bar, "no progress bar is drawn")
+
+	// A mailbox that stops early still gets a last line.
+	hook.Reset()
+	report(ProgressStart, 0)
+	report(ProgressBatch, 30)
+	report(ProgressFinish, 30)
+	require.Len(t, hook.AllEntries(), 1)
+	assert.Equal(t, "INBOX: 30/250", hook.LastEntry().Message)
+}
+
+func TestOverallProgress(t *testing.T) {
+	mailboxes := []string{"INBOX", "Archive", "Failed", "Sent"}
+	scans := map[string]*mailboxScan{
+		"INBOX":   {toSync: 50},
+		"Archive": {toSync: 150},
+		"Sent":    {toSync: 0},
+	}
+
+	o := newOverallProgress(mailboxes, scans, func(string) bool { return false })
+	assert.Equal(t, 4, o.mailboxes)
+	assert.Equal(t, 200, o.total, "mailboxes that failed to scan count for nothing")
+
+	o.index = 1
+	ev := o.annotate(ProgressEvent{Kind: ProgressBatch, Mailbox: "INBOX", Done: 25, Total: 50})
+	assert.Equal(t, 25, ev.OverallDone)
+	assert.Equal(t, 12, ev.OverallPercent())
+	o.annotate(ProgressEvent{Kind: ProgressFinish, Mailbox: "INBOX", Done: 50, Total: 50})
+
+	o.index = 2
+	ev = o.annotate(ProgressEvent{Kind: ProgressBatch, Mailbox: "Archive", Done: 40, Total: 150})
+	assert.Equal(t, ProgressEvent{
+		Kind: ProgressBatch, Mailbox: "Archive", Done: 40, Total: 150,
+		MailboxIndex: 2, Mailboxes: 4, OverallDone: 90, OverallTotal: 200,
+	}, ev)
+	assert.Equal(t, 45, ev.OverallPercent())
+
+	// Messages that arrived after the scan don't go past 100%.
+	ev = o.annotate(ProgressEvent{Kind: ProgressFinish, Mailbox: "Archive", Done: 160, Total: 160})
+	assert.Equal(t, 100, ev.OverallPercent())
+
+	assert.Equal(t, 400, newOverallProgress(mailboxes, scans, func(string) bool { return true }).total, "metadata and bodies are two passes")
+	assert.Zero(t, ProgressEvent{}.OverallPercent())
+
+	log, hook := logtest.NewNullLogger()
+	(&plainProgress{}).handle(log, ProgressEvent{Kind: ProgressFinish, Mailbox: "Archive", Done: 40, Total: 150, MailboxIndex: 3, Mailboxes: 12, OverallDone: 90, OverallTotal: 200})
+	assert.Equal(t, "Archive: 40/150 (mailbox 3/12, overall 45%)", hook.LastEntry().Message)
+}
+
+func TestTakeScan(t *testing.T) {
+	scan := &mailboxScan{uidValidity: 7, uidNext: 11, numMessages: 10, uids: []uint32{1, 2}}
+	selected := &imap.SelectData{UIDValidity: 7, UIDNext: 11, NumMessages: 10}
+
+	s := &Syncer{scans: map[string]*mailboxScan{"INBOX": scan}}
+	assert.Same(t, scan, s.takeScan("INBOX", selected))
+	assert.Nil(t, s.takeScan("INBOX", selected), "a scan is used once")
+
+	for _, changed := range []*imap.SelectData{
+		{UIDValidity: 8, UIDNext: 11, NumMessages: 10},
+		{UIDValidity: 7, UIDNext: 12, NumMessages: 11}, // a new message
+		{UIDValidity: 7, UIDNext: 11, NumMessages: 9},  // an expunged one
+	} {
+		s.scans = map[string]*mailboxScan{"INBOX": scan}
+		assert.Nil(t, s.takeScan("INBOX", changed), "the mailbox is searched again")
+	}
+}
+
+func TestPolicyFor(t *testing.T) {
+	s := New(nil, nil, logrus.New(), WithMetadataFirst(true), WithMailboxPolicies(map[string]FetchPolicy{
+		"Lists/*":         FetchHeaders,
+		"Lists/Archive*":  FetchMetadata,
+		"Lists/Important": FetchFull,
+		"*/Old":           FetchMetadata,
+	}))
+
+	for mailbox, policy := range map[string]FetchPolicy{
+		"INBOX":             FetchFull,
+		"Lists/golang-nuts": FetchHeaders,
+		"Lists/Archive2019": FetchMetadata,
+		"Lists/Important":   FetchFull,
+		"Lists/Old":         FetchHeaders,
+		"Work/Old":          FetchMetadata,
+	} {
+		assert.Equal(t, policy, s.policyFor(mailbox), mailbox)
+	}
+
+	assert.True(t, s.twoPass("INBOX"))
+	assert.False(t, s.twoPass("Lists/golang-nuts"), "mailboxes without bodies take one pass")
 }
 
 func TestWithGmailConfig_NonGmail(t *testing.T) {
@@ -191,7 +394,7 @@ func TestWithGmailConfig_NonGmail(t *testing.T) {
 	defer store.Close()
 
 	cfg := &config.GmailConfig{}
-	s := New(nil, store, log, WithGmailConfig(cfg, false))
+	s := New(nil, store, log, WithGmailConfig(cfg))
 	assert.NotNil(t, s.gmailFilter)
 }
 
@@ -221,9 +424,9 @@ func TestNew_WithOptions(t *testing.T) {
 
 	s := New(nil, store, log,
 		WithProgress(true),
-		WithGmailConfig(cfg, false),
+		WithGmailConfig(cfg),
 	)
-	assert.True(t, s.showProgress)
+	assert.Equal(t, ProgressFormatBar, s.progressFormat)
 	assert.NotNil(t, s.gmailFilter)
 }
 
@@ -251,6 +454,26 @@ func TestWithPurgeAfterDays(t *testing.T) {
 	assert.Equal(t, 30, s.purgeAfterDays)
 }
 
+func TestWithBatchSize(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	store, err := storage.New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer store.Close()
+
+	assert.Equal(t, 5, New(nil, store, log).batchSize)
+	assert.Equal(t, 100, New(nil, store, log, WithBatchSize(100)).batchSize)
+	assert.Equal(t, 5, New(nil, store, log, WithBatchSize(0)).batchSize, "invalid sizes keep the default")
+}
+
+func TestSameFlags(t *testing.T) {
+	assert.True(t, sameFlags(nil, []string{}))
+	assert.True(t, sameFlags([]string{`\Seen`, `\Flagged`}, []string{`\Flagged`, `\Seen`}))
+	assert.False(t, sameFlags([]string{`\Seen`}, []string{`\Flagged`}))
+	assert.False(t, sameFlags([]string{`\Seen`}, nil))
+}
+
 func TestPurgeOldDeleted_Disabled(t *testing.T) {
 	log := logrus.New()
 	log.SetLevel(logrus.PanicLevel)
@@ -296,3 +519,113 @@ func TestPurgeOldDeleted_RemovesOldRows(t *testing.T) {
 	require.NotNil(t, e)
 	assert.Equal(t, "new", e.Subject)
 }
+
+func TestSyncInBatches_Pipelined(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+	s := &Syncer{log: log}
+
+	const (
+		batches = 4
+		delay   = 50 * time.Millisecond
+	)
+	uids := []uint32{1, 2, 3, 4, 5, 6, 7, 8}
+
+	// A fake connection: fetches take delay each and never overlap.
+	var fetching atomic.Int32
+	fetch := func(_ context.Context, mailbox string, uids []uint32) ([]*storage.Email, int64, error) {
+		if fetching.Add(1) != 1 {
+			t.Error("fetches overlap")
+		}
+		defer fetching.Add(-1)
+		time.Sleep(delay)
+
+		emails := make([]*storage.Email, len(uids))
+		for i, uid := range uids {
+			emails[i] = &storage.Email{UID: uid, Mailbox: mailbox}
+		}
+		return emails, 0, nil
+	}
+
+	var stored []uint32
+	store := func(_ context.Context, _ string, emails []*storage.Email) error {
+		time.Sleep(delay)
+		for _, e := range emails {
+			stored = append(stored, e.UID)
+		}
+		return nil
+	}
+
+	start := time.Now()
+	require.NoError(t, s.syncInBatches(context.Background(), "INBOX", uids, len(uids)/batches, fetch, store))
+	elapsed := time.Since(start)
+
+	assert.Equal(t, uids, stored, "batches are stored in order")
+	serial := 2 * batches * delay
+	assert.Less(t, elapsed, serial-delay, "fetching overlaps storing")
+}
+
+func TestSyncInBatches_StoreError(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+	s := &Syncer{log: log}
+
+	var fetched atomic.Int32
+	fetch := func(_ context.Context, _ string, uids []uint32) ([]*storage.Email, int64, error) {
+		fetched.Add(1)
+		return []*storage.Email{{UID: uids[0]}}, 0, nil
+	}
+	store := func(context.Context, string, []*storage.Email) error { return errors.New("disk full") }
+
+	err := s.syncInBatches(context.Background(), "INBOX", []uint32{1, 2, 3, 4, 5, 6}, 1, fetch, store)
+	assert.ErrorContains(t, err, "disk full")
+	assert.LessOrEqual(t, fetched.Load(), int32(1+pipelineDepth+1), "the fetcher stops")
+}
+
+func TestResumeState(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	store, err := storage.New(filepath.Join(t.TempDir(), "test.db"), log)
+	require.NoError(t, err)
+	defer store.Close()
+	require.NoError(t, store.SaveEmail(&storage.Email{UID: 1, Mailbox: "Stored"}))
+
+	lenient := New(nil, store, log)
+	strict := New(nil, store, log, WithStrictUIDValidity(true))
+	current := &storage.MailboxState{Name: "Stored", UIDValidity: 7, LastUID: 1}
+
+	t.Run("match", func(t *testing.T) {
+		for _, s := range []*Syncer{lenient, strict} {
+			state, err := s.resumeState("Stored", current, 7)
+			require.NoError(t, err)
+			assert.Same(t, current, state)
+		}
+	})
+
+	t.Run("UIDVALIDITY mismatch", func(t *testing.T) {
+		state, err := lenient.resumeState("Stored", current, 8)
+		require.NoError(t, err)
+		assert.Nil(t, state, "full resync")
+
+		_, err = strict.resumeState("Stored", current, 8)
+		assert.ErrorIs(t, err, ErrStateMismatch)
+	})
+
+	t.Run("stored emails without UIDVALIDITY", func(t *testing.T) {
+		for _, state := range []*storage.MailboxState{nil, {Name: "Stored", LastUID: 1}} {
+			got, err := lenient.resumeState("Stored", state, 7)
+			require.NoError(t, err)
+			assert.Nil(t, got)
+
+			_, err = strict.resumeState("Stored", state, 7)
+			assert.ErrorIs(t, err, ErrStateMismatch)
+		}
+	})
+
+	t.Run("new mailbox", func(t *testing.T) {
+		state, err := strict.resumeState("Empty", nil, 7)
+		require.NoError(t, err)
+		assert.Nil(t, state)
+	})
+}
@@ -2,6 +2,7 @@ package syncer
 
 import (
 	"context"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,7 +22,7 @@ func (s *Syncer) Watch(ctx context.Context, interval time.Duration) error {
 
 	s.log.Info("Starting watch mode, performing initial sync...")
 
-	if err := s.SyncAll(ctx); err != nil {
+	if _, err := s.SyncAll(ctx); err != nil {
 		if ctx.Err() != nil {
 			return nil
 		}
@@ -29,7 +30,11 @@ func (s *Syncer) Watch(ctx context.Context, interval time.Duration) error {
 	}
 
 	if interval == 0 {
-		return s.watchWithIdle(ctx)
+		if s.client.SupportsIdle() {
+			return s.watchWithIdle(ctx)
+		}
+		s.log.Warnf("Watch: server does not support IDLE, falling back to polling every %v", defaultPollOther)
+		interval = defaultPollOther
 	}
 
 	return s.watchWithInterval(ctx, interval)
@@ -47,7 +52,7 @@ func (s *Syncer) watchWithInterval(ctx context.Context, interval time.Duration)
 			return nil
 		case <-ticker.C:
 			s.log.Info("Watch: interval elapsed, syncing...")
-			if err := s.SyncAll(ctx); err != nil {
+			if _, err := s.SyncAll(ctx); err != nil {
 				if ctx.Err() != nil {
 					return nil
 				}
@@ -109,6 +114,92 @@ func (s *Syncer) watchWithIdle(ctx context.Context) error {
 	}
 }
 
+// WatchMailbox keeps a single mailbox in sync until the context is cancelled.
+// It uses IMAP IDLE and runs an incremental SyncMailbox whenever the server
+// reports a change. If the server doesn't advertise IDLE, it polls every
+// pollInterval instead.
+func (s *Syncer) WatchMailbox(ctx context.Context, mailbox string, pollInterval time.Duration) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	s.log.Infof("Watch: performing initial sync of %s...", mailbox)
+	if _, err := s.SyncMailbox(ctx, mailbox); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+
+	if !s.client.SupportsIdle() {
+		s.log.Warnf("Watch: server does not support IDLE, polling %s every %v", mailbox, pollInterval)
+		return s.pollMailbox(ctx, mailbox, pollInterval)
+	}
+
+	s.log.Infof("Watch: IDLE on %s for real-time updates", mailbox)
+
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		// Leave IDLE on the first update; the sync needs the connection.
+		idleCtx, idleCancel := context.WithTimeout(ctx, idleTimeout)
+		var updated atomic.Bool
+		err := s.client.Idle(idleCtx, mailbox, func() {
+			updated.Store(true)
+			idleCancel()
+		})
+		idleCancel()
+
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err != nil {
+			s.log.WithError(err).Warnf("Watch: IDLE on %s failed, retrying in %v", mailbox, idleRetryBackoff)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(idleRetryBackoff):
+			}
+			continue
+		}
+
+		if updated.Load() {
+			s.syncWatchedMailbox(ctx, mailbox)
+		}
+	}
+}
+
+func (s *Syncer) pollMailbox(ctx context.Context, mailbox string, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			s.syncWatchedMailbox(ctx, mailbox)
+		}
+	}
+}
+
+func (s *Syncer) syncWatchedMailbox(ctx context.Context, mailbox string) {
+	stats, err := s.SyncMailbox(ctx, mailbox)
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		s.log.WithError(err).Errorf("Watch: failed to sync %s", mailbox)
+		return
+	}
+	if stats.NewMessages > 0 || stats.DeletedMessages > 0 {
+		s.log.Infof("Watch: %s %d new, %d deleted", mailbox, stats.NewMessages, stats.DeletedMessages)
+	}
+}
+
 func (s *Syncer) pollOtherMailboxes(ctx context.Context) {
 	mailboxes, err := s.getWatchMailboxList(ctx)
 	if err != nil {
@@ -176,6 +176,77 @@ func TestWatchWithIdle_PollsOtherMailboxesAfterIdleTimeout(t *testing.T) {
 	assert.Equal(t, 2, sentCount)
 }
 
+func TestWatchMailbox_SyncsOnIdleUpdate(t *testing.T) {
+	opts, cleanup := newSyncTestServer(t)
+	defer cleanup()
+
+	appendSyncMsgs(t, opts, "Sent", 1)
+
+	s, store := newTestSyncer(t, opts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.WatchMailbox(ctx, "Sent", time.Hour)
+	}()
+
+	require.Eventually(t, func() bool {
+		n, _ := store.CountMessages("Sent")
+		return n == 1
+	}, 2*time.Second, 10*time.Millisecond, "initial sync")
+
+	appendSyncMsgs(t, opts, "Sent", 2)
+
+	require.Eventually(t, func() bool {
+		n, _ := store.CountMessages("Sent")
+		return n == 3
+	}, 2*time.Second, 10*time.Millisecond, "sync after IDLE update")
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("WatchMailbox did not return after context cancel")
+	}
+
+	inboxCount, err := store.CountMessages("INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, 0, inboxCount, "only the watched mailbox is synced")
+}
+
+func TestWatchMailbox_ContextAlreadyCancelled(t *testing.T) {
+	log := logrus.New()
+	log.SetLevel(logrus.PanicLevel)
+
+	s := &Syncer{log: log}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.NoError(t, s.WatchMailbox(ctx, "INBOX", time.Minute))
+}
+
+func TestPollMailbox_SyncsOnTick(t *testing.T) {
+	opts, cleanup := newSyncTestServer(t)
+	defer cleanup()
+
+	appendSyncMsgs(t, opts, "INBOX", 2)
+
+	s, store := newTestSyncer(t, opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	assert.NoError(t, s.pollMailbox(ctx, "INBOX", 20*time.Millisecond))
+
+	count, err := store.CountMessages("INBOX")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
 func TestPollOtherMailboxes_SyncsNonInboxFolders(t *testing.T) {
 	opts, cleanup := newSyncTestServer(t)
 	defer cleanup()
@@ -6,7 +6,16 @@ import (
 	"github.com/newsamples/imapsync/internal/app"
 )
 
+// Set at build time with -ldflags "-X main.version=... -X main.commit=... -X main.date=...".
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
 func main() {
+	app.SetBuildInfo(version, commit, date)
+
 	if err := app.RootCmd.Execute(); err != nil {
 		app.Log.WithError(err).Error("Command execution failed")
 		os.Exit(1)